@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 
+	"go-backend/llms"
 	"go-backend/models"
 	"go-backend/server"
 )
@@ -28,5 +29,6 @@ func InitServer() *server.Server {
 	}
 
 	server.RunMigrations(s)
+	llms.ConfigureProvidersFromEnv()
 	return s
 }
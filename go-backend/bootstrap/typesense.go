@@ -20,10 +20,13 @@ func InitTypesense() (*typesense.Client, error) {
 
 	collectionName := os.Getenv("TYPESENSE_COLLECTION")
 
-	_, err := client.Collection(collectionName).Retrieve(ctx)
+	existing, err := client.Collection(collectionName).Retrieve(ctx)
 	if err == nil {
 		// Collection exists
 		fmt.Println("Collection already exists:", collectionName)
+		if err := addFilterFields(ctx, client, collectionName, existing.Fields); err != nil {
+			fmt.Println("Unable to add filter fields:", err)
+		}
 		return client, nil
 	}
 	// log.Printf("delete")
@@ -123,6 +126,46 @@ func InitTypesense() (*typesense.Client, error) {
 		},
 		DefaultSortingField: &sortField,
 	}
+	schema.Fields = append(schema.Fields, filterFields()...)
 	_, err = client.Collections().Create(context.Background(), schema)
 	return client, err
 }
+
+// filterFields are the fields of card documents that search queries filter
+// on, and the fields results are faceted by. Facts and entities leave out
+// the card fields. result_type repeats type, which predates faceting and
+// cannot be made a facet in place.
+func filterFields() []api.Field {
+	optional := true
+	facet := true
+	return []api.Field{
+		{Name: "tags", Type: "string[]", Optional: &optional, Facet: &facet},
+		{Name: "entities", Type: "string[]", Optional: &optional, Facet: &facet},
+		{Name: "has_task", Type: "bool", Optional: &optional},
+		{Name: "has_file", Type: "bool", Optional: &optional},
+		{Name: "is_literature", Type: "bool", Optional: &optional},
+		{Name: "link", Type: "string", Optional: &optional},
+		{Name: "result_type", Type: "string", Optional: &optional, Facet: &facet},
+		{Name: "entity_type", Type: "string", Optional: &optional, Facet: &facet},
+	}
+}
+
+// addFilterFields adds the filter fields a collection created before them
+// is missing. Documents pick them up as they are indexed again.
+func addFilterFields(ctx context.Context, client *typesense.Client, collectionName string, fields []api.Field) error {
+	have := map[string]bool{}
+	for _, field := range fields {
+		have[field.Name] = true
+	}
+	var missing []api.Field
+	for _, field := range filterFields() {
+		if !have[field.Name] {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	_, err := client.Collection(collectionName).Update(ctx, &api.CollectionUpdateSchema{Fields: missing})
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"go-backend/bootstrap"
+	"go-backend/handlers"
+	"go-backend/models"
+)
+
+func main() {
+	limit := flag.Int("limit", 0, "maximum number of cards to embed, 0 for all")
+	all := flag.Bool("all", false, "check every card against its content hash, not just unembedded ones")
+	flag.Parse()
+
+	s := bootstrap.InitServer()
+	h := &handlers.Handler{DB: s.DB, Server: s}
+
+	// Cards that were never embedded, or whose embeddings predate content
+	// hashing. Stale hashes are caught by EmbedCard.
+	query := `
+	SELECT id, user_id, card_id, title, body, parent_id, created_at, updated_at
+	FROM cards
+	WHERE is_deleted = FALSE
+	AND (
+		$1
+		OR embedding_hash IS NULL
+		OR NOT EXISTS (SELECT 1 FROM card_embeddings ce WHERE ce.card_pk = cards.id)
+	)
+	ORDER BY id
+	`
+	rows, err := s.DB.Query(query, *all)
+	if err != nil {
+		log.Fatalf("query failed: %v", err)
+	}
+	var cards []models.Card
+	for rows.Next() {
+		var card models.Card
+		if err := rows.Scan(
+			&card.ID,
+			&card.UserID,
+			&card.CardID,
+			&card.Title,
+			&card.Body,
+			&card.ParentID,
+			&card.CreatedAt,
+			&card.UpdatedAt,
+		); err != nil {
+			log.Printf("failed to scan card: %v", err)
+			continue
+		}
+		cards = append(cards, card)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("rows iteration error: %v", err)
+	}
+	rows.Close()
+
+	embedded, skipped, failed := 0, 0, 0
+	for _, card := range cards {
+		if *limit > 0 && embedded >= *limit {
+			break
+		}
+		written, err := h.EmbedCard(context.Background(), card)
+		if err != nil {
+			log.Printf("failed to embed card %d: %v", card.ID, err)
+			failed++
+			continue
+		}
+		if written {
+			embedded++
+		} else {
+			skipped++
+		}
+	}
+	log.Printf("backfill complete: %d embedded, %d unchanged, %d failed", embedded, skipped, failed)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"go-backend/bootstrap"
+	"go-backend/handlers"
+)
+
+func main() {
+	userID := flag.Int("user", 0, "id of the user whose cards to export")
+	out := flag.String("out", "", "path of the zip file to write (default zettelkasten-<user>.zip)")
+	noAttachments := flag.Bool("no-attachments", false, "leave uploaded files out of the export")
+	flag.Parse()
+
+	if *userID == 0 {
+		log.Fatal("-user is required")
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("zettelkasten-%d.zip", *userID)
+	}
+
+	s := bootstrap.InitServer()
+	h := &handlers.Handler{DB: s.DB, Server: s}
+	if !*noAttachments {
+		s.S3 = h.CreateS3Client()
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("unable to create %q: %v", *out, err)
+	}
+	defer file.Close()
+	buffered := bufio.NewWriter(file)
+
+	options := handlers.ExportOptions{IncludeAttachments: !*noAttachments}
+	if err := h.ExportVault(context.Background(), *userID, buffered, options); err != nil {
+		log.Fatalf("export failed: %v", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		log.Fatalf("unable to write %q: %v", *out, err)
+	}
+	log.Printf("exported cards of user %d to %s", *userID, *out)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"go-backend/bootstrap"
+	"go-backend/handlers"
+)
+
+func main() {
+	userID := flag.Int("user", 0, "id of the user to import cards for")
+	file := flag.String("file", "", "path of the zip of markdown files to import")
+	dryRun := flag.Bool("dry-run", false, "report what would be imported without creating cards")
+	flag.Parse()
+
+	if *userID == 0 || *file == "" {
+		log.Fatal("-user and -file are required")
+	}
+
+	archive, err := zip.OpenReader(*file)
+	if err != nil {
+		log.Fatalf("unable to open %q: %v", *file, err)
+	}
+	defer archive.Close()
+
+	s := bootstrap.InitServer()
+	h := &handlers.Handler{DB: s.DB, Server: s}
+	if !*dryRun {
+		s.S3 = h.CreateS3Client()
+	}
+
+	result, err := h.ImportVault(context.Background(), *userID, &archive.Reader, handlers.ImportOptions{DryRun: *dryRun})
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
+	log.Printf("%d cards, %d conflicts, %d broken links", len(result.Cards), len(result.Conflicts), len(result.BrokenLinks))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"go-backend/bootstrap"
+	"go-backend/llms"
+)
+
+func main() {
+	all := flag.Bool("all", false, "reprice every row, not just those without a cost")
+	sinceStr := flag.String("since", "", "only reprice rows created on or after this date (YYYY-MM-DD)")
+	flag.Parse()
+
+	var since time.Time
+	if *sinceStr != "" {
+		parsed, err := time.Parse("2006-01-02", *sinceStr)
+		if err != nil {
+			log.Fatalf("invalid -since date: %v", err)
+		}
+		since = parsed
+	}
+
+	s := bootstrap.InitServer()
+	result, err := llms.RecomputeCosts(s.DB, since, *all)
+	if err != nil {
+		log.Fatalf("recompute failed: %v", err)
+	}
+	log.Printf("recompute complete: %d llm requests, %d summarizations repriced", result.QueryLogRows, result.SummarizationRows)
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"go-backend/bootstrap"
 	"go-backend/llms"
+	"go-backend/models"
 	"go-backend/server"
 )
 
 func processUserMemory(s *server.Server, userID uint) {
-	client := llms.NewDefaultClient(s.DB, int(userID))
-	llms.CompressUserMemory(s.DB, client, userID)
+	client := llms.NewClientForTask(s.DB, int(userID), models.LLMTaskMemory)
+	llms.CompressUserMemory(context.Background(), s.DB, client, userID)
 
 	_, err := s.DB.Exec("UPDATE users SET memory_has_changed = false WHERE id = $1", userID)
 	if err != nil {
@@ -1,20 +1,124 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"go-backend/bootstrap"
+	"go-backend/handlers"
+	"go-backend/jobs"
+	"go-backend/mail"
 )
 
 func main() {
+	concurrency := flag.Int("concurrency", 2, "number of jobs to run at once")
+	lease := flag.Duration("lease", 15*time.Minute, "how long a job may run, and is held before another worker may retry it; must exceed the longest job")
+	poll := flag.Duration("poll", 2*time.Second, "how often to check for new jobs when the queue is empty")
+	trashRetention := flag.Int("trash-retention-days", 30, "purge trashed items deleted more than this many days ago, 0 to keep them")
+	trashInterval := flag.Duration("trash-interval", time.Hour, "how often to purge expired trash")
+	linkReportInterval := flag.Duration("link-report-interval", 0, "how often to email users a report of their broken links and orphaned cards, 0 to never")
+	flag.Parse()
+
 	s := bootstrap.InitServer()
-	log.Println("Worker service started")
+	typesenseClient, err := bootstrap.InitTypesense()
+	if err != nil {
+		log.Printf("typesense unavailable, indexing jobs will be retried: %v", err)
+	} else {
+		s.TypesenseClient = typesenseClient
+	}
+	h := &handlers.Handler{DB: s.DB, Server: s}
+	s.Mail = &mail.MailClient{
+		Host:     os.Getenv("MAIL_HOST"),
+		Password: os.Getenv("MAIL_PASSWORD"),
+		Queue:    mail.NewEmailQueue(),
+		DB:       s.DB,
+	}
+	if os.Getenv("B2_ACCESS_KEY_ID") != "" && os.Getenv("B2_SECRET_ACCESS_KEY") != "" {
+		s.S3 = h.CreateS3Client()
+	} else {
+		log.Printf("file storage is not configured, trashed files will not be purged")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Example background loop
+	hostname, _ := os.Hostname()
+	log.Printf("Worker service started with %d workers", *concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		worker := &jobs.Worker{
+			DB:           s.DB,
+			ID:           fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), i),
+			Handlers:     h.JobHandlers(),
+			Lease:        *lease,
+			PollInterval: *poll,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker.Run(ctx)
+		}()
+	}
+
+	if *trashRetention > 0 {
+		retention := time.Duration(*trashRetention) * 24 * time.Hour
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runEvery(ctx, *trashInterval, func() { purgeExpiredTrash(ctx, h, retention) })
+		}()
+	}
+	if *linkReportInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runEvery(ctx, time.Hour, func() { scheduleLinkReports(h, *linkReportInterval) })
+		}()
+	}
+
+	wg.Wait()
+	log.Println("Worker service stopped")
+}
+
+// runEvery calls fn now and then every interval until ctx is cancelled
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
-		// Example background work - replace with your actual tasks
-		log.Println("Worker tick - DB is ready to use:", s.DB != nil)
-		time.Sleep(1 * time.Minute)
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeExpiredTrash applies the trash retention policy
+func purgeExpiredTrash(ctx context.Context, h *handlers.Handler, retention time.Duration) {
+	result, err := h.PurgeExpiredTrash(ctx, retention)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("failed to purge expired trash: %v", err)
+	} else if result.Cards+result.Tasks+result.Files > 0 {
+		log.Printf("purged expired trash: %d cards, %d tasks, %d files", result.Cards, result.Tasks, result.Files)
+	}
+}
+
+// scheduleLinkReports queues the link reports that are due. It is checked
+// hourly, so reports go out within an hour of being due.
+func scheduleLinkReports(h *handlers.Handler, interval time.Duration) {
+	queued, err := h.ScheduleLinkHealthReports(interval)
+	if err != nil {
+		log.Printf("failed to schedule link reports: %v", err)
+	} else if queued > 0 {
+		log.Printf("queued %d link reports", queued)
 	}
 }
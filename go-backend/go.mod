@@ -24,6 +24,7 @@ require (
 	github.com/stripe/stripe-go/v79 v79.4.0
 	golang.org/x/crypto v0.29.0
 	golang.org/x/net v0.31.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -56,5 +57,4 @@ require (
 	github.com/typesense/typesense-go v1.1.0 // indirect
 	github.com/typesense/typesense-go/v3 v3.2.0 // indirect
 	golang.org/x/text v0.20.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"go-backend/llms"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// writeLLMRequestError maps the errors of an LLM request to a status: 402 for
+// a spent budget, 429 for a rate limit, and 422, 503 or 504 for a refusal,
+// an unavailable provider or a timeout. It returns false if err is none of
+// these, leaving the response untouched.
+func writeLLMRequestError(w http.ResponseWriter, err error) bool {
+	var budgetErr *llms.BudgetExceededError
+	var rateErr *llms.RateLimitError
+	var providerErr *llms.ProviderError
+	switch {
+	case errors.As(err, &budgetErr):
+		retryAfter := int(time.Until(budgetErr.ResetsAt).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, budgetErr.Error(), http.StatusPaymentRequired)
+	case errors.As(err, &rateErr):
+		retryAfter := int(rateErr.RetryAfter.Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, rateErr.Error(), http.StatusTooManyRequests)
+	case errors.As(err, &providerErr):
+		log.Printf("llm provider error: %v", providerErr)
+		switch providerErr.Kind {
+		case llms.ErrorKindRateLimit:
+			http.Error(w, "The AI provider is rate limiting requests", http.StatusTooManyRequests)
+		case llms.ErrorKindTimeout:
+			http.Error(w, "The AI provider timed out", http.StatusGatewayTimeout)
+		case llms.ErrorKindRefusal:
+			http.Error(w, "The AI model declined to answer", http.StatusUnprocessableEntity)
+		case llms.ErrorKindUnavailable:
+			http.Error(w, "The AI provider is unavailable", http.StatusServiceUnavailable)
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// isLLMQuotaError reports whether err is a spent budget or a rate limit, the
+// user's or the provider's. Requests that work around a failed LLM call, as
+// searches do without a rerank, pass these on so the user learns why.
+func isLLMQuotaError(err error) bool {
+	var budgetErr *llms.BudgetExceededError
+	var rateErr *llms.RateLimitError
+	var providerErr *llms.ProviderError
+	return errors.As(err, &budgetErr) || errors.As(err, &rateErr) ||
+		(errors.As(err, &providerErr) && providerErr.Kind == llms.ErrorKindRateLimit)
+}
+
+func (s *Handler) GetLLMSpendRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	spend, err := llms.GetSpend(s.DB, userID)
+	if err != nil {
+		log.Printf("failed to query llm spend: %v", err)
+		http.Error(w, "Failed to query spend", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spend)
+}
+
+// admin protected
+func (s *Handler) GetLLMBudgetsRoute(w http.ResponseWriter, r *http.Request) {
+	budgets, err := llms.ListBudgets(s.DB)
+	if err != nil {
+		log.Printf("failed to list llm budgets: %v", err)
+		http.Error(w, "Failed to query budgets", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budgets)
+}
+
+// admin protected
+func (s *Handler) SaveLLMBudgetRoute(w http.ResponseWriter, r *http.Request) {
+	var params models.LLMBudget
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	budget, err := llms.SaveBudget(s.DB, params)
+	if err != nil {
+		switch {
+		case err.Error() == "budget needs either a plan or a user",
+			err.Error() == "budget limit cannot be negative":
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case isForeignKeyViolation(err):
+			http.Error(w, "user not found", http.StatusBadRequest)
+		default:
+			log.Printf("failed to save llm budget: %v", err)
+			http.Error(w, "Failed to save budget", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budget)
+}
+
+// admin protected
+func (s *Handler) DeleteLLMBudgetRoute(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := llms.DeleteBudget(s.DB, id); err != nil {
+		if err.Error() == "budget not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("failed to delete llm budget: %v", err)
+		http.Error(w, "Failed to delete budget", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
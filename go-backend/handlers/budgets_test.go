@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"go-backend/llms"
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCheckBudget(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	if err := llms.CheckBudget(s.DB, 1); err != nil {
+		t.Fatalf("users without a budget should not be limited, got %v", err)
+	}
+
+	plan := models.DefaultBudgetPlan
+	if _, err := llms.SaveBudget(s.DB, models.LLMBudget{Plan: &plan, MonthlyLimitUSD: 1}); err != nil {
+		t.Fatalf("failed to save plan budget: %v", err)
+	}
+	_, err := s.DB.Exec(`
+		INSERT INTO llm_query_log (user_id, model, prompt_tokens, completion_tokens, cost_usd)
+		VALUES (1, 'gpt-4', 1000, 1000, 1.5)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var budgetErr *llms.BudgetExceededError
+	if err := llms.CheckBudget(s.DB, 1); !errors.As(err, &budgetErr) {
+		t.Fatalf("expected a budget error, got %v", err)
+	}
+	if budgetErr.SpentUSD != 1.5 || budgetErr.LimitUSD != 1 {
+		t.Errorf("wrong budget error, got %+v", budgetErr)
+	}
+	if err := llms.CheckBudget(s.DB, 2); err != nil {
+		t.Errorf("other users should not be affected, got %v", err)
+	}
+
+	userID := 1
+	rpm := 1
+	_, err = llms.SaveBudget(s.DB, models.LLMBudget{UserID: &userID, MonthlyLimitUSD: 10, RequestsPerMinute: &rpm})
+	if err != nil {
+		t.Fatalf("failed to save user budget: %v", err)
+	}
+	var rateErr *llms.RateLimitError
+	if err := llms.CheckBudget(s.DB, 1); !errors.As(err, &rateErr) {
+		t.Fatalf("expected the user budget to override the plan and rate limit, got %v", err)
+	}
+
+	if _, err := llms.SaveBudget(s.DB, models.LLMBudget{MonthlyLimitUSD: 1}); err == nil {
+		t.Errorf("expected an error for a budget without a plan or user")
+	}
+}
+
+func TestGetLLMSpendRoute(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	userID := 1
+	if _, err := llms.SaveBudget(s.DB, models.LLMBudget{UserID: &userID, MonthlyLimitUSD: 5}); err != nil {
+		t.Fatalf("failed to save budget: %v", err)
+	}
+	_, err := s.DB.Exec(`
+		INSERT INTO llm_query_log (user_id, model, prompt_tokens, completion_tokens, cost_usd)
+		VALUES (1, 'gpt-4', 100, 100, 2), (1, 'gpt-4', 100, 100, 1)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := tests.GenerateTestJWT(1)
+	req, err := http.NewRequest("GET", "/api/llms/spend", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/llms/spend", s.JwtMiddleware(s.GetLLMSpendRoute))
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+	var spend models.LLMSpend
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &spend)
+	if spend.SpentUSD != 3 {
+		t.Errorf("wrong spend, got %v want %v", spend.SpentUSD, 3)
+	}
+	if spend.RemainingUSD == nil || *spend.RemainingUSD != 2 {
+		t.Errorf("wrong remaining budget, got %v", spend.RemainingUSD)
+	}
+}
+
+func TestPostChatMessageRouteOverBudget(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	userID := 1
+	if _, err := llms.SaveBudget(s.DB, models.LLMBudget{UserID: &userID, MonthlyLimitUSD: 0}); err != nil {
+		t.Fatalf("failed to save budget: %v", err)
+	}
+
+	token, _ := tests.GenerateTestJWT(1)
+	body := tests.CreateJsonBody(t, models.ChatCompletion{UserQuery: "hello"})
+	req, err := http.NewRequest("POST", "/api/chat", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/chat", s.JwtMiddleware(s.PostChatMessageRoute))
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusPaymentRequired {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusPaymentRequired)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a Retry-After header")
+	}
+}
+
+func TestIsLLMQuotaError(t *testing.T) {
+	cases := []struct {
+		err   error
+		quota bool
+	}{
+		{&llms.BudgetExceededError{}, true},
+		{&llms.RateLimitError{}, true},
+		{&llms.ProviderError{Kind: llms.ErrorKindRateLimit}, true},
+		{&llms.ProviderError{Kind: llms.ErrorKindUnavailable}, false},
+		{errors.New("rerank failed"), false},
+		{nil, false},
+	}
+	for _, tt := range cases {
+		if got := isLLMQuotaError(tt.err); got != tt.quota {
+			t.Errorf("isLLMQuotaError(%v) = %v, want %v", tt.err, got, tt.quota)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
 	readability "github.com/go-shiori/go-readability"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"golang.org/x/net/html"
 )
 
@@ -77,42 +78,38 @@ func (s *Handler) checkIsCardIDUnique(userID int, cardID string) bool {
 	}
 }
 
-func extractBacklinks(text string) []string {
-	// Match all text within square brackets
-	re := regexp.MustCompile(`\[([^\]]+)\]`)
-
-	// Find all matches
-	matches := re.FindAllStringSubmatch(text, -1)
-
-	// Extract the first capturing group from each match
-	var backlinks []string
-	for _, match := range matches {
-		if len(match) > 1 {
-			// Check if the match is not followed by a parenthesis
-			if !isMarkdownLink(text, match[0]) {
-				backlinks = append(backlinks, match[1])
-			}
+// extractBacklinks finds the links to other cards in text: [card_id]
+// references and ![card_id] or ![card_id#heading] transclusions. Markdown
+// links and images are not links to cards.
+func extractBacklinks(text string) []models.CardLink {
+	var backlinks []models.CardLink
+	for _, match := range backlinkPattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[0], match[1]
+		if isMarkdownLink(text, end) {
+			continue
+		}
+		target := text[match[2]:match[3]]
+		if start > 0 && text[start-1] == '!' {
+			cardID, heading, _ := strings.Cut(target, "#")
+			backlinks = append(backlinks, models.CardLink{
+				CardID:   strings.TrimSpace(cardID),
+				Heading:  strings.TrimSpace(heading),
+				LinkType: models.LinkTypeTransclusion,
+			})
+			continue
 		}
+		backlinks = append(backlinks, models.CardLink{CardID: target, LinkType: models.LinkTypeReference})
 	}
-
 	return backlinks
 }
 
-// Helper function to check if a match is part of a markdown link
-func isMarkdownLink(text, match string) bool {
-	// Find the position of the match in the text
-	pos := strings.Index(text, match)
-	if pos == -1 {
-		return false
-	}
-	// Check if the match is followed by an opening parenthesis
-	if pos+len(match) < len(text) && text[pos+len(match)] == '(' {
-		return true
-	}
-	return false
+// isMarkdownLink reports whether the square brackets ending at end are
+// followed by an opening parenthesis, as in [text](url)
+func isMarkdownLink(text string, end int) bool {
+	return end < len(text) && text[end] == '('
 }
 
-func (s *Handler) updateBacklinks(cardPK int, backlinks []string) error {
+func (s *Handler) updateBacklinks(cardPK int, backlinks []models.CardLink) error {
 	tx, _ := s.DB.Begin()
 	_, err := tx.Exec("DELETE FROM backlinks WHERE source_id_int = $1", cardPK)
 	if err != nil {
@@ -120,18 +117,18 @@ func (s *Handler) updateBacklinks(cardPK int, backlinks []string) error {
 		tx.Rollback()
 		return err
 	}
-	for _, targetID := range backlinks {
+	for _, link := range backlinks {
 		_, err = tx.Exec(`
 	WITH target_id AS (
     SELECT id 
     FROM cards 
     WHERE card_id = $2
 )
-INSERT INTO backlinks (source_id_int, target_id_int, created_at, updated_at)
-SELECT $1, target_id.id, NOW(), NOW()
+INSERT INTO backlinks (source_id_int, target_id_int, link_type, created_at, updated_at)
+SELECT $1, target_id.id, $3, NOW(), NOW()
 FROM target_id;	
 		`,
-			cardPK, targetID,
+			cardPK, link.CardID, link.LinkType,
 		)
 		if err != nil {
 			tx.Rollback()
@@ -150,9 +147,9 @@ func (s *Handler) getDirectlinks(userID int, card models.Card) []models.PartialC
 	backlinks := extractBacklinks(card.Body)
 	var directLinks []models.PartialCard
 
-	for _, value := range backlinks {
-		log.Printf("value %v", value)
-		card, err := s.QueryPartialCard(userID, value)
+	for _, link := range backlinks {
+		log.Printf("value %v", link.CardID)
+		card, err := s.QueryPartialCard(userID, link.CardID)
 		if err == nil {
 			directLinks = append(directLinks, card)
 		}
@@ -490,6 +487,7 @@ func (s *Handler) GetCardRoute(w http.ResponseWriter, r *http.Request) {
 	}
 	card.Parent = parent
 
+	w.Header().Set("ETag", versionTag(card.UpdatedAt))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(card)
 }
@@ -572,13 +570,24 @@ func (s *Handler) UpdateCardRoute(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
-	card, err := s.UpdateCard(userID, id, params)
+	ifMatch := r.Header.Get("If-Match")
+	card, err := s.UpdateCardIfMatch(userID, id, params, ifMatch)
+	if err != nil && err.Error() == "version conflict" {
+		current, err := s.QueryFullCard(userID, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.writeVersionConflict(w, "card", id, ifMatch, current.UpdatedAt, current, params)
+		return
+	}
 	if err != nil {
 		log.Printf("?")
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	w.Header().Set("ETag", versionTag(card.UpdatedAt))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(card)
 }
@@ -595,13 +604,17 @@ func (s *Handler) CreateCardRoute(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
-	if !s.checkIsCardIDUnique(userID, params.CardID) {
+	if params.Allocate == nil && !s.checkIsCardIDUnique(userID, params.CardID) {
 		http.Error(w, "card_id already exists", http.StatusBadRequest)
 		return
 	}
 
 	card, err := s.CreateCard(userID, params)
 	if err != nil {
+		if params.Allocate != nil {
+			writeAllocationError(w, err)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -649,6 +662,10 @@ func (s *Handler) GetNextRootCardIDRoute(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *Handler) getNextRootCardID(userID int) string {
+	return nextRootCardID(s.DB, userID)
+}
+
+func nextRootCardID(db cardQueryer, userID int) string {
 	var result string
 
 	// Query to get the highest numeric card_id
@@ -662,7 +679,7 @@ func (s *Handler) getNextRootCardID(userID int) string {
         LIMIT 1
     `
 
-	err := s.DB.QueryRow(query, userID).Scan(&result)
+	err := db.QueryRow(query, userID).Scan(&result)
 	if err != nil && err != sql.ErrNoRows {
 		log.Printf("Error finding next root card ID: %v", err)
 		return "1" // Default to 1 if there's an error
@@ -734,6 +751,21 @@ func (s *Handler) QueryPartialCard(userID int, cardID string) (models.PartialCar
 }
 
 func (s *Handler) QueryFullCard(userID int, id int) (models.Card, error) {
+	card, err := s.queryCard(userID, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Card{}, fmt.Errorf("card not found")
+		}
+		log.Printf("query error: %v", err)
+		return models.Card{}, fmt.Errorf("unable to access card")
+	}
+
+	s.logCardView(id, userID)
+	return card, nil
+}
+
+// queryCard loads a card without recording a view, for background work
+func (s *Handler) queryCard(userID int, id int) (models.Card, error) {
 	var card models.Card
 
 	err := s.DB.QueryRow(`
@@ -754,24 +786,31 @@ func (s *Handler) QueryFullCard(userID int, id int) (models.Card, error) {
 		&card.CreatedAt,
 		&card.UpdatedAt,
 	)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return models.Card{}, fmt.Errorf("card not found")
-		}
-		log.Printf("query error: %v", err)
-		return models.Card{}, fmt.Errorf("unable to access card")
-	}
-
-	s.logCardView(id, userID)
-	return card, nil
+	return card, err
 }
 
 func (s *Handler) UpdateCard(userID int, cardPK int, params models.EditCardParams) (models.Card, error) {
+	return s.updateCard(userID, cardPK, params, "", "update", nil)
+}
+
+// UpdateCardIfMatch saves a card only if it is still at a version ifMatch
+// names, returning a "version conflict" error otherwise
+func (s *Handler) UpdateCardIfMatch(userID int, cardPK int, params models.EditCardParams, ifMatch string) (models.Card, error) {
+	return s.updateCard(userID, cardPK, params, ifMatch, "update", nil)
+}
+
+// updateCard saves a card, recording the change as an audit event with the
+// given action and extra details. A non-empty ifMatch makes the save
+// conditional on the card's version.
+func (s *Handler) updateCard(userID int, cardPK int, params models.EditCardParams, ifMatch string, auditAction string, auditData map[string]interface{}) (models.Card, error) {
 	// Get the old state first
 	oldCard, err := s.QueryFullCard(userID, cardPK)
 	if err != nil {
 		return models.Card{}, err
 	}
+	if !matchesVersion(ifMatch, oldCard.UpdatedAt) {
+		return models.Card{}, fmt.Errorf("version conflict")
+	}
 
 	var parent_id int
 	parent, _ := s.QueryPartialCard(userID, getParentIdAlternating(params.CardID))
@@ -783,16 +822,21 @@ func (s *Handler) UpdateCard(userID int, cardPK int, params models.EditCardParam
 		parent_id = parent.ID
 	}
 
+	// The card must not have changed since it was read, or a conditional
+	// save could overwrite a change it never saw
 	query := `
 	UPDATE cards SET title = $1, body = $2, link = $3, parent_id = $4, updated_at = NOW(), card_id = $5
 	WHERE
-	id = $6
+	id = $6 AND ($7 = '' OR updated_at = $8)
 	`
-	_, err = s.DB.Exec(query, params.Title, params.Body, params.Link, parent_id, params.CardID, cardPK)
+	result, err := s.DB.Exec(query, params.Title, params.Body, params.Link, parent_id, params.CardID, cardPK, ifMatch, oldCard.UpdatedAt)
 	if err != nil {
 		log.Printf("updatecard err %v", err)
 		return models.Card{}, err
 	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return models.Card{}, fmt.Errorf("version conflict")
+	}
 
 	// Get the new state
 	newCard, err := s.QueryFullCard(userID, cardPK)
@@ -800,15 +844,23 @@ func (s *Handler) UpdateCard(userID int, cardPK int, params models.EditCardParam
 		return models.Card{}, err
 	}
 
-	// Create audit event
-	s.CreateAuditEvent(userID, cardPK, "card", "update", oldCard, newCard)
+	// Create audit event, noting the version it leaves the card at
+	eventData := map[string]interface{}{"version": versionTag(newCard.UpdatedAt)}
+	for key, value := range auditData {
+		eventData[key] = value
+	}
+	s.createAuditEventWithData(userID, cardPK, "card", auditAction, oldCard, newCard, eventData)
 
 	backlinks := extractBacklinks(newCard.Body)
 	s.updateBacklinks(newCard.ID, backlinks)
 
-	s.upsertCardToTypesense(newCard)
+	if oldCard.Title != newCard.Title || oldCard.Body != newCard.Body {
+		s.UpdateCardEmbeddings(newCard)
+	}
 
 	s.AddTagsFromCard(userID, cardPK)
+	// Queued once its tags are saved, as they are indexed with it
+	s.upsertCardToTypesense(newCard)
 	if s.UserHasSubscription(userID) {
 		s.GenerateMemory(uint(userID), newCard.Body)
 		if params.ProcessEntitiesAndFacts != nil && *params.ProcessEntitiesAndFacts {
@@ -818,20 +870,58 @@ func (s *Handler) UpdateCard(userID int, cardPK int, params models.EditCardParam
 	return s.QueryFullCard(userID, cardPK)
 }
 
-func (s *Handler) CreateCard(userID int, params models.EditCardParams) (models.Card, error) {
-	// Strip all whitespace from card_id before proceeding
-	params.CardID = strings.ReplaceAll(params.CardID, " ", "")
-	params.CardID = regexp.MustCompile(`\s+`).ReplaceAllString(params.CardID, "")
-
-	parent, err := s.QueryPartialCard(userID, getParentIdAlternating(params.CardID))
-	query := `
+const insertCardQuery = `
 	INSERT INTO cards 
 	(title, body, link, user_id, card_id, parent_id, created_at, updated_at)
 	VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
 	RETURNING id;
 	`
+
+// insertAllocatedCard inserts a card with the card_id its allocation asks
+// for, holding the user's card_ids so no other card can claim it first
+func (s *Handler) insertAllocatedCard(userID int, params *models.EditCardParams) (int, int, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	if err := lockCardIDs(tx, userID); err != nil {
+		return 0, 0, err
+	}
+
+	params.CardID, err = allocateCardID(tx, userID, *params.Allocate)
+	if err != nil {
+		return 0, 0, err
+	}
+	var parentPK int
+	if parentID := getParentIdAlternating(params.CardID); parentID != params.CardID {
+		tx.QueryRow(`
+		SELECT id FROM cards WHERE user_id = $1 AND card_id = $2 AND is_deleted = FALSE
+		`, userID, parentID).Scan(&parentPK)
+	}
+
 	var id int
-	err = s.DB.QueryRow(query, params.Title, params.Body, params.Link, userID, params.CardID, parent.ID).Scan(&id)
+	err = tx.QueryRow(insertCardQuery, params.Title, params.Body, params.Link, userID, params.CardID, parentPK).Scan(&id)
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, parentPK, tx.Commit()
+}
+
+func (s *Handler) CreateCard(userID int, params models.EditCardParams) (models.Card, error) {
+	// Strip all whitespace from card_id before proceeding
+	params.CardID = strings.ReplaceAll(params.CardID, " ", "")
+	params.CardID = regexp.MustCompile(`\s+`).ReplaceAllString(params.CardID, "")
+
+	var id, parentPK int
+	var err error
+	if params.Allocate != nil {
+		id, parentPK, err = s.insertAllocatedCard(userID, &params)
+	} else {
+		parent, _ := s.QueryPartialCard(userID, getParentIdAlternating(params.CardID))
+		parentPK = parent.ID
+		err = s.DB.QueryRow(insertCardQuery, params.Title, params.Body, params.Link, userID, params.CardID, parentPK).Scan(&id)
+	}
 	if err != nil {
 		log.Printf("updatecard err %v", err)
 		return models.Card{}, err
@@ -842,13 +932,13 @@ func (s *Handler) CreateCard(userID int, params models.EditCardParams) (models.C
 	if err != nil {
 		return models.Card{}, err
 	}
-	s.upsertCardToTypesense(newCard)
+	s.UpdateCardEmbeddings(newCard)
 
 	// Create audit event for creation
 	s.CreateAuditEvent(userID, id, "card", "create", nil, newCard)
 
 	// set parent id to id if there's no parent
-	if parent.ID == 0 || params.CardID == "" {
+	if parentPK == 0 || params.CardID == "" {
 		_, err = s.DB.Exec("UPDATE cards SET parent_id = $1 WHERE id = $1", id)
 		if err != nil {
 			return models.Card{}, err
@@ -859,6 +949,8 @@ func (s *Handler) CreateCard(userID int, params models.EditCardParams) (models.C
 	s.updateBacklinks(newCard.ID, backlinks)
 
 	s.AddTagsFromCard(userID, id)
+	// Queued once its tags are saved, as they are indexed with it
+	s.upsertCardToTypesense(newCard)
 
 	if s.UserHasSubscription(userID) {
 		s.GenerateMemory(uint(userID), newCard.Body)
@@ -884,7 +976,7 @@ func (s *Handler) DeleteCard(userID int, id int) error {
 	}
 
 	_, err = s.DB.Exec(`
-	UPDATE cards SET is_deleted = TRUE, updated_at = NOW()
+	UPDATE cards SET is_deleted = TRUE, deleted_at = NOW(), updated_at = NOW()
 	WHERE
 	id = $1 AND user_id = $2
 	`, id, userID)
@@ -961,6 +1053,7 @@ func (s *Handler) GetTemplateRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("ETag", versionTag(template.UpdatedAt))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(template)
 }
@@ -1004,12 +1097,27 @@ func (s *Handler) UpdateTemplateRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	template, err := s.UpdateTemplate(userID, id, params)
+	ifMatch := r.Header.Get("If-Match")
+	template, err := s.UpdateTemplateIfMatch(userID, id, params, ifMatch)
+	if err != nil && err.Error() == "version conflict" {
+		current, err := s.QueryTemplate(userID, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.writeVersionConflict(w, "template", id, ifMatch, current.UpdatedAt, current, params)
+		return
+	}
+	if err != nil && err.Error() == "template not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", versionTag(template.UpdatedAt))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(template)
 }
@@ -1113,21 +1221,36 @@ func (s *Handler) CreateTemplate(userID int, params models.CreateTemplateParams)
 		return models.CardTemplate{}, err
 	}
 
+	s.CreateAuditEvent(userID, template.ID, "template", "create", nil, template)
 	return template, nil
 }
 
 // UpdateTemplate updates an existing template
 func (s *Handler) UpdateTemplate(userID, id int, params models.UpdateTemplateParams) (models.CardTemplate, error) {
+	return s.UpdateTemplateIfMatch(userID, id, params, "")
+}
+
+// UpdateTemplateIfMatch updates a template only if it is still at a version
+// ifMatch names, returning a "version conflict" error otherwise
+func (s *Handler) UpdateTemplateIfMatch(userID, id int, params models.UpdateTemplateParams, ifMatch string) (models.CardTemplate, error) {
+	oldTemplate, err := s.QueryTemplate(userID, id)
+	if err != nil {
+		return models.CardTemplate{}, err
+	}
+	if !matchesVersion(ifMatch, oldTemplate.UpdatedAt) {
+		return models.CardTemplate{}, fmt.Errorf("version conflict")
+	}
+
 	var template models.CardTemplate
 
 	query := `
 	UPDATE card_templates
 	SET title = $1, body = $2, updated_at = NOW()
-	WHERE id = $3 AND user_id = $4
+	WHERE id = $3 AND user_id = $4 AND ($5 = '' OR updated_at = $6)
 	RETURNING id, user_id, title, body, created_at, updated_at
 	`
 
-	err := s.DB.QueryRow(query, params.Title, params.Body, id, userID).Scan(
+	err = s.DB.QueryRow(query, params.Title, params.Body, id, userID, ifMatch, oldTemplate.UpdatedAt).Scan(
 		&template.ID,
 		&template.UserID,
 		&template.Title,
@@ -1135,10 +1258,16 @@ func (s *Handler) UpdateTemplate(userID, id int, params models.UpdateTemplatePar
 		&template.CreatedAt,
 		&template.UpdatedAt,
 	)
+	if err == sql.ErrNoRows && ifMatch != "" {
+		return models.CardTemplate{}, fmt.Errorf("version conflict")
+	}
 	if err != nil {
 		return models.CardTemplate{}, fmt.Errorf("failed to update template: %v", err)
 	}
 
+	s.createAuditEventWithData(userID, id, "template", "update", oldTemplate, template, map[string]interface{}{
+		"version": versionTag(template.UpdatedAt),
+	})
 	return template, nil
 }
 
@@ -1264,11 +1393,62 @@ func (h *Handler) ParseURLRoute(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(result)
 }
 
-// upsertCardToTypesense adds or updates a card document in Typesense
+// addCardFilterFields adds the fields search queries filter on to a card's
+// Typesense document
+func (s *Handler) addCardFilterFields(cardPK int, doc map[string]interface{}) error {
+	var tags, entities []string
+	var hasTask, hasFile, isLiterature bool
+	var link string
+	err := s.DB.QueryRow(`
+	SELECT
+		ARRAY(SELECT t.name FROM card_tags ct JOIN tags t ON t.id = ct.tag_id
+			WHERE ct.card_pk = c.id AND t.is_deleted = FALSE ORDER BY t.name),
+		ARRAY(SELECT e.name FROM entity_card_junction j JOIN entities e ON e.id = j.entity_id
+			WHERE j.card_pk = c.id ORDER BY e.name),
+		EXISTS (SELECT 1 FROM tasks WHERE tasks.card_pk = c.id AND tasks.is_deleted = FALSE),
+		EXISTS (SELECT 1 FROM files WHERE files.card_pk = c.id AND files.is_deleted = FALSE),
+		COALESCE(c.is_literature_card, FALSE),
+		COALESCE(c.link, '')
+	FROM cards c WHERE c.id = $1
+	`, cardPK).Scan(pq.Array(&tags), pq.Array(&entities), &hasTask, &hasFile, &isLiterature, &link)
+	if err != nil {
+		return err
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	if entities == nil {
+		entities = []string{}
+	}
+	doc["tags"] = tags
+	doc["entities"] = entities
+	doc["has_task"] = hasTask
+	doc["has_file"] = hasFile
+	doc["is_literature"] = isLiterature
+	doc["link"] = link
+	return nil
+}
+
+// upsertCardToTypesense queues a card to be added or updated in Typesense
 func (s *Handler) upsertCardToTypesense(card models.Card) {
-	if s.Server.Testing {
+	s.reindexCardTypesense(card.UserID, card.ID)
+}
+
+// reindexCardTypesense queues a card to be updated in Typesense after a
+// change to its tasks, files, tags or entities, which its document is
+// filtered on. Tasks and files without a card pass a cardPK of 0 or less.
+func (s *Handler) reindexCardTypesense(userID, cardPK int) {
+	if s.Server.Testing || cardPK <= 0 {
 		return
 	}
+	s.enqueueJob(models.JobTypeUpsertCardTypesense, userID, cardJobPayload{CardPK: cardPK})
+}
+
+// indexCardTypesense adds or updates a card document in Typesense
+func (s *Handler) indexCardTypesense(card models.Card) error {
+	if s.Server.TypesenseClient == nil {
+		return fmt.Errorf("typesense is not configured")
+	}
 	collectionName := os.Getenv("TYPESENSE_COLLECTION")
 	doc := map[string]interface{}{
 		"id":                    "card-" + strconv.Itoa(card.ID),
@@ -1278,6 +1458,7 @@ func (s *Handler) upsertCardToTypesense(card models.Card) {
 		"entity_pk":             -1,
 		"user_id":               card.UserID,
 		"type":                  "card",
+		"result_type":           "card",
 		"title":                 card.Title,
 		"preview":               card.Body,
 		"parent_id":             card.ParentID,
@@ -1288,16 +1469,20 @@ func (s *Handler) upsertCardToTypesense(card models.Card) {
 		"linked_card_title":     "",
 		"linked_card_parent_id": -1,
 	}
+	if err := s.addCardFilterFields(card.ID, doc); err != nil {
+		return fmt.Errorf("failed to load filter fields of card %d: %w", card.ID, err)
+	}
 
 	_, err := s.Server.TypesenseClient.Collection(collectionName).
 		Documents().Upsert(context.Background(), doc)
 	if err != nil {
-		log.Printf("failed to upsert card ID %d: %v", card.ID, err)
+		return fmt.Errorf("failed to upsert card ID %d: %w", card.ID, err)
 	}
+	return nil
 }
 
 func (s *Handler) deleteCardTypesense(cardPK int) {
-	if s.Server.Testing {
+	if s.Server.Testing || s.Server.TypesenseClient == nil {
 		return
 	}
 	collectionName := os.Getenv("TYPESENSE_COLLECTION")
@@ -168,8 +168,13 @@ func TestGetCardSuccessParent(t *testing.T) {
 }
 
 func TestExtractBacklinks(t *testing.T) {
-	text := "This is a sample text with [link1] and [another link]."
-	expected := []string{"link1", "another link"}
+	text := "This is a sample text with [link1] and [another link], ![12/A] and ![12#Heading], but not [a link](https://example.com) or ![an image](cat.png)."
+	expected := []models.CardLink{
+		{CardID: "link1", LinkType: models.LinkTypeReference},
+		{CardID: "another link", LinkType: models.LinkTypeReference},
+		{CardID: "12/A", LinkType: models.LinkTypeTransclusion},
+		{CardID: "12", Heading: "Heading", LinkType: models.LinkTypeTransclusion},
+	}
 	result := extractBacklinks(text)
 
 	if !reflect.DeepEqual(result, expected) {
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-backend/llms"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/pgvector/pgvector-go"
+)
+
+// chatContextChunkLimit is the number of related card chunks retrieved for each message
+const chatContextChunkLimit = 5
+
+// QueryUserConversations returns all conversations for a user, most recent first
+func (s *Handler) QueryUserConversations(userID int) ([]models.ConversationSummary, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, COALESCE(title, ''), user_id, COALESCE(model, ''), COALESCE(message_count, 0),
+		created_at, COALESCE(updated_at, created_at)
+		FROM chat_conversations
+		WHERE user_id = $1
+		ORDER BY COALESCE(updated_at, created_at) DESC
+	`, userID)
+	if err != nil {
+		log.Printf("error querying conversations: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []models.ConversationSummary{}
+	for rows.Next() {
+		var conversation models.ConversationSummary
+		if err := rows.Scan(
+			&conversation.ID,
+			&conversation.Title,
+			&conversation.UserID,
+			&conversation.Model,
+			&conversation.MessageCount,
+			&conversation.CreatedAt,
+			&conversation.UpdatedAt,
+		); err != nil {
+			log.Printf("error scanning conversation: %v", err)
+			return nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, rows.Err()
+}
+
+// QueryConversation returns a single conversation, checking that it belongs to the user
+func (s *Handler) QueryConversation(userID int, conversationID string) (models.ConversationSummary, error) {
+	var conversation models.ConversationSummary
+	err := s.DB.QueryRow(`
+		SELECT id, COALESCE(title, ''), user_id, COALESCE(model, ''), COALESCE(message_count, 0),
+		created_at, COALESCE(updated_at, created_at)
+		FROM chat_conversations
+		WHERE id = $1 AND user_id = $2
+	`, conversationID, userID).Scan(
+		&conversation.ID,
+		&conversation.Title,
+		&conversation.UserID,
+		&conversation.Model,
+		&conversation.MessageCount,
+		&conversation.CreatedAt,
+		&conversation.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.ConversationSummary{}, fmt.Errorf("conversation not found")
+		}
+		log.Printf("error querying conversation: %v", err)
+		return models.ConversationSummary{}, fmt.Errorf("unable to access conversation")
+	}
+	return conversation, nil
+}
+
+// QueryChatHistory returns the messages of a conversation in order, along with
+// the cards each message referenced
+func (s *Handler) QueryChatHistory(userID int, conversationID string) ([]models.ChatCompletion, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, user_id, conversation_id, sequence_number, role, content, refusal,
+		COALESCE(model, ''), COALESCE(tokens, 0), created_at, COALESCE(card_chunks, '{}'), COALESCE(user_query, '')
+		FROM chat_completions
+		WHERE user_id = $1 AND conversation_id = $2
+		ORDER BY sequence_number ASC
+	`, userID, conversationID)
+	if err != nil {
+		log.Printf("error querying chat history: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.ChatCompletion{}
+	for rows.Next() {
+		var message models.ChatCompletion
+		var cardPKs pq.Int64Array
+		if err := rows.Scan(
+			&message.ID,
+			&message.UserID,
+			&message.ConversationID,
+			&message.SequenceNumber,
+			&message.Role,
+			&message.Content,
+			&message.Refusal,
+			&message.Model,
+			&message.Tokens,
+			&message.CreatedAt,
+			&cardPKs,
+			&message.UserQuery,
+		); err != nil {
+			log.Printf("error scanning chat message: %v", err)
+			return nil, err
+		}
+		for _, pk := range cardPKs {
+			message.ReferencedCardPKs = append(message.ReferencedCardPKs, int(pk))
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+		messages[i].ReferencedCards = s.queryReferencedCards(userID, messages[i].ReferencedCardPKs)
+	}
+	return messages, nil
+}
+
+func (s *Handler) queryReferencedCards(userID int, cardPKs []int) []models.PartialCard {
+	cards := []models.PartialCard{}
+	for _, pk := range Ints(cardPKs) {
+		card, err := s.QueryPartialCardByID(userID, pk)
+		if err != nil {
+			// the card may have been deleted since the message was written
+			continue
+		}
+		cards = append(cards, card)
+	}
+	return cards
+}
+
+// QueryRelatedCardChunks finds the user's cards whose embeddings are closest
+// to the given query embedding, returning the best match per card
+func (s *Handler) QueryRelatedCardChunks(userID int, embedding pgvector.Vector, limit int) ([]models.CardChunk, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, card_id, user_id, title, body, created_at, updated_at, parent_id,
+		ranking, 0, 0.0, ranking
+		FROM (
+			SELECT DISTINCT ON (c.id)
+			c.id, c.card_id, c.user_id, c.title, c.body, c.created_at, c.updated_at, c.parent_id,
+			1 - (ce.embedding_1024 <=> $2) AS ranking
+			FROM card_embeddings ce
+			JOIN cards c ON c.id = ce.card_pk
+			WHERE ce.user_id = $1 AND c.is_deleted = FALSE AND ce.embedding_1024 IS NOT NULL
+			ORDER BY c.id, ce.embedding_1024 <=> $2
+		) best
+		ORDER BY ranking DESC
+		LIMIT $3
+	`, userID, embedding, limit)
+	if err != nil {
+		log.Printf("error querying related chunks: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return models.ScanCardChunks(rows)
+}
+
+// getChatContext assembles the cards used to answer a message: any cards the
+// user explicitly attached, followed by the cards most related to the query
+func (s *Handler) getChatContext(ctx context.Context, userID int, query string, attachedCardPKs []int) ([]models.CardChunk, error) {
+	var chunks []models.CardChunk
+	seen := make(map[int]bool)
+
+	for _, pk := range Ints(attachedCardPKs) {
+		card, err := s.QueryFullCard(userID, pk)
+		if err != nil {
+			continue
+		}
+		seen[card.ID] = true
+		chunks = append(chunks, models.ConvertCardToChunk(card))
+	}
+
+	embedding, err := s.searchEmbedding(ctx, query)
+	if err != nil {
+		return chunks, err
+	}
+
+	related, err := s.QueryRelatedCardChunks(userID, embedding, chatContextChunkLimit)
+	if err != nil {
+		return chunks, err
+	}
+	for _, chunk := range related {
+		if seen[chunk.ID] {
+			continue
+		}
+		seen[chunk.ID] = true
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+func (s *Handler) insertChatMessage(message models.ChatCompletion) (models.ChatCompletion, error) {
+	cardPKs := make(pq.Int64Array, len(message.ReferencedCardPKs))
+	for i, pk := range message.ReferencedCardPKs {
+		cardPKs[i] = int64(pk)
+	}
+	err := s.DB.QueryRow(`
+		INSERT INTO chat_completions
+		(user_id, conversation_id, sequence_number, role, content, refusal, model, tokens, card_chunks, user_query, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
+		RETURNING id, created_at
+	`, message.UserID, message.ConversationID, message.SequenceNumber, message.Role, message.Content,
+		message.Refusal, message.Model, message.Tokens, cardPKs, message.UserQuery,
+	).Scan(&message.ID, &message.CreatedAt)
+	if err != nil {
+		log.Printf("error inserting chat message: %v", err)
+		return models.ChatCompletion{}, err
+	}
+	return message, nil
+}
+
+// CreateConversation starts a new, empty conversation for the user
+func (s *Handler) CreateConversation(userID int, title string, model string) (models.ConversationSummary, error) {
+	conversation := models.ConversationSummary{
+		ID:     uuid.New().String(),
+		Title:  title,
+		UserID: userID,
+		Model:  model,
+	}
+	err := s.DB.QueryRow(`
+		INSERT INTO chat_conversations (id, title, user_id, model, message_count, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, 0, NOW(), NOW())
+		RETURNING created_at, updated_at
+	`, conversation.ID, conversation.Title, conversation.UserID, conversation.Model,
+	).Scan(&conversation.CreatedAt, &conversation.UpdatedAt)
+	if err != nil {
+		log.Printf("error creating conversation: %v", err)
+		return models.ConversationSummary{}, err
+	}
+	return conversation, nil
+}
+
+// SendChatMessage answers a user's question against their cards. It persists
+// both the user's message and the answer, and names the conversation after
+// its first exchange.
+func (s *Handler) SendChatMessage(ctx context.Context, userID int, params models.ChatCompletion) (models.ChatCompletion, error) {
+	query := strings.TrimSpace(params.UserQuery)
+	if query == "" {
+		query = strings.TrimSpace(params.Content)
+	}
+	if query == "" {
+		return models.ChatCompletion{}, fmt.Errorf("message is empty")
+	}
+	if err := llms.CheckBudget(s.DB, userID); err != nil {
+		return models.ChatCompletion{}, err
+	}
+
+	client := llms.NewClientForTask(s.DB, userID, models.LLMTaskChat)
+	if params.ConfigurationID != 0 {
+		configured, err := llms.NewClientFromConfiguration(s.DB, userID, params.ConfigurationID)
+		if err != nil {
+			return models.ChatCompletion{}, err
+		}
+		client = configured
+	}
+
+	var conversation models.ConversationSummary
+	var err error
+	if params.ConversationID == "" {
+		conversation, err = s.CreateConversation(userID, "", client.Model.ModelIdentifier)
+	} else {
+		conversation, err = s.QueryConversation(userID, params.ConversationID)
+	}
+	if err != nil {
+		return models.ChatCompletion{}, err
+	}
+
+	history, err := s.QueryChatHistory(userID, conversation.ID)
+	if err != nil {
+		return models.ChatCompletion{}, err
+	}
+	nextSequence := 1
+	if len(history) > 0 {
+		nextSequence = history[len(history)-1].SequenceNumber + 1
+	}
+
+	relatedCards, err := s.getChatContext(ctx, userID, query, params.ReferencedCardPKs)
+	if err != nil {
+		// answer with whatever context we have rather than failing the message
+		log.Printf("error retrieving chat context: %v", err)
+	}
+
+	userMessage, err := s.insertChatMessage(models.ChatCompletion{
+		UserID:            userID,
+		ConversationID:    conversation.ID,
+		SequenceNumber:    nextSequence,
+		Role:              "user",
+		Content:           query,
+		Model:             client.Model.ModelIdentifier,
+		UserQuery:         query,
+		ReferencedCardPKs: params.ReferencedCardPKs,
+	})
+	if err != nil {
+		return models.ChatCompletion{}, err
+	}
+	history = append(history, userMessage)
+
+	completion, err := llms.CardSearchChatCompletion(ctx, client, history, relatedCards)
+	if err != nil {
+		return models.ChatCompletion{}, err
+	}
+	completion.UserID = userID
+	completion.ConversationID = conversation.ID
+	completion.SequenceNumber = nextSequence + 1
+	completion.UserQuery = query
+	if completion.Role == "" {
+		completion.Role = "assistant"
+	}
+
+	completion, err = s.insertChatMessage(completion)
+	if err != nil {
+		return models.ChatCompletion{}, err
+	}
+
+	title := conversation.Title
+	if title == "" {
+		summary, err := llms.CreateConversationSummary(ctx, client, completion)
+		if err != nil {
+			log.Printf("error creating conversation summary: %v", err)
+		} else {
+			title = summary.Title
+		}
+	}
+	_, err = s.DB.Exec(`
+		UPDATE chat_conversations SET title = $1, message_count = $2, model = $3, updated_at = $4
+		WHERE id = $5 AND user_id = $6
+	`, title, completion.SequenceNumber, completion.Model, time.Now(), conversation.ID, userID)
+	if err != nil {
+		log.Printf("error updating conversation: %v", err)
+	}
+
+	completion.ReferencedCards = s.queryReferencedCards(userID, completion.ReferencedCardPKs)
+	return completion, nil
+}
+
+// GetUserConversationsRoute lists the current user's conversations
+func (s *Handler) GetUserConversationsRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	conversations, err := s.QueryUserConversations(userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve conversations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversations)
+}
+
+// CreateConversationRoute starts a new empty conversation
+func (s *Handler) CreateConversationRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	var params models.ConversationSummary
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+	}
+
+	conversation, err := s.CreateConversation(userID, params.Title, params.Model)
+	if err != nil {
+		http.Error(w, "Failed to create conversation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// GetChatConversationRoute returns the full message history of a conversation
+func (s *Handler) GetChatConversationRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	conversationID := mux.Vars(r)["id"]
+
+	if _, err := uuid.Parse(conversationID); err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.QueryConversation(userID, conversationID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	messages, err := s.QueryChatHistory(userID, conversationID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve conversation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// PostChatMessageRoute sends a message, starting a new conversation if no
+// conversation_id is given, and returns the assistant's answer
+func (s *Handler) PostChatMessageRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	var params models.ChatCompletion
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if params.ConversationID != "" {
+		if _, err := uuid.Parse(params.ConversationID); err != nil {
+			http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	completion, err := s.SendChatMessage(r.Context(), userID, params)
+	if err != nil {
+		if writeLLMRequestError(w, err) {
+			return
+		}
+		switch err.Error() {
+		case "message is empty":
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case "conversation not found", "configuration not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(completion)
+}
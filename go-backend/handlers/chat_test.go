@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestGetUserConversations(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	conversations, err := s.QueryUserConversations(1)
+	if err != nil {
+		t.Fatalf("failed to query conversations: %v", err)
+	}
+	if len(conversations) == 0 {
+		t.Errorf("expected conversations for user 1, got none")
+	}
+	for _, conversation := range conversations {
+		if conversation.UserID != 1 {
+			t.Errorf("wrong user on conversation, got %v want %v", conversation.UserID, 1)
+		}
+	}
+}
+
+func TestGetChatConversationRoute(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	token, _ := tests.GenerateTestJWT(1)
+	req, err := http.NewRequest("GET", "/api/chat/550e8400-e29b-41d4-a716-446655440000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/chat/{id}", s.JwtMiddleware(s.GetChatConversationRoute))
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var messages []models.ChatCompletion
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &messages)
+	if len(messages) == 0 {
+		t.Fatalf("expected messages, got none")
+	}
+	for i := 1; i < len(messages); i++ {
+		if messages[i].SequenceNumber <= messages[i-1].SequenceNumber {
+			t.Errorf("messages out of order: %v then %v", messages[i-1].SequenceNumber, messages[i].SequenceNumber)
+		}
+	}
+}
+
+func TestGetChatConversationRouteOtherUser(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	token, _ := tests.GenerateTestJWT(2)
+	req, err := http.NewRequest("GET", "/api/chat/550e8400-e29b-41d4-a716-446655440000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/chat/{id}", s.JwtMiddleware(s.GetChatConversationRoute))
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestSendChatMessageNewConversation(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	completion, err := s.SendChatMessage(context.Background(), 1, models.ChatCompletion{
+		UserQuery:         "what do my notes say about apples?",
+		ReferencedCardPKs: []int{1},
+	})
+	if err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	if completion.ConversationID == "" {
+		t.Fatalf("expected a new conversation id")
+	}
+	if completion.Role != "assistant" {
+		t.Errorf("wrong role, got %v want %v", completion.Role, "assistant")
+	}
+	if completion.SequenceNumber != 2 {
+		t.Errorf("wrong sequence number, got %v want %v", completion.SequenceNumber, 2)
+	}
+	if len(completion.ReferencedCardPKs) == 0 || completion.ReferencedCardPKs[0] != 1 {
+		t.Errorf("expected attached card to be referenced first, got %v", completion.ReferencedCardPKs)
+	}
+
+	history, err := s.QueryChatHistory(1, completion.ConversationID)
+	if err != nil {
+		t.Fatalf("failed to query history: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("wrong number of messages, got %v want %v", len(history), 2)
+	}
+	if len(history[1].ReferencedCards) == 0 {
+		t.Errorf("expected referenced cards on the answer")
+	}
+
+	conversation, err := s.QueryConversation(1, completion.ConversationID)
+	if err != nil {
+		t.Fatalf("failed to query conversation: %v", err)
+	}
+	if conversation.MessageCount != 2 {
+		t.Errorf("wrong message count, got %v want %v", conversation.MessageCount, 2)
+	}
+	if conversation.Title == "" {
+		t.Errorf("expected conversation to be titled")
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mergeFields are the fields of each entity type that an update can change,
+// by the names audit events record them under
+var mergeFields = map[string][]string{
+	"card":     {"CardID", "Title", "Body", "Link"},
+	"task":     {"CardPK", "ScheduledDate", "Title", "Priority", "IsComplete"},
+	"template": {"Title", "Body"},
+}
+
+// lineMergedFields are merged line by line rather than as a whole
+var lineMergedFields = map[string]bool{"Body": true}
+
+const (
+	conflictOurs   = "<<<<<<< yours"
+	conflictMiddle = "======="
+	conflictTheirs = ">>>>>>> current"
+)
+
+// versionTag is the ETag of a version of an entity, taken from the time it
+// was last updated
+func versionTag(updatedAt time.Time) string {
+	return `"` + strconv.FormatInt(updatedAt.UnixMicro(), 10) + `"`
+}
+
+// ifMatchTags splits an If-Match header into its entity tags. Weak tags are
+// compared as strong ones.
+func ifMatchTags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// matchesVersion reports whether an If-Match header allows an update to the
+// version last updated at updatedAt. An empty header allows any update.
+func matchesVersion(header string, updatedAt time.Time) bool {
+	if header == "" {
+		return true
+	}
+	current := versionTag(updatedAt)
+	for _, tag := range ifMatchTags(header) {
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonValue turns a value into the form it takes after a round trip through
+// JSON, which is how audit events hold it
+func jsonValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	json.Unmarshal(data, &out)
+	return out
+}
+
+// entityState picks fields out of a struct, as JSON values keyed by their
+// Go names
+func entityState(entity interface{}, fields []string) map[string]interface{} {
+	v := reflect.Indirect(reflect.ValueOf(entity))
+	state := map[string]interface{}{}
+	for _, field := range fields {
+		if f := v.FieldByName(field); f.IsValid() {
+			state[field] = jsonValue(f.Interface())
+		}
+	}
+	return state
+}
+
+// jsonFieldName is the name a field of entity is encoded under
+func jsonFieldName(entity interface{}, field string) string {
+	t := reflect.Indirect(reflect.ValueOf(entity)).Type()
+	if f, ok := t.FieldByName(field); ok {
+		if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" {
+			return name
+		}
+	}
+	return field
+}
+
+// eventVersion is the ETag of the version an audit event left its entity
+// at, if the event recorded it
+func eventVersion(event models.AuditEvent) string {
+	if version, ok := event.Details.CustomData["version"].(string); ok {
+		return version
+	}
+	if state, ok := event.Details.CustomData["initial_state"].(map[string]interface{}); ok {
+		if value, ok := state["updated_at"].(string); ok {
+			if updatedAt, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				return versionTag(updatedAt)
+			}
+		}
+	}
+	return ""
+}
+
+// versionState rebuilds the fields of an entity as they were at one of its
+// versions, by undoing its audited changes from the current state, newest
+// first. It reports false if no audit event left the entity at that version.
+func versionState(events []models.AuditEvent, current map[string]interface{}, fields []string, version string) (map[string]interface{}, bool) {
+	state := map[string]interface{}{}
+	for field, value := range current {
+		state[field] = value
+	}
+	for _, event := range events {
+		if eventVersion(event) == version {
+			return state, true
+		}
+		for _, field := range fields {
+			if change, ok := event.Details.Changes[field]; ok {
+				state[field] = change.From
+			}
+		}
+	}
+	return nil, false
+}
+
+// mergeLines merges two edits of base line by line. Where both change the
+// same lines differently, both versions are kept between conflict markers
+// and the merge reports a conflict.
+func mergeLines(base, ours, theirs string) (string, bool) {
+	b, o, t := splitLines(base), splitLines(ours), splitLines(theirs)
+	toOurs, toTheirs := lineMatches(base, ours, len(b)), lineMatches(base, theirs, len(b))
+
+	var out []string
+	conflict := false
+	i, j, k := 0, 0, 0
+	for {
+		// The next base line both sides kept marks the end of a chunk
+		next := i
+		for next < len(b) && (toOurs[next] < 0 || toTheirs[next] < 0) {
+			next++
+		}
+		jEnd, kEnd := len(o), len(t)
+		if next < len(b) {
+			jEnd, kEnd = toOurs[next], toTheirs[next]
+		}
+		baseChunk, ourChunk, theirChunk := b[i:next], o[j:jEnd], t[k:kEnd]
+		switch {
+		case reflect.DeepEqual(ourChunk, baseChunk):
+			out = append(out, theirChunk...)
+		case reflect.DeepEqual(theirChunk, baseChunk), reflect.DeepEqual(ourChunk, theirChunk):
+			out = append(out, ourChunk...)
+		default:
+			conflict = true
+			out = append(out, conflictOurs)
+			out = append(out, ourChunk...)
+			out = append(out, conflictMiddle)
+			out = append(out, theirChunk...)
+			out = append(out, conflictTheirs)
+		}
+		if next == len(b) {
+			break
+		}
+		out = append(out, b[next])
+		i, j, k = next+1, jEnd+1, kEnd+1
+	}
+
+	merged := strings.Join(out, "\n")
+	if merged != "" && strings.HasSuffix(ours, "\n") {
+		merged += "\n"
+	}
+	return merged, conflict
+}
+
+// lineMatches maps each line of from to the line of to it is kept as, or -1
+// if it is not kept
+func lineMatches(from, to string, lines int) []int {
+	matches := make([]int, lines)
+	for i := range matches {
+		matches[i] = -1
+	}
+	for _, line := range diffLines(from, to) {
+		if line.Op == "equal" {
+			matches[line.OldLine-1] = line.NewLine - 1
+		}
+	}
+	return matches
+}
+
+// mergeVersions suggests a three-way merge of an update (ours) with the
+// current version (theirs). Without a base, nothing is known to be
+// unchanged, so every difference is a conflict.
+func mergeVersions(fields []string, base, ours, theirs map[string]interface{}, baseFound bool, jsonName func(string) string) models.MergeSuggestion {
+	merge := models.MergeSuggestion{BaseFound: baseFound, Fields: map[string]interface{}{}, Conflicts: []string{}}
+	for _, field := range fields {
+		o, t := ours[field], theirs[field]
+		var b interface{}
+		if baseFound {
+			b = base[field]
+		}
+		name := jsonName(field)
+
+		if lineMergedFields[field] {
+			ourText, _ := o.(string)
+			theirText, _ := t.(string)
+			baseText, _ := b.(string)
+			merged, conflict := mergeLines(baseText, ourText, theirText)
+			merge.Fields[name] = merged
+			if conflict {
+				merge.Conflicts = append(merge.Conflicts, name)
+			}
+			continue
+		}
+		switch {
+		case reflect.DeepEqual(o, t), baseFound && reflect.DeepEqual(t, b):
+			merge.Fields[name] = o
+		case baseFound && reflect.DeepEqual(o, b):
+			merge.Fields[name] = t
+		default:
+			merge.Fields[name] = o
+			merge.Conflicts = append(merge.Conflicts, name)
+		}
+	}
+	return merge
+}
+
+// writeVersionConflict answers a stale update with 409, the current version
+// of the entity and a merge of the update into it. The version the update
+// was based on is rebuilt from the entity's audit events.
+func (s *Handler) writeVersionConflict(w http.ResponseWriter, entityType string, entityID int, ifMatch string, updatedAt time.Time, current, update interface{}) {
+	fields := mergeFields[entityType]
+	theirs := entityState(current, fields)
+
+	var base map[string]interface{}
+	baseFound := false
+	events, err := s.GetAuditEvents(entityType, entityID)
+	if err != nil {
+		log.Printf("failed to load audit events of %s %d: %v", entityType, entityID, err)
+	} else if tags := ifMatchTags(ifMatch); len(tags) > 0 {
+		base, baseFound = versionState(events, theirs, fields, tags[0])
+	}
+
+	conflict := models.VersionConflict{
+		Error:   entityType + " has changed since it was loaded",
+		ETag:    versionTag(updatedAt),
+		Current: current,
+		Merge: mergeVersions(fields, base, entityState(update, fields), theirs, baseFound, func(field string) string {
+			return jsonFieldName(current, field)
+		}),
+	}
+	w.Header().Set("ETag", conflict.ETag)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(conflict)
+}
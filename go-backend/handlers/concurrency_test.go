@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMatchesVersion(t *testing.T) {
+	updatedAt := time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)
+	tag := versionTag(updatedAt)
+	for _, header := range []string{"", tag, "W/" + tag, `"1", ` + tag, "*"} {
+		if !matchesVersion(header, updatedAt) {
+			t.Errorf("expected %q to match %s", header, tag)
+		}
+	}
+	if matchesVersion(versionTag(updatedAt.Add(time.Microsecond)), updatedAt) {
+		t.Errorf("expected a later version not to match")
+	}
+}
+
+func TestMergeLines(t *testing.T) {
+	base := "one\ntwo\nthree\nfour"
+	merged, conflict := mergeLines(base, "one\n2\nthree\nfour", "one\ntwo\nthree\nfour\nfive")
+	if conflict || merged != "one\n2\nthree\nfour\nfive" {
+		t.Errorf("expected separate edits to merge, got %q %v", merged, conflict)
+	}
+	merged, conflict = mergeLines(base, "one\nmine\nthree\nfour", "one\ntheirs\nthree\nfour")
+	expected := "one\n" + conflictOurs + "\nmine\n" + conflictMiddle + "\ntheirs\n" + conflictTheirs + "\nthree\nfour"
+	if !conflict || merged != expected {
+		t.Errorf("expected clashing edits to conflict, got %q", merged)
+	}
+}
+
+func TestVersionState(t *testing.T) {
+	fields := mergeFields["template"]
+	events := []models.AuditEvent{
+		{Details: models.Details{
+			Changes:    map[string]models.FieldChange{"Body": {From: "second", To: "third"}},
+			CustomData: map[string]interface{}{"version": `"3"`},
+		}},
+		{Details: models.Details{
+			Changes:    map[string]models.FieldChange{"Title": {From: "First", To: "Second"}, "Body": {From: "first", To: "second"}},
+			CustomData: map[string]interface{}{"version": `"2"`},
+		}},
+	}
+	current := map[string]interface{}{"Title": "Second", "Body": "third"}
+	state, ok := versionState(events, current, fields, `"2"`)
+	if !ok || state["Title"] != "Second" || state["Body"] != "second" {
+		t.Errorf("wrong state at version 2, got %v", state)
+	}
+	if _, ok := versionState(events, current, fields, `"1"`); ok {
+		t.Errorf("expected an unknown version not to be found")
+	}
+}
+
+func TestUpdateCardRouteConflict(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	card, err := s.CreateCard(1, models.EditCardParams{CardID: "800", Title: "Shared", Body: "one\ntwo\nthree"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := versionTag(card.UpdatedAt)
+
+	update := func(params models.EditCardParams, ifMatch string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(params)
+		token, _ := tests.GenerateTestJWT(1)
+		req, _ := http.NewRequest("PUT", "/api/cards/"+strconv.Itoa(card.ID), bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("If-Match", ifMatch)
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/api/cards/{id}", s.JwtMiddleware(s.UpdateCardRoute))
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := update(models.EditCardParams{CardID: "800", Title: "Shared", Body: "one\ntwo\nthree\nfrom the laptop"}, loaded)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+	if rr.Header().Get("ETag") == loaded {
+		t.Errorf("expected a new ETag after the update")
+	}
+
+	rr = update(models.EditCardParams{CardID: "800", Title: "From the phone", Body: "one\n2\nthree"}, loaded)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusConflict, rr.Body.String())
+	}
+	var conflict models.VersionConflict
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &conflict)
+	if !conflict.Merge.BaseFound || len(conflict.Merge.Conflicts) != 0 {
+		t.Fatalf("expected a clean merge from the loaded version, got %+v", conflict.Merge)
+	}
+	if conflict.Merge.Fields["body"] != "one\n2\nthree\nfrom the laptop" || conflict.Merge.Fields["title"] != "From the phone" {
+		t.Errorf("wrong merge, got %+v", conflict.Merge.Fields)
+	}
+
+	current, _ := s.QueryFullCard(1, card.ID)
+	if current.Body != "one\ntwo\nthree\nfrom the laptop" {
+		t.Errorf("expected the stale update not to be saved, got %q", current.Body)
+	}
+}
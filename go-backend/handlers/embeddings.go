@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"go-backend/llms"
+	"go-backend/models"
+	"log"
+)
+
+// cardEmbeddingIsCurrent reports whether the stored embeddings of a card were
+// built from its current title and body
+func (s *Handler) cardEmbeddingIsCurrent(card models.Card) (bool, error) {
+	var hash sql.NullString
+	err := s.DB.QueryRow(
+		"SELECT embedding_hash FROM cards WHERE id = $1 AND user_id = $2",
+		card.ID, card.UserID,
+	).Scan(&hash)
+	if err != nil {
+		return false, err
+	}
+	return hash.Valid && hash.String == llms.CardContentHash(card), nil
+}
+
+// EmbedCard regenerates the embeddings of a card unless its content hash
+// matches the one stored with the current embeddings. It returns whether
+// new embeddings were written.
+func (s *Handler) EmbedCard(ctx context.Context, card models.Card) (bool, error) {
+	current, err := s.cardEmbeddingIsCurrent(card)
+	if err != nil {
+		return false, err
+	}
+	if current {
+		return false, nil
+	}
+
+	chunks := llms.ChunkCard(card)
+	if err := llms.ProcessEmbeddings(ctx, s.DB, card.UserID, card.ID, chunks); err != nil {
+		return false, err
+	}
+
+	_, err = s.DB.Exec(
+		"UPDATE cards SET embedding_hash = $1 WHERE id = $2",
+		llms.CardContentHash(card), card.ID,
+	)
+	if err != nil {
+		log.Printf("failed to store embedding hash for card %d: %v", card.ID, err)
+		return true, err
+	}
+	return true, nil
+}
+
+// UpdateCardEmbeddings queues a card to have its embeddings refreshed
+func (s *Handler) UpdateCardEmbeddings(card models.Card) {
+	if s.Server.Testing {
+		return
+	}
+	s.enqueueJob(models.JobTypeEmbedCard, card.UserID, cardJobPayload{CardPK: card.ID})
+}
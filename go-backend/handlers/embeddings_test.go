@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"go-backend/llms"
+	"go-backend/tests"
+	"testing"
+)
+
+func TestEmbedCardSkipsUnchangedContent(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	card, err := s.QueryFullCard(1, 1)
+	if err != nil {
+		t.Fatalf("failed to query card: %v", err)
+	}
+	_, err = s.DB.Exec("UPDATE cards SET embedding_hash = $1 WHERE id = $2", llms.CardContentHash(card), card.ID)
+	if err != nil {
+		t.Fatalf("failed to set embedding hash: %v", err)
+	}
+
+	written, err := s.EmbedCard(context.Background(), card)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written {
+		t.Errorf("card with an unchanged hash should not be re-embedded")
+	}
+
+	card.Body = card.Body + " changed"
+	current, err := s.cardEmbeddingIsCurrent(card)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current {
+		t.Errorf("changed card should not be considered current")
+	}
+}
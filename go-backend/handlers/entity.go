@@ -188,7 +188,26 @@ func (s *Handler) QueryEntitiesForCard(userID int, cardPK int) ([]models.Entity,
 	return entities, nil
 }
 
-func (s *Handler) MergeEntities(userID int, entity1ID int, entity2ID int) error {
+// entityCardPKs returns the cards an entity is linked to
+func entityCardPKs(db cardQueryer, entityID int) ([]int, error) {
+	rows, err := db.Query(`SELECT card_pk FROM entity_card_junction WHERE entity_id = $1`, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cardPKs []int
+	for rows.Next() {
+		var cardPK int
+		if err := rows.Scan(&cardPK); err != nil {
+			return nil, err
+		}
+		cardPKs = append(cardPKs, cardPK)
+	}
+	return cardPKs, rows.Err()
+}
+
+func (s *Handler) MergeEntities(ctx context.Context, userID int, entity1ID int, entity2ID int) error {
 	// Start transaction
 	tx, err := s.DB.Begin()
 	if err != nil {
@@ -244,6 +263,12 @@ func (s *Handler) MergeEntities(userID int, entity1ID int, entity2ID int) error
 		return fmt.Errorf("failed to merge fact relationships: %w", err)
 	}
 
+	// entity2's cards are indexed with entity1's name once merged
+	mergedCardPKs, err := entityCardPKs(tx, entity2.ID)
+	if err != nil {
+		return fmt.Errorf("failed to find entity2 cards: %w", err)
+	}
+
 	// Delete entity2's relationships
 	_, err = tx.Exec(`
 		DELETE FROM entity_card_junction
@@ -261,9 +286,9 @@ func (s *Handler) MergeEntities(userID int, entity1ID int, entity2ID int) error
 		return fmt.Errorf("failed to delete entity2 fact relationships: %w", err)
 	}
 
-	client := llms.NewDefaultClient(s.DB, userID)
+	client := llms.NewClientForTask(s.DB, userID, models.LLMTaskFactExtraction)
 
-	newDescription, err := llms.GenerateNewEntityDescription(client, entity1, entity2, entity1.Name)
+	newDescription, err := llms.GenerateNewEntityDescription(ctx, client, entity1, entity2, entity1.Name)
 	if err != nil {
 		newDescription = entity1.Description
 	}
@@ -298,9 +323,12 @@ func (s *Handler) MergeEntities(userID int, entity1ID int, entity2ID int) error
 		}
 		s.upsertEntityToTypesense(entity1, partialCard)
 		s.deleteEntityTypesense(entity2.ID)
+		for _, cardPK := range mergedCardPKs {
+			s.reindexCardTypesense(userID, cardPK)
+		}
 
 		// Recalculate embedding for surviving entity
-		err := s.CalculateEmbeddingForEntity(entity1)
+		err := s.CalculateEmbeddingForEntity(ctx, entity1)
 		if err != nil {
 			log.Printf("Error recalculating embedding for merged entity %d: %v", entity1.ID, err)
 		}
@@ -333,7 +361,7 @@ func (s *Handler) MergeEntitiesRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := s.MergeEntities(userID, req.Entity1ID, req.Entity2ID)
+	err := s.MergeEntities(r.Context(), userID, req.Entity1ID, req.Entity2ID)
 	if err != nil {
 		log.Printf("Error merging entities: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -371,6 +399,11 @@ func (s *Handler) DeleteEntity(userID int, entityID int) error {
 		return fmt.Errorf("entity not found or does not belong to user")
 	}
 
+	cardPKs, err := entityCardPKs(tx, entityID)
+	if err != nil {
+		return fmt.Errorf("failed to find entity cards: %w", err)
+	}
+
 	// Delete entity-card relationships first
 	_, err = tx.Exec(`
 		DELETE FROM entity_card_junction
@@ -396,6 +429,9 @@ func (s *Handler) DeleteEntity(userID int, entityID int) error {
 
 	// Delete from Typesense after successful commit
 	go s.deleteEntityTypesense(entityID)
+	for _, cardPK := range cardPKs {
+		s.reindexCardTypesense(userID, cardPK)
+	}
 
 	return nil
 }
@@ -505,10 +541,19 @@ func (s *Handler) UpdateEntity(userID int, entityID int, params UpdateEntityRequ
 		return fmt.Errorf("failed to update entity: %w", err)
 	}
 
+	// Its cards are indexed with its name
+	cardPKs, err := entityCardPKs(tx, entityID)
+	if err != nil {
+		return fmt.Errorf("failed to find entity cards: %w", err)
+	}
+
 	// Commit transaction for the basic update
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	for _, cardPK := range cardPKs {
+		s.reindexCardTypesense(userID, cardPK)
+	}
 
 	// Only attempt to update embedding if not in test mode
 	if !s.Server.Testing {
@@ -534,7 +579,7 @@ func (s *Handler) UpdateEntity(userID int, entityID int, params UpdateEntityRequ
 				return
 			}
 
-			if err := s.CalculateEmbeddingForEntity(entity); err != nil {
+			if err := s.CalculateEmbeddingForEntity(context.Background(), entity); err != nil {
 				log.Printf("Error calculating embedding for entity %d: %v", entityID, err)
 			}
 
@@ -553,11 +598,11 @@ func (s *Handler) UpdateEntity(userID int, entityID int, params UpdateEntityRequ
 	return nil
 }
 
-func (s *Handler) CalculateEmbeddingForEntity(entity models.Entity) error {
+func (s *Handler) CalculateEmbeddingForEntity(ctx context.Context, entity models.Entity) error {
 
-	client := llms.NewDefaultClient(s.DB, entity.UserID)
+	client := llms.NewClientForTask(s.DB, entity.UserID, models.LLMTaskFactExtraction)
 
-	embedding, err := llms.GenerateEntityEmbedding(client, entity)
+	embedding, err := llms.GenerateEntityEmbedding(ctx, client, entity)
 	if err != nil {
 		log.Printf("Error generating embedding for entity %d: %v", entity.ID, err)
 		return err
@@ -682,6 +727,7 @@ func (s *Handler) AddEntityToCardRoute(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to add entity to card", http.StatusInternalServerError)
 		return
 	}
+	s.reindexCardTypesense(userID, cardPK)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -716,6 +762,7 @@ func (s *Handler) RemoveEntityFromCardRoute(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "Failed to remove entity from card", http.StatusInternalServerError)
 		return
 	}
+	s.reindexCardTypesense(userID, cardPK)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -1052,18 +1099,20 @@ func (s *Handler) upsertEntityToTypesense(entity models.Entity, card *models.Par
 	}
 	collectionName := os.Getenv("TYPESENSE_COLLECTION")
 	doc := map[string]interface{}{
-		"id":         "entity-" + strconv.Itoa(entity.ID),
-		"fact_pk":    -1,
-		"card_id":    "",
-		"card_pk":    -1,
-		"entity_pk":  entity.ID,
-		"user_id":    entity.UserID,
-		"type":       "entity",
-		"title":      entity.Name,
-		"preview":    entity.Description,
-		"parent_id":  -1,
-		"created_at": entity.CreatedAt.Unix(),
-		"updated_at": entity.UpdatedAt.Unix(),
+		"id":          "entity-" + strconv.Itoa(entity.ID),
+		"fact_pk":     -1,
+		"card_id":     "",
+		"card_pk":     -1,
+		"entity_pk":   entity.ID,
+		"user_id":     entity.UserID,
+		"type":        "entity",
+		"result_type": "entity",
+		"entity_type": entity.Type,
+		"title":       entity.Name,
+		"preview":     entity.Description,
+		"parent_id":   -1,
+		"created_at":  entity.CreatedAt.Unix(),
+		"updated_at":  entity.UpdatedAt.Unix(),
 	}
 
 	doc["linked_card_id"] = ""
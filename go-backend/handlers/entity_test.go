@@ -19,7 +19,7 @@ func TestMergeEntitiesSuccess(t *testing.T) {
 	defer tests.Teardown()
 
 	// Perform merge using pre-loaded test data
-	err := s.MergeEntities(1, 1, 2)
+	err := s.MergeEntities(context.Background(), 1, 1, 2)
 	if err != nil {
 		t.Errorf("MergeEntities failed: %v", err)
 	}
@@ -49,7 +49,7 @@ func TestMergeEntitiesWrongUser(t *testing.T) {
 	defer tests.Teardown()
 
 	// Try to merge entities belonging to different users
-	err := s.MergeEntities(1, 1, 3)
+	err := s.MergeEntities(context.Background(), 1, 1, 3)
 	if err == nil {
 		t.Error("Expected error when merging entities from different users")
 	}
@@ -70,7 +70,7 @@ func TestMergeEntitiesNonExistent(t *testing.T) {
 	defer tests.Teardown()
 
 	// Try to merge with non-existent entity
-	err := s.MergeEntities(1, 1, 99999)
+	err := s.MergeEntities(context.Background(), 1, 1, 99999)
 	if err == nil {
 		t.Error("Expected error when merging with non-existent entity")
 	}
@@ -708,3 +708,20 @@ func TestGetEntityByNameRouteEmptyName(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
 	}
 }
+
+func TestEntityCardPKs(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	var count int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM entity_card_junction WHERE entity_id = $1", 1).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	cardPKs, err := entityCardPKs(s.DB, 1)
+	if err != nil {
+		t.Fatalf("entityCardPKs failed: %v", err)
+	}
+	if count == 0 || len(cardPKs) != count {
+		t.Errorf("expected the %d cards linked to the entity, got %v", count, cardPKs)
+	}
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"go-backend/models"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cardFrontMatter is the YAML header of an exported card
+type cardFrontMatter struct {
+	CardID      string             `yaml:"card_id"`
+	Title       string             `yaml:"title"`
+	Link        string             `yaml:"link,omitempty"`
+	Tags        []string           `yaml:"tags,omitempty"`
+	Parent      string             `yaml:"parent,omitempty"`
+	Created     time.Time          `yaml:"created"`
+	Updated     time.Time          `yaml:"updated"`
+	Tasks       []exportedTask     `yaml:"tasks,omitempty"`
+	Entities    []exportedEntity   `yaml:"entities,omitempty"`
+	Attachments []exportedFileLink `yaml:"attachments,omitempty"`
+}
+
+type exportedTask struct {
+	Title     string     `yaml:"title"`
+	Complete  bool       `yaml:"complete"`
+	Priority  string     `yaml:"priority,omitempty"`
+	Scheduled *time.Time `yaml:"scheduled,omitempty"`
+	Due       *time.Time `yaml:"due,omitempty"`
+	Completed *time.Time `yaml:"completed,omitempty"`
+}
+
+type exportedEntity struct {
+	Name        string `yaml:"name"`
+	Type        string `yaml:"type,omitempty"`
+	Description string `yaml:"description,omitempty"`
+}
+
+type exportedFileLink struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+// ExportOptions controls what goes into a vault export
+type ExportOptions struct {
+	IncludeAttachments bool
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[\\/:*?"<>|#^\[\]\x00-\x1f]+`)
+
+// sanitizeFilename makes name safe to use as a file name on any platform,
+// shortening it to at most 100 bytes without splitting a character
+func sanitizeFilename(name string) string {
+	name = unsafeFilenameChars.ReplaceAllString(name, "_")
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if len(name) > 100 {
+		end := 100
+		for end > 0 && !utf8.RuneStart(name[end]) {
+			end--
+		}
+		name = strings.TrimSpace(name[:end])
+	}
+	return name
+}
+
+// cardFilename names the markdown file of a card after its card_id and
+// title, so that the files sort the way the Zettelkasten does
+func cardFilename(card models.Card) string {
+	name := sanitizeFilename(card.CardID)
+	if name == "" {
+		name = fmt.Sprintf("card-%d", card.ID)
+	}
+	if title := sanitizeFilename(card.Title); title != "" {
+		name += " " + title
+	}
+	return name + ".md"
+}
+
+// uniqueName returns name, or name with a counter before its extension if
+// it was already used
+func uniqueName(name string, used map[string]bool) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+var backlinkPattern = regexp.MustCompile(`\[([^\]]+)\]`)
+
+// rewriteBacklinks turns [card_id] references into relative links to the
+// exported files. References to cards that are not in the export, and
+// existing markdown links, are left alone.
+func rewriteBacklinks(body string, filenames map[string]string) string {
+	var out strings.Builder
+	last := 0
+	for _, match := range backlinkPattern.FindAllStringSubmatchIndex(body, -1) {
+		start, end := match[0], match[1]
+		if end < len(body) && body[end] == '(' {
+			continue
+		}
+		cardID := body[match[2]:match[3]]
+		filename, ok := filenames[cardID]
+		if !ok {
+			continue
+		}
+		out.WriteString(body[last:start])
+		out.WriteString(fmt.Sprintf("[%s](%s)", cardID, (&url.URL{Path: filename}).EscapedPath()))
+		last = end
+	}
+	out.WriteString(body[last:])
+	return out.String()
+}
+
+func renderCardMarkdown(frontMatter cardFrontMatter, body string) ([]byte, error) {
+	header, err := yaml.Marshal(frontMatter)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	buf.Write(header)
+	buf.WriteString("---\n\n")
+	if frontMatter.Title != "" {
+		buf.WriteString("# " + frontMatter.Title + "\n\n")
+	}
+	buf.WriteString(body)
+	if !strings.HasSuffix(body, "\n") {
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// queryCardsForExport loads every card of a user, ordered by card_id
+func (s *Handler) queryCardsForExport(userID int) ([]models.Card, error) {
+	rows, err := s.DB.Query(`
+	SELECT id, card_id, user_id, title, body, link, parent_id, created_at, updated_at
+	FROM cards
+	WHERE user_id = $1 AND is_deleted = FALSE
+	ORDER BY card_id, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cards []models.Card
+	for rows.Next() {
+		var card models.Card
+		if err := rows.Scan(
+			&card.ID,
+			&card.CardID,
+			&card.UserID,
+			&card.Title,
+			&card.Body,
+			&card.Link,
+			&card.ParentID,
+			&card.CreatedAt,
+			&card.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
+
+// ExportVault writes a ZIP of a user's cards to w, one markdown file per
+// card with its metadata as YAML front matter. Attachments are stored under
+// attachments/ and linked from the front matter.
+func (s *Handler) ExportVault(ctx context.Context, userID int, w io.Writer, options ExportOptions) error {
+	cards, err := s.queryCardsForExport(userID)
+	if err != nil {
+		return fmt.Errorf("failed to query cards: %w", err)
+	}
+
+	used := map[string]bool{}
+	filenames := map[int]string{}
+	byCardID := map[string]string{}
+	cardIDs := map[int]string{}
+	for _, card := range cards {
+		filename := uniqueName(cardFilename(card), used)
+		filenames[card.ID] = filename
+		cardIDs[card.ID] = card.CardID
+		if card.CardID != "" {
+			byCardID[card.CardID] = filename
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	usedAttachments := map[string]bool{}
+	for _, card := range cards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		frontMatter := cardFrontMatter{
+			CardID:  card.CardID,
+			Title:   card.Title,
+			Link:    card.Link,
+			Created: card.CreatedAt.UTC(),
+			Updated: card.UpdatedAt.UTC(),
+		}
+		if card.ParentID != card.ID {
+			frontMatter.Parent = cardIDs[card.ParentID]
+		}
+
+		tags, err := s.QueryTagsForCard(userID, card.ID)
+		if err != nil {
+			return fmt.Errorf("failed to query tags of card %d: %w", card.ID, err)
+		}
+		for _, tag := range tags {
+			frontMatter.Tags = append(frontMatter.Tags, tag.Name)
+		}
+
+		tasks, err := s.QueryTasksByCard(userID, card.ID)
+		if err != nil {
+			return fmt.Errorf("failed to query tasks of card %d: %w", card.ID, err)
+		}
+		for _, task := range tasks {
+			exported := exportedTask{
+				Title:     task.Title,
+				Complete:  task.IsComplete,
+				Scheduled: task.ScheduledDate,
+				Due:       task.DueDate,
+				Completed: task.CompletedAt,
+			}
+			if task.Priority != nil {
+				exported.Priority = *task.Priority
+			}
+			frontMatter.Tasks = append(frontMatter.Tasks, exported)
+		}
+
+		entities, err := s.QueryEntitiesForCard(userID, card.ID)
+		if err != nil {
+			return fmt.Errorf("failed to query entities of card %d: %w", card.ID, err)
+		}
+		for _, entity := range entities {
+			frontMatter.Entities = append(frontMatter.Entities, exportedEntity{
+				Name:        entity.Name,
+				Type:        entity.Type,
+				Description: entity.Description,
+			})
+		}
+
+		files, err := s.getFilesFromCardPK(userID, card.ID)
+		if err != nil {
+			return fmt.Errorf("failed to query files of card %d: %w", card.ID, err)
+		}
+		for _, file := range files {
+			name := sanitizeFilename(file.Name)
+			if name == "" {
+				name = fmt.Sprintf("file-%d", file.ID)
+			}
+			attachmentPath := "attachments/" + uniqueName(name, usedAttachments)
+			if options.IncludeAttachments {
+				if err := s.writeAttachment(archive, attachmentPath, file); err != nil {
+					return err
+				}
+			}
+			frontMatter.Attachments = append(frontMatter.Attachments, exportedFileLink{
+				Name: file.Name,
+				Path: attachmentPath,
+			})
+		}
+
+		content, err := renderCardMarkdown(frontMatter, rewriteBacklinks(card.Body, byCardID))
+		if err != nil {
+			return fmt.Errorf("failed to render card %d: %w", card.ID, err)
+		}
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     filenames[card.ID],
+			Method:   zip.Deflate,
+			Modified: card.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(content); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// writeAttachment copies a file from S3 into the archive
+func (s *Handler) writeAttachment(archive *zip.Writer, name string, file models.File) error {
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: file.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	object, err := s.downloadObject(s.Server.S3, file.Filename, "")
+	if err != nil {
+		return fmt.Errorf("failed to download file %d: %w", file.ID, err)
+	}
+	if object == nil {
+		return nil
+	}
+	defer object.Body.Close()
+	if _, err := io.Copy(entry, object.Body); err != nil {
+		return fmt.Errorf("failed to copy file %d: %w", file.ID, err)
+	}
+	return nil
+}
+
+// ExportMarkdownRoute streams the user's Zettelkasten as a ZIP of markdown
+// files. Pass attachments=false to leave out uploaded files.
+func (s *Handler) ExportMarkdownRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	options := ExportOptions{IncludeAttachments: r.URL.Query().Get("attachments") != "false"}
+
+	filename := fmt.Sprintf("zettelkasten-%s.zip", time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	out := &countingWriter{w: w}
+	if err := s.ExportVault(r.Context(), userID, out, options); err != nil {
+		log.Printf("failed to export vault for user %d: %v", userID, err)
+		// Once the archive has started streaming, an error can only cut it
+		// short
+		if out.n == 0 {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "Failed to export cards", http.StatusInternalServerError)
+		}
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
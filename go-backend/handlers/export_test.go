@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"go-backend/models"
+	"go-backend/tests"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRewriteBacklinks(t *testing.T) {
+	filenames := map[string]string{"1/2a": "1_2a Note.md"}
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{"see [1/2a]", "see [1/2a](1_2a%20Note.md)"},
+		{"see [1/2a](https://example.com)", "see [1/2a](https://example.com)"},
+		{"see [3] and [1/2a].", "see [3] and [1/2a](1_2a%20Note.md)."},
+	}
+	for _, tt := range tests {
+		if got := rewriteBacklinks(tt.body, filenames); got != tt.expected {
+			t.Errorf("rewriteBacklinks(%q) = %q, want %q", tt.body, got, tt.expected)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	if got := sanitizeFilename(` a/b: c? `); got != "a_b_ c_" {
+		t.Errorf("wrong file name, got %q", got)
+	}
+	long := sanitizeFilename(strings.Repeat("a", 99) + "éé")
+	if !utf8.ValidString(long) || long != strings.Repeat("a", 99) {
+		t.Errorf("expected a long name to be cut before a split character, got %q", long)
+	}
+}
+
+func TestExportMarkdownRoute(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	_, err := s.CreateCard(1, models.EditCardParams{CardID: "900", Title: "Export parent", Body: "parent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.CreateCard(1, models.EditCardParams{CardID: "900/a", Title: "Export child", Body: "See [900] and [missing] #exported"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := tests.GenerateTestJWT(1)
+	req, err := http.NewRequest("GET", "/api/export/markdown", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(s.JwtMiddleware(s.ExportMarkdownRoute))
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("response is not a zip: %v", err)
+	}
+
+	var content string
+	for _, file := range archive.File {
+		if file.Name == "900_a Export child.md" {
+			f, _ := file.Open()
+			data, _ := io.ReadAll(f)
+			f.Close()
+			content = string(data)
+		}
+	}
+	if content == "" {
+		t.Fatalf("expected a file for the child card")
+	}
+
+	parts := strings.SplitN(content, "---\n", 3)
+	if len(parts) != 3 {
+		t.Fatalf("expected front matter, got %q", content)
+	}
+	var frontMatter cardFrontMatter
+	if err := yaml.Unmarshal([]byte(parts[1]), &frontMatter); err != nil {
+		t.Fatalf("front matter is not valid yaml: %v", err)
+	}
+	if frontMatter.CardID != "900/a" || frontMatter.Parent != "900" {
+		t.Errorf("wrong front matter, got card_id %q parent %q", frontMatter.CardID, frontMatter.Parent)
+	}
+	if len(frontMatter.Tags) != 1 || frontMatter.Tags[0] != "exported" {
+		t.Errorf("expected the card's tag in the front matter, got %v", frontMatter.Tags)
+	}
+	if !strings.Contains(parts[2], "[900](900%20Export%20parent.md) and [missing]") {
+		t.Errorf("expected backlinks to be rewritten, got %q", parts[2])
+	}
+}
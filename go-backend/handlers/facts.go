@@ -15,7 +15,7 @@ import (
 )
 
 // ExtractSaveCardFacts deletes and re-inserts facts for a given card.
-func (s *Handler) ExtractSaveCardFacts(userID int, cardPK int, facts []string) ([]models.Fact, error) {
+func (s *Handler) ExtractSaveCardFacts(ctx context.Context, userID int, cardPK int, facts []string) ([]models.Fact, error) {
 	var results []models.Fact
 
 	tx, _ := s.DB.Begin()
@@ -53,7 +53,7 @@ func (s *Handler) ExtractSaveCardFacts(userID int, cardPK int, facts []string) (
 		if fact == "" {
 			continue
 		}
-		embedding, err := llms.GetEmbedding1024(fact, false)
+		embedding, err := llms.GetEmbedding1024(ctx, fact, false)
 		if err != nil {
 			log.Printf("error generating embedding for fact: %v", err)
 			tx.Rollback()
@@ -124,7 +124,7 @@ func (s *Handler) ExtractSaveCardFacts(userID int, cardPK int, facts []string) (
 	}
 
 	// Call entity extraction on the saved facts
-	// if err := s.ExtractSaveFactEntities(userID, card, dbFacts); err != nil {
+	// if err := s.ExtractSaveFactEntities(ctx, userID, card, dbFacts); err != nil {
 	// 	log.Printf("error extracting entities from facts: %v", err)
 	// 	return err
 	// }
@@ -352,10 +352,10 @@ func (s *Handler) GetAllFacts(w http.ResponseWriter, r *http.Request) {
 }
 
 // ExtractSaveFactEntities runs entity extraction on facts and links them in entity_fact_junction
-func (s *Handler) ExtractSaveFactEntities(userID int, card models.Card, factObjs []models.Fact) error {
-	client := llms.NewDefaultClient(s.DB, userID)
+func (s *Handler) ExtractSaveFactEntities(ctx context.Context, userID int, card models.Card, factObjs []models.Fact) error {
+	client := llms.NewClientForTask(s.DB, userID, models.LLMTaskFactExtraction)
 
-	factEntities, err := llms.FindEntitiesBatch(client, factObjs)
+	factEntities, err := llms.FindEntitiesBatch(ctx, client, factObjs)
 	if err != nil {
 		log.Printf("find entities batch err %v", err)
 	}
@@ -367,7 +367,7 @@ func (s *Handler) ExtractSaveFactEntities(userID int, card models.Card, factObjs
 			// if err != nil {
 			// 	return err
 			// }
-			// entity, err = llms.CheckExistingEntities(client, similarEntities, entity)
+			// entity, err = llms.CheckExistingEntities(ctx, client, similarEntities, entity)
 			// if err != nil {
 			// 	log.Printf("error checking existing entities: %v", err)
 			// 	return err
@@ -424,6 +424,7 @@ func (s *Handler) ExtractSaveFactEntities(userID int, card models.Card, factObjs
 			}
 		}
 	}
+	s.reindexCardTypesense(userID, card.ID)
 	return nil
 }
 
@@ -696,6 +697,7 @@ func (s *Handler) upsertFactToTypesense(fact models.Fact, card models.PartialCar
 		"entity_pk":             -1,
 		"user_id":               fact.UserID,
 		"type":                  "fact",
+		"result_type":           "fact",
 		"title":                 fact.Fact,
 		"preview":               "",
 		"parent_id":             -1,
@@ -715,7 +717,7 @@ func (s *Handler) upsertFactToTypesense(fact models.Fact, card models.PartialCar
 }
 
 func (s *Handler) deleteFactTypesense(factPK int) {
-	if s.Server.Testing {
+	if s.Server.Testing || s.Server.TypesenseClient == nil {
 		return
 	}
 	collectionName := os.Getenv("TYPESENSE_COLLECTION")
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -46,7 +47,7 @@ func TestExtractSaveCardFacts_MultiCardFactPreserved(t *testing.T) {
                        VALUES ($1, 2, 1, FALSE, NOW(), NOW())`, factID)
 
 	// Call ExtractSaveCardFacts on card 1
-	_, err := s.ExtractSaveCardFacts(1, 1, []string{})
+	_, err := s.ExtractSaveCardFacts(context.Background(), 1, 1, []string{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -80,7 +81,7 @@ func TestExtractSaveCardFacts_SingleCardFactDeleted(t *testing.T) {
                        VALUES ($1, 1, 1, TRUE, NOW(), NOW())`, factID)
 
 	// Call ExtractSaveCardFacts on card 1
-	_, err := s.ExtractSaveCardFacts(1, 1, []string{})
+	_, err := s.ExtractSaveCardFacts(context.Background(), 1, 1, []string{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
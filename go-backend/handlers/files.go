@@ -207,7 +207,7 @@ func (s *Handler) EditFileMetadataRoute(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, err = s.queryFile(userID, filePK)
+	oldFile, err := s.queryFile(userID, filePK)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -219,6 +219,10 @@ func (s *Handler) EditFileMetadataRoute(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Failed to update file metadata", http.StatusInternalServerError)
 		return
 	}
+	if data.CardPK != oldFile.CardPK {
+		s.reindexCardTypesense(userID, oldFile.CardPK)
+		s.reindexCardTypesense(userID, data.CardPK)
+	}
 
 	file, err := s.queryFile(userID, filePK)
 	if err != nil {
@@ -232,6 +236,10 @@ func (s *Handler) EditFileMetadataRoute(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *Handler) userCanUploadFile(userID int, header *multipart.FileHeader) error {
+	return s.userCanStoreBytes(userID, header.Size)
+}
+
+func (s *Handler) userCanStoreBytes(userID int, size int64) error {
 	user, err := s.QueryUser(userID)
 	if err != nil {
 		return fmt.Errorf("unknown problem")
@@ -244,7 +252,7 @@ func (s *Handler) userCanUploadFile(userID int, header *multipart.FileHeader) er
 	if err != nil {
 		return err
 	}
-	if alreadyUploaded+int(header.Size) > user.MaxFileStorage {
+	if alreadyUploaded+int(size) > user.MaxFileStorage {
 		return fmt.Errorf("out of storage")
 	}
 	return nil
@@ -287,40 +295,47 @@ func (s *Handler) UploadFileRoute(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	tempFile, err := os.CreateTemp("/tmp", "upload-*.tmp")
+	newFile, err := s.storeFile(userID, cardPK, handler.Filename, handler.Header.Get("Content-Type"), file)
 	if err != nil {
-		http.Error(w, "Unable to create temp file", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer os.Remove(tempFile.Name())
+	output := models.UploadFileResponse{
+		Message: "File successfully uploaded",
+		File:    newFile,
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(output)
+}
 
-	// Write the file content to the temporary file
-	if _, err := file.Seek(0, 0); err != nil {
-		http.Error(w, "Unable to seek file", http.StatusInternalServerError)
-		return
+// storeFile uploads the contents of data to S3 and records it as a file of
+// the given card. A cardPK of -1 leaves the file unattached.
+func (s *Handler) storeFile(userID, cardPK int, name, contentType string, data io.Reader) (models.File, error) {
+	tempFile, err := os.CreateTemp("/tmp", "upload-*.tmp")
+	if err != nil {
+		return models.File{}, fmt.Errorf("Unable to create temp file")
 	}
-	if _, err := tempFile.ReadFrom(file); err != nil {
-		http.Error(w, "Unable to read file", http.StatusInternalServerError)
-		return
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	fileSize, err := io.Copy(tempFile, data)
+	if err != nil {
+		return models.File{}, fmt.Errorf("Unable to read file")
 	}
 	uuidKey := uuid.New().String()
 	s3Key := fmt.Sprintf("%s/%s", strconv.Itoa(userID), uuidKey)
 
 	s.uploadObject(s.Server.S3, s3Key, tempFile.Name())
 
-	fileSize, err := tempFile.Seek(0, io.SeekEnd)
-	if err != nil {
-		http.Error(w, "Unable to determine file size", http.StatusInternalServerError)
-		return
-	}
 	var lastInsertId int
 	query := `INSERT INTO files (name, user_id, type, path, filename,
 		size, card_pk, created_by, updated_by, updated_at) VALUES
 		($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW()) RETURNING id;`
 	err = s.DB.QueryRow(query,
-		handler.Filename,
+		name,
 		userID,
-		handler.Header.Get("Content-Type"),
+		contentType,
 		s3Key,
 		s3Key,
 		fileSize,
@@ -328,21 +343,11 @@ func (s *Handler) UploadFileRoute(w http.ResponseWriter, r *http.Request) {
 		userID,
 		userID).Scan(&lastInsertId)
 	if err != nil {
-		http.Error(w, "Unable to execute query", http.StatusInternalServerError)
-		return
-	}
-	newFile, err := s.queryFile(userID, lastInsertId)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	output := models.UploadFileResponse{
-		Message: "File successfully uploaded",
-		File:    newFile,
+		log.Printf("insert file err %v", err)
+		return models.File{}, fmt.Errorf("Unable to execute query")
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(output)
+	s.reindexCardTypesense(userID, cardPK)
+	return s.queryFile(userID, lastInsertId)
 }
 
 func (s *Handler) DownloadFileRoute(w http.ResponseWriter, r *http.Request) {
@@ -389,16 +394,12 @@ func (s *Handler) DeleteFileRoute(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	query := `UPDATE files SET is_deleted = true WHERE id = $1`
-	_, err = s.DB.Exec(query, cardPK)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	err = s.deleteObject(s.Server.S3, file.Path)
+	// The S3 object is kept until the file is purged from the trash
+	query := `UPDATE files SET is_deleted = true, deleted_at = NOW() WHERE id = $1`
+	_, err = s.DB.Exec(query, file.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
-		_, _ = s.DB.Exec(`UPDATE files SET is_deleted = false WHERE id = $1`, cardPK)
 		return
 	}
+	s.reindexCardTypesense(userID, file.CardPK)
 }
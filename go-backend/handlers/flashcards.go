@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-backend/models"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// fsrsWeights are the default FSRS v4 model parameters
+var fsrsWeights = [17]float64{
+	0.4, 0.6, 2.4, 5.8, 4.93, 0.94, 0.86, 0.01, 1.49,
+	0.14, 0.94, 2.18, 0.05, 0.34, 1.26, 0.29, 2.61,
+}
+
+const (
+	fsrsRequestRetention = 0.9
+	fsrsMaximumInterval  = 36500
+)
+
+// fsrsSchedule is the outcome of rating a flashcard
+type fsrsSchedule struct {
+	State       string
+	Stability   float64
+	Difficulty  float64
+	Reps        int
+	Lapses      int
+	ElapsedDays float64
+	Due         time.Time
+}
+
+func clampDifficulty(d float64) float64 {
+	return math.Min(math.Max(d, 1), 10)
+}
+
+func fsrsInitialStability(rating int) float64 {
+	return math.Max(fsrsWeights[rating-1], 0.1)
+}
+
+func fsrsInitialDifficulty(rating int) float64 {
+	return clampDifficulty(fsrsWeights[4] - fsrsWeights[5]*float64(rating-3))
+}
+
+func fsrsNextDifficulty(d float64, rating int) float64 {
+	next := d - fsrsWeights[6]*float64(rating-3)
+	// mean reversion towards the difficulty of a "good" first answer
+	return clampDifficulty(fsrsWeights[7]*fsrsInitialDifficulty(models.FlashcardRatingGood) + (1-fsrsWeights[7])*next)
+}
+
+func fsrsRetrievability(elapsedDays, stability float64) float64 {
+	if stability <= 0 {
+		return 0
+	}
+	return math.Pow(1+elapsedDays/(9*stability), -1)
+}
+
+func fsrsRecallStability(d, s, r float64, rating int) float64 {
+	hardPenalty := 1.0
+	if rating == models.FlashcardRatingHard {
+		hardPenalty = fsrsWeights[15]
+	}
+	easyBonus := 1.0
+	if rating == models.FlashcardRatingEasy {
+		easyBonus = fsrsWeights[16]
+	}
+	return s * (1 + math.Exp(fsrsWeights[8])*
+		(11-d)*
+		math.Pow(s, -fsrsWeights[9])*
+		(math.Exp(fsrsWeights[10]*(1-r))-1)*
+		hardPenalty*
+		easyBonus)
+}
+
+func fsrsForgetStability(d, s, r float64) float64 {
+	return fsrsWeights[11] *
+		math.Pow(d, -fsrsWeights[12]) *
+		(math.Pow(s+1, fsrsWeights[13]) - 1) *
+		math.Exp(fsrsWeights[14]*(1-r))
+}
+
+// fsrsInterval converts a stability into the number of days until the
+// probability of recall drops to the requested retention
+func fsrsInterval(stability float64) int {
+	interval := int(math.Round(9 * stability * (1/fsrsRequestRetention - 1)))
+	if interval < 1 {
+		interval = 1
+	}
+	if interval > fsrsMaximumInterval {
+		interval = fsrsMaximumInterval
+	}
+	return interval
+}
+
+// scheduleFlashcard applies a rating to a flashcard and returns its new
+// scheduling state, following the FSRS algorithm
+func scheduleFlashcard(card models.Flashcard, rating int, now time.Time) fsrsSchedule {
+	result := fsrsSchedule{
+		State:      card.State,
+		Stability:  card.Stability,
+		Difficulty: card.Difficulty,
+		Reps:       card.Reps + 1,
+		Lapses:     card.Lapses,
+	}
+	if card.LastReview != nil {
+		result.ElapsedDays = math.Max(now.Sub(*card.LastReview).Hours()/24, 0)
+	}
+
+	switch card.State {
+	case "", models.FlashcardStateNew:
+		result.Stability = fsrsInitialStability(rating)
+		result.Difficulty = fsrsInitialDifficulty(rating)
+		switch rating {
+		case models.FlashcardRatingAgain:
+			result.State = models.FlashcardStateLearning
+			result.Due = now.Add(1 * time.Minute)
+		case models.FlashcardRatingHard:
+			result.State = models.FlashcardStateLearning
+			result.Due = now.Add(5 * time.Minute)
+		case models.FlashcardRatingGood:
+			result.State = models.FlashcardStateLearning
+			result.Due = now.Add(10 * time.Minute)
+		default:
+			result.State = models.FlashcardStateReview
+			result.Due = now.AddDate(0, 0, fsrsInterval(result.Stability))
+		}
+	case models.FlashcardStateLearning, models.FlashcardStateRelearning:
+		result.Difficulty = fsrsNextDifficulty(card.Difficulty, rating)
+		switch rating {
+		case models.FlashcardRatingAgain:
+			result.Due = now.Add(5 * time.Minute)
+		case models.FlashcardRatingHard:
+			result.Due = now.Add(10 * time.Minute)
+		default:
+			result.State = models.FlashcardStateReview
+			result.Due = now.AddDate(0, 0, fsrsInterval(result.Stability))
+		}
+	default:
+		r := fsrsRetrievability(result.ElapsedDays, card.Stability)
+		result.Difficulty = fsrsNextDifficulty(card.Difficulty, rating)
+		if rating == models.FlashcardRatingAgain {
+			result.Lapses++
+			result.State = models.FlashcardStateRelearning
+			result.Stability = fsrsForgetStability(card.Difficulty, card.Stability, r)
+			result.Due = now.Add(10 * time.Minute)
+		} else {
+			result.State = models.FlashcardStateReview
+			result.Stability = fsrsRecallStability(card.Difficulty, card.Stability, r, rating)
+			result.Due = now.AddDate(0, 0, fsrsInterval(result.Stability))
+		}
+	}
+	return result
+}
+
+func scanFlashcard(scanner interface{ Scan(...interface{}) error }) (models.Flashcard, error) {
+	var flashcard models.Flashcard
+	var state sql.NullString
+	err := scanner.Scan(
+		&flashcard.Card.ID,
+		&flashcard.Card.CardID,
+		&flashcard.Card.UserID,
+		&flashcard.Card.Title,
+		&flashcard.Body,
+		&flashcard.Card.ParentID,
+		&flashcard.Card.CreatedAt,
+		&flashcard.Card.UpdatedAt,
+		&state,
+		&flashcard.Reps,
+		&flashcard.Lapses,
+		&flashcard.Stability,
+		&flashcard.Difficulty,
+		&flashcard.LastReview,
+		&flashcard.Due,
+	)
+	if state.Valid {
+		flashcard.State = state.String
+	} else {
+		flashcard.State = models.FlashcardStateNew
+	}
+	return flashcard, err
+}
+
+const flashcardColumns = `
+	id, card_id, user_id, title, body, parent_id, created_at, updated_at,
+	flashcard_state, COALESCE(flashcard_reps, 0), COALESCE(flashcard_lapses, 0),
+	COALESCE(flashcard_stability, 0), COALESCE(flashcard_difficulty, 0),
+	flashcard_last_review, flashcard_due
+`
+
+func (s *Handler) QueryFlashcard(userID int, cardPK int) (models.Flashcard, error) {
+	return queryFlashcard(s.DB, userID, cardPK, "")
+}
+
+// queryFlashcard loads a flashcard, with lock, such as FOR UPDATE, added to
+// the query
+func queryFlashcard(db cardQueryer, userID int, cardPK int, lock string) (models.Flashcard, error) {
+	row := db.QueryRow(`
+	SELECT `+flashcardColumns+`
+	FROM cards
+	WHERE id = $1 AND user_id = $2 AND is_deleted = FALSE AND is_flashcard = TRUE
+	`+lock, cardPK, userID)
+	flashcard, err := scanFlashcard(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Flashcard{}, fmt.Errorf("flashcard not found")
+		}
+		log.Printf("query flashcard err %v", err)
+		return models.Flashcard{}, fmt.Errorf("unable to access flashcard")
+	}
+	return flashcard, nil
+}
+
+// QueryDueFlashcards returns the user's flashcards that are due for review,
+// most overdue first. New cards that have never been scheduled are included.
+func (s *Handler) QueryDueFlashcards(userID int, limit int) ([]models.Flashcard, error) {
+	rows, err := s.DB.Query(`
+	SELECT `+flashcardColumns+`
+	FROM cards
+	WHERE user_id = $1 AND is_deleted = FALSE AND is_flashcard = TRUE
+	AND (flashcard_due IS NULL OR flashcard_due <= NOW())
+	ORDER BY flashcard_due ASC NULLS LAST, id ASC
+	LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		log.Printf("query due flashcards err %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	flashcards := []models.Flashcard{}
+	for rows.Next() {
+		flashcard, err := scanFlashcard(rows)
+		if err != nil {
+			log.Printf("scan flashcard err %v", err)
+			return nil, err
+		}
+		flashcards = append(flashcards, flashcard)
+	}
+	return flashcards, rows.Err()
+}
+
+// SetFlashcard marks or unmarks a card as a flashcard. Marking a card that
+// is already a flashcard keeps its scheduling history.
+func (s *Handler) SetFlashcard(userID int, cardPK int, isFlashcard bool) error {
+	var query string
+	if isFlashcard {
+		query = `
+		UPDATE cards SET
+			is_flashcard = TRUE,
+			flashcard_state = COALESCE(flashcard_state, 'new'),
+			flashcard_due = COALESCE(flashcard_due, NOW())
+		WHERE id = $1 AND user_id = $2 AND is_deleted = FALSE
+		`
+	} else {
+		query = `
+		UPDATE cards SET is_flashcard = FALSE
+		WHERE id = $1 AND user_id = $2 AND is_deleted = FALSE
+		`
+	}
+	result, err := s.DB.Exec(query, cardPK, userID)
+	if err != nil {
+		log.Printf("set flashcard err %v", err)
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("card not found")
+	}
+	return nil
+}
+
+// ReviewFlashcard records a review and reschedules the flashcard
+func (s *Handler) ReviewFlashcard(userID int, cardPK int, rating int) (models.Flashcard, error) {
+	if rating < models.FlashcardRatingAgain || rating > models.FlashcardRatingEasy {
+		return models.Flashcard{}, fmt.Errorf("rating must be between 1 and 4")
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.Flashcard{}, err
+	}
+	// The row stays locked until the review is saved, so concurrent reviews
+	// of the card are scheduled one after the other
+	flashcard, err := queryFlashcard(tx, userID, cardPK, "FOR UPDATE")
+	if err != nil {
+		tx.Rollback()
+		return models.Flashcard{}, err
+	}
+
+	now := time.Now()
+	schedule := scheduleFlashcard(flashcard, rating, now)
+
+	_, err = tx.Exec(`
+	UPDATE cards SET
+		flashcard_state = $1,
+		flashcard_reps = $2,
+		flashcard_lapses = $3,
+		flashcard_stability = $4,
+		flashcard_difficulty = $5,
+		flashcard_last_review = $6,
+		flashcard_due = $7
+	WHERE id = $8 AND user_id = $9
+	`, schedule.State, schedule.Reps, schedule.Lapses, schedule.Stability, schedule.Difficulty,
+		now, schedule.Due, cardPK, userID)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("update flashcard err %v", err)
+		return models.Flashcard{}, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO flashcard_reviews
+	(card_pk, user_id, rating, state, stability, difficulty, elapsed_days, due, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, cardPK, userID, rating, schedule.State, schedule.Stability, schedule.Difficulty,
+		schedule.ElapsedDays, schedule.Due, now)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("insert flashcard review err %v", err)
+		return models.Flashcard{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Flashcard{}, err
+	}
+
+	return s.QueryFlashcard(userID, cardPK)
+}
+
+// MarkFlashcardRoute adds a card to the user's review deck
+func (s *Handler) MarkFlashcardRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.SetFlashcard(userID, id, true); err != nil {
+		if err.Error() == "card not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to mark flashcard", http.StatusInternalServerError)
+		return
+	}
+
+	flashcard, err := s.QueryFlashcard(userID, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flashcard)
+}
+
+// UnmarkFlashcardRoute removes a card from the user's review deck
+func (s *Handler) UnmarkFlashcardRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.SetFlashcard(userID, id, false); err != nil {
+		if err.Error() == "card not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to unmark flashcard", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetDueFlashcardsRoute returns the review queue for the current user
+func (s *Handler) GetDueFlashcardsRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	flashcards, err := s.QueryDueFlashcards(userID, limit)
+	if err != nil {
+		http.Error(w, "Failed to retrieve flashcards", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flashcards)
+}
+
+// ReviewFlashcardRoute records a rating for a flashcard and returns its new schedule
+func (s *Handler) ReviewFlashcardRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	var params models.ReviewFlashcardParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	flashcard, err := s.ReviewFlashcard(userID, id, params.Rating)
+	if err != nil {
+		switch err.Error() {
+		case "flashcard not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "rating must be between 1 and 4":
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flashcard)
+}
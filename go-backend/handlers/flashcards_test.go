@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestScheduleFlashcardNewCard(t *testing.T) {
+	now := time.Now()
+	card := models.Flashcard{State: models.FlashcardStateNew}
+
+	result := scheduleFlashcard(card, models.FlashcardRatingAgain, now)
+	if result.State != models.FlashcardStateLearning {
+		t.Errorf("wrong state, got %v want %v", result.State, models.FlashcardStateLearning)
+	}
+	if !result.Due.Equal(now.Add(time.Minute)) {
+		t.Errorf("wrong due date, got %v want %v", result.Due, now.Add(time.Minute))
+	}
+	if result.Reps != 1 {
+		t.Errorf("wrong reps, got %v want %v", result.Reps, 1)
+	}
+
+	result = scheduleFlashcard(card, models.FlashcardRatingEasy, now)
+	if result.State != models.FlashcardStateReview {
+		t.Errorf("wrong state, got %v want %v", result.State, models.FlashcardStateReview)
+	}
+	if !result.Due.After(now.Add(24 * time.Hour)) {
+		t.Errorf("easy card should be due in more than a day, got %v", result.Due)
+	}
+}
+
+func TestScheduleFlashcardReview(t *testing.T) {
+	now := time.Now()
+	lastReview := now.AddDate(0, 0, -10)
+	card := models.Flashcard{
+		State:      models.FlashcardStateReview,
+		Stability:  10,
+		Difficulty: 5,
+		Reps:       3,
+		LastReview: &lastReview,
+	}
+
+	good := scheduleFlashcard(card, models.FlashcardRatingGood, now)
+	if good.Stability <= card.Stability {
+		t.Errorf("stability should grow after a successful review, got %v", good.Stability)
+	}
+	if good.State != models.FlashcardStateReview {
+		t.Errorf("wrong state, got %v want %v", good.State, models.FlashcardStateReview)
+	}
+
+	again := scheduleFlashcard(card, models.FlashcardRatingAgain, now)
+	if again.Stability >= card.Stability {
+		t.Errorf("stability should shrink after a lapse, got %v", again.Stability)
+	}
+	if again.Lapses != 1 {
+		t.Errorf("wrong lapses, got %v want %v", again.Lapses, 1)
+	}
+	if again.State != models.FlashcardStateRelearning {
+		t.Errorf("wrong state, got %v want %v", again.State, models.FlashcardStateRelearning)
+	}
+
+	easy := scheduleFlashcard(card, models.FlashcardRatingEasy, now)
+	if !easy.Due.After(good.Due) {
+		t.Errorf("easy should be scheduled after good, got %v and %v", easy.Due, good.Due)
+	}
+}
+
+func TestFlashcardReviewFlow(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	if err := s.SetFlashcard(1, 1, true); err != nil {
+		t.Fatalf("failed to mark flashcard: %v", err)
+	}
+
+	due, err := s.QueryDueFlashcards(1, 50)
+	if err != nil {
+		t.Fatalf("failed to query due flashcards: %v", err)
+	}
+	if len(due) != 1 || due[0].Card.ID != 1 {
+		t.Fatalf("expected card 1 to be due, got %v", due)
+	}
+
+	flashcard, err := s.ReviewFlashcard(1, 1, models.FlashcardRatingEasy)
+	if err != nil {
+		t.Fatalf("failed to review flashcard: %v", err)
+	}
+	if flashcard.Reps != 1 {
+		t.Errorf("wrong reps, got %v want %v", flashcard.Reps, 1)
+	}
+	if flashcard.Due == nil || !flashcard.Due.After(time.Now()) {
+		t.Errorf("flashcard should be scheduled in the future, got %v", flashcard.Due)
+	}
+
+	due, _ = s.QueryDueFlashcards(1, 50)
+	if len(due) != 0 {
+		t.Errorf("expected no due flashcards, got %v", len(due))
+	}
+
+	var reviewCount int
+	_ = s.DB.QueryRow("SELECT count(*) FROM flashcard_reviews WHERE card_pk = 1").Scan(&reviewCount)
+	if reviewCount != 1 {
+		t.Errorf("wrong number of reviews, got %v want %v", reviewCount, 1)
+	}
+
+	if _, err := s.ReviewFlashcard(1, 1, 7); err == nil {
+		t.Errorf("expected an error for an invalid rating")
+	}
+}
+
+func TestReviewFlashcardRouteNotFlashcard(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	token, _ := tests.GenerateTestJWT(1)
+	body := tests.CreateJsonBody(t, models.ReviewFlashcardParams{Rating: 3})
+	req, err := http.NewRequest("POST", "/api/flashcards/"+strconv.Itoa(2)+"/review", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/flashcards/{id}/review", s.JwtMiddleware(s.ReviewFlashcardRoute))
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
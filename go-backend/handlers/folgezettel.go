@@ -0,0 +1,597 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// cardIDLockClass namespaces the advisory locks that serialize changes to a
+// user's card_ids
+const cardIDLockClass = 0x466f6c67
+
+// lockCardIDs holds the user's card_ids until tx ends, so that two clients
+// allocating or moving at once do not hand out the same card_id
+func lockCardIDs(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1, $2)", cardIDLockClass, userID)
+	return err
+}
+
+// cardQueryer is what the allocator needs of a *sql.DB or *sql.Tx
+type cardQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func splitCardID(cardID string) []string {
+	return strings.FieldsFunc(cardID, func(r rune) bool { return r == '/' || r == '.' })
+}
+
+// childSeparator returns the separator between a card_id and the ids of its
+// children. Folgezettel alternate between / and . at each level, the way
+// getParentIdAlternating reads them.
+func childSeparator(cardID string) string {
+	if len(splitCardID(cardID))%2 == 1 {
+		return "/"
+	}
+	return "."
+}
+
+// childSegment returns the part of cardID below parentID, if cardID is a
+// direct child of it
+func childSegment(cardID, parentID string) (string, bool) {
+	prefix := parentID + childSeparator(parentID)
+	if !strings.HasPrefix(cardID, prefix) {
+		return "", false
+	}
+	segment := cardID[len(prefix):]
+	if segment == "" || strings.ContainsAny(segment, "/.") {
+		return "", false
+	}
+	return segment, true
+}
+
+func isNumericSegment(segment string) bool {
+	for _, r := range segment {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return segment != ""
+}
+
+// segmentLess orders the segments of sibling card_ids the way the cards are
+// listed: numbers by value, before letters, which sort alphabetically
+func segmentLess(a, b string) bool {
+	aNumeric, bNumeric := isNumericSegment(a), isNumericSegment(b)
+	if aNumeric && bNumeric {
+		an, _ := strconv.Atoi(a)
+		bn, _ := strconv.Atoi(b)
+		return an < bn
+	}
+	if aNumeric != bNumeric {
+		return aNumeric
+	}
+	return a < b
+}
+
+// firstChildSegment starts the children of a card. Numbers and letters
+// alternate, so 12 is followed by 12/A and 12/A by 12/A.1. A child always
+// gets a separator: Luhmann's 12/3a would be read by getParentIdAlternating
+// as a sibling of 12/3, so its first child is 12/3.A instead.
+func firstChildSegment(parentID string) string {
+	parts := splitCardID(parentID)
+	if isNumericSegment(parts[len(parts)-1]) {
+		return "A"
+	}
+	return "1"
+}
+
+// nextSegment returns the segment that follows segment among its siblings:
+// 3 is followed by 4, B by C and Z by ZA
+func nextSegment(segment string) string {
+	if isNumericSegment(segment) {
+		n, _ := strconv.Atoi(segment)
+		return strconv.Itoa(n + 1)
+	}
+	last := segment[len(segment)-1]
+	switch {
+	case last >= 'a' && last < 'z', last >= 'A' && last < 'Z':
+		return segment[:len(segment)-1] + string(last+1)
+	case last == 'z':
+		return segment + "a"
+	}
+	return segment + "A"
+}
+
+// segmentBetween finds a segment that sorts after a and before b
+func segmentBetween(a, b string) (string, bool) {
+	if isNumericSegment(a) != isNumericSegment(b) {
+		return "", false
+	}
+	if candidate := nextSegment(a); segmentLess(candidate, b) {
+		return candidate, true
+	}
+	if isNumericSegment(a) {
+		return "", false
+	}
+	// Letters can always branch further, as in Luhmann's 21/3a
+	candidate := a + "A"
+	if last := a[len(a)-1]; last >= 'a' && last <= 'z' {
+		candidate = a + "a"
+	}
+	if segmentLess(candidate, b) {
+		return candidate, true
+	}
+	return "", false
+}
+
+// nextChildCardID returns the card_id after the last child of parentID among
+// cardIDs
+func nextChildCardID(parentID string, cardIDs []string) string {
+	last := ""
+	for _, cardID := range cardIDs {
+		if segment, ok := childSegment(cardID, parentID); ok && (last == "" || segmentLess(last, segment)) {
+			last = segment
+		}
+	}
+	if last == "" {
+		return parentID + childSeparator(parentID) + firstChildSegment(parentID)
+	}
+	return parentID + childSeparator(parentID) + nextSegment(last)
+}
+
+// cardIDBetween returns a card_id that sorts between two siblings, and
+// before any other sibling that sorts between them
+func cardIDBetween(after, before string, cardIDs []string) (string, error) {
+	parentID := getParentIdAlternating(after)
+	if parentID == after || parentID != getParentIdAlternating(before) {
+		return "", fmt.Errorf("cards are not siblings")
+	}
+	a, _ := childSegment(after, parentID)
+	b, _ := childSegment(before, parentID)
+	if segmentLess(b, a) {
+		a, b = b, a
+	}
+	for _, cardID := range cardIDs {
+		if segment, ok := childSegment(cardID, parentID); ok && segmentLess(a, segment) && segmentLess(segment, b) {
+			b = segment
+		}
+	}
+	segment, ok := segmentBetween(a, b)
+	if !ok {
+		return "", fmt.Errorf("no free card_id between the siblings")
+	}
+	return parentID + childSeparator(parentID) + segment, nil
+}
+
+// cardIDLess orders card_ids the way the cards are read, segment by
+// segment, so that 12/A.2 comes before 12/A.10
+func cardIDLess(a, b string) bool {
+	aParts, bParts := splitCardID(a), splitCardID(b)
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		if aParts[i] != bParts[i] {
+			return segmentLess(aParts[i], bParts[i])
+		}
+	}
+	return len(aParts) < len(bParts)
+}
+
+// cardIDAllocator hands out card_ids that are not yet taken
+type cardIDAllocator struct {
+	taken    map[string]bool
+	cardIDs  []string
+	nextRoot int
+}
+
+func newCardIDAllocator(taken map[string]bool, nextRoot int) *cardIDAllocator {
+	a := &cardIDAllocator{taken: taken, nextRoot: nextRoot}
+	for cardID := range taken {
+		a.cardIDs = append(a.cardIDs, cardID)
+	}
+	return a
+}
+
+func (a *cardIDAllocator) next(parentID string) string {
+	var cardID string
+	if parentID == "" {
+		for a.taken[strconv.Itoa(a.nextRoot)] {
+			a.nextRoot++
+		}
+		cardID = strconv.Itoa(a.nextRoot)
+	} else {
+		cardID = nextChildCardID(parentID, a.cardIDs)
+	}
+	a.taken[cardID] = true
+	a.cardIDs = append(a.cardIDs, cardID)
+	return cardID
+}
+
+// queryFamilyCardIDs returns the card_ids below parentID, including those of
+// cards in the trash, which may still be restored
+func queryFamilyCardIDs(db cardQueryer, userID int, parentID string) ([]string, error) {
+	prefix := parentID + childSeparator(parentID)
+	rows, err := db.Query(`
+	SELECT card_id FROM cards
+	WHERE user_id = $1 AND left(card_id, length($2)) = $2
+	`, userID, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cardIDs []string
+	for rows.Next() {
+		var cardID string
+		if err := rows.Scan(&cardID); err != nil {
+			return nil, err
+		}
+		cardIDs = append(cardIDs, cardID)
+	}
+	return cardIDs, rows.Err()
+}
+
+// allocateCardID returns a free card_id for the allocation. Children are
+// separated from their parent, alternating / and ., so the first child of
+// 12/3 is 12/3.A and its next sibling 12/3.B. Callers that create the card
+// should hold lockCardIDs in the same transaction.
+func allocateCardID(db cardQueryer, userID int, allocation models.CardIDAllocation) (string, error) {
+	// requireCard returns "card not found" unless the user has cardID
+	requireCard := func(cardID string) error {
+		if cardID == "" {
+			return fmt.Errorf("card not found")
+		}
+		var count int
+		err := db.QueryRow(`
+		SELECT count(*) FROM cards WHERE user_id = $1 AND card_id = $2 AND is_deleted = FALSE
+		`, userID, cardID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("card not found")
+		}
+		return nil
+	}
+
+	switch allocation.Mode {
+	case models.AllocateRoot:
+		return nextRootCardID(db, userID), nil
+	case models.AllocateChild:
+		if err := requireCard(allocation.CardID); err != nil {
+			return "", err
+		}
+		cardIDs, err := queryFamilyCardIDs(db, userID, allocation.CardID)
+		if err != nil {
+			return "", err
+		}
+		return nextChildCardID(allocation.CardID, cardIDs), nil
+	case models.AllocateSibling:
+		if err := requireCard(allocation.CardID); err != nil {
+			return "", err
+		}
+		parentID := getParentIdAlternating(allocation.CardID)
+		if parentID == allocation.CardID {
+			return nextRootCardID(db, userID), nil
+		}
+		cardIDs, err := queryFamilyCardIDs(db, userID, parentID)
+		if err != nil {
+			return "", err
+		}
+		return nextChildCardID(parentID, cardIDs), nil
+	case models.AllocateBetween:
+		if err := requireCard(allocation.CardID); err != nil {
+			return "", err
+		}
+		if err := requireCard(allocation.Before); err != nil {
+			return "", err
+		}
+		parentID := getParentIdAlternating(allocation.CardID)
+		cardIDs, err := queryFamilyCardIDs(db, userID, parentID)
+		if err != nil {
+			return "", err
+		}
+		return cardIDBetween(allocation.CardID, allocation.Before, cardIDs)
+	}
+	return "", fmt.Errorf("unknown allocation mode")
+}
+
+// GetNextCardIDRoute previews the card_id an allocation would give, such as
+// 12/3.A for the first child of 12/3. Use the allocate field of a new card to
+// claim one.
+func (s *Handler) GetNextCardIDRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	query := r.URL.Query()
+	allocation := models.CardIDAllocation{
+		Mode:   query.Get("mode"),
+		CardID: query.Get("card_id"),
+		Before: query.Get("before"),
+	}
+
+	nextID, err := allocateCardID(s.DB, userID, allocation)
+	if err != nil {
+		writeAllocationError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.NextIDResponse{NextID: nextID})
+}
+
+func writeAllocationError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "card not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "unknown allocation mode", "cards are not siblings":
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case "no free card_id between the siblings":
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("allocate card_id error: %v", err)
+		http.Error(w, "Unable to allocate card_id", http.StatusInternalServerError)
+	}
+}
+
+// renumberCardID gives a card of a moved subtree its new card_id. The part
+// of cardID below oldRoot is rebuilt under newRoot, so separators keep
+// alternating when the subtree changes depth.
+func renumberCardID(cardID, oldRoot, newRoot string) string {
+	newID := newRoot
+	for _, part := range strings.FieldsFunc(cardID[len(oldRoot):], func(r rune) bool { return r == '/' || r == '.' }) {
+		newID += childSeparator(newID) + part
+	}
+	return newID
+}
+
+// isInSubtree reports whether cardID is root or one of its descendants
+func isInSubtree(cardID, root string) bool {
+	return cardID == root || strings.HasPrefix(cardID, root+childSeparator(root))
+}
+
+// rewriteCardReferences replaces [card_id] references and ![card_id#heading]
+// transclusions of the renamed cards. Markdown links are left alone.
+func rewriteCardReferences(body string, renamed map[string]string) string {
+	var out strings.Builder
+	last := 0
+	for _, match := range backlinkPattern.FindAllStringSubmatchIndex(body, -1) {
+		if isMarkdownLink(body, match[1]) {
+			continue
+		}
+		idEnd := match[3]
+		if match[0] > 0 && body[match[0]-1] == '!' {
+			if i := strings.Index(body[match[2]:match[3]], "#"); i >= 0 {
+				idEnd = match[2] + i
+			}
+		}
+		newID, ok := renamed[body[match[2]:idEnd]]
+		if !ok {
+			continue
+		}
+		out.WriteString(body[last:match[2]])
+		out.WriteString(newID)
+		last = idEnd
+	}
+	out.WriteString(body[last:])
+	return out.String()
+}
+
+// MoveCard gives a card a new card_id along with every card below it, and
+// rewrites the references to them in the user's cards. It all happens in one
+// transaction, with an audit event for every card changed.
+func (s *Handler) MoveCard(userID, cardPK int, newID string) (models.MoveCardResult, error) {
+	newID = regexp.MustCompile(`\s+`).ReplaceAllString(newID, "")
+	if newID == "" {
+		return models.MoveCardResult{}, fmt.Errorf("card_id is required")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.MoveCardResult{}, err
+	}
+	defer tx.Rollback()
+	if err := lockCardIDs(tx, userID); err != nil {
+		return models.MoveCardResult{}, err
+	}
+
+	rows, err := tx.Query(`
+	SELECT id, card_id, user_id, title, body, link, parent_id, is_deleted
+	FROM cards WHERE user_id = $1
+	ORDER BY id
+	FOR UPDATE
+	`, userID)
+	if err != nil {
+		return models.MoveCardResult{}, err
+	}
+	var cards []models.Card
+	for rows.Next() {
+		var card models.Card
+		if err := rows.Scan(&card.ID, &card.CardID, &card.UserID, &card.Title, &card.Body, &card.Link, &card.ParentID, &card.IsDeleted); err != nil {
+			rows.Close()
+			return models.MoveCardResult{}, err
+		}
+		cards = append(cards, card)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return models.MoveCardResult{}, err
+	}
+
+	var oldID string
+	found := false
+	for _, card := range cards {
+		if card.ID == cardPK && !card.IsDeleted {
+			oldID, found = card.CardID, true
+		}
+	}
+	if !found {
+		return models.MoveCardResult{}, fmt.Errorf("card not found")
+	}
+	if newID == oldID {
+		return models.MoveCardResult{}, fmt.Errorf("card_id unchanged")
+	}
+	if oldID != "" && isInSubtree(newID, oldID) {
+		return models.MoveCardResult{}, fmt.Errorf("cannot move a card into its own subtree")
+	}
+
+	// renamed maps the old card_ids of the subtree to their new ones, and
+	// live maps every card_id to its card once the move is done
+	renamed := map[string]string{}
+	newIDs := map[int]string{}
+	for _, card := range cards {
+		if card.IsDeleted {
+			continue
+		}
+		if card.ID == cardPK || (oldID != "" && isInSubtree(card.CardID, oldID)) {
+			newIDs[card.ID] = renumberCardID(card.CardID, oldID, newID)
+			if card.CardID != "" {
+				renamed[card.CardID] = newIDs[card.ID]
+			}
+		}
+	}
+	live := map[string]int{}
+	for _, card := range cards {
+		if card.IsDeleted {
+			continue
+		}
+		cardID, moved := newIDs[card.ID]
+		if !moved {
+			cardID = card.CardID
+		}
+		if other, ok := live[cardID]; ok && cardID != "" {
+			if _, otherMoved := newIDs[other]; moved || otherMoved {
+				return models.MoveCardResult{}, fmt.Errorf("card_id already in use")
+			}
+		}
+		live[cardID] = card.ID
+	}
+
+	result := models.MoveCardResult{Moved: []models.MovedCard{}, Rewritten: []int{}}
+	var changed []models.Card
+	for _, card := range cards {
+		updated := card
+		updated.Body = rewriteCardReferences(card.Body, renamed)
+		if cardID, ok := newIDs[card.ID]; ok {
+			updated.CardID = cardID
+			updated.ParentID = card.ID
+			if parentID := getParentIdAlternating(cardID); parentID != cardID {
+				if parentPK, ok := live[parentID]; ok {
+					updated.ParentID = parentPK
+				}
+			}
+			result.Moved = append(result.Moved, models.MovedCard{ID: card.ID, From: card.CardID, To: cardID})
+		}
+		if updated.Body != card.Body {
+			result.Rewritten = append(result.Rewritten, card.ID)
+		}
+		if updated.CardID == card.CardID && updated.ParentID == card.ParentID && updated.Body == card.Body {
+			continue
+		}
+
+		_, err := tx.Exec(`
+		UPDATE cards SET card_id = $1, parent_id = $2, body = $3, updated_at = NOW()
+		WHERE id = $4
+		`, updated.CardID, updated.ParentID, updated.Body, card.ID)
+		if err != nil {
+			return models.MoveCardResult{}, fmt.Errorf("failed to update card %d: %w", card.ID, err)
+		}
+		err = createAuditEventTx(tx, userID, card.ID, "card", "move", card, updated, map[string]interface{}{
+			"moved_from": oldID,
+			"moved_to":   newID,
+		})
+		if err != nil {
+			return models.MoveCardResult{}, err
+		}
+		if !card.IsDeleted {
+			changed = append(changed, updated)
+		}
+	}
+
+	// Links are stored by primary key, so only cards whose references now
+	// resolve differently need their backlinks recorded again
+	for _, card := range cards {
+		if card.IsDeleted {
+			continue
+		}
+		body := rewriteCardReferences(card.Body, renamed)
+		relink := body != card.Body
+		for _, link := range extractBacklinks(body) {
+			if _, ok := newIDs[live[link.CardID]]; ok {
+				relink = true
+			}
+		}
+		if !relink {
+			continue
+		}
+		if err := relinkCardTx(tx, card.ID, body, live); err != nil {
+			return models.MoveCardResult{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.MoveCardResult{}, err
+	}
+	for _, card := range changed {
+		s.upsertCardToTypesense(card)
+	}
+	return result, nil
+}
+
+// relinkCardTx records the links from a card again, resolving card_ids
+// through live
+func relinkCardTx(tx *sql.Tx, cardPK int, body string, live map[string]int) error {
+	if _, err := tx.Exec("DELETE FROM backlinks WHERE source_id_int = $1", cardPK); err != nil {
+		return err
+	}
+	for _, link := range extractBacklinks(body) {
+		targetPK, ok := live[link.CardID]
+		if !ok {
+			continue
+		}
+		_, err := tx.Exec(`
+		INSERT INTO backlinks (source_id_int, target_id_int, link_type, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		`, cardPK, targetPK, link.LinkType)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Handler) MoveCardRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	cardPK, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+	var params models.MoveCardParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.MoveCard(userID, cardPK, params.CardID)
+	if err != nil {
+		switch err.Error() {
+		case "card not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "card_id is required", "card_id unchanged", "cannot move a card into its own subtree":
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case "card_id already in use":
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("move card error: %v", err)
+			http.Error(w, "Unable to move card", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestNextChildCardID(t *testing.T) {
+	cardIDs := []string{"12/A", "12/B", "12/B.1", "12/B.2", "12/B.10", "12/Z", "12/Z.1/A"}
+	cases := []struct {
+		parentID, expected string
+	}{
+		{"12", "12/ZA"},
+		{"12/B", "12/B.11"},
+		{"12/Z.1", "12/Z.1/B"},
+		{"7", "7/A"},
+		{"7/C", "7/C.1"},
+		// not 12/3a, which getParentIdAlternating reads as a child of 12
+		{"12/3", "12/3.A"},
+	}
+	for _, c := range cases {
+		if got := nextChildCardID(c.parentID, cardIDs); got != c.expected {
+			t.Errorf("nextChildCardID(%q) = %q, want %q", c.parentID, got, c.expected)
+		}
+	}
+}
+
+func TestCardIDBetween(t *testing.T) {
+	cardIDs := []string{"12/A", "12/C", "12/D", "12/D.3", "12/D.4", "12/D.7"}
+	cases := []struct {
+		after, before, expected string
+	}{
+		{"12/A", "12/C", "12/B"},
+		{"12/D", "12/C", "12/CA"},
+		{"12/D.4", "12/D.7", "12/D.5"},
+	}
+	for _, c := range cases {
+		got, err := cardIDBetween(c.after, c.before, cardIDs)
+		if err != nil || got != c.expected {
+			t.Errorf("cardIDBetween(%q, %q) = %q, %v, want %q", c.after, c.before, got, err, c.expected)
+		}
+	}
+	if _, err := cardIDBetween("12/D.3", "12/D.7", cardIDs); err == nil {
+		t.Errorf("expected no room after 12/D.3, which is followed by 12/D.4")
+	}
+	if _, err := cardIDBetween("12/A", "13/A", cardIDs); err == nil {
+		t.Errorf("expected cards of different parents to be rejected")
+	}
+}
+
+func TestAllocateCardID(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	for _, cardID := range []string{"500", "500/A", "500/B"} {
+		if _, err := s.CreateCard(1, models.EditCardParams{CardID: cardID, Title: cardID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, _ := tests.GenerateTestJWT(1)
+	req, _ := http.NewRequest("GET", "/api/cards/next-id?mode=child&card_id=500/A", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.JwtMiddleware(s.GetNextCardIDRoute)).ServeHTTP(rr, req)
+	var next models.NextIDResponse
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &next)
+	if next.NextID != "500/A.1" {
+		t.Errorf("wrong next child, got %q", next.NextID)
+	}
+
+	params := models.EditCardParams{
+		Title:    "Sibling",
+		Allocate: &models.CardIDAllocation{Mode: models.AllocateSibling, CardID: "500/A"},
+	}
+	for _, expected := range []string{"500/C", "500/D"} {
+		body, _ := json.Marshal(params)
+		req, _ = http.NewRequest("POST", "/api/cards", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(s.JwtMiddleware(s.CreateCardRoute)).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+		}
+		var card models.Card
+		tests.ParseJsonResponse(t, rr.Body.Bytes(), &card)
+		if card.CardID != expected {
+			t.Errorf("wrong allocated card_id, got %q want %q", card.CardID, expected)
+		}
+	}
+
+	params.Allocate = &models.CardIDAllocation{Mode: models.AllocateChild, CardID: "404"}
+	body, _ := json.Marshal(params)
+	req, _ = http.NewRequest("POST", "/api/cards", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(s.JwtMiddleware(s.CreateCardRoute)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("allocating below a missing card should fail, got %v", rr.Code)
+	}
+}
+
+func TestRenumberCardID(t *testing.T) {
+	cases := []struct {
+		cardID, oldRoot, newRoot, expected string
+	}{
+		{"1/2", "1/2", "3", "3"},
+		{"1/2.1", "1/2", "3", "3/1"},
+		{"1/2.1/4a", "1/2", "3", "3/1.4a"},
+		{"5/1", "5", "2/7", "2/7.1"},
+	}
+	for _, c := range cases {
+		if got := renumberCardID(c.cardID, c.oldRoot, c.newRoot); got != c.expected {
+			t.Errorf("renumberCardID(%q, %q, %q) = %q, want %q", c.cardID, c.oldRoot, c.newRoot, got, c.expected)
+		}
+	}
+}
+
+func TestRewriteCardReferences(t *testing.T) {
+	body := "See [1/2] and [1/2.1], not [1/2a] or [1/2](https://example.com)\n![1/2#Intro] ![1/2.1]"
+	got := rewriteCardReferences(body, map[string]string{"1/2": "3", "1/2.1": "3/1"})
+	expected := "See [3] and [3/1], not [1/2a] or [1/2](https://example.com)\n![3#Intro] ![3/1]"
+	if got != expected {
+		t.Errorf("wrong rewrite, got %q want %q", got, expected)
+	}
+}
+
+func TestMoveCardRoute(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	root, err := s.CreateCard(1, models.EditCardParams{CardID: "600", Title: "Root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := s.CreateCard(1, models.EditCardParams{CardID: "600/1", Title: "Child"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newParent, err := s.CreateCard(1, models.EditCardParams{CardID: "601", Title: "New parent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CreateCard(1, models.EditCardParams{CardID: "602", Title: "Taken"})
+	linking, err := s.CreateCard(1, models.EditCardParams{CardID: "603", Title: "Linking", Body: "See [600/1]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	move := func(cardID string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.MoveCardParams{CardID: cardID})
+		token, _ := tests.GenerateTestJWT(1)
+		req, _ := http.NewRequest("POST", "/api/cards/"+strconv.Itoa(root.ID)+"/move", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/api/cards/{id}/move", s.JwtMiddleware(s.MoveCardRoute))
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := move("602"); rr.Code != http.StatusConflict {
+		t.Errorf("moving onto a taken card_id should conflict, got %v", rr.Code)
+	}
+	if rr := move("600/1.2"); rr.Code != http.StatusBadRequest {
+		t.Errorf("moving into its own subtree should be rejected, got %v", rr.Code)
+	}
+
+	rr := move("601/3")
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+	var result models.MoveCardResult
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &result)
+	if len(result.Moved) != 2 || len(result.Rewritten) != 1 || result.Rewritten[0] != linking.ID {
+		t.Errorf("wrong move result, got %+v", result)
+	}
+
+	movedChild, _ := s.QueryFullCard(1, child.ID)
+	if movedChild.CardID != "601/3.1" || movedChild.ParentID != root.ID {
+		t.Errorf("expected the child to move with its parent, got %q under %d", movedChild.CardID, movedChild.ParentID)
+	}
+	movedRoot, _ := s.QueryFullCard(1, root.ID)
+	if movedRoot.ParentID != newParent.ID {
+		t.Errorf("expected the root to move under 601, got parent %d", movedRoot.ParentID)
+	}
+	linkingCard, _ := s.QueryFullCard(1, linking.ID)
+	if linkingCard.Body != "See [601/3.1]" {
+		t.Errorf("expected the reference to be rewritten, got %q", linkingCard.Body)
+	}
+	backlinks, _ := s.getBacklinks(1, "601/3.1")
+	if len(backlinks) != 1 || backlinks[0].CardID != "603" {
+		t.Errorf("expected the link to the moved card to be kept, got %v", backlinks)
+	}
+
+	events, _ := s.queryCardAuditEvents(child.ID)
+	if last := events[len(events)-1]; last.Action != "move" || last.Details.CustomData["moved_to"] != "601/3" {
+		t.Errorf("expected the move to be audited, got %+v", last)
+	}
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"go-backend/models"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxGraphDepth bounds how far a neighbourhood reaches from its node
+const maxGraphDepth = 5
+
+// maxFactLabel bounds the length of the labels of fact nodes
+const maxFactLabel = 80
+
+func graphNodeID(nodeType string, pk int) string {
+	return nodeType + ":" + strconv.Itoa(pk)
+}
+
+// QueryGraph loads the user's cards, entities and facts and the links
+// between them
+func (s *Handler) QueryGraph(userID int) (models.Graph, error) {
+	graph := models.Graph{Nodes: []models.GraphNode{}, Edges: []models.GraphEdge{}}
+
+	nodeQueries := []struct {
+		nodeType string
+		query    string
+	}{
+		{models.GraphNodeCard, `
+		SELECT id, COALESCE(title, ''), COALESCE(card_id, ''), '' FROM cards
+		WHERE user_id = $1 AND is_deleted = FALSE ORDER BY id`},
+		{models.GraphNodeEntity, `
+		SELECT id, COALESCE(name, ''), '', COALESCE(type, '') FROM entities
+		WHERE user_id = $1 ORDER BY id`},
+		{models.GraphNodeFact, `
+		SELECT id, fact, '', '' FROM facts
+		WHERE user_id = $1 ORDER BY id`},
+	}
+	for _, q := range nodeQueries {
+		rows, err := s.DB.Query(q.query, userID)
+		if err != nil {
+			return graph, fmt.Errorf("failed to load %s nodes: %w", q.nodeType, err)
+		}
+		for rows.Next() {
+			node := models.GraphNode{Type: q.nodeType}
+			if err := rows.Scan(&node.PK, &node.Label, &node.CardID, &node.EntityType); err != nil {
+				rows.Close()
+				return graph, err
+			}
+			node.ID = graphNodeID(q.nodeType, node.PK)
+			if q.nodeType == models.GraphNodeFact && len([]rune(node.Label)) > maxFactLabel {
+				node.Label = string([]rune(node.Label)[:maxFactLabel-1]) + "…"
+			}
+			graph.Nodes = append(graph.Nodes, node)
+		}
+		rows.Close()
+	}
+
+	edgeQueries := []struct {
+		edgeType, sourceType, targetType string
+		query                            string
+	}{
+		{models.GraphEdgeLink, models.GraphNodeCard, models.GraphNodeCard, `
+		SELECT DISTINCT b.source_id_int, b.target_id_int FROM backlinks b
+		JOIN cards src ON src.id = b.source_id_int
+		JOIN cards tgt ON tgt.id = b.target_id_int
+		WHERE src.user_id = $1 AND tgt.user_id = $1 AND src.is_deleted = FALSE AND tgt.is_deleted = FALSE
+		AND b.link_type = 'reference'`},
+		{models.GraphEdgeTransclusion, models.GraphNodeCard, models.GraphNodeCard, `
+		SELECT DISTINCT b.source_id_int, b.target_id_int FROM backlinks b
+		JOIN cards src ON src.id = b.source_id_int
+		JOIN cards tgt ON tgt.id = b.target_id_int
+		WHERE src.user_id = $1 AND tgt.user_id = $1 AND src.is_deleted = FALSE AND tgt.is_deleted = FALSE
+		AND b.link_type = 'transclusion'`},
+		{models.GraphEdgeParent, models.GraphNodeCard, models.GraphNodeCard, `
+		SELECT c.id, c.parent_id FROM cards c
+		JOIN cards p ON p.id = c.parent_id
+		WHERE c.user_id = $1 AND p.user_id = $1 AND c.id != c.parent_id
+		AND c.is_deleted = FALSE AND p.is_deleted = FALSE`},
+		{models.GraphEdgeEntityCard, models.GraphNodeEntity, models.GraphNodeCard, `
+		SELECT j.entity_id, j.card_pk FROM entity_card_junction j
+		JOIN cards c ON c.id = j.card_pk
+		WHERE j.user_id = $1 AND c.is_deleted = FALSE`},
+		{models.GraphEdgeEntityFact, models.GraphNodeEntity, models.GraphNodeFact, `
+		SELECT entity_id, fact_id FROM entity_fact_junction WHERE user_id = $1`},
+		{models.GraphEdgeFactCard, models.GraphNodeFact, models.GraphNodeCard, `
+		SELECT j.fact_id, j.card_pk FROM fact_card_junction j
+		JOIN cards c ON c.id = j.card_pk
+		WHERE j.user_id = $1 AND c.is_deleted = FALSE`},
+	}
+	nodes := map[string]bool{}
+	for _, node := range graph.Nodes {
+		nodes[node.ID] = true
+	}
+	for _, q := range edgeQueries {
+		rows, err := s.DB.Query(q.query, userID)
+		if err != nil {
+			return graph, fmt.Errorf("failed to load %s edges: %w", q.edgeType, err)
+		}
+		for rows.Next() {
+			var source, target int
+			if err := rows.Scan(&source, &target); err != nil {
+				rows.Close()
+				return graph, err
+			}
+			edge := models.GraphEdge{
+				Source: graphNodeID(q.sourceType, source),
+				Target: graphNodeID(q.targetType, target),
+				Type:   q.edgeType,
+			}
+			if nodes[edge.Source] && nodes[edge.Target] {
+				graph.Edges = append(graph.Edges, edge)
+			}
+		}
+		rows.Close()
+	}
+	return graph, nil
+}
+
+// graphNeighbourhood returns the part of graph within depth edges of the
+// node, following edges in either direction. It returns false if the node
+// is not in the graph.
+func graphNeighbourhood(graph models.Graph, nodeID string, depth int) (models.Graph, bool) {
+	adjacent := map[string][]string{}
+	for _, edge := range graph.Edges {
+		adjacent[edge.Source] = append(adjacent[edge.Source], edge.Target)
+		adjacent[edge.Target] = append(adjacent[edge.Target], edge.Source)
+	}
+	found := false
+	for _, node := range graph.Nodes {
+		if node.ID == nodeID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return models.Graph{}, false
+	}
+
+	reached := map[string]bool{nodeID: true}
+	frontier := []string{nodeID}
+	for i := 0; i < depth && len(frontier) > 0; i++ {
+		var next []string
+		for _, id := range frontier {
+			for _, neighbour := range adjacent[id] {
+				if !reached[neighbour] {
+					reached[neighbour] = true
+					next = append(next, neighbour)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	result := models.Graph{Nodes: []models.GraphNode{}, Edges: []models.GraphEdge{}}
+	for _, node := range graph.Nodes {
+		if reached[node.ID] {
+			result.Nodes = append(result.Nodes, node)
+		}
+	}
+	for _, edge := range graph.Edges {
+		if reached[edge.Source] && reached[edge.Target] {
+			result.Edges = append(result.Edges, edge)
+		}
+	}
+	return result, true
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// writeGraphML writes a graph in the GraphML format read by Gephi and yEd
+func writeGraphML(w io.Writer, graph models.Graph) error {
+	doc := graphMLDocument{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "label", For: "node", AttrName: "label", AttrType: "string"},
+			{ID: "type", For: "node", AttrName: "type", AttrType: "string"},
+			{ID: "card_id", For: "node", AttrName: "card_id", AttrType: "string"},
+			{ID: "entity_type", For: "node", AttrName: "entity_type", AttrType: "string"},
+			{ID: "edge_type", For: "edge", AttrName: "type", AttrType: "string"},
+		},
+	}
+	doc.Graph.ID = "zettelkasten"
+	doc.Graph.EdgeDefault = "directed"
+	for _, node := range graph.Nodes {
+		n := graphMLNode{ID: node.ID, Data: []graphMLData{
+			{Key: "label", Value: node.Label},
+			{Key: "type", Value: node.Type},
+		}}
+		if node.CardID != "" {
+			n.Data = append(n.Data, graphMLData{Key: "card_id", Value: node.CardID})
+		}
+		if node.EntityType != "" {
+			n.Data = append(n.Data, graphMLData{Key: "entity_type", Value: node.EntityType})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, n)
+	}
+	for _, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: edge.Source,
+			Target: edge.Target,
+			Data:   []graphMLData{{Key: "edge_type", Value: edge.Type}},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// dotString quotes a string for Graphviz
+func dotString(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", "")
+	return `"` + replacer.Replace(value) + `"`
+}
+
+var dotShapes = map[string]string{
+	models.GraphNodeCard:   "box",
+	models.GraphNodeEntity: "ellipse",
+	models.GraphNodeFact:   "note",
+}
+
+// writeDOT writes a graph in the Graphviz DOT language
+func writeDOT(w io.Writer, graph models.Graph) error {
+	var b strings.Builder
+	b.WriteString("digraph zettelkasten {\n")
+	for _, node := range graph.Nodes {
+		label := node.Label
+		if node.CardID != "" {
+			label = "[" + node.CardID + "] " + label
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, type=%s, shape=%s];\n",
+			dotString(node.ID), dotString(label), dotString(node.Type), dotShapes[node.Type])
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&b, "  %s -> %s [type=%s];\n", dotString(edge.Source), dotString(edge.Target), dotString(edge.Type))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeGraph sends a graph in the format asked for by the format query
+// parameter: json, graphml or dot
+func writeGraph(w http.ResponseWriter, r *http.Request, graph models.Graph, name string) {
+	var err error
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(graph)
+	case "graphml":
+		w.Header().Set("Content-Type", "application/graphml+xml")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.graphml"`, name))
+		err = writeGraphML(w, graph)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.dot"`, name))
+		err = writeDOT(w, graph)
+	default:
+		http.Error(w, "unknown format", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to write graph: %v", err)
+	}
+}
+
+// GetGraphRoute exports the whole of the user's graph
+func (s *Handler) GetGraphRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	graph, err := s.QueryGraph(userID)
+	if err != nil {
+		log.Printf("graph error: %v", err)
+		http.Error(w, "Unable to load graph", http.StatusInternalServerError)
+		return
+	}
+	writeGraph(w, r, graph, "zettelkasten")
+}
+
+// GetGraphNeighbourhoodRoute returns the graph around a card, entity or
+// fact, up to depth edges away
+func (s *Handler) GetGraphNeighbourhoodRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	nodeType := mux.Vars(r)["type"]
+	if _, ok := dotShapes[nodeType]; !ok {
+		http.Error(w, "unknown node type", http.StatusBadRequest)
+		return
+	}
+	pk, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	depth := 1
+	if value := r.URL.Query().Get("depth"); value != "" {
+		depth, err = strconv.Atoi(value)
+		if err != nil || depth < 0 || depth > maxGraphDepth {
+			http.Error(w, fmt.Sprintf("depth must be between 0 and %d", maxGraphDepth), http.StatusBadRequest)
+			return
+		}
+	}
+
+	graph, err := s.QueryGraph(userID)
+	if err != nil {
+		log.Printf("graph error: %v", err)
+		http.Error(w, "Unable to load graph", http.StatusInternalServerError)
+		return
+	}
+	neighbourhood, ok := graphNeighbourhood(graph, graphNodeID(nodeType, pk), depth)
+	if !ok {
+		http.Error(w, nodeType+" not found", http.StatusNotFound)
+		return
+	}
+	writeGraph(w, r, neighbourhood, nodeType+"-"+strconv.Itoa(pk))
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func testGraph() models.Graph {
+	return models.Graph{
+		Nodes: []models.GraphNode{
+			{ID: "card:1", Type: models.GraphNodeCard, PK: 1, Label: `Say "hi"`, CardID: "1"},
+			{ID: "card:2", Type: models.GraphNodeCard, PK: 2, Label: "Two", CardID: "1/A"},
+			{ID: "entity:3", Type: models.GraphNodeEntity, PK: 3, Label: "Luhmann", EntityType: "person"},
+			{ID: "card:4", Type: models.GraphNodeCard, PK: 4, Label: "Far", CardID: "4"},
+		},
+		Edges: []models.GraphEdge{
+			{Source: "card:2", Target: "card:1", Type: models.GraphEdgeParent},
+			{Source: "entity:3", Target: "card:2", Type: models.GraphEdgeEntityCard},
+			{Source: "card:4", Target: "card:2", Type: models.GraphEdgeLink},
+		},
+	}
+}
+
+func TestGraphNeighbourhood(t *testing.T) {
+	graph, ok := graphNeighbourhood(testGraph(), "card:1", 1)
+	if !ok || len(graph.Nodes) != 2 || len(graph.Edges) != 1 {
+		t.Errorf("expected the card and its child, got %+v", graph)
+	}
+	graph, _ = graphNeighbourhood(testGraph(), "card:1", 2)
+	if len(graph.Nodes) != 4 || len(graph.Edges) != 3 {
+		t.Errorf("expected everything within two edges, got %+v", graph)
+	}
+	if _, ok := graphNeighbourhood(testGraph(), "fact:9", 1); ok {
+		t.Errorf("expected a missing node not to be found")
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := writeGraphML(&buffer, testGraph()); err != nil {
+		t.Fatal(err)
+	}
+	var doc graphMLDocument
+	if err := xml.Unmarshal(buffer.Bytes(), &doc); err != nil {
+		t.Fatalf("expected valid XML, got %v", err)
+	}
+	if len(doc.Graph.Nodes) != 4 || len(doc.Graph.Edges) != 3 || doc.Graph.Nodes[0].Data[0].Value != `Say "hi"` {
+		t.Errorf("wrong GraphML, got %s", buffer.String())
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := writeDOT(&buffer, testGraph()); err != nil {
+		t.Fatal(err)
+	}
+	dot := buffer.String()
+	if !strings.Contains(dot, `"card:1" [label="[1] Say \"hi\"", type="card", shape=box];`) {
+		t.Errorf("expected labels to be escaped, got %s", dot)
+	}
+	if !strings.Contains(dot, `"entity:3" -> "card:2" [type="entity_card"];`) {
+		t.Errorf("expected typed edges, got %s", dot)
+	}
+}
+
+func TestGetGraphNeighbourhoodRoute(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	root, err := s.CreateCard(1, models.EditCardParams{CardID: "200", Title: "Root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CreateCard(1, models.EditCardParams{CardID: "200/A", Title: "Child"})
+	s.CreateCard(1, models.EditCardParams{CardID: "201", Title: "Linking", Body: "See [200]"})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		token, _ := tests.GenerateTestJWT(1)
+		req, _ := http.NewRequest("GET", "/api/graph/card/"+strconv.Itoa(root.ID)+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/api/graph/{type}/{id}", s.JwtMiddleware(s.GetGraphNeighbourhoodRoute))
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("?depth=1")
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+	var graph models.Graph
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &graph)
+	types := map[string]int{}
+	for _, edge := range graph.Edges {
+		types[edge.Type]++
+	}
+	if len(graph.Nodes) != 3 || types[models.GraphEdgeParent] != 1 || types[models.GraphEdgeLink] != 1 {
+		t.Errorf("expected the child and the linking card, got %+v", graph)
+	}
+
+	rr = get("?format=dot")
+	if rr.Header().Get("Content-Type") != "text/vnd.graphviz" || !strings.HasPrefix(rr.Body.String(), "digraph") {
+		t.Errorf("expected a DOT export, got %s", rr.Body.String())
+	}
+	if rr = get("?depth=99"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected too deep a neighbourhood to be rejected, got %v", rr.Code)
+	}
+}
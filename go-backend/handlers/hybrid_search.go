@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"context"
+	"go-backend/llms"
+	"go-backend/models"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pgvector/pgvector-go"
+)
+
+const (
+	fusionRRF      = "rrf"
+	fusionWeighted = "weighted"
+
+	// rrfK damps the weight of the top ranks in reciprocal rank fusion, as
+	// in the original paper
+	rrfK = 60
+	// hybridCandidateLimit is how many cards semantic retrieval contributes
+	hybridCandidateLimit = 50
+	// defaultSemanticWeight balances the two retrievals in weighted fusion
+	defaultSemanticWeight = 0.5
+)
+
+// rankedCard is a card's score in one retrieval, best first
+type rankedCard struct {
+	CardPK int
+	Score  float64
+}
+
+// searchTerms are the words and phrases a query looks for, leaving out
+// those it excludes
+func searchTerms(q *SearchQuery) []string {
+	var terms []string
+	var walk func(q *SearchQuery)
+	walk = func(q *SearchQuery) {
+		if q == nil || q.Negate {
+			return
+		}
+		switch q.Kind {
+		case searchAnd, searchOr:
+			for _, child := range q.Children {
+				walk(child)
+			}
+		case searchTerm, searchPhrase:
+			terms = append(terms, q.Value)
+		}
+	}
+	walk(q)
+	return terms
+}
+
+// searchFilters is a query without its top level text clauses, leaving the
+// tags, entities and field filters that restrict which cards can match
+func searchFilters(q *SearchQuery) *SearchQuery {
+	var filters []*SearchQuery
+	for _, clause := range searchConjuncts(q) {
+		if clause.Kind != searchTerm && clause.Kind != searchPhrase {
+			filters = append(filters, clause)
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return joinSearchQueries(searchAnd, filters)
+}
+
+// keywordScore scores how well a card matches the terms of a query. An exact
+// card_id beats any amount of text, and a match in the title counts for
+// more than one in the body.
+func keywordScore(card models.Card, terms []string) float64 {
+	cardID := strings.ToLower(card.CardID)
+	title := strings.ToLower(card.Title)
+	body := strings.ToLower(card.Body)
+
+	score := 0.0
+	for _, term := range terms {
+		term = strings.ToLower(term)
+		if term == "" {
+			continue
+		}
+		switch {
+		case cardID == term:
+			score += 10
+		case strings.Contains(cardID, term):
+			score += 3
+		}
+		if strings.Contains(title, term) {
+			score += 2
+		}
+		score += math.Min(float64(strings.Count(body, term)), 5) * 0.2
+	}
+	return score
+}
+
+// rankKeywordResults orders the cards keyword search found by their score,
+// keeping the newest first among equals
+func rankKeywordResults(cards []models.Card, terms []string) []rankedCard {
+	ranked := make([]rankedCard, len(cards))
+	for i, card := range cards {
+		ranked[i] = rankedCard{CardPK: card.ID, Score: keywordScore(card, terms)}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked
+}
+
+// fuseRankings merges keyword and semantic rankings into one. Reciprocal
+// rank fusion sums 1/(k + rank) over the rankings a card is in, so only the
+// order of each matters. Weighted fusion instead mixes the scores, each
+// scaled to 0-1 by the best in its ranking, with semanticWeight going to the
+// semantic score.
+func fuseRankings(keyword, semantic []rankedCard, fusion string, semanticWeight float64) ([]int, map[int]*models.ScoreBreakdown) {
+	breakdowns := map[int]*models.ScoreBreakdown{}
+	var order []int
+	breakdown := func(cardPK int) *models.ScoreBreakdown {
+		if b, ok := breakdowns[cardPK]; ok {
+			return b
+		}
+		b := &models.ScoreBreakdown{Fusion: fusion}
+		breakdowns[cardPK] = b
+		order = append(order, cardPK)
+		return b
+	}
+
+	maxKeyword, maxSemantic := 0.0, 0.0
+	for _, r := range keyword {
+		maxKeyword = math.Max(maxKeyword, r.Score)
+	}
+	for _, r := range semantic {
+		maxSemantic = math.Max(maxSemantic, r.Score)
+	}
+
+	for i, r := range keyword {
+		b := breakdown(r.CardPK)
+		b.KeywordRank, b.KeywordScore = i+1, r.Score
+		if fusion == fusionWeighted {
+			if maxKeyword > 0 {
+				b.FusedScore += (1 - semanticWeight) * r.Score / maxKeyword
+			}
+		} else {
+			b.FusedScore += 1.0 / float64(rrfK+i+1)
+		}
+	}
+	for i, r := range semantic {
+		b := breakdown(r.CardPK)
+		b.SemanticRank, b.SemanticScore = i+1, r.Score
+		if fusion == fusionWeighted {
+			if maxSemantic > 0 {
+				b.FusedScore += semanticWeight * r.Score / maxSemantic
+			}
+		} else {
+			b.FusedScore += 1.0 / float64(rrfK+i+1)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return breakdowns[order[i]].FusedScore > breakdowns[order[j]].FusedScore
+	})
+	return order, breakdowns
+}
+
+// SemanticCardSearch ranks the user's cards by how close their closest
+// chunk is to the embedding, among the cards the filter lets through
+func (s *Handler) SemanticCardSearch(userID int, embedding pgvector.Vector, filter *SearchQuery, limit int) ([]rankedCard, error) {
+	rows, err := s.DB.Query(`
+		SELECT c.id, best.similarity
+		FROM (
+			SELECT ce.card_pk, 1 - MIN(ce.embedding_1024 <=> $2) AS similarity
+			FROM card_embeddings ce
+			WHERE ce.user_id = $1 AND ce.embedding_1024 IS NOT NULL
+			GROUP BY ce.card_pk
+		) best
+		JOIN cards c ON c.id = best.card_pk
+		WHERE c.user_id = $1 AND c.is_deleted = FALSE
+		`+buildCardSearchSQL(filter, false, time.Now())+`
+		ORDER BY best.similarity DESC, c.id
+		LIMIT $3
+	`, userID, embedding, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ranked []rankedCard
+	for rows.Next() {
+		var r rankedCard
+		if err := rows.Scan(&r.CardPK, &r.Score); err != nil {
+			return nil, err
+		}
+		ranked = append(ranked, r)
+	}
+	return ranked, nil
+}
+
+// queryCardsByID loads the user's cards with the given primary keys
+func (s *Handler) queryCardsByID(userID int, cardPKs []int) (map[int]models.Card, error) {
+	cards := map[int]models.Card{}
+	if len(cardPKs) == 0 {
+		return cards, nil
+	}
+	ids := make(pq.Int64Array, len(cardPKs))
+	for i, pk := range cardPKs {
+		ids[i] = int64(pk)
+	}
+	rows, err := s.DB.Query(`
+		SELECT c.id, c.card_id, c.user_id, c.title, c.body, c.link, c.parent_id, c.created_at, c.updated_at,
+		(SELECT COUNT(*) FROM card_tags ct WHERE ct.card_pk = c.id)
+		FROM cards c
+		WHERE c.user_id = $1 AND c.is_deleted = FALSE AND c.id = ANY($2)
+	`, userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scanned, err := models.ScanCards(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, card := range scanned {
+		cards[card.ID] = card
+	}
+	return cards, nil
+}
+
+// searchEmbedding embeds the text of a search
+func (s *Handler) searchEmbedding(ctx context.Context, text string) (pgvector.Vector, error) {
+	if s.Server.Testing {
+		dummy := make([]float32, 1024)
+		for i := range dummy {
+			dummy[i] = 1.0
+		}
+		return pgvector.NewVector(dummy), nil
+	}
+	return llms.GetEmbedding1024(ctx, text, true)
+}
+
+// HybridSearch runs keyword and semantic retrieval over cards side by side
+// and fuses the two rankings, so that exact card_ids and words are found
+// along with paraphrases of them. Each result's Metadata holds a
+// models.ScoreBreakdown under "scores". A query without text has nothing to
+// embed and falls back to keyword search alone.
+func (s *Handler) HybridSearch(ctx context.Context, searchParams SearchRequestParams, userID int) ([]models.SearchResult, error) {
+	query := ParseSearchQuery(searchParams.SearchTerm)
+	terms := searchTerms(query)
+
+	fusion := searchParams.Fusion
+	if fusion != fusionWeighted {
+		fusion = fusionRRF
+	}
+	semanticWeight := defaultSemanticWeight
+	if searchParams.SemanticWeight != nil {
+		semanticWeight = math.Max(0, math.Min(1, *searchParams.SemanticWeight))
+	}
+
+	var (
+		wg                      sync.WaitGroup
+		keywordCards            []models.Card
+		semantic                []rankedCard
+		keywordErr, semanticErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		keywordCards, keywordErr = s.ClassicCardSearch(userID, searchParams)
+	}()
+	if len(terms) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			embedding, err := s.searchEmbedding(ctx, strings.Join(terms, " "))
+			if err != nil {
+				semanticErr = err
+				return
+			}
+			semantic, semanticErr = s.SemanticCardSearch(userID, embedding, searchFilters(query), hybridCandidateLimit)
+		}()
+	}
+	wg.Wait()
+
+	if keywordErr != nil {
+		log.Printf("hybrid keyword error %v", keywordErr)
+		return nil, keywordErr
+	}
+	if isLLMQuotaError(semanticErr) {
+		return nil, semanticErr
+	}
+	if semanticErr != nil {
+		// keyword results are still worth returning without the semantic ones
+		log.Printf("hybrid semantic error %v", semanticErr)
+		semantic = nil
+	}
+
+	cards := map[int]models.Card{}
+	for _, card := range keywordCards {
+		cards[card.ID] = card
+	}
+	var missing []int
+	for _, r := range semantic {
+		if _, ok := cards[r.CardPK]; !ok {
+			missing = append(missing, r.CardPK)
+		}
+	}
+	loaded, err := s.queryCardsByID(userID, missing)
+	if err != nil {
+		return nil, err
+	}
+	for pk, card := range loaded {
+		cards[pk] = card
+	}
+
+	order, breakdowns := fuseRankings(rankKeywordResults(keywordCards, terms), semantic, fusion, semanticWeight)
+
+	var results []models.SearchResult
+	for _, pk := range order {
+		card, ok := cards[pk]
+		if !ok {
+			continue
+		}
+		var tags []models.Tag
+		if card.TagCount > 0 {
+			tags, _ = s.QueryTagsForCard(userID, card.ID)
+		}
+		results = append(results, models.SearchResult{
+			ID:        strconv.Itoa(card.ID),
+			Type:      "card",
+			Title:     card.Title,
+			Preview:   card.Body,
+			Score:     breakdowns[pk].FusedScore,
+			CreatedAt: card.CreatedAt,
+			UpdatedAt: card.UpdatedAt,
+			Tags:      tags,
+			CardID:    card.CardID,
+			Metadata: map[string]interface{}{
+				"id":        card.ID,
+				"card_id":   card.CardID,
+				"parent_id": card.ParentID,
+				"scores":    breakdowns[pk],
+			},
+		})
+	}
+
+	// Reranking is not deterministic, so pages are cut from the fused order
+	if s.Server.Testing || !searchParams.Rerank || pagedSearch(searchParams) || len(results) == 0 {
+		return results, nil
+	}
+	client := llms.NewClientForTask(s.DB, userID, models.LLMTaskSearch)
+	reranked, err := llms.RerankSearchResults(ctx, client, searchParams.SearchTerm, results)
+	if isLLMQuotaError(err) {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("reranking error %v", err)
+		return results, nil
+	}
+	return reranked, nil
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"go-backend/models"
+	"go-backend/tests"
+	"testing"
+)
+
+func TestFuseRankings(t *testing.T) {
+	keyword := []rankedCard{{CardPK: 1, Score: 10}, {CardPK: 2, Score: 2}}
+	semantic := []rankedCard{{CardPK: 3, Score: 0.9}, {CardPK: 2, Score: 0.8}}
+
+	order, breakdowns := fuseRankings(keyword, semantic, fusionRRF, 0)
+	if len(order) != 3 || order[0] != 2 {
+		t.Fatalf("expected the card found both ways to come first, got %v", order)
+	}
+	b := breakdowns[2]
+	if b.KeywordRank != 2 || b.SemanticRank != 2 || b.FusedScore != 2.0/62 {
+		t.Errorf("wrong breakdown, got %+v", b)
+	}
+	if breakdowns[3].KeywordRank != 0 || breakdowns[3].SemanticScore != 0.9 {
+		t.Errorf("wrong breakdown for a semantic only card, got %+v", breakdowns[3])
+	}
+
+	order, breakdowns = fuseRankings(keyword, semantic, fusionWeighted, 0.25)
+	if order[0] != 1 || breakdowns[1].FusedScore != 0.75 {
+		t.Errorf("expected the exact keyword match to lead a keyword weighted fusion, got %v %+v", order, breakdowns[1])
+	}
+}
+
+func TestKeywordScore(t *testing.T) {
+	cards := []models.Card{
+		{ID: 1, CardID: "12", Title: "Zettelkasten", Body: "notes on notes"},
+		{ID: 2, CardID: "12/a", Title: "Notes", Body: "notes"},
+		{ID: 3, CardID: "3", Title: "Unrelated", Body: "notes about 12"},
+	}
+	ranked := rankKeywordResults(cards, []string{"12"})
+	if ranked[0].CardPK != 1 || ranked[1].CardPK != 2 || ranked[2].CardPK != 3 {
+		t.Errorf("expected an exact card_id first, got %+v", ranked)
+	}
+	if terms := searchTerms(ParseSearchQuery(`slip "paper box" !draft #idea`)); len(terms) != 2 || terms[1] != "paper box" {
+		t.Errorf("wrong terms, got %v", terms)
+	}
+	if filters := searchFilters(ParseSearchQuery("slip #idea has:task")); filters == nil || len(filters.Children) != 2 {
+		t.Errorf("expected text to be left out of the filters, got %+v", filters)
+	}
+}
+
+func TestHybridSearch(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	card, err := s.CreateCard(1, models.EditCardParams{CardID: "950", Title: "Hybrid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := s.HybridSearch(context.Background(), SearchRequestParams{SearchTerm: "950", SearchType: "hybrid"}, 1)
+	if err != nil {
+		t.Fatalf("hybrid search failed: %v", err)
+	}
+	if len(results) < 2 {
+		t.Fatalf("expected semantic results alongside the keyword match, got %v", len(results))
+	}
+	if results[0].CardID != card.CardID {
+		t.Errorf("expected the exact card_id first, got %v", results[0].CardID)
+	}
+	scores := results[0].Metadata.(map[string]interface{})["scores"].(*models.ScoreBreakdown)
+	if scores.KeywordRank != 1 || scores.Fusion != fusionRRF {
+		t.Errorf("wrong score breakdown, got %+v", scores)
+	}
+	semanticOnly := false
+	for _, result := range results {
+		if b := result.Metadata.(map[string]interface{})["scores"].(*models.ScoreBreakdown); b.KeywordRank == 0 && b.SemanticRank > 0 {
+			semanticOnly = true
+		}
+	}
+	if !semanticOnly {
+		t.Errorf("expected cards found only by semantic search")
+	}
+}
@@ -0,0 +1,704 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-backend/models"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+const maxImportSize = 256 << 20
+
+// maxVaultNoteSize caps how much of a single note of a vault is read, since a
+// small archive can unpack to far more than maxImportSize
+const maxVaultNoteSize = 4 << 20
+
+var errVaultNoteTooLarge = fmt.Errorf("note is larger than %d MB", maxVaultNoteSize>>20)
+
+// ImportOptions controls how a vault is imported
+type ImportOptions struct {
+	// DryRun reports what would be imported without creating anything
+	DryRun bool
+}
+
+// vaultNote is a markdown file of an imported vault, or a card standing in
+// for a folder that has no note of its own
+type vaultNote struct {
+	source      string
+	name        string
+	dir         string
+	title       string
+	link        string
+	tags        []string
+	aliases     []string
+	cardID      string
+	explicitID  bool
+	body        string
+	attachments []*zip.File
+	skip        bool
+	// attachmentPaths are the files listed in the front matter, as in a
+	// vault export
+	attachmentPaths []string
+}
+
+// vaultFolder is a directory of an imported vault. Its note becomes the
+// parent card of everything inside it.
+type vaultFolder struct {
+	path       string
+	note       *vaultNote
+	notes      []*vaultNote
+	subfolders []*vaultFolder
+}
+
+var (
+	wikilinkPattern     = regexp.MustCompile(`(!?)\[\[([^\]|#]*)(#[^\]|]*)?(\|[^\]]*)?\]\]`)
+	markdownLinkPattern = regexp.MustCompile(`(!?)\[([^\]]*)\]\(<?([^)<>\s]+)>?\)`)
+)
+
+// ignoredVaultPath reports whether a file of a vault holds editor state or
+// archive metadata rather than notes
+func ignoredVaultPath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// splitFrontMatter separates the YAML front matter of a note from its body
+func splitFrontMatter(content string) (map[string]interface{}, string) {
+	content = strings.TrimPrefix(content, "\ufeff")
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if !strings.HasPrefix(content, "---\n") {
+		return nil, content
+	}
+	rest := content[len("---\n"):]
+	end := strings.Index(rest, "\n---")
+	if end == -1 {
+		return nil, content
+	}
+	var frontMatter map[string]interface{}
+	if err := yaml.Unmarshal([]byte(rest[:end]), &frontMatter); err != nil {
+		return nil, content
+	}
+	body := rest[end+len("\n---"):]
+	if newline := strings.Index(body, "\n"); newline != -1 {
+		body = body[newline+1:]
+	} else {
+		body = ""
+	}
+	return frontMatter, strings.TrimLeft(body, "\n")
+}
+
+func frontMatterString(frontMatter map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := frontMatter[key]; ok && value != nil {
+			return strings.TrimSpace(fmt.Sprint(value))
+		}
+	}
+	return ""
+}
+
+// frontMatterList reads a key that may hold a list or a comma separated
+// string
+func frontMatterList(frontMatter map[string]interface{}, key string) []string {
+	var values []string
+	switch value := frontMatter[key].(type) {
+	case string:
+		for _, item := range strings.Split(value, ",") {
+			values = append(values, strings.TrimSpace(item))
+		}
+	case []interface{}:
+		for _, item := range value {
+			if item == nil {
+				continue
+			}
+			if object, ok := item.(map[string]interface{}); ok {
+				values = append(values, frontMatterString(object, "path", "name"))
+				continue
+			}
+			values = append(values, strings.TrimSpace(fmt.Sprint(item)))
+		}
+	}
+	var result []string
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+var invalidTagChars = regexp.MustCompile(`[^\w-]+`)
+
+// normalizeTag turns an Obsidian tag into one that ParseTagsFromCardBody
+// recognizes, so that nested tags like area/work become area-work
+func normalizeTag(tag string) string {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+	return strings.Trim(invalidTagChars.ReplaceAllString(tag, "-"), "-")
+}
+
+// naturalLess orders strings so that embedded numbers compare by value,
+// putting "note 2" before "note 10"
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		ra, sizeA := utf8.DecodeRuneInString(a)
+		rb, sizeB := utf8.DecodeRuneInString(b)
+		if unicode.IsDigit(ra) && unicode.IsDigit(rb) {
+			na, restA := leadingNumber(a)
+			nb, restB := leadingNumber(b)
+			if na != nb {
+				return na < nb
+			}
+			a, b = restA, restB
+			continue
+		}
+		if ra != rb {
+			return ra < rb
+		}
+		a, b = a[sizeA:], b[sizeB:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingNumber(s string) (int, string) {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n, s[end:]
+}
+
+// readVaultNote parses a markdown file of a vault
+func readVaultNote(file *zip.File) (*vaultNote, error) {
+	if file.UncompressedSize64 > maxVaultNoteSize {
+		return nil, errVaultNoteTooLarge
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	// The size in the header is not to be trusted, so never read past the cap
+	content, err := io.ReadAll(io.LimitReader(reader, maxVaultNoteSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxVaultNoteSize {
+		return nil, errVaultNoteTooLarge
+	}
+
+	frontMatter, body := splitFrontMatter(string(content))
+	dir := path.Dir(file.Name)
+	if dir == "." {
+		dir = ""
+	}
+	note := &vaultNote{
+		source:  file.Name,
+		name:    strings.TrimSuffix(path.Base(file.Name), path.Ext(file.Name)),
+		dir:     dir,
+		title:   frontMatterString(frontMatter, "title"),
+		link:    frontMatterString(frontMatter, "link", "url", "source"),
+		aliases: frontMatterList(frontMatter, "aliases"),
+		cardID:  strings.Join(strings.Fields(frontMatterString(frontMatter, "card_id")), ""),
+	}
+	note.explicitID = note.cardID != ""
+	if note.title == "" {
+		note.title = note.name
+	}
+	for _, tag := range frontMatterList(frontMatter, "tags") {
+		if tag = normalizeTag(tag); tag != "" {
+			note.tags = append(note.tags, tag)
+		}
+	}
+	// Exported cards repeat their title as a heading
+	if heading := "# " + note.title; strings.HasPrefix(body, heading+"\n") || body == heading {
+		body = strings.TrimLeft(strings.TrimPrefix(body, heading), "\n")
+	}
+	note.body = body
+	note.attachmentPaths = frontMatterList(frontMatter, "attachments")
+	return note, nil
+}
+
+// vaultIndex finds the notes, attachments and existing cards that links in
+// a vault point to
+type vaultIndex struct {
+	notes         map[string]*vaultNote
+	files         map[string]*zip.File
+	filesByName   map[string]*zip.File
+	existingIDs   map[string]bool
+	existingTitle map[string]string
+}
+
+func (idx *vaultIndex) resolveNote(dir, target string) (string, bool) {
+	target = strings.TrimSpace(target)
+	key := strings.ToLower(strings.TrimSuffix(target, ".md"))
+	for _, candidate := range []string{strings.ToLower(path.Join(dir, key)), key, path.Base(key)} {
+		if note, ok := idx.notes[candidate]; ok {
+			return note.cardID, true
+		}
+	}
+	if idx.existingIDs[target] {
+		return target, true
+	}
+	if cardID, ok := idx.existingTitle[strings.ToLower(target)]; ok {
+		return cardID, true
+	}
+	return "", false
+}
+
+func (idx *vaultIndex) resolveFile(dir, target string) (*zip.File, bool) {
+	target = strings.TrimSpace(target)
+	if file, ok := idx.files[strings.ToLower(path.Join(dir, target))]; ok {
+		return file, true
+	}
+	if file, ok := idx.files[strings.ToLower(path.Clean(target))]; ok {
+		return file, true
+	}
+	file, ok := idx.filesByName[strings.ToLower(path.Base(target))]
+	return file, ok
+}
+
+func (idx *vaultIndex) addAttachment(note *vaultNote, file *zip.File) {
+	for _, existing := range note.attachments {
+		if existing == file {
+			return
+		}
+	}
+	note.attachments = append(note.attachments, file)
+}
+
+// convertLinks rewrites the wikilinks and relative markdown links of a note
+// into [card_id] references, and collects the files it embeds
+func (idx *vaultIndex) convertLinks(note *vaultNote, result *models.ImportResult) string {
+	broken := func(target string) {
+		result.BrokenLinks = append(result.BrokenLinks, models.ImportBrokenLink{Source: note.source, Target: target})
+	}
+
+	for _, attachment := range note.attachmentPaths {
+		if file, ok := idx.resolveFile("", attachment); ok {
+			idx.addAttachment(note, file)
+		} else {
+			broken(attachment)
+		}
+	}
+
+	body := wikilinkPattern.ReplaceAllStringFunc(note.body, func(match string) string {
+		parts := wikilinkPattern.FindStringSubmatch(match)
+		embed, target, alias := parts[1] == "!", parts[2], strings.TrimPrefix(parts[4], "|")
+		if embed {
+			if file, ok := idx.resolveFile(note.dir, target); ok {
+				idx.addAttachment(note, file)
+				name := path.Base(file.Name)
+				return fmt.Sprintf("![%s](%s)", name, (&url.URL{Path: name}).EscapedPath())
+			}
+		}
+		if target == "" {
+			// A link to a heading of the same note
+			return strings.TrimPrefix(parts[3], "#")
+		}
+		cardID, ok := idx.resolveNote(note.dir, target)
+		if !ok {
+			broken(target)
+			if alias != "" {
+				return alias
+			}
+			return target
+		}
+		if alias != "" && alias != cardID {
+			return fmt.Sprintf("%s [%s]", alias, cardID)
+		}
+		return fmt.Sprintf("[%s]", cardID)
+	})
+
+	return markdownLinkPattern.ReplaceAllStringFunc(body, func(match string) string {
+		parts := markdownLinkPattern.FindStringSubmatch(match)
+		embed, text, target := parts[1] == "!", parts[2], parts[3]
+		if strings.Contains(target, ":") || strings.HasPrefix(target, "#") {
+			return match
+		}
+		if unescaped, err := url.PathUnescape(target); err == nil {
+			target = unescaped
+		}
+		target = strings.SplitN(target, "#", 2)[0]
+		if embed || path.Ext(target) != ".md" {
+			file, ok := idx.resolveFile(note.dir, target)
+			if !ok {
+				broken(target)
+				return match
+			}
+			idx.addAttachment(note, file)
+			name := path.Base(file.Name)
+			return fmt.Sprintf("%s[%s](%s)", parts[1], text, (&url.URL{Path: name}).EscapedPath())
+		}
+		cardID, ok := idx.resolveNote(note.dir, target)
+		if !ok {
+			broken(target)
+			return text
+		}
+		if text != "" && text != cardID {
+			return fmt.Sprintf("%s [%s]", text, cardID)
+		}
+		return fmt.Sprintf("[%s]", cardID)
+	})
+}
+
+// queryExistingCardIDs loads the card_ids and titles of the user's cards
+func (s *Handler) queryExistingCardIDs(userID int) (map[string]bool, map[string]string, error) {
+	rows, err := s.DB.Query(`
+	SELECT card_id, title FROM cards WHERE user_id = $1 AND is_deleted = FALSE
+	`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	ids := map[string]bool{}
+	titles := map[string]string{}
+	for rows.Next() {
+		var cardID, title string
+		if err := rows.Scan(&cardID, &title); err != nil {
+			return nil, nil, err
+		}
+		if cardID == "" {
+			continue
+		}
+		ids[cardID] = true
+		if _, ok := titles[strings.ToLower(title)]; !ok && title != "" {
+			titles[strings.ToLower(title)] = cardID
+		}
+	}
+	return ids, titles, rows.Err()
+}
+
+// ImportVault creates cards from the markdown files of a ZIP, such as an
+// Obsidian vault or a vault export. Folders become Folgezettel: a folder's
+// note (named like the folder, or index or README) is the parent of
+// everything inside it, and folders without one get an empty card. Notes
+// whose card_id is already taken are reported as conflicts and skipped, and
+// a folder whose note is skipped gets an empty card too. Cards are created
+// one at a time, so a note that fails to import is listed in Failures and
+// the rest are imported anyway. Notes over maxVaultNoteSize are listed there
+// too without being read.
+func (s *Handler) ImportVault(ctx context.Context, userID int, archive *zip.Reader, options ImportOptions) (models.ImportResult, error) {
+	result := models.ImportResult{
+		DryRun:      options.DryRun,
+		Cards:       []models.ImportedCard{},
+		Conflicts:   []models.ImportConflict{},
+		BrokenLinks: []models.ImportBrokenLink{},
+		Failures:    []models.ImportConflict{},
+	}
+
+	existingIDs, existingTitles, err := s.queryExistingCardIDs(userID)
+	if err != nil {
+		return result, fmt.Errorf("failed to query cards: %w", err)
+	}
+	idx := &vaultIndex{
+		notes:         map[string]*vaultNote{},
+		files:         map[string]*zip.File{},
+		filesByName:   map[string]*zip.File{},
+		existingIDs:   existingIDs,
+		existingTitle: existingTitles,
+	}
+
+	root := &vaultFolder{}
+	folders := map[string]*vaultFolder{"": root}
+	var folderFor func(dir string) *vaultFolder
+	folderFor = func(dir string) *vaultFolder {
+		if folder, ok := folders[dir]; ok {
+			return folder
+		}
+		parentDir := path.Dir(dir)
+		if parentDir == "." {
+			parentDir = ""
+		}
+		parent := folderFor(parentDir)
+		folder := &vaultFolder{path: dir}
+		parent.subfolders = append(parent.subfolders, folder)
+		folders[dir] = folder
+		return folder
+	}
+
+	var notes []*vaultNote
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || ignoredVaultPath(file.Name) {
+			continue
+		}
+		if strings.ToLower(path.Ext(file.Name)) != ".md" {
+			idx.files[strings.ToLower(file.Name)] = file
+			if _, ok := idx.filesByName[strings.ToLower(path.Base(file.Name))]; !ok {
+				idx.filesByName[strings.ToLower(path.Base(file.Name))] = file
+			}
+			continue
+		}
+		note, err := readVaultNote(file)
+		if err == errVaultNoteTooLarge {
+			result.Failures = append(result.Failures, models.ImportConflict{
+				Source: file.Name, Reason: err.Error(),
+			})
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		notes = append(notes, note)
+
+		folder := folderFor(note.dir)
+		base := strings.ToLower(note.name)
+		if folder != root && folder.note == nil &&
+			(base == strings.ToLower(path.Base(folder.path)) || base == "index" || base == "readme") {
+			folder.note = note
+		} else {
+			folder.notes = append(folder.notes, note)
+		}
+	}
+
+	// Explicit card_ids win over allocated ones, so they are claimed first
+	taken := map[string]bool{}
+	for id := range existingIDs {
+		taken[id] = true
+	}
+	claimed := map[string]string{}
+	for _, note := range notes {
+		if !note.explicitID {
+			continue
+		}
+		switch {
+		case !s.checkIsCardIDUnique(userID, note.cardID):
+			note.skip = true
+			result.Conflicts = append(result.Conflicts, models.ImportConflict{
+				Source: note.source, CardID: note.cardID, Reason: "card_id already exists",
+			})
+		case claimed[note.cardID] != "":
+			note.skip = true
+			result.Conflicts = append(result.Conflicts, models.ImportConflict{
+				Source: note.source, CardID: note.cardID, Reason: "duplicate card_id in vault, also used by " + claimed[note.cardID],
+			})
+		default:
+			claimed[note.cardID] = note.source
+		}
+		taken[note.cardID] = true
+	}
+
+	nextRoot, _ := strconv.Atoi(s.getNextRootCardID(userID))
+	allocator := newCardIDAllocator(taken, max(nextRoot, 1))
+
+	// Walk the folders, giving each note an id below its folder's note
+	var ordered []*vaultNote
+	var assign func(folder *vaultFolder, parentID string)
+	assign = func(folder *vaultFolder, parentID string) {
+		type entry struct {
+			name   string
+			note   *vaultNote
+			folder *vaultFolder
+		}
+		var entries []entry
+		for _, note := range folder.notes {
+			entries = append(entries, entry{name: note.name, note: note})
+		}
+		for _, sub := range folder.subfolders {
+			if sub.note != nil && sub.note.skip {
+				// Its children go below a card that is created, not the
+				// one whose card_id it conflicts with
+				entries = append(entries, entry{name: sub.note.name, note: sub.note})
+				sub.note = nil
+			}
+			if sub.note == nil {
+				sub.note = &vaultNote{
+					source: sub.path + "/",
+					name:   path.Base(sub.path),
+					dir:    sub.path,
+					title:  path.Base(sub.path),
+				}
+			}
+			entries = append(entries, entry{name: path.Base(sub.path), note: sub.note, folder: sub})
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return naturalLess(entries[i].name, entries[j].name) })
+
+		for _, e := range entries {
+			if !e.note.explicitID {
+				e.note.cardID = allocator.next(parentID)
+			}
+			ordered = append(ordered, e.note)
+			if e.folder != nil {
+				assign(e.folder, e.note.cardID)
+			}
+		}
+	}
+	assign(root, "")
+
+	for _, note := range ordered {
+		if note.skip && claimed[note.cardID] != "" && claimed[note.cardID] != note.source {
+			// Links by name go to the note that kept the card_id
+			continue
+		}
+		for _, key := range []string{path.Join(note.dir, note.name), note.name} {
+			key = strings.ToLower(key)
+			if _, ok := idx.notes[key]; !ok {
+				idx.notes[key] = note
+			}
+		}
+		for _, alias := range note.aliases {
+			if _, ok := idx.notes[strings.ToLower(alias)]; !ok {
+				idx.notes[strings.ToLower(alias)] = note
+			}
+		}
+	}
+
+	for _, note := range ordered {
+		if note.skip {
+			continue
+		}
+		note.body = idx.convertLinks(note, &result)
+
+		// Tags are read from the body, so front matter tags are added to it
+		bodyTags, _ := s.ParseTagsFromCardBody(note.body)
+		present := map[string]bool{}
+		for _, tag := range bodyTags {
+			present[tag] = true
+		}
+		var missing []string
+		for _, tag := range note.tags {
+			if !present[tag] {
+				present[tag] = true
+				missing = append(missing, "#"+tag)
+			}
+		}
+		if len(missing) > 0 {
+			note.body = strings.TrimRight(note.body, "\n") + "\n\n" + strings.Join(missing, " ") + "\n"
+			note.body = strings.TrimLeft(note.body, "\n")
+		}
+	}
+
+	// Parents are created before their children, so CreateCard can find them
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return strings.Count(ordered[i].cardID, "/")+strings.Count(ordered[i].cardID, ".") <
+			strings.Count(ordered[j].cardID, "/")+strings.Count(ordered[j].cardID, ".")
+	})
+
+	var created []models.Card
+	for _, note := range ordered {
+		if note.skip {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		imported := models.ImportedCard{
+			CardID:      note.cardID,
+			Title:       note.title,
+			Source:      note.source,
+			Attachments: []string{},
+		}
+		for _, file := range note.attachments {
+			imported.Attachments = append(imported.Attachments, file.Name)
+		}
+		if options.DryRun {
+			result.Cards = append(result.Cards, imported)
+			continue
+		}
+
+		card, err := s.CreateCard(userID, models.EditCardParams{
+			CardID: note.cardID,
+			Title:  note.title,
+			Body:   note.body,
+			Link:   note.link,
+		})
+		if err != nil {
+			log.Printf("failed to create card for %s: %v", note.source, err)
+			result.Failures = append(result.Failures, models.ImportConflict{
+				Source: note.source, CardID: note.cardID, Reason: "card not created: " + err.Error(),
+			})
+			continue
+		}
+		imported.ID = card.ID
+		created = append(created, card)
+
+		for _, file := range note.attachments {
+			if err := s.importAttachment(userID, card.ID, file); err != nil {
+				result.Conflicts = append(result.Conflicts, models.ImportConflict{
+					Source: file.Name, CardID: note.cardID, Reason: "attachment not imported: " + err.Error(),
+				})
+			}
+		}
+		result.Cards = append(result.Cards, imported)
+	}
+
+	// Links to cards created later in the import were not found when the
+	// linking card was created
+	for _, card := range created {
+		if err := s.updateBacklinks(card.ID, extractBacklinks(card.Body)); err != nil {
+			log.Printf("failed to update backlinks of imported card %d: %v", card.ID, err)
+		}
+	}
+	return result, nil
+}
+
+func (s *Handler) importAttachment(userID, cardPK int, file *zip.File) error {
+	if err := s.userCanStoreBytes(userID, int64(file.UncompressedSize64)); err != nil {
+		return err
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = s.storeFile(userID, cardPK, path.Base(file.Name), mime.TypeByExtension(path.Ext(file.Name)), reader)
+	return err
+}
+
+// ImportMarkdownRoute creates cards from an uploaded ZIP of markdown files.
+// Pass dry_run=true to get the report without importing anything.
+func (s *Handler) ImportMarkdownRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "No file part", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		http.Error(w, "Invalid zip file", http.StatusBadRequest)
+		return
+	}
+
+	options := ImportOptions{DryRun: r.FormValue("dry_run") == "true"}
+	result, err := s.ImportVault(r.Context(), userID, archive, options)
+	if err != nil {
+		log.Printf("failed to import vault for user %d: %v", userID, err)
+		http.Error(w, "Failed to import cards", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !options.DryRun {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(result)
+}
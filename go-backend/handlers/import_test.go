@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"go-backend/models"
+	"go-backend/tests"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func makeTestVault(t *testing.T, files map[string]string) []byte {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for name, content := range files {
+		f, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func makeImportRequest(s *Handler, t *testing.T, vault []byte, dryRun bool) models.ImportResult {
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+	part, err := writer.CreateFormFile("file", "vault.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(vault)
+	if dryRun {
+		writer.WriteField("dry_run", "true")
+	}
+	writer.Close()
+
+	token, _ := tests.GenerateTestJWT(1)
+	req, err := http.NewRequest("POST", "/api/import/markdown", &buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(s.JwtMiddleware(s.ImportMarkdownRoute))
+	handler.ServeHTTP(rr, req)
+
+	expected := http.StatusCreated
+	if dryRun {
+		expected = http.StatusOK
+	}
+	if status := rr.Code; status != expected {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, expected, rr.Body.String())
+	}
+	var result models.ImportResult
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &result)
+	return result
+}
+
+func TestNaturalLess(t *testing.T) {
+	expected := []string{"apple", "note 1b", "Note 2", "note 10"}
+	for i := range expected {
+		for j := range expected {
+			if got := naturalLess(expected[i], expected[j]); got != (i < j) {
+				t.Errorf("naturalLess(%q, %q) = %v", expected[i], expected[j], got)
+			}
+		}
+	}
+}
+
+func TestImportMarkdownRoute(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	if _, err := s.CreateCard(1, models.EditCardParams{CardID: "800", Title: "Taken"}); err != nil {
+		t.Fatal(err)
+	}
+	vault := makeTestVault(t, map[string]string{
+		"Projects/Projects.md": "Overview of [[Idea]]",
+		"Projects/Idea.md": "---\ntitle: A big idea\ntags: [research, area/work]\n---\n" +
+			"See [[Projects|the project]] and [[Nowhere]]\n\n![[diagram.png]]",
+		"Projects/diagram.png":   "png",
+		"Inbox/Loose.md":         "A loose note",
+		"Taken.md":               "---\ncard_id: 800\n---\nAlready imported",
+		".obsidian/workspace.md": "editor state",
+	})
+
+	dryRun := makeImportRequest(s, t, vault, true)
+	if !dryRun.DryRun || len(dryRun.Cards) != 4 {
+		t.Fatalf("expected four cards to be planned, got %+v", dryRun.Cards)
+	}
+	if len(dryRun.Conflicts) != 1 || dryRun.Conflicts[0].CardID != "800" {
+		t.Errorf("expected the taken card_id to be reported, got %+v", dryRun.Conflicts)
+	}
+	if len(dryRun.BrokenLinks) != 1 || dryRun.BrokenLinks[0].Target != "Nowhere" {
+		t.Errorf("expected the broken link to be reported, got %+v", dryRun.BrokenLinks)
+	}
+	var count int
+	s.DB.QueryRow("SELECT count(*) FROM cards WHERE user_id = 1 AND title = 'A big idea'").Scan(&count)
+	if count != 0 {
+		t.Fatalf("a dry run should not create cards")
+	}
+
+	result := makeImportRequest(s, t, vault, false)
+	cards := map[string]models.ImportedCard{}
+	for _, card := range result.Cards {
+		cards[card.Source] = card
+	}
+	projects, idea, inbox, loose := cards["Projects/Projects.md"], cards["Projects/Idea.md"], cards["Inbox/"], cards["Inbox/Loose.md"]
+	if idea.CardID != projects.CardID+"/A" || loose.CardID != inbox.CardID+"/A" {
+		t.Errorf("expected folders to become Folgezettel, got %+v", result.Cards)
+	}
+
+	card, err := s.QueryFullCard(1, idea.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(card.Body, "the project ["+projects.CardID+"]") {
+		t.Errorf("expected the wikilink to be converted, got %q", card.Body)
+	}
+	tags, _ := s.QueryTagsForCard(1, idea.ID)
+	if len(tags) != 2 {
+		t.Errorf("expected the front matter tags on the card, got %v", tags)
+	}
+	files, _ := s.getFilesFromCardPK(1, idea.ID)
+	if len(files) != 1 || files[0].Name != "diagram.png" {
+		t.Errorf("expected the embedded image to be attached, got %v", files)
+	}
+
+	backlinks, _ := s.getBacklinks(1, idea.CardID)
+	if len(backlinks) != 1 || backlinks[0].CardID != projects.CardID {
+		t.Errorf("expected the link from the folder note, created first, to be recorded, got %v", backlinks)
+	}
+
+	output, _ := json.Marshal(result)
+	if strings.Contains(string(output), "workspace") {
+		t.Errorf("editor state should not be imported")
+	}
+}
+
+func TestImportSkippedFolderNote(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	if _, err := s.CreateCard(1, models.EditCardParams{CardID: "810", Title: "Taken"}); err != nil {
+		t.Fatal(err)
+	}
+	vault := makeTestVault(t, map[string]string{
+		"Old/Old.md":   "---\ncard_id: 810\n---\nAlready imported",
+		"Old/Child.md": "A child",
+	})
+
+	result := makeImportRequest(s, t, vault, false)
+	if len(result.Conflicts) != 1 || len(result.Failures) != 0 {
+		t.Fatalf("expected only the taken card_id to be reported, got %+v", result)
+	}
+	cards := map[string]models.ImportedCard{}
+	for _, card := range result.Cards {
+		cards[card.Source] = card
+	}
+	folder, child := cards["Old/"], cards["Old/Child.md"]
+	if folder.ID == 0 || folder.CardID == "810" || child.CardID != folder.CardID+"/A" {
+		t.Errorf("expected the folder to get a card of its own for its children, got %+v", result.Cards)
+	}
+}
+
+func TestImportOversizedNote(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	vault := makeTestVault(t, map[string]string{
+		"Small.md": "A small note",
+		"Huge.md":  strings.Repeat("a", maxVaultNoteSize+1),
+	})
+
+	result := makeImportRequest(s, t, vault, true)
+	if len(result.Failures) != 1 || result.Failures[0].Source != "Huge.md" {
+		t.Fatalf("expected the oversized note to be reported, got %+v", result.Failures)
+	}
+	if len(result.Cards) != 1 || result.Cards[0].Source != "Small.md" {
+		t.Errorf("expected only the small note to be imported, got %+v", result.Cards)
+	}
+}
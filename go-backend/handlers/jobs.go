@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-backend/jobs"
+	"go-backend/llms"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type cardJobPayload struct {
+	CardPK int `json:"card_pk"`
+}
+
+type memoryJobPayload struct {
+	CardContent string `json:"card_content"`
+}
+
+type summarizeJobPayload struct {
+	SummarizationID int                    `json:"summarization_id"`
+	Analyses        []llms.SectionAnalysis `json:"analyses"`
+	Usage           llms.Usage             `json:"usage"`
+}
+
+// JobHandlers maps each job type to the function the worker runs for it
+func (s *Handler) JobHandlers() map[string]jobs.HandlerFunc {
+	return map[string]jobs.HandlerFunc{
+		models.JobTypeProcessEntitiesAndFacts: s.processEntitiesAndFactsJob,
+		models.JobTypeSummarize:               s.summarizeJob,
+		models.JobTypeGenerateMemory:          s.generateMemoryJob,
+		models.JobTypeUpsertCardTypesense:     s.upsertCardTypesenseJob,
+		models.JobTypeEmbedCard:               s.embedCardJob,
+		models.JobTypeLinkHealthReport:        s.linkHealthReportJob,
+	}
+}
+
+func (s *Handler) enqueueJob(jobType string, userID int, payload interface{}) {
+	if _, err := jobs.Enqueue(s.DB, jobType, userID, payload); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// loadJobCard returns the card a job refers to. It returns sql.ErrNoRows if
+// the card was deleted after the job was queued.
+func (s *Handler) loadJobCard(job models.Job) (models.Card, error) {
+	var payload cardJobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return models.Card{}, err
+	}
+	if job.UserID == nil {
+		return models.Card{}, fmt.Errorf("card job has no user")
+	}
+	return s.queryCard(*job.UserID, payload.CardPK)
+}
+
+func (s *Handler) upsertCardTypesenseJob(ctx context.Context, job models.Job) error {
+	card, err := s.loadJobCard(job)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return s.indexCardTypesense(card)
+}
+
+func (s *Handler) embedCardJob(ctx context.Context, job models.Job) error {
+	card, err := s.loadJobCard(job)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = s.EmbedCard(ctx, card)
+	return err
+}
+
+// admin protected
+func (s *Handler) GetJobsRoute(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	jobType := r.URL.Query().Get("type")
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	results, err := jobs.List(s.DB, status, jobType, limit)
+	if err != nil {
+		log.Printf("failed to list jobs: %v", err)
+		http.Error(w, "Failed to query jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// admin protected
+func (s *Handler) GetJobStatsRoute(w http.ResponseWriter, r *http.Request) {
+	stats, err := jobs.Stats(s.DB)
+	if err != nil {
+		log.Printf("failed to query job stats: %v", err)
+		http.Error(w, "Failed to query jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// admin protected
+func (s *Handler) GetJobRoute(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := jobs.Get(s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// admin protected
+func (s *Handler) RetryJobRoute(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := jobs.Retry(s.DB, id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	job, err := jobs.Get(s.DB, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
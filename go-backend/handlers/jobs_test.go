@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"go-backend/jobs"
+	"go-backend/models"
+	"go-backend/tests"
+	"testing"
+	"time"
+)
+
+func TestJobQueueRetryAndDeadLetter(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	id, err := jobs.Enqueue(s.DB, "test_job", 1, cardJobPayload{CardPK: 1})
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %v", err)
+	}
+	_, err = s.DB.Exec("UPDATE jobs SET max_attempts = 2 WHERE id = $1", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	worker := &jobs.Worker{
+		DB: s.DB,
+		ID: "test",
+		Handlers: map[string]jobs.HandlerFunc{
+			"test_job": func(ctx context.Context, job models.Job) error {
+				calls++
+				return fmt.Errorf("boom")
+			},
+		},
+		Lease: time.Minute,
+	}
+
+	found, err := worker.RunOnce()
+	if err != nil || !found {
+		t.Fatalf("expected a job to run, got found=%v err=%v", found, err)
+	}
+	job, _ := jobs.Get(s.DB, id)
+	if job.Status != models.JobStatusPending || job.Attempts != 1 {
+		t.Errorf("failed job should be pending for retry, got %v after %d attempts", job.Status, job.Attempts)
+	}
+	if job.LastError == nil || *job.LastError != "boom" {
+		t.Errorf("failed job should record its error, got %v", job.LastError)
+	}
+	if !job.RunAt.After(time.Now()) {
+		t.Errorf("failed job should be delayed by a backoff, run_at %v", job.RunAt)
+	}
+
+	found, _ = worker.RunOnce()
+	if found {
+		t.Errorf("job should not run again before its backoff expires")
+	}
+
+	_, _ = s.DB.Exec("UPDATE jobs SET run_at = NOW() WHERE id = $1", id)
+	worker.RunOnce()
+	job, _ = jobs.Get(s.DB, id)
+	if job.Status != models.JobStatusDead {
+		t.Errorf("job should be dead after its last attempt, got %v", job.Status)
+	}
+	if calls != 2 {
+		t.Errorf("wrong number of attempts, got %d want %d", calls, 2)
+	}
+
+	if err := jobs.Retry(s.DB, id); err != nil {
+		t.Fatalf("failed to retry dead job: %v", err)
+	}
+	worker.Handlers["test_job"] = func(ctx context.Context, job models.Job) error { return nil }
+	worker.RunOnce()
+	job, _ = jobs.Get(s.DB, id)
+	if job.Status != models.JobStatusComplete || job.CompletedAt == nil {
+		t.Errorf("retried job should complete, got %v", job.Status)
+	}
+}
+
+func TestJobQueueExpiredLease(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	id, err := jobs.Enqueue(s.DB, "test_job", 0, nil)
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %v", err)
+	}
+	job, err := jobs.Lease(s.DB, "first", time.Minute)
+	if err != nil || job == nil || job.ID != id {
+		t.Fatalf("expected to lease job %d, got %v, %v", id, job, err)
+	}
+	if other, _ := jobs.Lease(s.DB, "second", time.Minute); other != nil {
+		t.Errorf("a leased job should not be handed to another worker")
+	}
+
+	_, _ = s.DB.Exec("UPDATE jobs SET locked_until = NOW() - INTERVAL '1 second' WHERE id = $1", id)
+	job, err = jobs.Lease(s.DB, "second", time.Minute)
+	if err != nil || job == nil || job.ID != id {
+		t.Fatalf("expired lease should be picked up again, got %v, %v", job, err)
+	}
+	if job.Attempts != 2 || job.LockedBy == nil || *job.LockedBy != "second" {
+		t.Errorf("wrong lease state after expiry: attempts %d, locked by %v", job.Attempts, job.LockedBy)
+	}
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-backend/jobs"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cardIDReferencePattern tells references to cards apart from other text in
+// square brackets, such as task list checkboxes or footnotes with spaces
+var cardIDReferencePattern = regexp.MustCompile(`^[\p{L}\p{N}_-]+([./][\p{L}\p{N}_-]+)*$`)
+
+// maxReportItems bounds each list in the emailed summary of a report
+const maxReportItems = 20
+
+func looksLikeCardID(target string) bool {
+	return target != "x" && target != "X" && cardIDReferencePattern.MatchString(target)
+}
+
+// QueryLinkHealth reports the user's broken references, orphaned cards and
+// cards whose card_id is also held by a card in the trash
+func (s *Handler) QueryLinkHealth(userID int) (models.LinkHealthReport, error) {
+	report := models.LinkHealthReport{
+		GeneratedAt: time.Now(),
+		BrokenLinks: []models.BrokenLink{},
+		Orphans:     []models.PartialCard{},
+		Collisions:  []models.CardIDCollision{},
+	}
+
+	rows, err := s.DB.Query(`
+	SELECT id, COALESCE(card_id, ''), COALESCE(title, ''), COALESCE(body, ''), is_deleted
+	FROM cards WHERE user_id = $1
+	ORDER BY id
+	`, userID)
+	if err != nil {
+		return report, err
+	}
+	var cards []models.Card
+	for rows.Next() {
+		var card models.Card
+		if err := rows.Scan(&card.ID, &card.CardID, &card.Title, &card.Body, &card.IsDeleted); err != nil {
+			rows.Close()
+			return report, err
+		}
+		cards = append(cards, card)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	live := map[string]bool{}
+	deleted := map[string][]int{}
+	for _, card := range cards {
+		if card.IsDeleted {
+			deleted[card.CardID] = append(deleted[card.CardID], card.ID)
+		} else {
+			live[card.CardID] = true
+		}
+	}
+
+	for _, card := range cards {
+		if card.IsDeleted {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, link := range extractBacklinks(card.Body) {
+			target := link.CardID
+			if seen[target] || live[target] || !looksLikeCardID(target) {
+				continue
+			}
+			seen[target] = true
+			reason := "missing"
+			if len(deleted[target]) > 0 {
+				reason = "deleted"
+			}
+			report.BrokenLinks = append(report.BrokenLinks, models.BrokenLink{
+				SourceID:     card.ID,
+				SourceCardID: card.CardID,
+				SourceTitle:  card.Title,
+				Target:       target,
+				Reason:       reason,
+			})
+		}
+		if card.CardID != "" && len(deleted[card.CardID]) > 0 {
+			report.Collisions = append(report.Collisions, models.CardIDCollision{
+				ID:         card.ID,
+				CardID:     card.CardID,
+				Title:      card.Title,
+				DeletedIDs: deleted[card.CardID],
+			})
+		}
+	}
+
+	rows, err = s.DB.Query(`
+	SELECT c.id, c.card_id, c.user_id, c.title, c.parent_id, c.created_at, c.updated_at
+	FROM cards c
+	WHERE c.user_id = $1 AND c.is_deleted = FALSE
+	AND (c.parent_id IS NULL OR c.parent_id = c.id OR NOT EXISTS (
+		SELECT 1 FROM cards p WHERE p.id = c.parent_id AND p.user_id = c.user_id AND p.is_deleted = FALSE
+	))
+	AND NOT EXISTS (
+		SELECT 1 FROM cards ch WHERE ch.parent_id = c.id AND ch.id != c.id AND ch.is_deleted = FALSE
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM backlinks b JOIN cards src ON src.id = b.source_id_int
+		WHERE b.target_id_int = c.id AND src.id != c.id AND src.is_deleted = FALSE
+	)
+	AND NOT EXISTS (SELECT 1 FROM card_tags ct WHERE ct.card_pk = c.id)
+	`, userID)
+	if err != nil {
+		return report, err
+	}
+	orphans, err := models.ScanPartialCards(rows)
+	rows.Close()
+	if err != nil {
+		return report, err
+	}
+	sort.SliceStable(orphans, func(i, j int) bool { return cardIDLess(orphans[i].CardID, orphans[j].CardID) })
+	report.Orphans = append(report.Orphans, orphans...)
+
+	sort.SliceStable(report.BrokenLinks, func(i, j int) bool {
+		return cardIDLess(report.BrokenLinks[i].SourceCardID, report.BrokenLinks[j].SourceCardID)
+	})
+	sort.SliceStable(report.Collisions, func(i, j int) bool {
+		return cardIDLess(report.Collisions[i].CardID, report.Collisions[j].CardID)
+	})
+	return report, nil
+}
+
+func linkHealthIsClean(report models.LinkHealthReport) bool {
+	return len(report.BrokenLinks) == 0 && len(report.Orphans) == 0 && len(report.Collisions) == 0
+}
+
+// linkHealthSummary writes a report as the plain text of an email
+func linkHealthSummary(username string, report models.LinkHealthReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\nHere is the link report for your Zettelkasten.\n", username)
+
+	fmt.Fprintf(&b, "\nBroken links: %d\n", len(report.BrokenLinks))
+	for i, link := range report.BrokenLinks {
+		if i == maxReportItems {
+			fmt.Fprintf(&b, "  ...and %d more\n", len(report.BrokenLinks)-i)
+			break
+		}
+		fmt.Fprintf(&b, "  [%s] %s links to [%s], which is %s\n", link.SourceCardID, link.SourceTitle, link.Target, link.Reason)
+	}
+
+	fmt.Fprintf(&b, "\nOrphaned cards: %d\n", len(report.Orphans))
+	for i, card := range report.Orphans {
+		if i == maxReportItems {
+			fmt.Fprintf(&b, "  ...and %d more\n", len(report.Orphans)-i)
+			break
+		}
+		fmt.Fprintf(&b, "  [%s] %s\n", card.CardID, card.Title)
+	}
+
+	fmt.Fprintf(&b, "\nCard IDs shared with deleted cards: %d\n", len(report.Collisions))
+	for i, collision := range report.Collisions {
+		if i == maxReportItems {
+			fmt.Fprintf(&b, "  ...and %d more\n", len(report.Collisions)-i)
+			break
+		}
+		fmt.Fprintf(&b, "  [%s] %s\n", collision.CardID, collision.Title)
+	}
+	return b.String()
+}
+
+// linkHealthReportJob emails a user the link report for their cards, if
+// there is anything to report
+func (s *Handler) linkHealthReportJob(ctx context.Context, job models.Job) error {
+	if job.UserID == nil {
+		return fmt.Errorf("link report job has no user")
+	}
+	user, err := s.QueryUser(*job.UserID)
+	if err != nil {
+		return err
+	}
+	report, err := s.QueryLinkHealth(user.ID)
+	if err != nil {
+		return err
+	}
+	if linkHealthIsClean(report) || s.Server.Mail == nil {
+		return nil
+	}
+	return s.Server.Mail.SendEmail("Your Zettelgarden link report", user.Email, linkHealthSummary(user.Username, report))
+}
+
+// ScheduleLinkHealthReports queues a link report for every user with a
+// validated email who has not had one within interval. It returns the
+// number of reports queued.
+func (s *Handler) ScheduleLinkHealthReports(interval time.Duration) (int, error) {
+	result, err := s.DB.Exec(`
+	INSERT INTO jobs (job_type, user_id, payload, max_attempts)
+	SELECT $1, u.id, '{}', $2
+	FROM users u
+	WHERE u.email_validated = TRUE AND NOT EXISTS (
+		SELECT 1 FROM jobs j
+		WHERE j.job_type = $1 AND j.user_id = u.id
+		AND (j.status IN ('pending', 'running') OR j.created_at > NOW() - $3 * INTERVAL '1 second')
+	)
+	`, models.JobTypeLinkHealthReport, jobs.DefaultMaxAttempts, int(interval.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	queued, _ := result.RowsAffected()
+	return int(queued), nil
+}
+
+func (s *Handler) GetLinkHealthRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	report, err := s.QueryLinkHealth(userID)
+	if err != nil {
+		log.Printf("link health error: %v", err)
+		http.Error(w, "Unable to build link report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
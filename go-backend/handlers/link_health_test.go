@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLooksLikeCardID(t *testing.T) {
+	for _, target := range []string{"12", "12/A.3", "SP104/A.6", "inbox"} {
+		if !looksLikeCardID(target) {
+			t.Errorf("expected %q to be read as a card_id", target)
+		}
+	}
+	for _, target := range []string{" ", "x", "see note", "1/", "a/.b"} {
+		if looksLikeCardID(target) {
+			t.Errorf("expected %q not to be read as a card_id", target)
+		}
+	}
+}
+
+func TestLinkHealthSummary(t *testing.T) {
+	report := models.LinkHealthReport{
+		BrokenLinks: []models.BrokenLink{{SourceCardID: "1", SourceTitle: "One", Target: "9", Reason: "missing"}},
+	}
+	for i := 0; i < maxReportItems+5; i++ {
+		report.Orphans = append(report.Orphans, models.PartialCard{CardID: "2", Title: "Two"})
+	}
+	summary := linkHealthSummary("test", report)
+	if !strings.Contains(summary, "[1] One links to [9], which is missing") {
+		t.Errorf("expected the broken link in the summary, got %q", summary)
+	}
+	if !strings.Contains(summary, "...and 5 more") {
+		t.Errorf("expected long lists to be cut short, got %q", summary)
+	}
+}
+
+func TestGetLinkHealthRoute(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	deleted, err := s.CreateCard(1, models.EditCardParams{CardID: "300", Title: "Deleted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.DeleteCard(1, deleted.ID)
+	source, err := s.CreateCard(1, models.EditCardParams{CardID: "301", Title: "Source", Body: "See [300], [399] and - [ ] a task"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateCard(1, models.EditCardParams{CardID: "300", Title: "Reused"}); err != nil {
+		t.Fatal(err)
+	}
+	lonely, err := s.CreateCard(1, models.EditCardParams{CardID: "302", Title: "Lonely"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := tests.GenerateTestJWT(1)
+	req, _ := http.NewRequest("GET", "/api/link-health", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.JwtMiddleware(s.GetLinkHealthRoute)).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+	var report models.LinkHealthReport
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &report)
+
+	var broken []string
+	for _, link := range report.BrokenLinks {
+		if link.SourceID == source.ID {
+			broken = append(broken, link.Target+" "+link.Reason)
+		}
+	}
+	if len(broken) != 1 || broken[0] != "399 missing" {
+		t.Errorf("expected only the missing card to be reported, got %v", broken)
+	}
+	collided := false
+	for _, collision := range report.Collisions {
+		if collision.CardID == "300" && len(collision.DeletedIDs) == 1 && collision.DeletedIDs[0] == deleted.ID {
+			collided = true
+		}
+	}
+	if !collided {
+		t.Errorf("expected the reused card_id to be reported, got %+v", report.Collisions)
+	}
+	orphaned := false
+	for _, card := range report.Orphans {
+		if card.ID == lonely.ID {
+			orphaned = true
+		}
+	}
+	if !orphaned {
+		t.Errorf("expected the lonely card to be reported, got %+v", report.Orphans)
+	}
+
+	sent := s.Server.Mail.TestingEmailsSent
+	userID := 1
+	if err := s.linkHealthReportJob(context.Background(), models.Job{UserID: &userID}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Server.Mail.TestingEmailsSent != sent+1 {
+		t.Errorf("expected the report to be emailed")
+	}
+}
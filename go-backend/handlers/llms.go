@@ -0,0 +1,763 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// API keys are write-only, they are never returned to the client
+func redactProvider(provider *models.LLMProvider) {
+	if provider != nil {
+		provider.APIKey = ""
+	}
+}
+
+func isForeignKeyViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23503"
+}
+
+func (s *Handler) QueryLLMProviders() ([]models.LLMProvider, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, name, COALESCE(base_url, ''), COALESCE(api_key_required, true), created_at, updated_at
+		FROM llm_providers
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	providers := []models.LLMProvider{}
+	for rows.Next() {
+		var provider models.LLMProvider
+		if err := rows.Scan(
+			&provider.ID,
+			&provider.Name,
+			&provider.BaseURL,
+			&provider.APIKeyRequired,
+			&provider.CreatedAt,
+			&provider.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return providers, rows.Err()
+}
+
+func (s *Handler) QueryLLMProvider(id int) (models.LLMProvider, error) {
+	var provider models.LLMProvider
+	err := s.DB.QueryRow(`
+		SELECT id, name, COALESCE(base_url, ''), COALESCE(api_key_required, true), created_at, updated_at
+		FROM llm_providers
+		WHERE id = $1
+	`, id).Scan(
+		&provider.ID,
+		&provider.Name,
+		&provider.BaseURL,
+		&provider.APIKeyRequired,
+		&provider.CreatedAt,
+		&provider.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return provider, fmt.Errorf("provider not found")
+	}
+	return provider, err
+}
+
+func (s *Handler) CreateLLMProvider(params models.EditLLMProviderParams) (models.LLMProvider, error) {
+	if strings.TrimSpace(params.Name) == "" {
+		return models.LLMProvider{}, fmt.Errorf("name is required")
+	}
+	var apiKey *string
+	if params.APIKey != nil && *params.APIKey != "" {
+		apiKey = params.APIKey
+	}
+
+	var id int
+	err := s.DB.QueryRow(`
+		INSERT INTO llm_providers (name, base_url, api_key_required, api_key)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, params.Name, params.BaseURL, params.APIKeyRequired, apiKey).Scan(&id)
+	if err != nil {
+		return models.LLMProvider{}, err
+	}
+	return s.QueryLLMProvider(id)
+}
+
+func (s *Handler) UpdateLLMProvider(id int, params models.EditLLMProviderParams) (models.LLMProvider, error) {
+	if strings.TrimSpace(params.Name) == "" {
+		return models.LLMProvider{}, fmt.Errorf("name is required")
+	}
+	result, err := s.DB.Exec(`
+		UPDATE llm_providers
+		SET name = $1, base_url = $2, api_key_required = $3, updated_at = NOW()
+		WHERE id = $4
+	`, params.Name, params.BaseURL, params.APIKeyRequired, id)
+	if err != nil {
+		return models.LLMProvider{}, err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return models.LLMProvider{}, fmt.Errorf("provider not found")
+	}
+
+	if params.APIKey != nil {
+		var apiKey *string
+		if *params.APIKey != "" {
+			apiKey = params.APIKey
+		}
+		if _, err := s.DB.Exec(`UPDATE llm_providers SET api_key = $1 WHERE id = $2`, apiKey, id); err != nil {
+			return models.LLMProvider{}, err
+		}
+	}
+	return s.QueryLLMProvider(id)
+}
+
+func (s *Handler) DeleteLLMProvider(id int) error {
+	result, err := s.DB.Exec(`DELETE FROM llm_providers WHERE id = $1`, id)
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("provider has models")
+	} else if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return fmt.Errorf("provider not found")
+	}
+	return nil
+}
+
+// QueryLLMModels returns the models with their providers, optionally
+// including inactive ones
+func (s *Handler) QueryLLMModels(includeInactive bool) ([]models.LLMModel, error) {
+	rows, err := s.DB.Query(`
+		SELECT `+models.LLMModelColumns+`
+		FROM llm_models m
+		JOIN llm_providers p ON p.id = m.provider_id
+		WHERE $1 OR m.is_active
+		ORDER BY p.name, m.name
+	`, includeInactive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.LLMModel{}
+	for rows.Next() {
+		model, err := models.ScanLLMModel(rows)
+		if err != nil {
+			return nil, err
+		}
+		redactProvider(model.Provider)
+		results = append(results, model)
+	}
+	return results, rows.Err()
+}
+
+func (s *Handler) QueryLLMModel(id int) (models.LLMModel, error) {
+	model, err := models.ScanLLMModel(s.DB.QueryRow(`
+		SELECT `+models.LLMModelColumns+`
+		FROM llm_models m
+		JOIN llm_providers p ON p.id = m.provider_id
+		WHERE m.id = $1
+	`, id))
+	if err == sql.ErrNoRows {
+		return model, fmt.Errorf("model not found")
+	}
+	redactProvider(model.Provider)
+	return model, err
+}
+
+// setDefaultLLMModel makes a model the default for users without a
+// configuration, clearing the previous default
+func setDefaultLLMModel(tx *sql.Tx, id int) error {
+	_, err := tx.Exec(`UPDATE llm_models SET is_default = (id = $1) WHERE is_default OR id = $1`, id)
+	return err
+}
+
+func (s *Handler) CreateLLMModel(params models.CreateLLMModelRequest) (models.LLMModel, error) {
+	if strings.TrimSpace(params.Name) == "" || strings.TrimSpace(params.ModelIdentifier) == "" {
+		return models.LLMModel{}, fmt.Errorf("name and model identifier are required")
+	}
+	if _, err := s.QueryLLMProvider(params.ProviderID); err != nil {
+		return models.LLMModel{}, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.LLMModel{}, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO llm_models (provider_id, name, model_identifier, description, is_active)
+		VALUES ($1, $2, $3, $4, true)
+		RETURNING id
+	`, params.ProviderID, params.Name, params.ModelIdentifier, params.Description).Scan(&id)
+	if err != nil {
+		return models.LLMModel{}, err
+	}
+	if params.IsDefault {
+		if err := setDefaultLLMModel(tx, id); err != nil {
+			return models.LLMModel{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return models.LLMModel{}, err
+	}
+	return s.QueryLLMModel(id)
+}
+
+func (s *Handler) UpdateLLMModel(id int, params models.EditLLMModelParams) (models.LLMModel, error) {
+	if strings.TrimSpace(params.Name) == "" || strings.TrimSpace(params.ModelIdentifier) == "" {
+		return models.LLMModel{}, fmt.Errorf("name and model identifier are required")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.LLMModel{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE llm_models
+		SET name = $1, model_identifier = $2, description = $3, is_active = $4,
+		is_default = is_default AND $4, updated_at = NOW()
+		WHERE id = $5
+	`, params.Name, params.ModelIdentifier, params.Description, params.IsActive, id)
+	if err != nil {
+		return models.LLMModel{}, err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return models.LLMModel{}, fmt.Errorf("model not found")
+	}
+	if params.IsDefault && params.IsActive {
+		if err := setDefaultLLMModel(tx, id); err != nil {
+			return models.LLMModel{}, err
+		}
+	} else if !params.IsDefault {
+		if _, err := tx.Exec(`UPDATE llm_models SET is_default = false WHERE id = $1`, id); err != nil {
+			return models.LLMModel{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return models.LLMModel{}, err
+	}
+	return s.QueryLLMModel(id)
+}
+
+func (s *Handler) DeleteLLMModel(id int) error {
+	result, err := s.DB.Exec(`DELETE FROM llm_models WHERE id = $1`, id)
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("model is in use")
+	} else if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return fmt.Errorf("model not found")
+	}
+	return nil
+}
+
+const llmConfigurationQuery = `
+	SELECT ` + models.LLMModelColumns + `,
+	c.id, c.user_id, c.model_id, COALESCE(c.custom_settings, '{}'), COALESCE(c.is_default, false),
+	c.created_at, c.updated_at
+	FROM user_llm_configurations c
+	JOIN llm_models m ON m.id = c.model_id
+	JOIN llm_providers p ON p.id = m.provider_id
+`
+
+func scanLLMConfiguration(row interface{ Scan(...interface{}) error }) (models.UserLLMConfiguration, error) {
+	var config models.UserLLMConfiguration
+	var settings []byte
+	model, err := models.ScanLLMModel(
+		row,
+		&config.ID,
+		&config.UserID,
+		&config.ModelID,
+		&settings,
+		&config.IsDefault,
+		&config.CreatedAt,
+		&config.UpdatedAt,
+	)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(settings, &config.CustomSettings); err != nil {
+		return config, err
+	}
+	redactProvider(model.Provider)
+	config.Model = &model
+	return config, nil
+}
+
+func (s *Handler) QueryUserLLMConfigurations(userID int) ([]models.UserLLMConfiguration, error) {
+	rows, err := s.DB.Query(llmConfigurationQuery+`
+		WHERE c.user_id = $1
+		ORDER BY c.is_default DESC, m.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	configs := []models.UserLLMConfiguration{}
+	for rows.Next() {
+		config, err := scanLLMConfiguration(rows)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	return configs, rows.Err()
+}
+
+func (s *Handler) QueryUserLLMConfiguration(userID, id int) (models.UserLLMConfiguration, error) {
+	config, err := scanLLMConfiguration(s.DB.QueryRow(llmConfigurationQuery+`
+		WHERE c.user_id = $1 AND c.id = $2
+	`, userID, id))
+	if err == sql.ErrNoRows {
+		return config, fmt.Errorf("configuration not found")
+	}
+	return config, err
+}
+
+// setDefaultLLMConfiguration makes a configuration the user's default,
+// clearing the previous default
+func setDefaultLLMConfiguration(tx *sql.Tx, userID, id int) error {
+	_, err := tx.Exec(`
+		UPDATE user_llm_configurations SET is_default = (id = $2), updated_at = NOW()
+		WHERE user_id = $1 AND (is_default OR id = $2)
+	`, userID, id)
+	return err
+}
+
+func (s *Handler) CreateUserLLMConfiguration(userID int, params models.EditLLMConfigurationParams) (models.UserLLMConfiguration, error) {
+	model, err := s.QueryLLMModel(params.ModelID)
+	if err != nil {
+		return models.UserLLMConfiguration{}, err
+	}
+	if !model.IsActive {
+		return models.UserLLMConfiguration{}, fmt.Errorf("model not found")
+	}
+	if params.CustomSettings == nil {
+		params.CustomSettings = map[string]interface{}{}
+	}
+	settings, err := json.Marshal(params.CustomSettings)
+	if err != nil {
+		return models.UserLLMConfiguration{}, err
+	}
+	var apiKey *string
+	if params.APIKey != nil && *params.APIKey != "" {
+		apiKey = params.APIKey
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.UserLLMConfiguration{}, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO user_llm_configurations (user_id, model_id, api_key, custom_settings, is_default)
+		VALUES ($1, $2, $3, $4, false)
+		ON CONFLICT (user_id, model_id) DO NOTHING
+		RETURNING id
+	`, userID, params.ModelID, apiKey, settings).Scan(&id)
+	if err == sql.ErrNoRows {
+		return models.UserLLMConfiguration{}, fmt.Errorf("configuration already exists")
+	} else if err != nil {
+		return models.UserLLMConfiguration{}, err
+	}
+	if params.IsDefault {
+		if err := setDefaultLLMConfiguration(tx, userID, id); err != nil {
+			return models.UserLLMConfiguration{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return models.UserLLMConfiguration{}, err
+	}
+	return s.QueryUserLLMConfiguration(userID, id)
+}
+
+func (s *Handler) UpdateUserLLMConfiguration(userID, id int, params models.EditLLMConfigurationParams) (models.UserLLMConfiguration, error) {
+	existing, err := s.QueryUserLLMConfiguration(userID, id)
+	if err != nil {
+		return existing, err
+	}
+	if params.ModelID == 0 {
+		params.ModelID = existing.ModelID
+	}
+	if params.ModelID != existing.ModelID {
+		model, err := s.QueryLLMModel(params.ModelID)
+		if err != nil || !model.IsActive {
+			return models.UserLLMConfiguration{}, fmt.Errorf("model not found")
+		}
+	}
+	if params.CustomSettings == nil {
+		params.CustomSettings = existing.CustomSettings
+	}
+	settings, err := json.Marshal(params.CustomSettings)
+	if err != nil {
+		return models.UserLLMConfiguration{}, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return models.UserLLMConfiguration{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE user_llm_configurations
+		SET model_id = $1, custom_settings = $2, updated_at = NOW()
+		WHERE id = $3 AND user_id = $4
+	`, params.ModelID, settings, id, userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return models.UserLLMConfiguration{}, fmt.Errorf("configuration already exists")
+		}
+		return models.UserLLMConfiguration{}, err
+	}
+	if params.APIKey != nil {
+		var apiKey *string
+		if *params.APIKey != "" {
+			apiKey = params.APIKey
+		}
+		if _, err := tx.Exec(`UPDATE user_llm_configurations SET api_key = $1 WHERE id = $2`, apiKey, id); err != nil {
+			return models.UserLLMConfiguration{}, err
+		}
+	}
+	if params.IsDefault {
+		err = setDefaultLLMConfiguration(tx, userID, id)
+	} else {
+		_, err = tx.Exec(`UPDATE user_llm_configurations SET is_default = false WHERE id = $1`, id)
+	}
+	if err != nil {
+		return models.UserLLMConfiguration{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.UserLLMConfiguration{}, err
+	}
+	return s.QueryUserLLMConfiguration(userID, id)
+}
+
+func (s *Handler) DeleteUserLLMConfiguration(userID, id int) error {
+	result, err := s.DB.Exec(`DELETE FROM user_llm_configurations WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return fmt.Errorf("configuration not found")
+	}
+	return nil
+}
+
+// QueryUserLLMTasks lists every task with the configuration the user has
+// assigned to it, or nil when it uses the default
+func (s *Handler) QueryUserLLMTasks(userID int) ([]models.LLMTaskConfiguration, error) {
+	rows, err := s.DB.Query(`
+		SELECT task, configuration_id FROM user_llm_task_configurations WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assigned := map[string]int{}
+	for rows.Next() {
+		var task string
+		var configID int
+		if err := rows.Scan(&task, &configID); err != nil {
+			return nil, err
+		}
+		assigned[task] = configID
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := []models.LLMTaskConfiguration{}
+	for _, task := range models.LLMTasks {
+		result := models.LLMTaskConfiguration{Task: task}
+		if configID, ok := assigned[task]; ok {
+			result.ConfigurationID = &configID
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// SetUserLLMTask assigns a configuration to a task, or clears the assignment
+// when configurationID is nil
+func (s *Handler) SetUserLLMTask(userID int, task string, configurationID *int) error {
+	if !slices.Contains(models.LLMTasks, task) {
+		return fmt.Errorf("unknown task")
+	}
+	if configurationID == nil {
+		_, err := s.DB.Exec(`DELETE FROM user_llm_task_configurations WHERE user_id = $1 AND task = $2`, userID, task)
+		return err
+	}
+	if _, err := s.QueryUserLLMConfiguration(userID, *configurationID); err != nil {
+		return err
+	}
+	_, err := s.DB.Exec(`
+		INSERT INTO user_llm_task_configurations (user_id, task, configuration_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, task) DO UPDATE SET configuration_id = $3, updated_at = NOW()
+	`, userID, task, *configurationID)
+	return err
+}
+
+func writeLLMError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "provider not found", "model not found", "configuration not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "provider has models", "model is in use", "configuration already exists":
+		http.Error(w, err.Error(), http.StatusConflict)
+	case "name is required", "name and model identifier are required", "unknown task":
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("llm configuration error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (s *Handler) GetLLMProvidersRoute(w http.ResponseWriter, r *http.Request) {
+	providers, err := s.QueryLLMProviders()
+	if err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(providers)
+}
+
+// admin protected
+func (s *Handler) CreateLLMProviderRoute(w http.ResponseWriter, r *http.Request) {
+	var params models.EditLLMProviderParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	provider, err := s.CreateLLMProvider(params)
+	if err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(provider)
+}
+
+// admin protected
+func (s *Handler) UpdateLLMProviderRoute(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var params models.EditLLMProviderParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	provider, err := s.UpdateLLMProvider(id, params)
+	if err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(provider)
+}
+
+// admin protected
+func (s *Handler) DeleteLLMProviderRoute(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := s.DeleteLLMProvider(id); err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetLLMModelsRoute lists the active models. Admins can include inactive
+// models with ?all=true.
+func (s *Handler) GetLLMModelsRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	includeInactive := false
+	if r.URL.Query().Get("all") == "true" {
+		user, err := s.QueryUser(userID)
+		includeInactive = err == nil && user.IsAdmin
+	}
+	results, err := s.QueryLLMModels(includeInactive)
+	if err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// admin protected
+func (s *Handler) CreateLLMModelRoute(w http.ResponseWriter, r *http.Request) {
+	var params models.CreateLLMModelRequest
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	model, err := s.CreateLLMModel(params)
+	if err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model)
+}
+
+// admin protected
+func (s *Handler) UpdateLLMModelRoute(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var params models.EditLLMModelParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	model, err := s.UpdateLLMModel(id, params)
+	if err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model)
+}
+
+// admin protected
+func (s *Handler) DeleteLLMModelRoute(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := s.DeleteLLMModel(id); err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Handler) GetUserLLMConfigurationsRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	configs, err := s.QueryUserLLMConfigurations(userID)
+	if err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(configs)
+}
+
+func (s *Handler) CreateUserLLMConfigurationRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	var params models.EditLLMConfigurationParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	config, err := s.CreateUserLLMConfiguration(userID, params)
+	if err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+func (s *Handler) UpdateUserLLMConfigurationRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var params models.EditLLMConfigurationParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	config, err := s.UpdateUserLLMConfiguration(userID, id, params)
+	if err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+func (s *Handler) DeleteUserLLMConfigurationRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := s.DeleteUserLLMConfiguration(userID, id); err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Handler) GetUserLLMTasksRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	tasks, err := s.QueryUserLLMTasks(userID)
+	if err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tasks)
+}
+
+func (s *Handler) UpdateUserLLMTaskRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	task := mux.Vars(r)["task"]
+
+	var params models.LLMTaskConfiguration
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.SetUserLLMTask(userID, task, params.ConfigurationID); err != nil {
+		writeLLMError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.LLMTaskConfiguration{Task: task, ConfigurationID: params.ConfigurationID})
+}
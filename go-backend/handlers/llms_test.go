@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"go-backend/llms"
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestResolveModelForTask(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	model, err := llms.ResolveModel(s.DB, 1, models.LLMTaskChat)
+	if err != nil {
+		t.Fatalf("failed to resolve model: %v", err)
+	}
+	if model.ModelIdentifier != "gpt-4" {
+		t.Errorf("expected the default configuration, got %v", model.ModelIdentifier)
+	}
+	if model.Provider.APIKey != "sk-test-key-1" {
+		t.Errorf("expected the user's own api key, got %q", model.Provider.APIKey)
+	}
+
+	config, err := s.CreateUserLLMConfiguration(1, models.EditLLMConfigurationParams{ModelID: 2})
+	if err != nil {
+		t.Fatalf("failed to create configuration: %v", err)
+	}
+	if err := s.SetUserLLMTask(1, models.LLMTaskFactExtraction, &config.ID); err != nil {
+		t.Fatalf("failed to assign task: %v", err)
+	}
+
+	model, _ = llms.ResolveModel(s.DB, 1, models.LLMTaskFactExtraction)
+	if model.ModelIdentifier != "gpt-3.5-turbo" {
+		t.Errorf("expected the task configuration, got %v", model.ModelIdentifier)
+	}
+	model, _ = llms.ResolveModel(s.DB, 1, models.LLMTaskChat)
+	if model.ModelIdentifier != "gpt-4" {
+		t.Errorf("other tasks should keep the default, got %v", model.ModelIdentifier)
+	}
+
+	if err := s.SetUserLLMTask(1, "unknown", &config.ID); err == nil {
+		t.Errorf("expected an error for an unknown task")
+	}
+	if err := s.SetUserLLMTask(2, models.LLMTaskChat, &config.ID); err == nil {
+		t.Errorf("users should not be able to use another user's configuration")
+	}
+}
+
+func TestResolveModelGlobalDefault(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	if _, err := llms.ResolveModel(s.DB, 3, models.LLMTaskChat); err == nil {
+		t.Errorf("expected no model for a user without configurations")
+	}
+
+	_, err := s.UpdateLLMModel(2, models.EditLLMModelParams{
+		Name:            "GPT-3.5 Turbo",
+		ModelIdentifier: "gpt-3.5-turbo",
+		IsActive:        true,
+		IsDefault:       true,
+	})
+	if err != nil {
+		t.Fatalf("failed to update model: %v", err)
+	}
+	model, err := llms.ResolveModel(s.DB, 3, models.LLMTaskChat)
+	if err != nil {
+		t.Fatalf("failed to resolve model: %v", err)
+	}
+	if model.ID != 2 {
+		t.Errorf("expected the default model, got %v", model.ID)
+	}
+}
+
+func TestUserLLMConfigurationDefault(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	key := "sk-own-key"
+	config, err := s.CreateUserLLMConfiguration(1, models.EditLLMConfigurationParams{
+		ModelID:   2,
+		APIKey:    &key,
+		IsDefault: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create configuration: %v", err)
+	}
+	if config.APIKey != "" || config.Model.Provider.APIKey != "" {
+		t.Errorf("api keys should not be returned")
+	}
+
+	configs, err := s.QueryUserLLMConfigurations(1)
+	if err != nil {
+		t.Fatalf("failed to query configurations: %v", err)
+	}
+	defaults := 0
+	for _, c := range configs {
+		if c.IsDefault {
+			defaults++
+			if c.ID != config.ID {
+				t.Errorf("wrong default configuration, got %v want %v", c.ID, config.ID)
+			}
+		}
+	}
+	if defaults != 1 {
+		t.Errorf("expected exactly one default configuration, got %v", defaults)
+	}
+
+	model, _ := llms.ResolveModel(s.DB, 1, models.LLMTaskChat)
+	if model.Provider.APIKey != key {
+		t.Errorf("expected the new key to be used, got %q", model.Provider.APIKey)
+	}
+
+	if _, err := s.CreateUserLLMConfiguration(1, models.EditLLMConfigurationParams{ModelID: 2}); err == nil {
+		t.Errorf("expected an error for a duplicate configuration")
+	}
+}
+
+func TestCreateLLMProviderRouteRequiresAdmin(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	token, _ := tests.GenerateTestJWT(2)
+	body := tests.CreateJsonBody(t, models.EditLLMProviderParams{Name: "Local"})
+	req, err := http.NewRequest("POST", "/api/llms/providers", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/llms/providers", s.JwtMiddleware(s.Admin(s.CreateLLMProviderRoute)))
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestDeleteLLMModelInUse(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	if err := s.DeleteLLMModel(1); err == nil || err.Error() != "model is in use" {
+		t.Errorf("expected model in use error, got %v", err)
+	}
+	if err := s.DeleteLLMProvider(1); err == nil || err.Error() != "provider has models" {
+		t.Errorf("expected provider has models error, got %v", err)
+	}
+}
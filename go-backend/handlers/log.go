@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"go-backend/models"
 	"log"
 	"net/http"
@@ -53,6 +54,26 @@ func (s *Handler) LogLastLogin(user models.User) {
 }
 
 func (s *Handler) CreateAuditEvent(userID int, entityID int, entityType string, action string, oldState interface{}, newState interface{}) error {
+	return s.createAuditEventWithData(userID, entityID, entityType, action, oldState, newState, nil)
+}
+
+// createAuditEventWithData records an audit event like CreateAuditEvent,
+// adding customData to its details
+func (s *Handler) createAuditEventWithData(userID int, entityID int, entityType string, action string, oldState interface{}, newState interface{}, customData map[string]interface{}) error {
+	return insertAuditEvent(s.DB, userID, entityID, entityType, action, oldState, newState, customData)
+}
+
+// createAuditEventTx records an audit event as part of a transaction, so it
+// is only kept if the change it describes is committed
+func createAuditEventTx(tx *sql.Tx, userID int, entityID int, entityType string, action string, oldState interface{}, newState interface{}, customData map[string]interface{}) error {
+	return insertAuditEvent(tx, userID, entityID, entityType, action, oldState, newState, customData)
+}
+
+type auditExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertAuditEvent(db auditExecer, userID int, entityID int, entityType string, action string, oldState interface{}, newState interface{}, customData map[string]interface{}) error {
 	changes := make(map[string]models.FieldChange)
 
 	// If we have both states, compute the differences
@@ -110,8 +131,14 @@ func (s *Handler) CreateAuditEvent(userID int, entityID int, entityType string,
 			"final_state": oldState,
 		}
 	}
+	for key, value := range customData {
+		if details.CustomData == nil {
+			details.CustomData = map[string]interface{}{}
+		}
+		details.CustomData[key] = value
+	}
 
-	_, err := s.DB.Exec(`
+	_, err := db.Exec(`
 		INSERT INTO audit_events (user_id, entity_id, entity_type, action, details)
 		VALUES ($1, $2, $3, $4, $5)
 	`, userID, entityID, entityType, action, details)
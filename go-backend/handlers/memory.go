@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"go-backend/llms"
+	"go-backend/models"
 	"io"
-	"log"
 	"net/http"
 )
 
@@ -14,19 +16,29 @@ func (s *Handler) GenerateMemory(userID uint, cardContent string) {
 		return
 	}
 
-	go func() {
-		client := llms.NewDefaultClient(s.DB, int(userID))
-		_, err := llms.GenerateUserMemory(s.DB, client, userID, cardContent)
-		if err != nil {
-			log.Printf("error generating user memory: %v", err)
-			return
-		}
-		_, err = s.DB.Exec("UPDATE users SET memory_has_changed = true WHERE id = $1", userID)
-		if err != nil {
-			log.Printf("failed to update memory_has_changed flag for user %d: %v", userID, err)
-			return
-		}
-	}()
+	s.enqueueJob(models.JobTypeGenerateMemory, int(userID), memoryJobPayload{CardContent: cardContent})
+}
+
+func (s *Handler) generateMemoryJob(ctx context.Context, job models.Job) error {
+	var payload memoryJobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return err
+	}
+	if job.UserID == nil {
+		return fmt.Errorf("memory job has no user")
+	}
+	userID := uint(*job.UserID)
+
+	client := llms.NewClientForTask(s.DB, int(userID), models.LLMTaskMemory)
+	_, err := llms.GenerateUserMemory(ctx, s.DB, client, userID, payload.CardContent)
+	if err != nil {
+		return fmt.Errorf("error generating user memory: %w", err)
+	}
+	_, err = s.DB.Exec("UPDATE users SET memory_has_changed = true WHERE id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to update memory_has_changed flag for user %d: %w", userID, err)
+	}
+	return nil
 }
 
 func (s *Handler) GetUserMemoryRoute(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// QueryOutline returns the tree of cards below the card with primary key
+// rootPK, or the user's whole forest when rootPK is 0. The tree is read by
+// parent_id in one recursive query. maxDepth limits how many levels below
+// the roots are returned; a negative maxDepth returns all of them.
+func (s *Handler) QueryOutline(userID, rootPK, maxDepth int) ([]models.OutlineNode, error) {
+	rows, err := s.DB.Query(`
+	WITH RECURSIVE tree AS (
+		SELECT c.id, c.card_id, c.title, c.parent_id, 0 AS depth, ARRAY[c.id] AS path
+		FROM cards c
+		WHERE c.user_id = $1 AND c.is_deleted = FALSE AND (
+			c.id = $2 OR ($2 = 0 AND (
+				c.parent_id IS NULL OR c.parent_id = c.id OR NOT EXISTS (
+					SELECT 1 FROM cards p
+					WHERE p.id = c.parent_id AND p.user_id = c.user_id AND p.is_deleted = FALSE
+				)
+			))
+		)
+		UNION ALL
+		SELECT c.id, c.card_id, c.title, c.parent_id, tree.depth + 1, tree.path || c.id
+		FROM cards c
+		JOIN tree ON c.parent_id = tree.id
+		WHERE c.user_id = $1 AND c.is_deleted = FALSE AND c.id != c.parent_id
+		AND NOT c.id = ANY(tree.path) AND ($3 < 0 OR tree.depth < $3)
+	)
+	SELECT
+		tree.id, COALESCE(tree.card_id, ''), COALESCE(tree.title, ''), COALESCE(tree.parent_id, 0), tree.depth,
+		(SELECT count(*) FROM cards ch
+			WHERE ch.parent_id = tree.id AND ch.id != tree.id AND ch.is_deleted = FALSE),
+		(SELECT count(*) FROM card_tags ct WHERE ct.card_pk = tree.id),
+		(SELECT count(*) FROM tasks t WHERE t.card_pk = tree.id AND t.is_deleted = FALSE),
+		(SELECT count(*) FROM tasks t WHERE t.card_pk = tree.id AND t.is_deleted = FALSE AND t.is_complete = FALSE)
+	FROM tree
+	`, userID, rootPK, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []models.OutlineNode
+	for rows.Next() {
+		var node models.OutlineNode
+		if err := rows.Scan(
+			&node.ID,
+			&node.CardID,
+			&node.Title,
+			&node.ParentID,
+			&node.Depth,
+			&node.ChildCount,
+			&node.TagCount,
+			&node.TaskCount,
+			&node.OpenTaskCount,
+		); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return buildOutline(nodes), nil
+}
+
+// buildOutline nests the nodes of a tree under their parents, in
+// Folgezettel order. Nodes at depth 0 are the roots.
+func buildOutline(nodes []models.OutlineNode) []models.OutlineNode {
+	children := map[int][]models.OutlineNode{}
+	var roots []models.OutlineNode
+	for _, node := range nodes {
+		if node.Depth == 0 {
+			roots = append(roots, node)
+		} else {
+			children[node.ParentID] = append(children[node.ParentID], node)
+		}
+	}
+
+	var attach func(nodes []models.OutlineNode) []models.OutlineNode
+	attach = func(nodes []models.OutlineNode) []models.OutlineNode {
+		sortOutline(nodes)
+		for i := range nodes {
+			nodes[i].Children = attach(children[nodes[i].ID])
+		}
+		return nodes
+	}
+	roots = attach(roots)
+	if roots == nil {
+		roots = []models.OutlineNode{}
+	}
+	return roots
+}
+
+// sortOutline sorts sibling nodes by card_id, with cards that have none
+// after the rest, by title
+func sortOutline(nodes []models.OutlineNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if (a.CardID == "") != (b.CardID == "") {
+			return b.CardID == ""
+		}
+		if a.CardID != b.CardID {
+			return cardIDLess(a.CardID, b.CardID)
+		}
+		return naturalLess(a.Title, b.Title)
+	})
+}
+
+func parseOutlineDepth(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("depth")
+	if value == "" {
+		return -1, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// GetOutlineRoute returns the outline of all of the user's cards
+func (s *Handler) GetOutlineRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	depth, err := parseOutlineDepth(r)
+	if err != nil {
+		http.Error(w, "Invalid depth", http.StatusBadRequest)
+		return
+	}
+
+	outline, err := s.QueryOutline(userID, 0, depth)
+	if err != nil {
+		log.Printf("outline error: %v", err)
+		http.Error(w, "Unable to build outline", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outline)
+}
+
+// GetCardOutlineRoute returns the outline below a card, with the card at
+// its root
+func (s *Handler) GetCardOutlineRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	depth, err := parseOutlineDepth(r)
+	if err != nil {
+		http.Error(w, "Invalid depth", http.StatusBadRequest)
+		return
+	}
+
+	outline, err := s.QueryOutline(userID, id, depth)
+	if err != nil {
+		log.Printf("outline error: %v", err)
+		http.Error(w, "Unable to build outline", http.StatusInternalServerError)
+		return
+	}
+	if len(outline) == 0 {
+		http.Error(w, "card not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outline[0])
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestBuildOutline(t *testing.T) {
+	nodes := []models.OutlineNode{
+		{ID: 1, CardID: "10", ParentID: 1},
+		{ID: 2, CardID: "9", ParentID: 2},
+		{ID: 3, CardID: "9/A.10", ParentID: 5, Depth: 2},
+		{ID: 4, CardID: "", Title: "Loose", ParentID: 4},
+		{ID: 5, CardID: "9/A", ParentID: 2, Depth: 1},
+		{ID: 6, CardID: "9/A.2", ParentID: 5, Depth: 2},
+	}
+	outline := buildOutline(nodes)
+
+	var order []string
+	var walk func(nodes []models.OutlineNode)
+	walk = func(nodes []models.OutlineNode) {
+		for _, node := range nodes {
+			order = append(order, node.CardID+node.Title)
+			walk(node.Children)
+		}
+	}
+	walk(outline)
+	expected := []string{"9", "9/A", "9/A.2", "9/A.10", "10", "Loose"}
+	if len(order) != len(expected) {
+		t.Fatalf("wrong outline, got %v want %v", order, expected)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("wrong outline, got %v want %v", order, expected)
+		}
+	}
+}
+
+func TestGetCardOutlineRoute(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	root, err := s.CreateCard(1, models.EditCardParams{CardID: "400", Title: "Root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cardID := range []string{"400/B", "400/A", "400/A.10", "400/A.2"} {
+		if _, err := s.CreateCard(1, models.EditCardParams{CardID: cardID, Title: cardID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.CreateTask(models.Task{UserID: 1, CardPK: root.ID, Title: "Open task"})
+
+	getOutline := func(query string) models.OutlineNode {
+		token, _ := tests.GenerateTestJWT(1)
+		req, _ := http.NewRequest("GET", "/api/cards/"+strconv.Itoa(root.ID)+"/outline"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/api/cards/{id}/outline", s.JwtMiddleware(s.GetCardOutlineRoute))
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+		}
+		var outline models.OutlineNode
+		tests.ParseJsonResponse(t, rr.Body.Bytes(), &outline)
+		return outline
+	}
+
+	outline := getOutline("")
+	if outline.TaskCount != 1 || outline.OpenTaskCount != 1 || len(outline.Children) != 2 {
+		t.Fatalf("wrong outline root, got %+v", outline)
+	}
+	first := outline.Children[0]
+	if first.CardID != "400/A" || len(first.Children) != 2 || first.Children[1].CardID != "400/A.10" {
+		t.Errorf("expected children in Folgezettel order, got %+v", first)
+	}
+
+	outline = getOutline("?depth=1")
+	first = outline.Children[0]
+	if len(first.Children) != 0 || first.ChildCount != 2 {
+		t.Errorf("expected the depth limit to cut off grandchildren but count them, got %+v", first)
+	}
+}
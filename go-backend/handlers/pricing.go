@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-backend/llms"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func writePricingError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "pricing not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "pricing overlaps an existing range":
+		http.Error(w, err.Error(), http.StatusConflict)
+	case "model identifier is required", "prices cannot be negative", "effective_to must be after effective_from":
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("llm pricing error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// admin protected
+func (s *Handler) GetLLMPricingRoute(w http.ResponseWriter, r *http.Request) {
+	pricing, err := llms.ListModelPricing(s.DB, r.URL.Query().Get("model"))
+	if err != nil {
+		writePricingError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pricing)
+}
+
+// admin protected
+func (s *Handler) CreateLLMPricingRoute(w http.ResponseWriter, r *http.Request) {
+	var params models.LLMModelPricing
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	pricing, err := llms.CreateModelPricing(s.DB, params)
+	if err != nil {
+		writePricingError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pricing)
+}
+
+// admin protected
+func (s *Handler) UpdateLLMPricingRoute(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var params models.LLMModelPricing
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	pricing, err := llms.UpdateModelPricing(s.DB, id, params)
+	if err != nil {
+		writePricingError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pricing)
+}
+
+// admin protected
+func (s *Handler) DeleteLLMPricingRoute(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := llms.DeleteModelPricing(s.DB, id); err != nil {
+		writePricingError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"go-backend/llms"
+	"go-backend/models"
+	"go-backend/tests"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestModelPricingEffectiveDates(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	changed := time.Now().UTC().Add(-24 * time.Hour).Truncate(time.Second)
+	_, err := llms.CreateModelPricing(s.DB, models.LLMModelPricing{
+		ModelIdentifier: "gpt-4",
+		PromptPer1K:     0.03,
+		CompletionPer1K: 0.06,
+	})
+	if err != nil {
+		t.Fatalf("failed to create pricing: %v", err)
+	}
+	_, err = llms.CreateModelPricing(s.DB, models.LLMModelPricing{
+		ModelIdentifier: "gpt-4",
+		PromptPer1K:     0.01,
+		CompletionPer1K: 0.03,
+		EffectiveFrom:   changed,
+	})
+	if err != nil {
+		t.Fatalf("failed to create new pricing: %v", err)
+	}
+
+	old, err := llms.GetModelPricing(s.DB, "gpt-4", changed.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("failed to get old pricing: %v", err)
+	}
+	if old.PromptPer1K != 0.03 || old.EffectiveTo == nil || !old.EffectiveTo.Equal(changed) {
+		t.Errorf("the old price should end when the new one starts, got %+v", old)
+	}
+	current, _ := llms.GetModelPricing(s.DB, "gpt-4", time.Now())
+	if current.PromptPer1K != 0.01 {
+		t.Errorf("wrong current price, got %v want %v", current.PromptPer1K, 0.01)
+	}
+
+	_, err = llms.UpdateModelPricing(s.DB, old.ID, models.LLMModelPricing{
+		ModelIdentifier: "gpt-4",
+		PromptPer1K:     0.03,
+		CompletionPer1K: 0.06,
+	})
+	if err == nil || err.Error() != "pricing overlaps an existing range" {
+		t.Errorf("expected an overlap error, got %v", err)
+	}
+
+	_, err = s.DB.Exec(`
+		INSERT INTO llm_query_log (user_id, model, prompt_tokens, completion_tokens, cost_usd, created_at)
+		VALUES
+			(1, 'gpt-4', 1000, 1000, NULL, $1),
+			(1, 'gpt-4', 1000, 1000, NULL, NOW()),
+			(1, 'unpriced', 1000, 1000, NULL, NOW())
+	`, changed.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := llms.RecomputeCosts(s.DB, time.Time{}, false)
+	if err != nil {
+		t.Fatalf("failed to recompute costs: %v", err)
+	}
+	if result.QueryLogRows != 2 {
+		t.Errorf("wrong number of repriced rows, got %v want %v", result.QueryLogRows, 2)
+	}
+
+	rows, err := s.DB.Query(`SELECT cost_usd FROM llm_query_log WHERE model = 'gpt-4' ORDER BY created_at`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var costs []float64
+	for rows.Next() {
+		var cost float64
+		if err := rows.Scan(&cost); err != nil {
+			t.Fatal(err)
+		}
+		costs = append(costs, cost)
+	}
+	if len(costs) != 2 || math.Abs(costs[0]-0.09) > 1e-9 || math.Abs(costs[1]-0.04) > 1e-9 {
+		t.Errorf("each request should use the price of its time, got %v", costs)
+	}
+}
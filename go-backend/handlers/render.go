@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultTransclusionDepth = 3
+	maxTransclusionDepth     = 10
+)
+
+var markdownHeadingPattern = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.*?)(\s+#+)?\s*$`)
+
+// transclusionLookup finds a live card by its card_id
+type transclusionLookup func(cardID string) (models.Card, bool)
+
+// transcluder expands the embeds in a card's body, remembering which cards
+// it has embedded along the way
+type transcluder struct {
+	lookup   transclusionLookup
+	embedded []string
+	seen     map[string]bool
+}
+
+func newTranscluder(lookup transclusionLookup) *transcluder {
+	return &transcluder{lookup: lookup, embedded: []string{}, seen: map[string]bool{}}
+}
+
+// expand replaces the ![card_id] and ![card_id#heading] embeds in body with
+// the quoted text of the cards they name, down to depth levels. chain holds
+// the card_ids already being expanded, so a card that embeds itself, directly
+// or not, is caught rather than expanded forever. Embeds past the depth limit
+// are left as plain references.
+func (t *transcluder) expand(body string, depth int, chain []string) string {
+	var out strings.Builder
+	last := 0
+	for _, match := range backlinkPattern.FindAllStringSubmatchIndex(body, -1) {
+		start, end := match[0], match[1]
+		if start == 0 || body[start-1] != '!' || isMarkdownLink(body, end) {
+			continue
+		}
+		target := body[match[2]:match[3]]
+		cardID, heading, _ := strings.Cut(target, "#")
+		cardID, heading = strings.TrimSpace(cardID), strings.TrimSpace(heading)
+
+		out.WriteString(body[last : start-1])
+		last = end
+		if depth <= 0 {
+			fmt.Fprintf(&out, "[%s]", cardID)
+			continue
+		}
+		out.WriteString("\n\n")
+		out.WriteString(t.embed(cardID, heading, depth, chain))
+		out.WriteString("\n\n")
+	}
+	out.WriteString(body[last:])
+	return out.String()
+}
+
+// embed renders one embedded card, or section of a card, as a block quote
+func (t *transcluder) embed(cardID, heading string, depth int, chain []string) string {
+	for _, expanding := range chain {
+		if expanding == cardID {
+			return fmt.Sprintf("> [%s] is already embedded above", cardID)
+		}
+	}
+	card, ok := t.lookup(cardID)
+	if !ok {
+		return fmt.Sprintf("> Card [%s] not found", cardID)
+	}
+	content := card.Body
+	title := fmt.Sprintf("[%s] %s", card.CardID, card.Title)
+	if heading != "" {
+		content, ok = markdownSection(card.Body, heading)
+		if !ok {
+			return fmt.Sprintf("> Heading %q not found in [%s]", heading, cardID)
+		}
+		title += " › " + heading
+	}
+	if !t.seen[card.CardID] {
+		t.seen[card.CardID] = true
+		t.embedded = append(t.embedded, card.CardID)
+	}
+
+	chain = append(chain[:len(chain):len(chain)], cardID)
+	content = strings.TrimSpace(t.expand(content, depth-1, chain))
+	return quoteMarkdown("**" + title + "**\n\n" + content)
+}
+
+// markdownSection returns the text below a heading, up to the next heading
+// of the same or a higher level. Headings are matched without regard to
+// case, and lines in fenced code blocks are never read as headings.
+func markdownSection(body, heading string) (string, bool) {
+	lines := strings.Split(body, "\n")
+	level := 0
+	start := -1
+	fenced := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			continue
+		}
+		match := markdownHeadingPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if start >= 0 && len(match[1]) <= level {
+			return strings.Join(lines[start:i], "\n"), true
+		}
+		if start < 0 && strings.EqualFold(match[2], heading) {
+			level = len(match[1])
+			start = i + 1
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
+	return strings.Join(lines[start:], "\n"), true
+}
+
+func quoteMarkdown(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isSafeDestination reports whether a link or image may point at
+// destination: an http, https or mailto URL, or a relative one
+func isSafeDestination(destination []byte) bool {
+	if len(destination) == 0 {
+		return false
+	}
+	u, err := url.Parse(string(destination))
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// unwrapNode replaces node with its children, keeping the text of a link or
+// the alt text of an image
+func unwrapNode(node ast.Node) {
+	parent := node.GetParent()
+	var children []ast.Node
+	for _, child := range parent.GetChildren() {
+		if child != node {
+			children = append(children, child)
+			continue
+		}
+		for _, grandchild := range node.GetChildren() {
+			grandchild.SetParent(parent)
+			children = append(children, grandchild)
+		}
+	}
+	parent.SetChildren(children)
+}
+
+// renderMarkdownHTML converts markdown to HTML. Raw HTML in the markdown is
+// dropped, and links and images that do not point at a trusted protocol
+// are reduced to their text, so the result is safe to show as is.
+func renderMarkdownHTML(text string) string {
+	p := parser.NewWithExtensions(parser.CommonExtensions | parser.AutoHeadingIDs)
+	doc := p.Parse([]byte(text))
+
+	var unsafe []ast.Node
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.Link:
+			if !isSafeDestination(n.Destination) {
+				unsafe = append(unsafe, n)
+			}
+		case *ast.Image:
+			if !isSafeDestination(n.Destination) {
+				unsafe = append(unsafe, n)
+			}
+		}
+		return ast.GoToNext
+	})
+	for _, node := range unsafe {
+		unwrapNode(node)
+	}
+
+	renderer := html.NewRenderer(html.RendererOptions{
+		Flags: html.CommonFlags | html.SkipHTML | html.NofollowLinks | html.NoreferrerLinks | html.NoopenerLinks,
+	})
+	return string(markdown.Render(doc, renderer))
+}
+
+// cardLookup finds the user's live cards by card_id, querying each card once
+func (s *Handler) cardLookup(userID int) transclusionLookup {
+	cache := map[string]*models.Card{}
+	return func(cardID string) (models.Card, bool) {
+		if card, ok := cache[cardID]; ok {
+			if card == nil {
+				return models.Card{}, false
+			}
+			return *card, true
+		}
+		var card models.Card
+		err := s.DB.QueryRow(`
+		SELECT id, card_id, title, body FROM cards
+		WHERE user_id = $1 AND card_id = $2 AND is_deleted = FALSE
+		ORDER BY id LIMIT 1
+		`, userID, cardID).Scan(&card.ID, &card.CardID, &card.Title, &card.Body)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("failed to look up card %q: %v", cardID, err)
+			}
+			cache[cardID] = nil
+			return models.Card{}, false
+		}
+		cache[cardID] = &card
+		return card, true
+	}
+}
+
+// RenderCard expands the cards a card transcludes, down to depth levels, and
+// renders the result as markdown and sanitized HTML
+func (s *Handler) RenderCard(userID, cardPK, depth int) (models.RenderedCard, error) {
+	card, err := s.queryCard(userID, cardPK)
+	if err != nil {
+		return models.RenderedCard{}, err
+	}
+	t := newTranscluder(s.cardLookup(userID))
+	body := t.expand(card.Body, depth, []string{card.CardID})
+	return models.RenderedCard{
+		ID:       card.ID,
+		CardID:   card.CardID,
+		Title:    card.Title,
+		Markdown: body,
+		HTML:     renderMarkdownHTML(body),
+		Embedded: t.embedded,
+	}, nil
+}
+
+// RenderCardRoute returns a card with its transclusions expanded
+func (s *Handler) RenderCardRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	depth := defaultTransclusionDepth
+	if value := r.URL.Query().Get("depth"); value != "" {
+		depth, err = strconv.Atoi(value)
+		if err != nil || depth < 0 || depth > maxTransclusionDepth {
+			http.Error(w, fmt.Sprintf("depth must be between 0 and %d", maxTransclusionDepth), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rendered, err := s.RenderCard(userID, id, depth)
+	if err == sql.ErrNoRows {
+		http.Error(w, "card not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("render error: %v", err)
+		http.Error(w, "Unable to render card", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rendered)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func testLookup(cards ...models.Card) transclusionLookup {
+	byID := map[string]models.Card{}
+	for _, card := range cards {
+		byID[card.CardID] = card
+	}
+	return func(cardID string) (models.Card, bool) {
+		card, ok := byID[cardID]
+		return card, ok
+	}
+}
+
+func TestExpandTransclusions(t *testing.T) {
+	lookup := testLookup(
+		models.Card{CardID: "1", Title: "One", Body: "First\n\n## Detail\nDeep ![2]\n\n## Other\nNot this"},
+		models.Card{CardID: "2", Title: "Two", Body: "Second ![1]"},
+		models.Card{CardID: "3", Title: "Three", Body: "Third ![1#Detail]"},
+	)
+
+	tr := newTranscluder(lookup)
+	got := tr.expand("![3] and ![9] and ![an image](cat.png)", 5, []string{"0"})
+	for _, want := range []string{
+		"> **[3] Three**",
+		"> > **[1] One › Detail**",
+		"> > > Second",
+		"> > > > [1] is already embedded above",
+		"> Card [9] not found",
+		"![an image](cat.png)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in the expansion, got %q", want, got)
+		}
+	}
+	if strings.Contains(got, "Not this") {
+		t.Errorf("expected only the Detail section to be embedded, got %q", got)
+	}
+	if len(tr.embedded) != 3 {
+		t.Errorf("expected three embedded cards, got %v", tr.embedded)
+	}
+
+	got = newTranscluder(lookup).expand("![3]", 1, nil)
+	if !strings.Contains(got, "Third [1]") {
+		t.Errorf("expected embeds past the depth limit to become references, got %q", got)
+	}
+}
+
+func TestMarkdownSection(t *testing.T) {
+	body := "# Title\n## A\nin a\n```\n# not a heading\n```\n### A.1\nin a.1\n## B\nin b"
+	section, ok := markdownSection(body, "a")
+	if !ok || section != "in a\n```\n# not a heading\n```\n### A.1\nin a.1" {
+		t.Errorf("wrong section, got %q", section)
+	}
+	if _, ok := markdownSection(body, "C"); ok {
+		t.Errorf("expected a missing heading not to be found")
+	}
+}
+
+func TestRenderMarkdownHTML(t *testing.T) {
+	got := renderMarkdownHTML("Hi <script>alert(1)</script> [x](javascript:alert(1))")
+	if strings.Contains(got, "<script>") || strings.Contains(got, `href="javascript:`) {
+		t.Errorf("expected unsafe markup to be removed, got %q", got)
+	}
+	if got := renderMarkdownHTML("see [here]() for more"); got != "<p>see here for more</p>\n" {
+		t.Errorf("expected an empty link to be reduced to its text, got %q", got)
+	}
+	if got := renderMarkdownHTML("![x](javascript:alert(1)) ![y](JavaScript:alert(1))"); strings.Contains(got, "<img") || !strings.Contains(got, "x") {
+		t.Errorf("expected an unsafe image to be reduced to its alt text, got %q", got)
+	}
+	got = renderMarkdownHTML("[a](https://example.com) ![b](/files/b.png) [c](mailto:c@example.com) [d](12/A)")
+	if !strings.Contains(got, `href="https://example.com"`) || !strings.Contains(got, `src="/files/b.png"`) ||
+		!strings.Contains(got, `href="mailto:c@example.com"`) || !strings.Contains(got, `href="12/A"`) {
+		t.Errorf("expected safe links and images to be kept, got %q", got)
+	}
+}
+
+func TestRenderCardRoute(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	if _, err := s.CreateCard(1, models.EditCardParams{CardID: "700", Title: "Embedded", Body: "Embedded text"}); err != nil {
+		t.Fatal(err)
+	}
+	structure, err := s.CreateCard(1, models.EditCardParams{CardID: "701", Title: "Structure", Body: "Gathering:\n\n![700]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := tests.GenerateTestJWT(1)
+	req, _ := http.NewRequest("GET", "/api/cards/"+strconv.Itoa(structure.ID)+"/render", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/cards/{id}/render", s.JwtMiddleware(s.RenderCardRoute))
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+	var rendered models.RenderedCard
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &rendered)
+	if !strings.Contains(rendered.Markdown, "> Embedded text") || !strings.Contains(rendered.HTML, "<blockquote>") {
+		t.Errorf("expected the embedded card to be quoted, got %+v", rendered)
+	}
+	if len(rendered.Embedded) != 1 || rendered.Embedded[0] != "700" {
+		t.Errorf("wrong embedded cards, got %v", rendered.Embedded)
+	}
+
+	var linkType string
+	err = s.DB.QueryRow("SELECT link_type FROM backlinks WHERE source_id_int = $1", structure.ID).Scan(&linkType)
+	if err != nil || linkType != models.LinkTypeTransclusion {
+		t.Errorf("expected a transclusion link to be recorded, got %q %v", linkType, err)
+	}
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// revisionFields are the fields of a card that make up a revision, by the
+// names CreateAuditEvent records them under
+var revisionFields = []string{"CardID", "Title", "Body", "Link"}
+
+// maxDiffCells bounds the work of a line diff. Larger diffs are shown as the
+// whole of one revision replacing the other.
+const maxDiffCells = 4_000_000
+
+func revisionField(revision *models.CardRevision, field string) *string {
+	switch field {
+	case "CardID":
+		return &revision.CardID
+	case "Title":
+		return &revision.Title
+	case "Body":
+		return &revision.Body
+	case "Link":
+		return &revision.Link
+	}
+	return nil
+}
+
+func auditString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// queryCardAuditEvents returns the audit events of a card, oldest first
+func (s *Handler) queryCardAuditEvents(cardPK int) ([]models.AuditEvent, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, user_id, entity_id, entity_type, action, details, created_at
+		FROM audit_events
+		WHERE entity_type = 'card' AND entity_id = $1
+		ORDER BY created_at, id
+	`, cardPK)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		if err := rows.Scan(
+			&event.ID,
+			&event.UserID,
+			&event.EntityID,
+			&event.EntityType,
+			&event.Action,
+			&event.Details,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// GetCardRevisions rebuilds the history of a card's title and body from its
+// audit events. Updates only record the fields that changed, so the history
+// is replayed backwards from the card as it is now. Cards changed before
+// they were audited start with an "original" revision holding their state
+// before the first recorded change.
+func (s *Handler) GetCardRevisions(userID, cardPK int) ([]models.CardRevision, error) {
+	card, err := s.queryCard(userID, cardPK)
+	if err != nil {
+		return nil, fmt.Errorf("card not found")
+	}
+	events, err := s.queryCardAuditEvents(cardPK)
+	if err != nil {
+		return nil, err
+	}
+
+	current := models.CardRevision{CardID: card.CardID, Title: card.Title, Body: card.Body, Link: card.Link}
+	after := make([]models.CardRevision, len(events))
+	changed := make([][]string, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		after[i] = current
+		for _, field := range revisionFields {
+			change, ok := events[i].Details.Changes[field]
+			if !ok {
+				continue
+			}
+			*revisionField(&current, field) = auditString(change.From)
+			changed[i] = append(changed[i], field)
+		}
+	}
+
+	var revisions []models.CardRevision
+	if len(events) == 0 || events[0].Action != "create" {
+		original := current
+		original.Action = "original"
+		original.CreatedAt = card.CreatedAt
+		original.ChangedFields = []string{}
+		revisions = append(revisions, original)
+	}
+	for i, event := range events {
+		if event.Action != "create" && len(changed[i]) == 0 {
+			continue
+		}
+		revision := after[i]
+		revision.EventID = event.ID
+		revision.Action = event.Action
+		revision.UserID = event.UserID
+		revision.CreatedAt = event.CreatedAt
+		revision.ChangedFields = changed[i]
+		if revision.ChangedFields == nil {
+			revision.ChangedFields = []string{}
+		}
+		if from, ok := event.Details.CustomData["restored_from"].(float64); ok {
+			revision.RestoredFrom = int(from)
+		}
+		revisions = append(revisions, revision)
+	}
+	for i := range revisions {
+		revisions[i].Number = i + 1
+	}
+	return revisions, nil
+}
+
+func findRevision(revisions []models.CardRevision, number int) (models.CardRevision, error) {
+	if number < 1 || number > len(revisions) {
+		return models.CardRevision{}, fmt.Errorf("revision not found")
+	}
+	return revisions[number-1], nil
+}
+
+// diffLines compares two texts line by line, using the longest common
+// subsequence of their lines
+func diffLines(from, to string) []models.DiffLine {
+	a, b := splitLines(from), splitLines(to)
+	diff := []models.DiffLine{}
+
+	if len(a)*len(b) > maxDiffCells {
+		for i, line := range a {
+			diff = append(diff, models.DiffLine{Op: "delete", Text: line, OldLine: i + 1})
+		}
+		for j, line := range b {
+			diff = append(diff, models.DiffLine{Op: "insert", Text: line, NewLine: j + 1})
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, models.DiffLine{Op: "equal", Text: a[i], OldLine: i + 1, NewLine: j + 1})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			diff = append(diff, models.DiffLine{Op: "insert", Text: b[j], NewLine: j + 1})
+			j++
+		default:
+			diff = append(diff, models.DiffLine{Op: "delete", Text: a[i], OldLine: i + 1})
+			i++
+		}
+	}
+	return diff
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// RestoreCardRevision writes the title, body and link of a past revision
+// back to the card. The card keeps its current card_id, which other cards
+// may link to. The restore is audited as its own revision.
+func (s *Handler) RestoreCardRevision(userID, cardPK, number int) (models.Card, error) {
+	revisions, err := s.GetCardRevisions(userID, cardPK)
+	if err != nil {
+		return models.Card{}, err
+	}
+	revision, err := findRevision(revisions, number)
+	if err != nil {
+		return models.Card{}, err
+	}
+	card, err := s.queryCard(userID, cardPK)
+	if err != nil {
+		return models.Card{}, fmt.Errorf("card not found")
+	}
+
+	params := models.EditCardParams{
+		CardID: card.CardID,
+		Title:  revision.Title,
+		Body:   revision.Body,
+		Link:   revision.Link,
+	}
+	return s.updateCard(userID, cardPK, params, "", "restore", map[string]interface{}{
+		"restored_from": revision.Number,
+	})
+}
+
+func writeRevisionError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "card not found", "revision not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Printf("revision error: %v", err)
+		http.Error(w, "Error retrieving revisions", http.StatusInternalServerError)
+	}
+}
+
+func (s *Handler) GetCardRevisionsRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	cardPK, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	revisions, err := s.GetCardRevisions(userID, cardPK)
+	if err != nil {
+		writeRevisionError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// GetCardRevisionDiffRoute compares two revisions, given as the from and to
+// query parameters. They default to the two latest revisions.
+func (s *Handler) GetCardRevisionDiffRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	cardPK, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+
+	revisions, err := s.GetCardRevisions(userID, cardPK)
+	if err != nil {
+		writeRevisionError(w, err)
+		return
+	}
+
+	to := len(revisions)
+	from := max(to-1, 1)
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid from revision", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid to revision", http.StatusBadRequest)
+			return
+		}
+	}
+	fromRevision, err := findRevision(revisions, from)
+	if err != nil {
+		writeRevisionError(w, err)
+		return
+	}
+	toRevision, err := findRevision(revisions, to)
+	if err != nil {
+		writeRevisionError(w, err)
+		return
+	}
+
+	diff := models.RevisionDiff{
+		From:      from,
+		To:        to,
+		TitleFrom: fromRevision.Title,
+		TitleTo:   toRevision.Title,
+		Lines:     diffLines(fromRevision.Body, toRevision.Body),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+func (s *Handler) RestoreCardRevisionRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	cardPK, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid card ID", http.StatusBadRequest)
+		return
+	}
+	number, err := strconv.Atoi(mux.Vars(r)["revision"])
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	card, err := s.RestoreCardRevision(userID, cardPK, number)
+	if err != nil {
+		writeRevisionError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(card)
+}
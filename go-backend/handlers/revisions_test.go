@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDiffLines(t *testing.T) {
+	diff := diffLines("one\ntwo\nthree", "one\n2\nthree\nfour")
+	var ops []string
+	for _, line := range diff {
+		ops = append(ops, line.Op+" "+line.Text)
+	}
+	expected := []string{"equal one", "delete two", "insert 2", "equal three", "insert four"}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Errorf("wrong diff, got %v want %v", ops, expected)
+	}
+}
+
+func TestCardRevisionsAndRestore(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	card, err := s.CreateCard(1, models.EditCardParams{CardID: "700", Title: "First", Body: "one\ntwo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.UpdateCard(1, card.ID, models.EditCardParams{CardID: "700", Title: "Second", Body: "one\nthree"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := s.GetCardRevisions(1, card.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Title != "First" || revisions[1].Body != "one\nthree" {
+		t.Fatalf("wrong revisions, got %+v", revisions)
+	}
+
+	token, _ := tests.GenerateTestJWT(1)
+	req, _ := http.NewRequest("POST", "/api/cards/"+strconv.Itoa(card.ID)+"/revisions/1/restore", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/cards/{id}/revisions/{revision}/restore", s.JwtMiddleware(s.RestoreCardRevisionRoute))
+	router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+
+	var restored models.Card
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &restored)
+	if restored.Title != "First" || restored.Body != "one\ntwo" {
+		t.Errorf("expected the first revision to be restored, got %q %q", restored.Title, restored.Body)
+	}
+
+	revisions, _ = s.GetCardRevisions(1, card.ID)
+	latest := revisions[len(revisions)-1]
+	if len(revisions) != 3 || latest.Action != "restore" || latest.RestoredFrom != 1 {
+		t.Errorf("expected the restore to be a revision, got %+v", latest)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/cards/"+strconv.Itoa(card.ID)+"/revisions/diff?from=2&to=3", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	router = mux.NewRouter()
+	router.HandleFunc("/api/cards/{id}/revisions/diff", s.JwtMiddleware(s.GetCardRevisionDiffRoute))
+	router.ServeHTTP(rr, req)
+	var diff models.RevisionDiff
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &diff)
+	if diff.TitleFrom != "Second" || diff.TitleTo != "First" || len(diff.Lines) != 3 {
+		t.Errorf("wrong diff, got %+v", diff)
+	}
+}
@@ -120,7 +120,7 @@ func (s *Handler) deleteObject(client *s3.Client, key string) error {
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		log.Fatalf("unable to delete item %q, %v", key, err)
+		log.Printf("unable to delete item %q, %v", key, err)
 		return err
 	}
 	//	fmt.Printf("Successfully deleted %q from %q\n", key, bucketName)
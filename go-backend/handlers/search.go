@@ -24,6 +24,7 @@ type SearchParams struct {
 	NegateTerms    []string
 	Entities       []string
 	NegateEntities []string
+	Query          *SearchQuery
 }
 
 func (s *Handler) InitSearchCollection() {
@@ -79,6 +80,7 @@ func (s *Handler) InitSearchCollection() {
 				"entity_pk":             -1,
 				"user_id":               userID,
 				"type":                  "card",
+				"result_type":           "card",
 				"title":                 title,
 				"preview":               body,
 				"parent_id":             parentID,
@@ -89,6 +91,9 @@ func (s *Handler) InitSearchCollection() {
 				"linked_card_title":     "",
 				"linked_card_parent_id": -1,
 			}
+			if err := s.addCardFilterFields(cardPK, doc); err != nil {
+				log.Printf("failed to load filter fields of card ID %d: %v", cardPK, err)
+			}
 
 			// Upsert (insert or overwrite if exists)
 			_, err = s.Server.TypesenseClient.Collection(collectionName).
@@ -139,6 +144,7 @@ func (s *Handler) InitSearchCollection() {
 				"entity_pk":             -1,
 				"user_id":               userID,
 				"type":                  "fact",
+				"result_type":           "fact",
 				"title":                 factText,
 				"preview":               "",
 				"score":                 0.0,
@@ -217,6 +223,8 @@ func (s *Handler) InitSearchCollection() {
 				"card_pk":               -1,
 				"fact_pk":               -1,
 				"type":                  "entity",
+				"result_type":           "entity",
+				"entity_type":           etype,
 				"user_id":               userID,
 				"title":                 name,
 				"parent_id":             -1,
@@ -253,181 +261,84 @@ func contains[T comparable](collection []T, target T) bool {
 	}
 	return false
 }
+
+// ParseSearchText parses a search query. The clauses that must all match
+// are also listed by kind, for searches that only understand those.
 func ParseSearchText(input string) SearchParams {
 	var searchParams SearchParams
-	var currentEntity strings.Builder
-	inEntity := false
-
-	// Split the input string by spaces, but preserve spaces within @[...]
-	parts := strings.Fields(input)
-
-	for i := 0; i < len(parts); i++ {
-		part := parts[i]
-
-		// Handle entity start
-		if strings.HasPrefix(part, "@[") {
-			inEntity = true
-			// Remove @[ prefix
-			currentEntity.WriteString(strings.TrimPrefix(part, "@["))
-
-			// If the entity name ends in this part
-			if strings.HasSuffix(part, "]") {
-				entityName := currentEntity.String()
-				entityName = strings.TrimSuffix(entityName, "]")
-				searchParams.Entities = append(searchParams.Entities, entityName)
-				currentEntity.Reset()
-				inEntity = false
-				continue
-			}
-			continue
-		}
+	searchParams.Query = ParseSearchQuery(input)
 
-		// Handle entity start with negation
-		if strings.HasPrefix(part, "!@[") {
-			inEntity = true
-			// Remove !@[ prefix
-			currentEntity.WriteString(strings.TrimPrefix(part, "!@["))
-
-			// If the entity name ends in this part
-			if strings.HasSuffix(part, "]") {
-				entityName := currentEntity.String()
-				entityName = strings.TrimSuffix(entityName, "]")
-				searchParams.NegateEntities = append(searchParams.NegateEntities, entityName)
-				currentEntity.Reset()
-				inEntity = false
-				continue
+	for _, clause := range searchConjuncts(searchParams.Query) {
+		switch {
+		case clause.Kind == searchTerm || clause.Kind == searchPhrase:
+			if clause.Negate {
+				searchParams.NegateTerms = append(searchParams.NegateTerms, clause.Value)
+			} else {
+				searchParams.Terms = append(searchParams.Terms, clause.Value)
 			}
-			continue
-		}
-
-		// Handle middle or end of entity name
-		if inEntity {
-			if strings.HasSuffix(part, "]") {
-				currentEntity.WriteString(" ")
-				currentEntity.WriteString(strings.TrimSuffix(part, "]"))
-				entityName := currentEntity.String()
-				if strings.HasPrefix(parts[i-1], "!") {
-					searchParams.NegateEntities = append(searchParams.NegateEntities, entityName)
-				} else {
-					searchParams.Entities = append(searchParams.Entities, entityName)
-				}
-				currentEntity.Reset()
-				inEntity = false
-				continue
+		case clause.Kind == searchTag:
+			if clause.Negate {
+				searchParams.NegateTags = append(searchParams.NegateTags, clause.Value)
+			} else {
+				searchParams.Tags = append(searchParams.Tags, clause.Value)
+			}
+		case clause.Kind == searchEntity:
+			if clause.Negate {
+				searchParams.NegateEntities = append(searchParams.NegateEntities, clause.Value)
+			} else {
+				searchParams.Entities = append(searchParams.Entities, clause.Value)
 			}
-			currentEntity.WriteString(" ")
-			currentEntity.WriteString(part)
-			continue
-		}
-
-		// Handle existing conditions
-		if strings.HasPrefix(part, "#") {
-			searchParams.Tags = append(searchParams.Tags, strings.TrimPrefix(part, "#"))
-		} else if strings.HasPrefix(part, "!#") {
-			searchParams.NegateTags = append(searchParams.NegateTags, strings.TrimPrefix(part, "!#"))
-		} else if strings.HasPrefix(part, "!") {
-			searchParams.NegateTerms = append(searchParams.NegateTerms, strings.TrimPrefix(part, "!"))
-		} else {
-			searchParams.Terms = append(searchParams.Terms, part)
 		}
 	}
 
 	return searchParams
 }
-func BuildPartialCardSqlSearchTermString(searchString string, fullText bool) string {
-	searchParams := ParseSearchText(searchString)
-
-	var result string
-	var termConditions []string
-	var tagConditions []string
-	var negateTagsConditions []string
-	var excludeTerms []string
-	var entityConditions []string
-	var negateEntityConditions []string
-
-	// Add conditions for terms that search both card_id and title
-	for _, term := range searchParams.Terms {
-		// Use ILIKE for case-insensitive pattern matching
-		var termCondition string
-		if fullText {
-			termCondition = fmt.Sprintf("(card_id ILIKE '%%%s%%' OR title ILIKE '%%%s%%' OR body ILIKE '%%%s%%')", term, term, term)
-
-		} else {
-			termCondition = fmt.Sprintf("(card_id ILIKE '%%%s%%' OR title ILIKE '%%%s%%')", term, term)
-
-		}
-		termConditions = append(termConditions, termCondition)
-	}
-
-	for _, term := range searchParams.NegateTerms {
-		var excludeCondition string
-		if fullText {
-			excludeCondition = fmt.Sprintf("NOT (card_id ILIKE '%%%s%%' OR title ILIKE '%%%s%%' OR body ILIKE '%%%s%%')", term, term, term)
-		} else {
-			excludeCondition = fmt.Sprintf("NOT (card_id ILIKE '%%%s%%' OR title ILIKE '%%%s%%')", term, term)
-		}
-		excludeTerms = append(excludeTerms, excludeCondition)
-	}
 
-	// Add conditions for tags
-	for _, tag := range searchParams.Tags {
-		tagCondition := fmt.Sprintf(`EXISTS (
-            SELECT 1 FROM card_tags
-            JOIN tags ON card_tags.tag_id = tags.id
-            WHERE card_tags.card_pk = c.id AND tags.name = '%s' AND tags.is_deleted = FALSE
-        )`, tag)
-		tagConditions = append(tagConditions, tagCondition)
+// searchConjuncts are the clauses of a query that must all match
+func searchConjuncts(query *SearchQuery) []*SearchQuery {
+	if query == nil {
+		return nil
 	}
-	// Build SQL for tags that should NOT exist
-	for _, tag := range searchParams.NegateTags {
-		tagCondition := fmt.Sprintf(`NOT EXISTS (
-            SELECT 1 FROM card_tags
-            JOIN tags ON card_tags.tag_id = tags.id
-            WHERE card_tags.card_pk = c.id AND tags.name = '%s' AND tags.is_deleted = FALSE
-        )`, tag)
-		negateTagsConditions = append(negateTagsConditions, tagCondition)
-	}
-
-	// Add conditions for entities
-	for _, entity := range searchParams.Entities {
-		entityCondition := fmt.Sprintf(`EXISTS (
-            SELECT 1 FROM entity_card_junction ecj
-            JOIN entities e ON ecj.entity_id = e.id
-            WHERE ecj.card_pk = c.id AND e.name = '%s'
-        )`, entity)
-		entityConditions = append(entityConditions, entityCondition)
+	if query.Kind == searchAnd && !query.Negate {
+		return query.Children
 	}
+	return []*SearchQuery{query}
+}
 
-	// Add conditions for negated entities
-	for _, entity := range searchParams.NegateEntities {
-		entityCondition := fmt.Sprintf(`NOT EXISTS (
-            SELECT 1 FROM entity_card_junction ecj
-            JOIN entities e ON ecj.entity_id = e.id
-            WHERE ecj.card_pk = c.id AND e.name = '%s'
-        )`, entity)
-		negateEntityConditions = append(negateEntityConditions, entityCondition)
-	}
+func BuildPartialCardSqlSearchTermString(searchString string, fullText bool) string {
+	return buildCardSearchSQL(ParseSearchText(searchString).Query, fullText, time.Now())
+}
 
-	if len(tagConditions) > 0 {
-		result = " AND (" + strings.Join(tagConditions, " AND ") + ")"
+// buildCardSearchSQL compiles a query to conditions on the cards aliased c,
+// to follow a WHERE clause. The clauses that must all match are grouped by
+// kind: tags, terms, excluded terms, excluded tags, entities, excluded
+// entities, and then field filters and nested groups.
+func buildCardSearchSQL(query *SearchQuery, fullText bool, now time.Time) string {
+	groups := make([][]string, 7)
+	for _, clause := range searchConjuncts(query) {
+		group := 6
+		switch {
+		case clause.Kind == searchTag && !clause.Negate:
+			group = 0
+		case (clause.Kind == searchTerm || clause.Kind == searchPhrase) && !clause.Negate:
+			group = 1
+		case clause.Kind == searchTerm || clause.Kind == searchPhrase:
+			group = 2
+		case clause.Kind == searchTag:
+			group = 3
+		case clause.Kind == searchEntity && !clause.Negate:
+			group = 4
+		case clause.Kind == searchEntity:
+			group = 5
+		}
+		groups[group] = append(groups[group], clause.cardSQL(fullText, now))
 	}
 
-	if len(termConditions) > 0 {
-		result += " AND (" + strings.Join(termConditions, " AND ") + ")"
-	}
-	if len(excludeTerms) > 0 {
-		excludeClause := strings.Join(excludeTerms, " AND ")
-		result += " AND (" + excludeClause + ")"
-	}
-	if len(negateTagsConditions) > 0 {
-		negateTagClause := strings.Join(negateTagsConditions, " AND ")
-		result += " AND (" + negateTagClause + ")"
-	}
-	if len(entityConditions) > 0 {
-		result += " AND (" + strings.Join(entityConditions, " AND ") + ")"
-	}
-	if len(negateEntityConditions) > 0 {
-		result += " AND (" + strings.Join(negateEntityConditions, " AND ") + ")"
+	var result string
+	for _, conditions := range groups {
+		if len(conditions) > 0 {
+			result += " AND (" + strings.Join(conditions, " AND ") + ")"
+		}
 	}
 	return result
 }
@@ -509,7 +420,7 @@ func (s *Handler) ClassicEntitySearch(userID int, params SearchRequestParams) ([
 	// 	}
 	// 	embedding = pgvector.NewVector(dummy)
 	// } else {
-	// 	embedding, err = llms.GetEmbedding1024(params.SearchTerm, false)
+	// 	embedding, err = llms.GetEmbedding1024(ctx, params.SearchTerm, false)
 	// 	if err != nil {
 	// 		return nil, err
 	// 	}
@@ -534,6 +445,7 @@ func (s *Handler) ClassicEntitySearch(userID int, params SearchRequestParams) ([
 		WHERE e.user_id = $1` + searchString + `
 		GROUP BY e.id, e.user_id, e.name, e.description, e.type, e.created_at, e.updated_at, e.card_pk,
 				c.id, c.card_id, c.title, c.user_id, c.parent_id, c.created_at, c.updated_at
+		ORDER BY e.id
 			LIMIT 500
 				`
 
@@ -622,7 +534,7 @@ GROUP BY
     c.parent_id,
     c.created_at,
     c.updated_at
-ORDER BY c.created_at DESC
+ORDER BY c.created_at DESC, c.id DESC
 	`
 
 	rows, err := s.DB.Query(query, userID)
@@ -636,19 +548,39 @@ ORDER BY c.created_at DESC
 
 type SearchRequestParams struct {
 	SearchTerm   string `json:"search_term"`
-	SearchType   string `json:"search_type"` // "classic" or "semantic"
+	SearchType   string `json:"search_type"` // "classic", "typesense", "postgres" or "hybrid"
 	FullText     bool   `json:"full_text"`
 	ShowEntities bool   `json:"show_entities"`
 	ShowFacts    bool   `json:"show_facts"`
 	SortBy       string `json:"sort"`
 	Rerank       bool   `json:"rerank"`
+	// Fusion and SemanticWeight tune hybrid search: "rrf" (the default) or
+	// "weighted", and the share of a weighted score given to semantic
+	// similarity, 0.5 if unset
+	Fusion         string   `json:"fusion"`
+	SemanticWeight *float64 `json:"semantic_weight"`
+	// Cursor continues from the next_cursor of an earlier page. Without
+	// one, Page picks a page by number, counting from 1.
+	Cursor   string `json:"cursor"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"page_size"`
 }
 
-func (s *Handler) TypesenseSearch(searchParams SearchRequestParams, userID int) ([]models.SearchResult, error) {
+// TypesenseSearch fetches a page of results from Typesense, which counts
+// the results and facets them
+func (s *Handler) TypesenseSearch(ctx context.Context, searchParams SearchRequestParams, userID int) (models.SearchResponse, error) {
 	log.Printf("typesense")
-	perPage := 250
+	response := models.SearchResponse{Results: []models.SearchResult{}}
+	offset, limit, err := searchPage(searchParams)
+	if err != nil {
+		return response, err
+	}
+	facetBy := "tags,entity_type,result_type"
+	maxFacetValues := searchFacetLimit
+	query := ParseSearchQuery(searchParams.SearchTerm)
+	searchTerm := searchText(query)
 	var sortBy string
-	if searchParams.SearchTerm == "" {
+	if searchTerm == "" {
 		sortBy = "created_at:desc"
 	} else {
 		switch searchParams.SortBy {
@@ -682,19 +614,26 @@ func (s *Handler) TypesenseSearch(searchParams SearchRequestParams, userID int)
 	if len(typeFilters) > 0 {
 		filter += " && " + strings.Join(typeFilters, " && ")
 	}
+	if query != nil {
+		if queryFilter := query.typesenseFilter(time.Now()); queryFilter != "" {
+			filter += " && " + queryFilter
+		}
+	}
 
 	var results []models.SearchResult
-	searchTerm := searchParams.SearchTerm
 	if searchTerm == "" {
 		searchTerm = "*"
 	}
 
 	typesenseParams := &api.SearchCollectionParams{
-		Q:        searchTerm,
-		QueryBy:  "card_id, title, embedding",
-		FilterBy: &filter,
-		SortBy:   &sortBy,
-		PerPage:  &perPage,
+		Q:              searchTerm,
+		QueryBy:        "card_id, title, embedding",
+		FilterBy:       &filter,
+		SortBy:         &sortBy,
+		Offset:         &offset,
+		Limit:          &limit,
+		FacetBy:        &facetBy,
+		MaxFacetValues: &maxFacetValues,
 	}
 	log.Printf("%v", typesenseParams)
 	collectionName := os.Getenv("TYPESENSE_COLLECTION")
@@ -702,10 +641,13 @@ func (s *Handler) TypesenseSearch(searchParams SearchRequestParams, userID int)
 
 	if err != nil {
 		log.Printf("Search error: %v", err)
-		return results, err
+		return response, err
 	}
 
 	fmt.Printf("Found %d docs\n", *typesenseResults.Found)
+	response.Total = *typesenseResults.Found
+	response.NextCursor = nextSearchCursor(offset, limit, response.Total)
+	response.Facets = typesenseFacets(typesenseResults.FacetCounts)
 	for i, hit := range *typesenseResults.Hits {
 		if hit.Document != nil {
 			doc := *hit.Document
@@ -717,6 +659,7 @@ func (s *Handler) TypesenseSearch(searchParams SearchRequestParams, userID int)
 				CreatedAt: time.Unix(int64(doc["created_at"].(float64)), 0),
 				UpdatedAt: time.Unix(int64(doc["updated_at"].(float64)), 0),
 			}
+			item.Highlights = typesenseHighlights(hit.Highlights)
 			resultType := doc["type"]
 			if resultType == "card" {
 
@@ -764,25 +707,76 @@ func (s *Handler) TypesenseSearch(searchParams SearchRequestParams, userID int)
 		}
 	}
 
-	var reranked []models.SearchResult
-	if s.Server.Testing {
-		reranked = results
-	} else if !searchParams.Rerank {
-		reranked = results
-	} else {
+	if len(results) > 0 {
+		response.Results = results
+	}
+	if !s.Server.Testing && searchParams.Rerank && len(results) > 0 {
 		log.Printf("reranking")
-		if len(results) > 0 {
-			client := llms.NewDefaultClient(s.DB, userID)
-			reranked, err = llms.RerankSearchResults(client, searchParams.SearchTerm, results)
-			if err != nil {
-				return results, nil
+		client := llms.NewClientForTask(s.DB, userID, models.LLMTaskSearch)
+		reranked, err := llms.RerankSearchResults(ctx, client, searchParams.SearchTerm, results)
+		if isLLMQuotaError(err) {
+			return models.SearchResponse{}, err
+		}
+		if err == nil {
+			response.Results = reranked
+		}
+	}
+	return response, nil
+}
+
+// typesenseFacets reads the facet counts of a Typesense search
+func typesenseFacets(facetCounts *[]api.FacetCounts) models.SearchFacets {
+	facets := models.SearchFacets{Tags: []models.FacetCount{}, EntityTypes: []models.FacetCount{}, Types: []models.FacetCount{}}
+	if facetCounts == nil {
+		return facets
+	}
+	for _, field := range *facetCounts {
+		if field.FieldName == nil || field.Counts == nil {
+			continue
+		}
+		var counts []models.FacetCount
+		for _, count := range *field.Counts {
+			if count.Value != nil && count.Count != nil {
+				counts = append(counts, models.FacetCount{Value: *count.Value, Count: *count.Count})
 			}
 		}
+		if counts == nil {
+			continue
+		}
+		switch *field.FieldName {
+		case "tags":
+			facets.Tags = counts
+		case "entity_type":
+			facets.EntityTypes = counts
+		case "result_type":
+			facets.Types = counts
+		}
 	}
-	return reranked, nil
+	return facets
 }
 
-func (s *Handler) ClassicSearch(searchParams SearchRequestParams, userID int) ([]models.SearchResult, error) {
+// typesenseHighlights reads the snippets Typesense marked the matches in
+func typesenseHighlights(highlights *[]api.SearchHighlight) []models.SearchHighlight {
+	if highlights == nil {
+		return nil
+	}
+	var result []models.SearchHighlight
+	for _, highlight := range *highlights {
+		if highlight.Field == nil {
+			continue
+		}
+		if highlight.Snippet != nil {
+			result = append(result, models.SearchHighlight{Field: *highlight.Field, Snippet: *highlight.Snippet})
+		} else if highlight.Snippets != nil {
+			for _, snippet := range *highlight.Snippets {
+				result = append(result, models.SearchHighlight{Field: *highlight.Field, Snippet: snippet})
+			}
+		}
+	}
+	return result
+}
+
+func (s *Handler) ClassicSearch(ctx context.Context, searchParams SearchRequestParams, userID int) ([]models.SearchResult, error) {
 
 	var searchResults []models.SearchResult
 
@@ -861,7 +855,7 @@ func (s *Handler) ClassicSearch(searchParams SearchRequestParams, userID int) ([
 
 	// Include fact results if requested
 	if searchParams.ShowFacts {
-		facts, err := s.ClassicFactSearch(userID, searchParams)
+		facts, err := s.ClassicFactSearch(ctx, userID, searchParams)
 
 		log.Printf("facts %v", len(facts))
 		if err != nil {
@@ -894,13 +888,18 @@ func (s *Handler) ClassicSearch(searchParams SearchRequestParams, userID int) ([
 		}
 	}
 
+	// Reranking is not deterministic, so a page of the results must be cut
+	// from them in the order they were found
 	var reranked []models.SearchResult
-	if s.Server.Testing {
+	if s.Server.Testing || pagedSearch(searchParams) {
 		reranked = searchResults
 	} else {
 		if len(searchResults) > 0 {
-			client := llms.NewDefaultClient(s.DB, userID)
-			reranked, err = llms.RerankSearchResults(client, searchParams.SearchTerm, searchResults)
+			client := llms.NewClientForTask(s.DB, userID, models.LLMTaskSearch)
+			reranked, err = llms.RerankSearchResults(ctx, client, searchParams.SearchTerm, searchResults)
+			if isLLMQuotaError(err) {
+				return nil, err
+			}
 			if err != nil {
 				log.Printf("reranking error %v", err)
 				return searchResults, nil
@@ -911,7 +910,7 @@ func (s *Handler) ClassicSearch(searchParams SearchRequestParams, userID int) ([
 }
 
 // ClassicFactSearch performs embedding-based semantic search on facts
-func (s *Handler) ClassicFactSearch(userID int, params SearchRequestParams) ([]struct {
+func (s *Handler) ClassicFactSearch(ctx context.Context, userID int, params SearchRequestParams) ([]struct {
 	ID        int
 	Fact      string
 	Title     string
@@ -936,7 +935,7 @@ func (s *Handler) ClassicFactSearch(userID int, params SearchRequestParams) ([]s
 		return results, nil
 	}
 	// Generate query embedding
-	embedding, err := llms.GetEmbedding1024(params.SearchTerm, false)
+	embedding, err := llms.GetEmbedding1024(ctx, params.SearchTerm, false)
 	if err != nil {
 		return nil, err
 	}
@@ -948,7 +947,7 @@ func (s *Handler) ClassicFactSearch(userID int, params SearchRequestParams) ([]s
 		FROM facts f
 		JOIN cards c ON f.card_pk = c.id
 		WHERE f.user_id = $1
-		ORDER BY f.embedding_1024 <=> $2
+		ORDER BY f.embedding_1024 <=> $2, f.id
 		LIMIT 25
 	`, userID, embedding)
 	if err != nil {
@@ -1004,20 +1003,22 @@ func (s *Handler) SearchRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var searchResults []models.SearchResult
-
-	log.Printf("type %v", searchParams.SearchType)
-	if searchParams.SearchType == "typesense" {
-		searchResults, err = s.TypesenseSearch(searchParams, userID)
-	} else {
-		searchResults, err = s.ClassicSearch(searchParams, userID)
+	if _, _, err := searchPage(searchParams); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
+	log.Printf("type %v", searchParams.SearchType)
+	response, err := s.searchBackend(searchParams.SearchType).Search(r.Context(), searchParams, userID)
 	if err != nil {
 		log.Printf("search err %v", err)
+		if writeLLMRequestError(w, err) {
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(searchResults)
+	json.NewEncoder(w).Encode(response)
 }
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"go-backend/models"
+	"os"
+)
+
+// Search types a request can ask for
+const (
+	searchTypeClassic   = "classic"
+	searchTypeTypesense = "typesense"
+	searchTypePostgres  = "postgres"
+	searchTypeHybrid    = "hybrid"
+)
+
+// SearchBackend finds a page of results for a search
+type SearchBackend interface {
+	Search(ctx context.Context, params SearchRequestParams, userID int) (models.SearchResponse, error)
+}
+
+// typesenseBackend searches the Typesense collection
+type typesenseBackend struct {
+	s *Handler
+}
+
+func (b typesenseBackend) Search(ctx context.Context, params SearchRequestParams, userID int) (models.SearchResponse, error) {
+	return b.s.TypesenseSearch(ctx, params, userID)
+}
+
+// postgresBackend searches cards with Postgres full text search
+type postgresBackend struct {
+	s *Handler
+}
+
+func (b postgresBackend) Search(ctx context.Context, params SearchRequestParams, userID int) (models.SearchResponse, error) {
+	return b.s.PostgresSearch(ctx, params, userID)
+}
+
+// memoryBackend pages through the results of a search that finds them all
+// at once, and highlights the page. Asked for no page, it returns them all.
+type memoryBackend struct {
+	search func(ctx context.Context, params SearchRequestParams, userID int) ([]models.SearchResult, error)
+}
+
+func (b memoryBackend) Search(ctx context.Context, params SearchRequestParams, userID int) (models.SearchResponse, error) {
+	offset, limit, err := searchPage(params)
+	if err != nil {
+		return models.SearchResponse{}, err
+	}
+	results, err := b.search(ctx, params, userID)
+	if err != nil {
+		return models.SearchResponse{}, err
+	}
+	if !pagedSearch(params) {
+		limit = len(results)
+	}
+	response := paginateSearchResults(results, offset, limit)
+	addHighlights(response.Results, searchTerms(ParseSearchQuery(params.SearchTerm)))
+	return response, nil
+}
+
+// searchBackend picks the backend for a search type. Without one, the
+// SEARCH_BACKEND environment variable decides, and then whether Typesense
+// is configured. Asking for Typesense when it is not configured falls back
+// to Postgres rather than failing.
+func (s *Handler) searchBackend(searchType string) SearchBackend {
+	if searchType == "" {
+		searchType = os.Getenv("SEARCH_BACKEND")
+	}
+	switch searchType {
+	case searchTypeClassic:
+		return memoryBackend{s.ClassicSearch}
+	case searchTypeHybrid:
+		return memoryBackend{s.HybridSearch}
+	case searchTypePostgres:
+		return postgresBackend{s}
+	}
+	if s.Server.TypesenseClient != nil {
+		return typesenseBackend{s}
+	}
+	return postgresBackend{s}
+}
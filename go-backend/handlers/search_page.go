@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"go-backend/models"
+	"html"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxSearchPageSize is also the most Typesense returns per page
+	maxSearchPageSize = 250
+	searchFacetLimit  = 20
+	// snippetLength is how many characters of a field a highlight shows,
+	// starting a little before the first match
+	snippetLength  = 160
+	snippetLeadIn  = 40
+	highlightStart = "<mark>"
+	highlightEnd   = "</mark>"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeSearchCursor is the cursor of the page of results starting at offset
+func encodeSearchCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeSearchCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	value, ok := strings.CutPrefix(string(data), "offset:")
+	if !ok {
+		return 0, errInvalidCursor
+	}
+	offset, err := strconv.Atoi(value)
+	if err != nil || offset < 0 {
+		return 0, errInvalidCursor
+	}
+	return offset, nil
+}
+
+// searchPage is the offset and size of the page of results a search asks
+// for. A cursor takes precedence over a page number, which counts from 1.
+func searchPage(params SearchRequestParams) (offset, limit int, err error) {
+	limit = params.PageSize
+	if limit <= 0 || limit > maxSearchPageSize {
+		limit = maxSearchPageSize
+	}
+	if params.Cursor != "" {
+		offset, err = decodeSearchCursor(params.Cursor)
+		return offset, limit, err
+	}
+	if params.Page > 1 {
+		offset = (params.Page - 1) * limit
+	}
+	return offset, limit, nil
+}
+
+// pagedSearch reports whether a search asks for a page of its results
+// rather than all of them
+func pagedSearch(params SearchRequestParams) bool {
+	return params.Cursor != "" || params.Page > 0 || params.PageSize > 0
+}
+
+// nextSearchCursor is the cursor of the page after the one at offset, or ""
+// if that page is the last
+func nextSearchCursor(offset, limit, total int) string {
+	if offset+limit >= total {
+		return ""
+	}
+	return encodeSearchCursor(offset + limit)
+}
+
+// facetCounts sorts counts most common first, and alphabetically among
+// equals, keeping the first searchFacetLimit
+func facetCounts(counts map[string]int) []models.FacetCount {
+	facets := []models.FacetCount{}
+	for value, count := range counts {
+		facets = append(facets, models.FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	if len(facets) > searchFacetLimit {
+		facets = facets[:searchFacetLimit]
+	}
+	return facets
+}
+
+// searchFacets counts results by their tags, the type of entity they are
+// and their type
+func searchFacets(results []models.SearchResult) models.SearchFacets {
+	tags, entityTypes, types := map[string]int{}, map[string]int{}, map[string]int{}
+	for _, result := range results {
+		types[result.Type]++
+		for _, tag := range result.Tags {
+			tags[tag.Name]++
+		}
+		if metadata, ok := result.Metadata.(map[string]interface{}); ok && result.Type == "entity" {
+			if entityType, ok := metadata["type"].(string); ok && entityType != "" {
+				entityTypes[entityType]++
+			}
+		}
+	}
+	return models.SearchFacets{
+		Tags:        facetCounts(tags),
+		EntityTypes: facetCounts(entityTypes),
+		Types:       facetCounts(types),
+	}
+}
+
+// paginateSearchResults pages through results a backend found all at once,
+// counting them all for the total and facets
+func paginateSearchResults(results []models.SearchResult, offset, limit int) models.SearchResponse {
+	total := len(results)
+	start, end := min(offset, total), min(offset+limit, total)
+	page := results[start:end]
+	if page == nil {
+		page = []models.SearchResult{}
+	}
+	return models.SearchResponse{
+		Results:    page,
+		Total:      total,
+		NextCursor: nextSearchCursor(offset, limit, total),
+		Facets:     searchFacets(results),
+	}
+}
+
+// highlightSnippet finds the terms in text, ignoring case, and returns the
+// stretch of it around the first match with every match in it between
+// <mark> tags. The text around the tags is HTML escaped. It reports false if
+// no term occurs in text.
+func highlightSnippet(text string, terms []string) (string, bool) {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var matches [][2]int
+	for _, term := range terms {
+		t := []rune(term)
+		for i, r := range t {
+			t[i] = unicode.ToLower(r)
+		}
+		if len(t) == 0 {
+			continue
+		}
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if slices.Equal(lower[i:i+len(t)], t) {
+				matches = append(matches, [2]int{i, i + len(t)})
+				i += len(t) - 1
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
+
+	start := max(0, matches[0][0]-snippetLeadIn)
+	end := min(len(runes), max(start+snippetLength, matches[0][1]))
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	at := start
+	for _, m := range matches {
+		if m[0] < at || m[1] > end {
+			// overlaps an earlier match or runs past the snippet
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[at:m[0]])))
+		b.WriteString(highlightStart)
+		b.WriteString(html.EscapeString(string(runes[m[0]:m[1]])))
+		b.WriteString(highlightEnd)
+		at = m[1]
+	}
+	b.WriteString(html.EscapeString(string(runes[at:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
+
+// addHighlights highlights where the terms occur in the title and preview
+// of each result
+func addHighlights(results []models.SearchResult, terms []string) {
+	for i := range results {
+		for _, field := range []struct{ name, text string }{
+			{"title", results[i].Title},
+			{"preview", results[i].Preview},
+		} {
+			if snippet, ok := highlightSnippet(field.text, terms); ok {
+				results[i].Highlights = append(results[i].Highlights, models.SearchHighlight{Field: field.name, Snippet: snippet})
+			}
+		}
+	}
+}
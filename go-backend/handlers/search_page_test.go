@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSearchPage(t *testing.T) {
+	offset, limit, err := searchPage(SearchRequestParams{Page: 3, PageSize: 20})
+	if err != nil || offset != 40 || limit != 20 {
+		t.Errorf("wrong page, got %v %v %v", offset, limit, err)
+	}
+	offset, limit, err = searchPage(SearchRequestParams{Cursor: encodeSearchCursor(500), Page: 3, PageSize: 1000})
+	if err != nil || offset != 500 || limit != maxSearchPageSize {
+		t.Errorf("expected the cursor to win and the size to be capped, got %v %v %v", offset, limit, err)
+	}
+	if pagedSearch(SearchRequestParams{SearchTerm: "slip"}) || !pagedSearch(SearchRequestParams{PageSize: 20}) {
+		t.Errorf("expected only a cursor, page or page size to ask for a page")
+	}
+	if _, _, err := searchPage(SearchRequestParams{Cursor: "not a cursor"}); err != errInvalidCursor {
+		t.Errorf("expected an invalid cursor error, got %v", err)
+	}
+}
+
+func TestPaginateSearchResults(t *testing.T) {
+	var results []models.SearchResult
+	for i := 0; i < 5; i++ {
+		results = append(results, models.SearchResult{ID: strconv.Itoa(i), Type: "card", Tags: []models.Tag{{Name: "idea"}}})
+	}
+	results = append(results, models.SearchResult{ID: "5", Type: "entity", Metadata: map[string]interface{}{"type": "person"}})
+
+	page := paginateSearchResults(results, 2, 2)
+	if page.Total != 6 || len(page.Results) != 2 || page.Results[0].ID != "2" {
+		t.Fatalf("wrong page, got %+v", page)
+	}
+	if page.NextCursor != encodeSearchCursor(4) {
+		t.Errorf("wrong next cursor, got %q", page.NextCursor)
+	}
+	if page.Facets.Types[0] != (models.FacetCount{Value: "card", Count: 5}) || page.Facets.Tags[0].Count != 5 || page.Facets.EntityTypes[0].Value != "person" {
+		t.Errorf("expected facets over every result, got %+v", page.Facets)
+	}
+	if last := paginateSearchResults(results, 4, 2); last.NextCursor != "" {
+		t.Errorf("expected no cursor after the last page, got %q", last.NextCursor)
+	}
+	if past := paginateSearchResults(results, 10, 2); past.Results == nil || len(past.Results) != 0 {
+		t.Errorf("expected an empty page past the end, got %+v", past.Results)
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	snippet, ok := highlightSnippet("The <b>Zettelkasten</b> keeps zettels", []string{"zettel"})
+	if !ok || snippet != "The &lt;b&gt;<mark>Zettel</mark>kasten&lt;/b&gt; keeps <mark>zettel</mark>s" {
+		t.Errorf("wrong snippet, got %q", snippet)
+	}
+	long := strings.Repeat("a ", 100) + "needle" + strings.Repeat(" b", 100)
+	snippet, _ = highlightSnippet(long, []string{"needle"})
+	if !strings.HasPrefix(snippet, "…") || !strings.HasSuffix(snippet, "…") || !strings.Contains(snippet, "<mark>needle</mark>") {
+		t.Errorf("expected a trimmed snippet around the match, got %q", snippet)
+	}
+	if _, ok := highlightSnippet("nothing here", []string{"needle"}); ok {
+		t.Errorf("expected no snippet without a match")
+	}
+}
+
+func TestSearchRoutePagination(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.CreateCard(1, models.EditCardParams{CardID: "960/" + strconv.Itoa(i), Title: "Paged card"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	search := func(params SearchRequestParams) models.SearchResponse {
+		body, _ := json.Marshal(params)
+		token, _ := tests.GenerateTestJWT(1)
+		req, _ := http.NewRequest("POST", "/api/search", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		router.HandleFunc("/api/search", s.JwtMiddleware(s.SearchRoute))
+		router.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+		}
+		var response models.SearchResponse
+		tests.ParseJsonResponse(t, rr.Body.Bytes(), &response)
+		return response
+	}
+
+	first := search(SearchRequestParams{SearchTerm: "Paged", SearchType: "classic", PageSize: 2})
+	if first.Total != 3 || len(first.Results) != 2 || first.NextCursor == "" {
+		t.Fatalf("wrong first page, got %+v", first)
+	}
+	if len(first.Results[0].Highlights) == 0 || first.Results[0].Highlights[0].Snippet != "<mark>Paged</mark> card" {
+		t.Errorf("expected the title to be highlighted, got %+v", first.Results[0].Highlights)
+	}
+	second := search(SearchRequestParams{SearchTerm: "Paged", SearchType: "classic", PageSize: 2, Cursor: first.NextCursor})
+	if len(second.Results) != 1 || second.NextCursor != "" {
+		t.Errorf("wrong last page, got %+v", second)
+	}
+	if all := search(SearchRequestParams{SearchTerm: "Paged", SearchType: "classic"}); len(all.Results) != 3 || all.NextCursor != "" {
+		t.Errorf("expected every result without a page, got %+v", all)
+	}
+}
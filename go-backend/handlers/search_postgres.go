@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-backend/models"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Private use characters mark matches in ts_headline output, so the text
+// around them can be escaped before they become <mark> tags
+const (
+	headlineStart = "\uE000"
+	headlineEnd   = "\uE001"
+)
+
+var headlineOptions = "StartSel=" + headlineStart + ", StopSel=" + headlineEnd + ", MaxFragments=1, MaxWords=30, MinWords=10"
+
+// postgresSortOrders are the orders search results can be sorted in, by
+// the columns of the page query. Ranking leaves the newest first when
+// nothing is searched for, since every rank is then 0.
+var postgresSortOrders = map[string]string{
+	"sortByRanking":     "rank DESC, created_at DESC",
+	"sortCreatedNewOld": "created_at DESC",
+	"sortCreatedOldNew": "created_at ASC",
+	"sortNewOld":        "updated_at DESC",
+	"sortOldNew":        "updated_at ASC",
+	"sortBigSmall":      "title ASC",
+	"sortSmallBig":      "title DESC",
+}
+
+// sqlRegconfig quotes a text search configuration for SQL
+func sqlRegconfig(language string) string {
+	return sqlString(language) + "::regconfig"
+}
+
+// tsvectorSQL compiles a query to a condition on the cards aliased c, as
+// cardSQL does, but matches terms and phrases against the cards' search
+// vectors, stemmed in the given language. An exact card_id matches too, so
+// ids the parser splits up can still be found.
+func (q *SearchQuery) tsvectorSQL(language string, now time.Time) string {
+	var condition string
+	switch q.Kind {
+	case searchAnd, searchOr:
+		var parts []string
+		for _, child := range q.Children {
+			parts = append(parts, child.tsvectorSQL(language, now))
+		}
+		condition = "(" + strings.Join(parts, " "+strings.ToUpper(q.Kind)+" ") + ")"
+	case searchTerm:
+		condition = fmt.Sprintf("(c.search_vector @@ plainto_tsquery(%s, %s) OR c.card_id = %s)",
+			sqlRegconfig(language), sqlString(q.Value), sqlString(q.Value))
+	case searchPhrase:
+		condition = fmt.Sprintf("(c.search_vector @@ phraseto_tsquery(%s, %s) OR c.card_id = %s)",
+			sqlRegconfig(language), sqlString(q.Value), sqlString(q.Value))
+	default:
+		return q.cardSQL(false, now)
+	}
+	if q.Negate {
+		return "NOT " + condition
+	}
+	return condition
+}
+
+// buildTsvectorSearchSQL compiles a query to conditions to follow a WHERE
+// clause on the cards aliased c
+func buildTsvectorSearchSQL(query *SearchQuery, language string, now time.Time) string {
+	if query == nil {
+		return ""
+	}
+	return " AND " + query.tsvectorSQL(language, now)
+}
+
+// tsRankQuery is a tsquery matching any of the terms a query looks for, to
+// rank and highlight cards by. It is "" when the query has no terms.
+func tsRankQuery(query *SearchQuery, language string) string {
+	var parts []string
+	for _, term := range searchTerms(query) {
+		parts = append(parts, fmt.Sprintf("plainto_tsquery(%s, %s)", sqlRegconfig(language), sqlString(term)))
+	}
+	return strings.Join(parts, " || ")
+}
+
+// cardIDBoost ranks cards whose card_id is one of the terms first
+func cardIDBoost(query *SearchQuery) string {
+	var ids []string
+	for _, term := range searchTerms(query) {
+		ids = append(ids, sqlString(term))
+	}
+	if len(ids) == 0 {
+		return "0"
+	}
+	return "CASE WHEN c.card_id IN (" + strings.Join(ids, ", ") + ") THEN 1 ELSE 0 END"
+}
+
+// headlineHighlight turns ts_headline output into a highlight snippet, or
+// reports false if nothing in it matched
+func headlineHighlight(headline string) (string, bool) {
+	if !strings.Contains(headline, headlineStart) {
+		return "", false
+	}
+	escaped := html.EscapeString(headline)
+	return strings.NewReplacer(headlineStart, highlightStart, headlineEnd, highlightEnd).Replace(escaped), true
+}
+
+// searchLanguage is the text search configuration a user's cards are
+// stemmed with
+func (s *Handler) searchLanguage(userID int) (string, error) {
+	var language string
+	err := s.DB.QueryRow(`SELECT search_language::text FROM users WHERE id = $1`, userID).Scan(&language)
+	return language, err
+}
+
+// PostgresSearch ranks the user's cards against a search with Postgres full
+// text search, using the GIN index on their search vectors. Only cards are
+// searched; entities and facts need Typesense or classic search.
+func (s *Handler) PostgresSearch(ctx context.Context, searchParams SearchRequestParams, userID int) (models.SearchResponse, error) {
+	response := models.SearchResponse{
+		Results: []models.SearchResult{},
+		Facets:  models.SearchFacets{Tags: []models.FacetCount{}, EntityTypes: []models.FacetCount{}, Types: []models.FacetCount{}},
+	}
+	offset, limit, err := searchPage(searchParams)
+	if err != nil {
+		return response, err
+	}
+	language, err := s.searchLanguage(userID)
+	if err != nil {
+		return response, err
+	}
+
+	query := ParseSearchQuery(searchParams.SearchTerm)
+	condition := buildTsvectorSearchSQL(query, language, time.Now())
+	rankQuery := tsRankQuery(query, language)
+
+	rank := "0"
+	titleHeadline, bodyHeadline := "''", "''"
+	if rankQuery != "" {
+		rank = fmt.Sprintf("ts_rank(c.search_vector, %s) + %s", rankQuery, cardIDBoost(query))
+		titleHeadline = fmt.Sprintf("ts_headline(%s, title, %s, %s)", sqlRegconfig(language), rankQuery, sqlString(headlineOptions))
+		bodyHeadline = fmt.Sprintf("ts_headline(%s, body, %s, %s)", sqlRegconfig(language), rankQuery, sqlString(headlineOptions))
+	}
+	// ids break ties so that pages neither skip nor repeat cards
+	order, ok := postgresSortOrders[searchParams.SortBy]
+	if !ok {
+		order = postgresSortOrders["sortByRanking"]
+	}
+	order += ", id DESC"
+
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM cards c
+		WHERE c.user_id = $1 AND c.is_deleted = FALSE`+condition,
+		userID,
+	).Scan(&response.Total)
+	if err != nil {
+		return response, err
+	}
+	response.NextCursor = nextSearchCursor(offset, limit, response.Total)
+	if response.Total > 0 {
+		response.Facets.Types = []models.FacetCount{{Value: "card", Count: response.Total}}
+	}
+
+	// Headlines are worked out in the outer query, for the page alone
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, card_id, title, body, parent_id, created_at, updated_at, rank,
+		`+titleHeadline+`, `+bodyHeadline+`
+		FROM (
+			SELECT c.id, c.card_id, c.title, c.body, c.parent_id, c.created_at, c.updated_at,
+			`+rank+` AS rank
+			FROM cards c
+			WHERE c.user_id = $1 AND c.is_deleted = FALSE`+condition+`
+			ORDER BY `+order+`
+			LIMIT $2 OFFSET $3
+		) page
+		ORDER BY `+order,
+		userID, limit, offset,
+	)
+	if err != nil {
+		return response, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var card models.Card
+		var score float64
+		var titleMatch, bodyMatch string
+		if err := rows.Scan(&card.ID, &card.CardID, &card.Title, &card.Body, &card.ParentID,
+			&card.CreatedAt, &card.UpdatedAt, &score, &titleMatch, &bodyMatch); err != nil {
+			return response, err
+		}
+		tags, _ := s.QueryTagsForCard(userID, card.ID)
+		result := models.SearchResult{
+			ID:        strconv.Itoa(card.ID),
+			Type:      "card",
+			Title:     card.Title,
+			Preview:   card.Body,
+			Score:     score,
+			CreatedAt: card.CreatedAt,
+			UpdatedAt: card.UpdatedAt,
+			Tags:      tags,
+			CardID:    card.CardID,
+			Metadata: map[string]interface{}{
+				"id":        card.ID,
+				"card_id":   card.CardID,
+				"parent_id": card.ParentID,
+			},
+		}
+		if snippet, ok := headlineHighlight(titleMatch); ok {
+			result.Highlights = append(result.Highlights, models.SearchHighlight{Field: "title", Snippet: snippet})
+		}
+		if snippet, ok := headlineHighlight(bodyMatch); ok {
+			result.Highlights = append(result.Highlights, models.SearchHighlight{Field: "preview", Snippet: snippet})
+		}
+		response.Results = append(response.Results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return response, err
+	}
+
+	tagRows, err := s.DB.QueryContext(ctx, `
+		SELECT t.name, COUNT(DISTINCT c.id)
+		FROM cards c
+		JOIN card_tags ct ON ct.card_pk = c.id
+		JOIN tags t ON t.id = ct.tag_id AND t.is_deleted = FALSE
+		WHERE c.user_id = $1 AND c.is_deleted = FALSE`+condition+`
+		GROUP BY t.name
+		ORDER BY 2 DESC, t.name
+		LIMIT $2`,
+		userID, searchFacetLimit,
+	)
+	if err != nil {
+		return response, err
+	}
+	defer tagRows.Close()
+	for tagRows.Next() {
+		var facet models.FacetCount
+		if err := tagRows.Scan(&facet.Value, &facet.Count); err != nil {
+			return response, err
+		}
+		response.Facets.Tags = append(response.Facets.Tags, facet)
+	}
+	return response, tagRows.Err()
+}
+
+// GetSearchLanguageRoute returns the language the user's cards are
+// searched in and the languages available
+func (s *Handler) GetSearchLanguageRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	language, err := s.searchLanguage(userID)
+	if err != nil {
+		log.Printf("error loading search language: %v", err)
+		http.Error(w, "Failed to load search language", http.StatusInternalServerError)
+		return
+	}
+	rows, err := s.DB.Query(`SELECT cfgname FROM pg_ts_config ORDER BY cfgname`)
+	if err != nil {
+		log.Printf("error loading search languages: %v", err)
+		http.Error(w, "Failed to load search language", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	settings := models.SearchLanguage{Language: language, Available: []string{}}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			http.Error(w, "Failed to load search language", http.StatusInternalServerError)
+			return
+		}
+		settings.Available = append(settings.Available, name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// SetSearchLanguage changes the language the user's cards are stemmed in,
+// which regenerates their search vectors
+func (s *Handler) SetSearchLanguage(userID int, language string) error {
+	var exists bool
+	err := s.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = $1)`, language).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("unknown search language")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE users SET search_language = $2::regconfig WHERE id = $1`, userID, language); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE cards SET search_language = $2::regconfig WHERE user_id = $1`, userID, language); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Handler) UpdateSearchLanguageRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	var params models.SearchLanguage
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	err := s.SetSearchLanguage(userID, params.Language)
+	if err != nil {
+		if err.Error() == "unknown search language" {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("error setting search language: %v", err)
+		http.Error(w, "Failed to set search language", http.StatusInternalServerError)
+		return
+	}
+	s.GetSearchLanguageRoute(w, r)
+}
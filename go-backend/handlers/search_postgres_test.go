@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"go-backend/models"
+	"go-backend/server"
+	"go-backend/tests"
+	"testing"
+	"time"
+
+	"github.com/typesense/typesense-go/typesense"
+)
+
+func TestTsvectorSQL(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	query := ParseSearchQuery(`(run OR "slip box") !draft #idea`)
+	expected := " AND (((c.search_vector @@ plainto_tsquery('english'::regconfig, 'run') OR c.card_id = 'run') OR " +
+		"(c.search_vector @@ phraseto_tsquery('english'::regconfig, 'slip box') OR c.card_id = 'slip box')) AND " +
+		"NOT (c.search_vector @@ plainto_tsquery('english'::regconfig, 'draft') OR c.card_id = 'draft') AND " +
+		ParseSearchQuery("#idea").cardSQL(false, now) + ")"
+	if got := buildTsvectorSearchSQL(query, "english", now); got != expected {
+		t.Errorf("wrong SQL\ngot:  %v\nwant: %v", got, expected)
+	}
+	if got := tsRankQuery(query, "german"); got != "plainto_tsquery('german'::regconfig, 'run') || plainto_tsquery('german'::regconfig, 'slip box')" {
+		t.Errorf("wrong rank query, got %v", got)
+	}
+	if got := tsRankQuery(ParseSearchQuery("#idea"), "english"); got != "" {
+		t.Errorf("expected no rank query without terms, got %v", got)
+	}
+}
+
+func TestHeadlineHighlight(t *testing.T) {
+	snippet, ok := headlineHighlight("a <b> " + headlineStart + "running" + headlineEnd + " start")
+	if !ok || snippet != "a &lt;b&gt; <mark>running</mark> start" {
+		t.Errorf("wrong highlight, got %q", snippet)
+	}
+	if _, ok := headlineHighlight("no match here"); ok {
+		t.Errorf("expected no highlight without a match")
+	}
+}
+
+func TestSearchBackend(t *testing.T) {
+	s := &Handler{Server: &server.Server{}}
+	t.Setenv("SEARCH_BACKEND", "")
+	if _, ok := s.searchBackend("typesense").(postgresBackend); !ok {
+		t.Errorf("expected Postgres without Typesense configured")
+	}
+	if _, ok := s.searchBackend("").(postgresBackend); !ok {
+		t.Errorf("expected Postgres by default without Typesense configured")
+	}
+	if _, ok := s.searchBackend("classic").(memoryBackend); !ok {
+		t.Errorf("expected classic search to page in memory")
+	}
+
+	s.Server.TypesenseClient = typesense.NewClient()
+	if _, ok := s.searchBackend("").(typesenseBackend); !ok {
+		t.Errorf("expected Typesense by default when it is configured")
+	}
+	t.Setenv("SEARCH_BACKEND", "postgres")
+	if _, ok := s.searchBackend("").(postgresBackend); !ok {
+		t.Errorf("expected SEARCH_BACKEND to pick the default")
+	}
+}
+
+func TestPostgresSearch(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	for _, params := range []models.EditCardParams{
+		{CardID: "970", Title: "Quokkas running", Body: "Quokkas run at dawn"},
+		{CardID: "971", Title: "Marsupials", Body: "A quokka is a marsupial"},
+		{CardID: "972", Title: "Unrelated"},
+	} {
+		if _, err := s.CreateCard(1, params); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	response, err := s.PostgresSearch(context.Background(), SearchRequestParams{SearchTerm: "quokka run"}, 1)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if response.Total != 1 || response.Results[0].CardID != "970" {
+		t.Fatalf("expected stemmed terms to match, got %+v", response)
+	}
+	if len(response.Results[0].Highlights) != 2 || response.Results[0].Highlights[0].Snippet != "<mark>Quokkas</mark> <mark>running</mark>" {
+		t.Errorf("wrong highlights, got %+v", response.Results[0].Highlights)
+	}
+
+	response, err = s.PostgresSearch(context.Background(), SearchRequestParams{SearchTerm: "quokka OR 972", PageSize: 1}, 1)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if response.Total != 3 || len(response.Results) != 1 || response.Results[0].CardID != "972" || response.NextCursor == "" {
+		t.Errorf("expected the exact card_id first on a page of one, got %+v", response)
+	}
+
+	if err := s.SetSearchLanguage(1, "german"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSearchLanguage(1, "klingon"); err == nil || err.Error() != "unknown search language" {
+		t.Errorf("expected an unknown language to be rejected, got %v", err)
+	}
+	response, err = s.PostgresSearch(context.Background(), SearchRequestParams{SearchTerm: "Marsupials"}, 1)
+	if err != nil || response.Total != 1 {
+		t.Errorf("expected search to still work in German, got %+v %v", response, err)
+	}
+}
@@ -0,0 +1,576 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Kinds of SearchQuery nodes
+const (
+	searchAnd    = "and"
+	searchOr     = "or"
+	searchTerm   = "term"
+	searchPhrase = "phrase"
+	searchTag    = "tag"
+	searchEntity = "entity"
+	searchFilter = "filter"
+)
+
+// SearchQuery is a parsed search query. And and or nodes hold their
+// clauses in Children; every other kind is a single clause. Field filters
+// keep their field, comparison and value.
+type SearchQuery struct {
+	Kind     string
+	Negate   bool
+	Value    string
+	Field    string
+	Op       string
+	Children []*SearchQuery
+}
+
+// searchFields are the fields a field:value clause can filter on. Anything
+// else before a colon is searched for as text.
+var searchFields = map[string]bool{
+	"created": true,
+	"updated": true,
+	"id":      true,
+	"has":     true,
+	"is":      true,
+	"link":    true,
+}
+
+var (
+	searchHasValues = map[string]bool{"task": true, "file": true}
+	searchIsValues  = map[string]bool{"literature": true}
+
+	searchDatePattern     = regexp.MustCompile(`^(>=|<=|>|<|=)?(\d{4}-\d{2}-\d{2})$`)
+	searchDurationPattern = regexp.MustCompile(`^(>=|<=|>|<|=)?(\d+)([dwmy])$`)
+)
+
+type searchToken struct {
+	kind string // "(", ")", "or", "and", "not", "word", "phrase" or "entity"
+	text string
+}
+
+// tokenizeSearch splits a query into words, "quoted phrases", @[entities]
+// and the operators between them. A leading ! negates what follows it.
+func tokenizeSearch(input string) []searchToken {
+	var tokens []searchToken
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, searchToken{kind: string(r)})
+			i++
+		case r == '!' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			tokens = append(tokens, searchToken{kind: "not"})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if phrase := strings.TrimSpace(string(runes[i+1 : end])); phrase != "" {
+				tokens = append(tokens, searchToken{kind: "phrase", text: phrase})
+			}
+			i = end + 1
+		case r == '@' && i+1 < len(runes) && runes[i+1] == '[':
+			end := i + 2
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if entity := strings.TrimSpace(string(runes[i+2 : end])); entity != "" {
+				tokens = append(tokens, searchToken{kind: "entity", text: entity})
+			}
+			i = end + 1
+		default:
+			var word strings.Builder
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != ')' {
+				// field:"quoted value"
+				if runes[i] == '"' && strings.HasSuffix(word.String(), ":") {
+					end := i + 1
+					for end < len(runes) && runes[end] != '"' {
+						end++
+					}
+					word.WriteString(string(runes[i+1 : end]))
+					i = end + 1
+					break
+				}
+				word.WriteRune(runes[i])
+				i++
+			}
+			switch text := word.String(); text {
+			case "OR":
+				tokens = append(tokens, searchToken{kind: "or"})
+			case "AND":
+				tokens = append(tokens, searchToken{kind: "and"})
+			case "NOT":
+				tokens = append(tokens, searchToken{kind: "not"})
+			default:
+				tokens = append(tokens, searchToken{kind: "word", text: text})
+			}
+		}
+	}
+	return tokens
+}
+
+type searchParser struct {
+	tokens []searchToken
+	pos    int
+}
+
+func (p *searchParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return ""
+}
+
+// ParseSearchQuery parses a search query. Clauses side by side must all
+// match, OR between clauses lets either match, and parentheses group them.
+// A ! or NOT before a clause or group negates it. Unbalanced parentheses
+// are forgiven rather than rejected.
+func ParseSearchQuery(input string) *SearchQuery {
+	p := &searchParser{tokens: tokenizeSearch(input)}
+	var clauses []*SearchQuery
+	for p.pos < len(p.tokens) {
+		if p.peek() == ")" {
+			p.pos++
+			continue
+		}
+		if query := p.parseOr(); query != nil {
+			clauses = append(clauses, query)
+		}
+	}
+	return joinSearchQueries(searchAnd, clauses)
+}
+
+func (p *searchParser) parseOr() *SearchQuery {
+	var clauses []*SearchQuery
+	for {
+		if query := p.parseAnd(); query != nil {
+			clauses = append(clauses, query)
+		}
+		if p.peek() != "or" {
+			break
+		}
+		p.pos++
+	}
+	return joinSearchQueries(searchOr, clauses)
+}
+
+func (p *searchParser) parseAnd() *SearchQuery {
+	var clauses []*SearchQuery
+	for {
+		switch p.peek() {
+		case "", ")", "or":
+			return joinSearchQueries(searchAnd, clauses)
+		case "and":
+			p.pos++
+			continue
+		}
+		if query := p.parseUnary(); query != nil {
+			clauses = append(clauses, query)
+		}
+	}
+}
+
+func (p *searchParser) parseUnary() *SearchQuery {
+	negate := false
+	for p.peek() == "not" {
+		negate = !negate
+		p.pos++
+	}
+	var query *SearchQuery
+	switch p.peek() {
+	case "", ")", "or", "and":
+		return nil
+	case "(":
+		p.pos++
+		query = p.parseOr()
+		if p.peek() == ")" {
+			p.pos++
+		}
+	default:
+		query = searchClause(p.tokens[p.pos])
+		p.pos++
+	}
+	if query != nil && negate {
+		query.Negate = !query.Negate
+	}
+	return query
+}
+
+func joinSearchQueries(kind string, clauses []*SearchQuery) *SearchQuery {
+	switch len(clauses) {
+	case 0:
+		return nil
+	case 1:
+		return clauses[0]
+	}
+	return &SearchQuery{Kind: kind, Children: clauses}
+}
+
+// searchClause reads a single token as a term, phrase, tag, entity or
+// field filter
+func searchClause(token searchToken) *SearchQuery {
+	switch token.kind {
+	case "phrase":
+		return &SearchQuery{Kind: searchPhrase, Value: token.text}
+	case "entity":
+		return &SearchQuery{Kind: searchEntity, Value: token.text}
+	}
+	word := token.text
+	if strings.HasPrefix(word, "#") && len(word) > 1 {
+		return &SearchQuery{Kind: searchTag, Value: strings.TrimPrefix(word, "#")}
+	}
+	if field, value, ok := strings.Cut(word, ":"); ok && searchFields[strings.ToLower(field)] && value != "" {
+		if filter := searchFilterClause(strings.ToLower(field), value); filter != nil {
+			return filter
+		}
+	}
+	return &SearchQuery{Kind: searchTerm, Value: word}
+}
+
+func searchFilterClause(field, value string) *SearchQuery {
+	filter := &SearchQuery{Kind: searchFilter, Field: field, Value: value}
+	switch field {
+	case "created", "updated":
+		if match := searchDatePattern.FindStringSubmatch(value); match != nil {
+			// A date that does not exist, like 2024-02-30, is searched for
+			// as text
+			if _, err := time.Parse("2006-01-02", match[2]); err != nil {
+				return nil
+			}
+			filter.Op, filter.Value = match[1], match[2]
+			if filter.Op == "" {
+				filter.Op = "="
+			}
+		} else if match := searchDurationPattern.FindStringSubmatch(value); match != nil {
+			filter.Op, filter.Value = match[1], match[2]+match[3]
+			if filter.Op == "" {
+				filter.Op = "<"
+			}
+		} else {
+			return nil
+		}
+	case "has":
+		filter.Value = strings.ToLower(value)
+		if !searchHasValues[filter.Value] {
+			return nil
+		}
+	case "is":
+		filter.Value = strings.ToLower(value)
+		if !searchIsValues[filter.Value] {
+			return nil
+		}
+	}
+	return filter
+}
+
+// timeRange resolves a created: or updated: filter to the times it allows,
+// as a lower and upper bound, either of which may be zero. Dates compare the
+// timestamp itself, so created:>2024-01-01 is after that day. Durations
+// compare age, so updated:<30d is within the last 30 days, and a duration
+// without a comparison means within.
+func (q *SearchQuery) timeRange(now time.Time) (from, until time.Time) {
+	if q.Value == "" {
+		return
+	}
+	if unit := q.Value[len(q.Value)-1]; unit >= 'a' && unit <= 'z' {
+		n, _ := strconv.Atoi(q.Value[:len(q.Value)-1])
+		var point time.Time
+		switch unit {
+		case 'd':
+			point = now.AddDate(0, 0, -n)
+		case 'w':
+			point = now.AddDate(0, 0, -7*n)
+		case 'm':
+			point = now.AddDate(0, -n, 0)
+		case 'y':
+			point = now.AddDate(-n, 0, 0)
+		}
+		switch q.Op {
+		case ">", ">=":
+			return time.Time{}, point
+		case "=":
+			day := time.Date(point.Year(), point.Month(), point.Day(), 0, 0, 0, 0, point.Location())
+			return day, day.AddDate(0, 0, 1)
+		}
+		return point, time.Time{}
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", q.Value, now.Location())
+	if err != nil {
+		return
+	}
+	switch q.Op {
+	case ">":
+		return day.AddDate(0, 0, 1), time.Time{}
+	case ">=":
+		return day, time.Time{}
+	case "<":
+		return time.Time{}, day
+	case "<=":
+		return time.Time{}, day.AddDate(0, 0, 1)
+	}
+	return day, day.AddDate(0, 0, 1)
+}
+
+// subtreeRoot is the card_id an id: filter scopes to, and whether it takes
+// in the cards below it
+func (q *SearchQuery) subtreeRoot() (string, bool) {
+	if root, ok := strings.CutSuffix(q.Value, "*"); ok {
+		return strings.TrimRight(root, "/."), true
+	}
+	return q.Value, false
+}
+
+// searchText is the free text of a query, with its terms and phrases in
+// order. Negated terms are marked with a leading -, and clauses under a
+// negated group are left out.
+func searchText(q *SearchQuery) string {
+	var words []string
+	var walk func(q *SearchQuery)
+	walk = func(q *SearchQuery) {
+		if q == nil {
+			return
+		}
+		switch q.Kind {
+		case searchAnd, searchOr:
+			if q.Negate {
+				return
+			}
+			for _, child := range q.Children {
+				walk(child)
+			}
+		case searchTerm:
+			if q.Negate {
+				words = append(words, "-"+q.Value)
+			} else {
+				words = append(words, q.Value)
+			}
+		case searchPhrase:
+			if q.Negate {
+				words = append(words, `-"`+q.Value+`"`)
+			} else {
+				words = append(words, `"`+q.Value+`"`)
+			}
+		}
+	}
+	walk(q)
+	return strings.Join(words, " ")
+}
+
+// sqlString quotes a string as an SQL literal
+func sqlString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// sqlLikeContains escapes a string for use within single quotes as an ILIKE
+// pattern matching anything containing it
+func sqlLikeContains(value string) string {
+	return "%" + strings.ReplaceAll(value, "'", "''") + "%"
+}
+
+func sqlLikePrefix(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`, "'", "''")
+	return replacer.Replace(value) + "%"
+}
+
+func sqlTimestamp(t time.Time) string {
+	return sqlString(t.UTC().Format("2006-01-02 15:04:05"))
+}
+
+// cardSQL compiles a query to a condition on the cards aliased c
+func (q *SearchQuery) cardSQL(fullText bool, now time.Time) string {
+	var condition string
+	switch q.Kind {
+	case searchAnd, searchOr:
+		var parts []string
+		for _, child := range q.Children {
+			parts = append(parts, child.cardSQL(fullText, now))
+		}
+		condition = "(" + strings.Join(parts, " "+strings.ToUpper(q.Kind)+" ") + ")"
+	case searchTerm, searchPhrase:
+		pattern := sqlLikeContains(q.Value)
+		if fullText {
+			condition = fmt.Sprintf("(card_id ILIKE '%s' OR title ILIKE '%s' OR body ILIKE '%s')", pattern, pattern, pattern)
+		} else {
+			condition = fmt.Sprintf("(card_id ILIKE '%s' OR title ILIKE '%s')", pattern, pattern)
+		}
+	case searchTag:
+		condition = fmt.Sprintf(`EXISTS (
+            SELECT 1 FROM card_tags
+            JOIN tags ON card_tags.tag_id = tags.id
+            WHERE card_tags.card_pk = c.id AND tags.name = %s AND tags.is_deleted = FALSE
+        )`, sqlString(q.Value))
+	case searchEntity:
+		condition = fmt.Sprintf(`EXISTS (
+            SELECT 1 FROM entity_card_junction ecj
+            JOIN entities e ON ecj.entity_id = e.id
+            WHERE ecj.card_pk = c.id AND e.name = %s
+        )`, sqlString(q.Value))
+	case searchFilter:
+		condition = q.cardFilterSQL(now)
+	}
+	if q.Negate {
+		return "NOT " + condition
+	}
+	return condition
+}
+
+func (q *SearchQuery) cardFilterSQL(now time.Time) string {
+	switch q.Field {
+	case "created", "updated":
+		column := "c." + q.Field + "_at"
+		from, until := q.timeRange(now)
+		var bounds []string
+		if !from.IsZero() {
+			bounds = append(bounds, column+" >= "+sqlTimestamp(from))
+		}
+		if !until.IsZero() {
+			bounds = append(bounds, column+" < "+sqlTimestamp(until))
+		}
+		if len(bounds) == 0 {
+			return "TRUE"
+		}
+		return "(" + strings.Join(bounds, " AND ") + ")"
+	case "id":
+		root, subtree := q.subtreeRoot()
+		if !subtree {
+			return "(c.card_id = " + sqlString(root) + ")"
+		}
+		return fmt.Sprintf(`(c.card_id = %s OR c.card_id LIKE '%s' ESCAPE '\' OR c.card_id LIKE '%s' ESCAPE '\')`,
+			sqlString(root), sqlLikePrefix(root+"/"), sqlLikePrefix(root+"."))
+	case "has":
+		switch q.Value {
+		case "task":
+			return "EXISTS (SELECT 1 FROM tasks WHERE tasks.card_pk = c.id AND tasks.is_deleted = FALSE)"
+		case "file":
+			return "EXISTS (SELECT 1 FROM files WHERE files.card_pk = c.id AND files.is_deleted = FALSE)"
+		}
+	case "is":
+		return "(COALESCE(c.is_literature_card, FALSE) = TRUE)"
+	case "link":
+		return fmt.Sprintf("(COALESCE(c.link, '') ILIKE '%s')", sqlLikeContains(q.Value))
+	}
+	return "TRUE"
+}
+
+// typesenseString quotes a value for a Typesense filter
+func typesenseString(value string) string {
+	return "`" + strings.ReplaceAll(value, "`", "") + "`"
+}
+
+// typesenseFilter compiles the non-text clauses of a query to a Typesense
+// filter_by expression. Text is searched for by the query itself, so terms
+// and phrases, and any clause the filter syntax cannot express, place no
+// restriction here: the filter may let through more than the SQL condition,
+// never less. Negation is pushed down to the clauses, since Typesense cannot
+// negate a group. It returns "" when nothing can be filtered.
+func (q *SearchQuery) typesenseFilter(now time.Time) string {
+	return q.typesenseFilterNegated(q.Negate, now)
+}
+
+func (q *SearchQuery) typesenseFilterNegated(negate bool, now time.Time) string {
+	switch q.Kind {
+	case searchAnd, searchOr:
+		// NOT (a AND b) is NOT a OR NOT b, and the other way around
+		isAnd := (q.Kind == searchAnd) != negate
+		var parts []string
+		for _, child := range q.Children {
+			part := child.typesenseFilterNegated(child.Negate != negate, now)
+			if part == "" {
+				if !isAnd {
+					return ""
+				}
+				continue
+			}
+			parts = append(parts, part)
+		}
+		switch len(parts) {
+		case 0:
+			return ""
+		case 1:
+			return parts[0]
+		}
+		if isAnd {
+			return "(" + strings.Join(parts, " && ") + ")"
+		}
+		return "(" + strings.Join(parts, " || ") + ")"
+	case searchTag:
+		return typesenseCompare("tags", negate, q.Value)
+	case searchEntity:
+		return typesenseCompare("entities", negate, q.Value)
+	case searchFilter:
+		return q.typesenseFieldFilter(negate, now)
+	}
+	return ""
+}
+
+func typesenseCompare(field string, negate bool, value string) string {
+	if negate {
+		return field + ":!=" + typesenseString(value)
+	}
+	return field + ":=" + typesenseString(value)
+}
+
+func (q *SearchQuery) typesenseFieldFilter(negate bool, now time.Time) string {
+	switch q.Field {
+	case "created", "updated":
+		field := q.Field + "_at"
+		from, until := q.timeRange(now)
+		var bounds []string
+		if !from.IsZero() {
+			if negate {
+				bounds = append(bounds, fmt.Sprintf("%s:<%d", field, from.Unix()))
+			} else {
+				bounds = append(bounds, fmt.Sprintf("%s:>=%d", field, from.Unix()))
+			}
+		}
+		if !until.IsZero() {
+			if negate {
+				bounds = append(bounds, fmt.Sprintf("%s:>=%d", field, until.Unix()))
+			} else {
+				bounds = append(bounds, fmt.Sprintf("%s:<%d", field, until.Unix()))
+			}
+		}
+		if len(bounds) == 0 {
+			return ""
+		}
+		if len(bounds) == 1 {
+			return bounds[0]
+		}
+		if negate {
+			return "(" + strings.Join(bounds, " || ") + ")"
+		}
+		return "(" + strings.Join(bounds, " && ") + ")"
+	case "id":
+		root, subtree := q.subtreeRoot()
+		if !subtree {
+			return typesenseCompare("card_id", negate, root)
+		}
+		if negate || strings.ContainsAny(root, "`,()[]&|: ") {
+			return ""
+		}
+		return fmt.Sprintf("(card_id:=%s || card_id:%s/* || card_id:%s.*)", typesenseString(root), root, root)
+	case "has":
+		return fmt.Sprintf("has_%s:%t", q.Value, !negate)
+	case "is":
+		return fmt.Sprintf("is_%s:%t", q.Value, !negate)
+	case "link":
+		if negate {
+			return ""
+		}
+		return "link:" + typesenseString(q.Value)
+	}
+	return ""
+}
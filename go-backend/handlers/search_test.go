@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"go-backend/models"
 	"go-backend/tests"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseSearchText(t *testing.T) {
@@ -371,3 +373,142 @@ func TestClassicEntitySearch(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSearchQuery(t *testing.T) {
+	query := ParseSearchQuery(`"exact phrase" (#idea OR @[Niklas Luhmann]) !created:>2024-01-01 id:12/3* note:x`)
+	if query.Kind != searchAnd || len(query.Children) != 5 {
+		t.Fatalf("expected five clauses, got %+v", query)
+	}
+	if phrase := query.Children[0]; phrase.Kind != searchPhrase || phrase.Value != "exact phrase" {
+		t.Errorf("wrong phrase, got %+v", phrase)
+	}
+	group := query.Children[1]
+	if group.Kind != searchOr || group.Children[0].Kind != searchTag || group.Children[1].Value != "Niklas Luhmann" {
+		t.Errorf("wrong group, got %+v", group)
+	}
+	created := query.Children[2]
+	if created.Kind != searchFilter || !created.Negate || created.Field != "created" || created.Op != ">" || created.Value != "2024-01-01" {
+		t.Errorf("wrong date filter, got %+v", created)
+	}
+	if subtree := query.Children[3]; subtree.Field != "id" || subtree.Value != "12/3*" {
+		t.Errorf("wrong subtree filter, got %+v", subtree)
+	}
+	if unknown := query.Children[4]; unknown.Kind != searchTerm || unknown.Value != "note:x" {
+		t.Errorf("expected an unknown field to be a term, got %+v", unknown)
+	}
+
+	params := ParseSearchText("hello has:task !world")
+	if len(params.Terms) != 1 || len(params.NegateTerms) != 1 {
+		t.Errorf("expected field filters to be left out of the terms, got %+v", params)
+	}
+	params = ParseSearchText("note:x has:everything")
+	if len(params.Terms) != 2 || params.Terms[1] != "has:everything" {
+		t.Errorf("expected unknown fields to be searched as text, got %+v", params.Terms)
+	}
+
+	for _, input := range []string{"created:2024-13-45", "updated:2024-02-30", "created:>2024-00-10"} {
+		if query := ParseSearchQuery(input); query.Kind != searchTerm || query.Value != input {
+			t.Errorf("expected a date that does not exist to be a term, got %+v", query)
+		}
+	}
+}
+
+func TestBuildCardSearchSQL(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		input, expected string
+	}{
+		{
+			"a OR b",
+			" AND (((card_id ILIKE '%a%' OR title ILIKE '%a%') OR (card_id ILIKE '%b%' OR title ILIKE '%b%')))",
+		},
+		{
+			"it's updated:<30d",
+			" AND ((card_id ILIKE '%it''s%' OR title ILIKE '%it''s%')) AND ((c.updated_at >= '2024-05-31 12:00:00'))",
+		},
+		{
+			"created:2024-01-01",
+			" AND ((c.created_at >= '2024-01-01 00:00:00' AND c.created_at < '2024-01-02 00:00:00'))",
+		},
+		{
+			"id:12/3*",
+			` AND ((c.card_id = '12/3' OR c.card_id LIKE '12/3/%' ESCAPE '\' OR c.card_id LIKE '12/3.%' ESCAPE '\'))`,
+		},
+		{
+			"!(is:literature link:example.com)",
+			" AND (NOT ((COALESCE(c.is_literature_card, FALSE) = TRUE) AND (COALESCE(c.link, '') ILIKE '%example.com%')))",
+		},
+		{
+			"updated:2024-02-30",
+			" AND ((card_id ILIKE '%updated:2024-02-30%' OR title ILIKE '%updated:2024-02-30%'))",
+		},
+	}
+	for _, c := range cases {
+		if got := buildCardSearchSQL(ParseSearchQuery(c.input), false, now); got != c.expected {
+			t.Errorf("wrong SQL for %q\ngot:  %v\nwant: %v", c.input, got, c.expected)
+		}
+	}
+}
+
+func TestTypesenseFilter(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		input, text, filter string
+	}{
+		{"hello \"big idea\" !world", `hello "big idea" -world`, ""},
+		{"idea #zettel has:task", "idea", "(tags:=`zettel` && has_task:true)"},
+		{"idea OR has:file", "idea", ""},
+		{"#a OR !is:literature", "", "(tags:=`a` || is_literature:false)"},
+		{"!(#a has:file)", "", "(tags:!=`a` || has_file:false)"},
+		{"created:>=2024-06-01", "", "created_at:>=1717200000"},
+		{"id:12/3*", "", "(card_id:=`12/3` || card_id:12/3/* || card_id:12/3.*)"},
+		{"created:2024-13-45", "created:2024-13-45", ""},
+	}
+	for _, c := range cases {
+		query := ParseSearchQuery(c.input)
+		if text := searchText(query); text != c.text {
+			t.Errorf("wrong text for %q, got %q want %q", c.input, text, c.text)
+		}
+		if filter := query.typesenseFilter(now); filter != c.filter {
+			t.Errorf("wrong filter for %q, got %q want %q", c.input, filter, c.filter)
+		}
+	}
+
+	unbounded := &SearchQuery{Kind: searchFilter, Field: "created", Value: "soon"}
+	if filter := unbounded.cardFilterSQL(now); filter != "TRUE" {
+		t.Errorf("expected a date filter without bounds to match everything, got %q", filter)
+	}
+	if filter := unbounded.typesenseFilter(now); filter != "" {
+		t.Errorf("expected a date filter without bounds to be left out, got %q", filter)
+	}
+}
+
+func TestClassicCardSearchFilters(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	for _, cardID := range []string{"900", "900/A", "900/A.1", "901"} {
+		if _, err := s.CreateCard(1, models.EditCardParams{CardID: cardID, Title: "Filtered " + cardID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		searchTerm string
+		wantCount  int
+	}{
+		{"id:900*", 3},
+		{"id:900/A*", 2},
+		{"Filtered (id:901 OR id:900/A.1)", 2},
+		{`"Filtered 90" !id:900* updated:<1d`, 1},
+		{"Filtered created:<2000-01-01", 0},
+	}
+	for _, c := range cases {
+		cards, err := s.ClassicCardSearch(1, SearchRequestParams{SearchTerm: c.searchTerm})
+		if err != nil {
+			t.Fatalf("search for %q failed: %v", c.searchTerm, err)
+		}
+		if len(cards) != c.wantCount {
+			t.Errorf("search for %q got %v cards, want %v", c.searchTerm, len(cards), c.wantCount)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"go-backend/jobs"
 	"go-backend/llms"
 	"go-backend/models"
 	"log"
@@ -84,7 +88,7 @@ func (h *Handler) ListSummarizationsRoute(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(jobs)
 }
 
-// CreateSummarizationRoute creates a summarization job and runs it asynchronously
+// CreateSummarizationRoute creates a summarization job for the worker to run
 func (h *Handler) CreateSummarizationRoute(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("current_user").(int)
 
@@ -93,10 +97,16 @@ func (h *Handler) CreateSummarizationRoute(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
-	client := llms.NewDefaultClient(h.DB, userID)
-	client.Model.ModelIdentifier = "openai/gpt-5-chat"
-	analyses, usage, err := llms.ExtractThesesAndArguments(client, req.Text)
-	id, err := h.runSummarizationJob(userID, analyses, usage, nil)
+	// The worker would only wait for the budget, so say so now
+	if err := llms.CheckBudget(h.DB, userID); err != nil {
+		if writeLLMRequestError(w, err) {
+			return
+		}
+		log.Printf("err %v", err)
+		http.Error(w, "Failed to create summarization job", http.StatusInternalServerError)
+		return
+	}
+	id, err := h.runSummarizationJob(userID, req.Text, nil, llms.Usage{}, nil)
 	if err != nil {
 		log.Printf("err %v", err)
 		http.Error(w, "Failed to create summarization job", http.StatusInternalServerError)
@@ -125,36 +135,53 @@ func (h *Handler) ProcessEntitiesAndFacts(userID int, card models.Card) {
 		return
 	}
 
-	//wordCount := len(strings.Fields(card.Body))
-	go func() {
-		client := llms.NewDefaultClient(h.DB, userID)
-		client.Model.ModelIdentifier = "openai/gpt-5-chat"
-		analyses, usage, err := llms.ExtractThesesAndArguments(client, card.Body)
-		if err != nil {
-			log.Printf("Fact extraction failed: %v", err)
+	h.enqueueJob(models.JobTypeProcessEntitiesAndFacts, userID, cardJobPayload{CardPK: card.ID})
+}
 
-			// todo think about how this should really work, this is a hack to make sure this happens regardless
-			h.LinkCardToEntityIfPossible(userID, card)
-			return
+func (h *Handler) processEntitiesAndFactsJob(ctx context.Context, job models.Job) error {
+	card, err := h.loadJobCard(job)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	userID := card.UserID
+
+	client := llms.NewClientForTask(h.DB, userID, models.LLMTaskFactExtraction)
+	analyses, usage, err := llms.ExtractThesesAndArguments(ctx, client, card.Body)
+	if err != nil {
+		// todo think about how this should really work, this is a hack to make sure this happens regardless
+		h.LinkCardToEntityIfPossible(userID, card)
+		return fmt.Errorf("fact extraction failed: %w", err)
+	}
+	var allFacts []string
+	for _, analysis := range analyses {
+		for _, th := range analysis.Theses {
+			allFacts = append(allFacts, th.Facts...)
 		}
-		var allFacts []string
-		for _, analysis := range analyses {
-			for _, th := range analysis.Theses {
-				allFacts = append(allFacts, th.Facts...)
-			}
+	}
+	log.Printf("found facts %v", len(allFacts))
+	if len(allFacts) > 0 {
+		facts, err := h.ExtractSaveCardFacts(ctx, userID, card.ID, allFacts)
+		if err != nil {
+			return fmt.Errorf("failed to save facts: %w", err)
 		}
-		_, err = h.runSummarizationJob(userID, analyses, usage, &card.ID)
-		log.Printf("found facts %v", len(allFacts))
-		if len(allFacts) > 0 {
-			facts, _ := h.ExtractSaveCardFacts(userID, card.ID, allFacts)
-			_ = h.ExtractSaveFactEntities(userID, card, facts)
+		if err := h.ExtractSaveFactEntities(ctx, userID, card, facts); err != nil {
+			return fmt.Errorf("failed to save fact entities: %w", err)
 		}
-		h.LinkCardToEntityIfPossible(userID, card)
-	}()
+	}
+	// Queued last, once nothing that makes the job retry can fail, so a
+	// retry does not queue a second summarization
+	if _, err := h.runSummarizationJob(userID, "", analyses, usage, &card.ID); err != nil {
+		log.Printf("failed to queue summarization for card %d: %v", card.ID, err)
+	}
+	h.LinkCardToEntityIfPossible(userID, card)
+	return nil
 }
 
-// runSummarizationJob inserts a summarization job and runs it asynchronously.
-func (h *Handler) runSummarizationJob(userID int, analyses []llms.SectionAnalysis, usage llms.Usage, cardPK *int) (int, error) {
+// runSummarizationJob inserts a summarization and queues it for the worker.
+// Without analyses, the worker extracts them from inputText first.
+func (h *Handler) runSummarizationJob(userID int, inputText string, analyses []llms.SectionAnalysis, usage llms.Usage, cardPK *int) (int, error) {
 	var id int
 	var err error
 
@@ -163,40 +190,72 @@ func (h *Handler) runSummarizationJob(userID int, analyses []llms.SectionAnalysi
 			INSERT INTO summarizations (user_id, card_pk, input_text, status, created_at, updated_at)
 			VALUES ($1, $2, $3, 'pending', NOW(), NOW())
 			RETURNING id
-		`, userID, *cardPK, "").Scan(&id)
+		`, userID, *cardPK, inputText).Scan(&id)
 	} else {
 		err = h.DB.QueryRow(`
 			INSERT INTO summarizations (user_id, input_text, status, created_at, updated_at)
 			VALUES ($1, $2, 'pending', NOW(), NOW())
 			RETURNING id
-		`, userID, "").Scan(&id)
+		`, userID, inputText).Scan(&id)
 	}
 	if err != nil {
 		return 0, err
 	}
 
-	// Background job
-	go func(jobID int, analyses []llms.SectionAnalysis, usage llms.Usage, uid int) {
-		client := llms.NewDefaultClient(h.DB, uid)
-		_, _ = h.DB.Exec(`UPDATE summarizations SET status='processing', updated_at=$2 WHERE id=$1`, jobID, time.Now())
+	payload := summarizeJobPayload{
+		SummarizationID: id,
+		Analyses:        analyses,
+		Usage:           usage,
+	}
+	if _, err := jobs.Enqueue(h.DB, models.JobTypeSummarize, userID, payload); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (h *Handler) summarizeJob(ctx context.Context, job models.Job) error {
+	var payload summarizeJobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return err
+	}
+	jobID := payload.SummarizationID
 
-		result, _, usage, err := llms.AnalyzeAndSummarizeText(client, analyses, usage)
+	var uid int
+	var inputText string
+	err := h.DB.QueryRow(`SELECT user_id, input_text FROM summarizations WHERE id = $1`, jobID).Scan(&uid, &inputText)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	client := llms.NewClientForTask(h.DB, uid, models.LLMTaskSummarization)
+	_, _ = h.DB.Exec(`UPDATE summarizations SET status='processing', updated_at=$2 WHERE id=$1`, jobID, time.Now())
+
+	analyses, usage := payload.Analyses, payload.Usage
+	if analyses == nil {
+		analyses, usage, err = llms.ExtractThesesAndArguments(ctx, client, inputText)
 		if err != nil {
 			_, _ = h.DB.Exec(`UPDATE summarizations SET status='failed', result=$2, updated_at=$3 WHERE id=$1`,
 				jobID, err.Error(), time.Now())
-			return
+			return err
 		}
+	}
 
-		modelName := client.Model.ModelIdentifier
-
-		_, _ = h.DB.Exec(`UPDATE summarizations 
-			SET status='complete', result=$2, prompt_tokens=$3, completion_tokens=$4, total_tokens=$5, cost=$6, model=$7, updated_at=$8 
-			WHERE id=$1`,
-			jobID, result, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, usage.TotalCost, modelName, time.Now())
+	result, _, usage, err := llms.AnalyzeAndSummarizeText(ctx, client, analyses, usage)
+	if err != nil {
+		_, _ = h.DB.Exec(`UPDATE summarizations SET status='failed', result=$2, updated_at=$3 WHERE id=$1`,
+			jobID, err.Error(), time.Now())
+		return err
+	}
 
-	}(id, analyses, usage, userID)
+	modelName := client.Model.ModelIdentifier
 
-	return id, nil
+	_, err = h.DB.Exec(`UPDATE summarizations 
+		SET status='complete', result=$2, prompt_tokens=$3, completion_tokens=$4, total_tokens=$5, cost=$6, model=$7, updated_at=$8 
+		WHERE id=$1`,
+		jobID, result, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, usage.TotalCost, modelName, time.Now())
+	return err
 }
 
 // GetSummarizationRoute fetches a summarization job by id
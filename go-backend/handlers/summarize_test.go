@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"go-backend/jobs"
+	"go-backend/llms"
+	"go-backend/models"
+	"go-backend/tests"
+	"testing"
+)
+
+func TestProcessEntitiesAndFactsJob(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	fake := llms.NewFakeProvider().
+		OnPrompt("cardIndex", `[
+			{"cardIndex": 0, "entities": [{"name": "Niklas Luhmann", "description": "Sociologist", "type": "person"}]}
+		]`).
+		OnPrompt("extracts theses", `{
+			"section": "1",
+			"theses": [{"thesis": "Cards should be atomic", "facts": ["Niklas Luhmann wrote 90,000 cards"], "arguments": []}]
+		}`)
+	defer llms.SetProvider(fake)()
+
+	var cardPK int
+	err := s.DB.QueryRow("SELECT id FROM cards WHERE user_id = 1 AND is_deleted = FALSE ORDER BY id LIMIT 1").Scan(&cardPK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := jobs.Enqueue(s.DB, models.JobTypeProcessEntitiesAndFacts, 1, cardJobPayload{CardPK: cardPK})
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %v", err)
+	}
+	job, _ := jobs.Get(s.DB, id)
+	if err := s.processEntitiesAndFactsJob(context.Background(), job); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+
+	var facts int
+	err = s.DB.QueryRow(`
+		SELECT count(*) FROM facts WHERE card_pk = $1 AND fact = 'Niklas Luhmann wrote 90,000 cards'
+	`, cardPK).Scan(&facts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts != 1 {
+		t.Errorf("expected the extracted fact to be saved, got %v", facts)
+	}
+
+	var entities int
+	err = s.DB.QueryRow("SELECT count(*) FROM entities WHERE user_id = 1 AND name = 'Niklas Luhmann'").Scan(&entities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entities != 1 {
+		t.Errorf("expected the fact's entity to be saved, got %v", entities)
+	}
+
+	queued, _ := jobs.List(s.DB, models.JobStatusPending, models.JobTypeSummarize, 10)
+	if len(queued) != 1 {
+		t.Errorf("expected a summarization to be queued, got %v", len(queued))
+	}
+}
@@ -163,6 +163,7 @@ func (s *Handler) GetTaskRoute(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	w.Header().Set("ETag", versionTag(task.UpdatedAt))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
@@ -189,10 +190,20 @@ func (s *Handler) GetTasksRoute(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Handler) UpdateTask(userID int, id int, task models.Task) error {
+	return s.UpdateTaskIfMatch(userID, id, task, "")
+}
+
+// UpdateTaskIfMatch saves a task only if it is still at a version ifMatch
+// names, returning a "version conflict" error otherwise. An empty ifMatch
+// saves it regardless.
+func (s *Handler) UpdateTaskIfMatch(userID int, id int, task models.Task, ifMatch string) error {
 	oldTask, err := s.QueryTask(userID, id)
 	if err != nil {
 		return fmt.Errorf("unable to query task: %v", err)
 	}
+	if !matchesVersion(ifMatch, oldTask.UpdatedAt) {
+		return fmt.Errorf("version conflict")
+	}
 
 	var completedAt *time.Time
 	if task.IsComplete && !oldTask.IsComplete {
@@ -208,7 +219,7 @@ func (s *Handler) UpdateTask(userID int, id int, task models.Task) error {
 		completedAt = nil
 	}
 
-	_, err = s.DB.Exec(`
+	result, err := s.DB.Exec(`
 		UPDATE tasks SET
 			card_pk = $1,
 			scheduled_date = $2,
@@ -218,24 +229,34 @@ func (s *Handler) UpdateTask(userID int, id int, task models.Task) error {
 			priority = $5,
 			is_complete = $6
 		WHERE id = $7 AND user_id = $8 AND is_deleted = FALSE
-	`, task.CardPK, task.ScheduledDate, completedAt, task.Title, task.Priority, task.IsComplete, id, userID)
+		AND ($9 = '' OR updated_at = $10)
+	`, task.CardPK, task.ScheduledDate, completedAt, task.Title, task.Priority, task.IsComplete, id, userID, ifMatch, oldTask.UpdatedAt)
 
 	if err != nil {
 		log.Printf("error: %v", err)
 		return fmt.Errorf("unable to update task")
 	}
+	if updated, _ := result.RowsAffected(); updated == 0 && ifMatch != "" {
+		return fmt.Errorf("version conflict")
+	}
 
 	newTask, err := s.QueryTask(userID, id)
 	if err != nil {
 		log.Printf("Error querying updated task for audit: %v", err)
 	} else {
-		err = s.CreateAuditEvent(userID, id, "task", "update", oldTask, newTask)
+		err = s.createAuditEventWithData(userID, id, "task", "update", oldTask, newTask, map[string]interface{}{
+			"version": versionTag(newTask.UpdatedAt),
+		})
 		if err != nil {
 			log.Printf("Error creating audit event: %v", err)
 		}
 	}
 
 	s.AddTagsFromTask(userID, id)
+	if task.CardPK != oldTask.CardPK {
+		s.reindexCardTypesense(userID, oldTask.CardPK)
+		s.reindexCardTypesense(userID, task.CardPK)
+	}
 	return nil
 }
 
@@ -284,15 +305,28 @@ func (s *Handler) UpdateTaskRoute(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = s.UpdateTask(userID, id, task)
+	ifMatch := r.Header.Get("If-Match")
+	err = s.UpdateTaskIfMatch(userID, id, task, ifMatch)
+	if err != nil && err.Error() == "version conflict" {
+		current, err := s.QueryTask(userID, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.writeVersionConflict(w, "task", id, ifMatch, current.UpdatedAt, current, task)
+		return
+	}
 	if err != nil {
 		log.Printf("error %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	if updated, err := s.QueryTask(userID, id); err == nil {
+		w.Header().Set("ETag", versionTag(updated.UpdatedAt))
+	}
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 
 	response := models.GenericResponse{
 		Message: "success",
@@ -334,6 +368,7 @@ func (s *Handler) CreateTask(task models.Task) (int, error) {
 	}
 
 	s.AddTagsFromTask(task.UserID, taskID)
+	s.reindexCardTypesense(task.UserID, task.CardPK)
 	return taskID, nil
 }
 
@@ -399,7 +434,7 @@ func (s *Handler) DeleteTask(userID int, id int) error {
 	}
 
 	_, err = s.DB.Exec(`
-	UPDATE tasks SET is_deleted = TRUE
+	UPDATE tasks SET is_deleted = TRUE, deleted_at = NOW()
 	WHERE id = $1 AND user_id = $2
 	`, id, userID)
 
@@ -413,6 +448,7 @@ func (s *Handler) DeleteTask(userID int, id int) error {
 		log.Printf("Error creating audit event: %v", err)
 	}
 
+	s.reindexCardTypesense(userID, oldTask.CardPK)
 	return nil
 }
 
@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-backend/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// QueryTrash lists the user's deleted items, most recently deleted first.
+// An empty itemType lists every kind.
+func (s *Handler) QueryTrash(userID int, itemType string) ([]models.TrashItem, error) {
+	rows, err := s.DB.Query(`
+	SELECT item_type, id, title, card_id, deleted_at FROM (
+		SELECT 'card' AS item_type, id, COALESCE(title, '') AS title, COALESCE(card_id, '') AS card_id,
+		COALESCE(deleted_at, updated_at) AS deleted_at
+		FROM cards WHERE user_id = $1 AND is_deleted = TRUE
+		UNION ALL
+		SELECT 'task', id, title, '', COALESCE(deleted_at, updated_at)
+		FROM tasks WHERE user_id = $1 AND is_deleted = TRUE
+		UNION ALL
+		SELECT 'file', id, COALESCE(name, ''), '', COALESCE(deleted_at, updated_at)
+		FROM files WHERE user_id = $1 AND is_deleted = TRUE
+	) trash
+	WHERE $2 = '' OR item_type = $2
+	ORDER BY deleted_at DESC, id DESC
+	`, userID, itemType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.TrashItem{}
+	for rows.Next() {
+		var item models.TrashItem
+		if err := rows.Scan(&item.Type, &item.ID, &item.Title, &item.CardID, &item.DeletedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// RestoreCard takes a card out of the trash, indexes it again and records
+// the links from and to it
+func (s *Handler) RestoreCard(userID, id int) (models.Card, error) {
+	var cardID string
+	err := s.DB.QueryRow(`
+	SELECT card_id FROM cards WHERE id = $1 AND user_id = $2 AND is_deleted = TRUE
+	`, id, userID).Scan(&cardID)
+	if err == sql.ErrNoRows {
+		return models.Card{}, fmt.Errorf("card not in trash")
+	} else if err != nil {
+		return models.Card{}, err
+	}
+	if !s.checkIsCardIDUnique(userID, cardID) {
+		return models.Card{}, fmt.Errorf("card_id already in use")
+	}
+
+	_, err = s.DB.Exec(`
+	UPDATE cards SET is_deleted = FALSE, deleted_at = NULL, updated_at = NOW()
+	WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return models.Card{}, err
+	}
+
+	card, err := s.queryCard(userID, id)
+	if err != nil {
+		return models.Card{}, err
+	}
+	s.upsertCardToTypesense(card)
+	s.updateBacklinks(card.ID, extractBacklinks(card.Body))
+
+	// Cards that link to this one while it was deleted have no backlink
+	// recorded for it
+	rows, err := s.DB.Query(`
+	SELECT id, body FROM cards
+	WHERE user_id = $1 AND is_deleted = FALSE AND id != $2
+	AND (position('[' || $3 || ']' IN body) > 0 OR position('![' || $3 || '#' IN body) > 0)
+	`, userID, id, cardID)
+	if err != nil {
+		log.Printf("failed to find links to restored card %d: %v", id, err)
+	} else {
+		type linkingCard struct {
+			id   int
+			body string
+		}
+		var linking []linkingCard
+		for rows.Next() {
+			var c linkingCard
+			if err := rows.Scan(&c.id, &c.body); err == nil {
+				linking = append(linking, c)
+			}
+		}
+		rows.Close()
+		for _, c := range linking {
+			s.updateBacklinks(c.id, extractBacklinks(c.body))
+		}
+	}
+
+	s.CreateAuditEvent(userID, id, "card", "undelete", nil, card)
+	return card, nil
+}
+
+// RestoreTask takes a task out of the trash
+func (s *Handler) RestoreTask(userID, id int) (models.Task, error) {
+	result, err := s.DB.Exec(`
+	UPDATE tasks SET is_deleted = FALSE, deleted_at = NULL, updated_at = NOW()
+	WHERE id = $1 AND user_id = $2 AND is_deleted = TRUE
+	`, id, userID)
+	if err != nil {
+		return models.Task{}, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return models.Task{}, fmt.Errorf("task not in trash")
+	}
+	task, err := s.QueryTask(userID, id)
+	if err != nil {
+		return models.Task{}, err
+	}
+	s.CreateAuditEvent(userID, id, "task", "undelete", nil, task)
+	s.reindexCardTypesense(userID, task.CardPK)
+	return task, nil
+}
+
+// RestoreFile takes a file out of the trash
+func (s *Handler) RestoreFile(userID, id int) (models.File, error) {
+	result, err := s.DB.Exec(`
+	UPDATE files SET is_deleted = FALSE, deleted_at = NULL, updated_at = NOW()
+	WHERE id = $1 AND user_id = $2 AND is_deleted = TRUE
+	`, id, userID)
+	if err != nil {
+		return models.File{}, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return models.File{}, fmt.Errorf("file not in trash")
+	}
+	file, err := s.queryFile(userID, id)
+	if err != nil {
+		return models.File{}, err
+	}
+	s.reindexCardTypesense(userID, file.CardPK)
+	return file, nil
+}
+
+// PurgeCard permanently deletes a card in the trash along with its
+// embeddings, facts, links, history and search documents. Its tasks and
+// files are kept, detached from it, and its children become their own
+// parents.
+func (s *Handler) PurgeCard(userID, id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var cardPK int
+	err = tx.QueryRow(`
+	SELECT id FROM cards WHERE id = $1 AND user_id = $2 AND is_deleted = TRUE FOR UPDATE
+	`, id, userID).Scan(&cardPK)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("card not in trash")
+	} else if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`DELETE FROM facts WHERE card_pk = $1 RETURNING id`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete facts: %w", err)
+	}
+	var factIDs []int
+	for rows.Next() {
+		var factID int
+		if err := rows.Scan(&factID); err != nil {
+			rows.Close()
+			return err
+		}
+		factIDs = append(factIDs, factID)
+	}
+	rows.Close()
+
+	statements := []string{
+		`DELETE FROM backlinks WHERE source_id_int = $1 OR target_id_int = $1`,
+		`DELETE FROM card_embeddings WHERE card_pk = $1`,
+		`DELETE FROM card_chunks WHERE card_pk = $1`,
+		`DELETE FROM keywords WHERE card_pk = $1`,
+		`DELETE FROM card_views WHERE card_pk = $1`,
+		`DELETE FROM flashcard_reviews WHERE card_pk = $1`,
+		`DELETE FROM inactive_cards WHERE card_pk = $1`,
+		`DELETE FROM entity_card_junction WHERE card_pk = $1`,
+		`UPDATE entities SET card_pk = NULL WHERE card_pk = $1`,
+		`UPDATE files SET card_pk = -1 WHERE card_pk = $1`,
+		`UPDATE tasks SET card_pk = 0 WHERE card_pk = $1`,
+		// Children become their own parents, like cards created without one
+		`UPDATE cards SET parent_id = id WHERE parent_id = $1 AND id != $1`,
+		`DELETE FROM audit_events WHERE entity_type = 'card' AND entity_id = $1`,
+		`DELETE FROM cards WHERE id = $1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id); err != nil {
+			return fmt.Errorf("failed to purge card %d: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.deleteCardTypesense(id)
+	for _, factID := range factIDs {
+		s.deleteFactTypesense(factID)
+	}
+	s.CreateAuditEvent(userID, id, "card", "purge", nil, nil)
+	return nil
+}
+
+// PurgeTask permanently deletes a task in the trash
+func (s *Handler) PurgeTask(userID, id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM tasks WHERE id = $1 AND user_id = $2 AND is_deleted = TRUE`, id, userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("task not in trash")
+	}
+	if _, err := tx.Exec(`DELETE FROM audit_events WHERE entity_type = 'task' AND entity_id = $1`, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.CreateAuditEvent(userID, id, "task", "purge", nil, nil)
+	return nil
+}
+
+// PurgeFile permanently deletes a file in the trash and its S3 object
+func (s *Handler) PurgeFile(userID, id int) error {
+	var path string
+	err := s.DB.QueryRow(`
+	SELECT COALESCE(path, '') FROM files WHERE id = $1 AND user_id = $2 AND is_deleted = TRUE
+	`, id, userID).Scan(&path)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("file not in trash")
+	} else if err != nil {
+		return err
+	}
+
+	if path != "" {
+		if s.Server.S3 == nil && !s.Server.Testing {
+			return fmt.Errorf("file storage is not configured")
+		}
+		if err := s.deleteObject(s.Server.S3, path); err != nil {
+			return fmt.Errorf("failed to delete object of file %d: %w", id, err)
+		}
+	}
+	_, err = s.DB.Exec(`DELETE FROM files WHERE id = $1 AND user_id = $2`, id, userID)
+	return err
+}
+
+func (s *Handler) purgeTrashItem(userID int, itemType string, id int) error {
+	switch itemType {
+	case models.TrashTypeCard:
+		return s.PurgeCard(userID, id)
+	case models.TrashTypeTask:
+		return s.PurgeTask(userID, id)
+	case models.TrashTypeFile:
+		return s.PurgeFile(userID, id)
+	}
+	return fmt.Errorf("unknown trash type")
+}
+
+// purgeTrash purges the items returned by query, which selects the item
+// type, id and owner of each
+func (s *Handler) purgeTrash(ctx context.Context, query string, args ...interface{}) (models.TrashPurgeResult, error) {
+	var result models.TrashPurgeResult
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return result, err
+	}
+	type trashed struct {
+		itemType string
+		id       int
+		userID   int
+	}
+	var items []trashed
+	for rows.Next() {
+		var item trashed
+		if err := rows.Scan(&item.itemType, &item.id, &item.userID); err != nil {
+			rows.Close()
+			return result, err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if err := s.purgeTrashItem(item.userID, item.itemType, item.id); err != nil {
+			// Another worker may have purged it first
+			log.Printf("failed to purge %s %d: %v", item.itemType, item.id, err)
+			continue
+		}
+		switch item.itemType {
+		case models.TrashTypeCard:
+			result.Cards++
+		case models.TrashTypeTask:
+			result.Tasks++
+		case models.TrashTypeFile:
+			result.Files++
+		}
+	}
+	return result, nil
+}
+
+const trashItemsQuery = `
+	SELECT 'card', id, user_id, COALESCE(deleted_at, updated_at) AS deleted_at FROM cards WHERE is_deleted = TRUE
+	UNION ALL
+	SELECT 'task', id, user_id, COALESCE(deleted_at, updated_at) FROM tasks WHERE is_deleted = TRUE
+	UNION ALL
+	SELECT 'file', id, user_id, COALESCE(deleted_at, updated_at) FROM files WHERE is_deleted = TRUE
+`
+
+// EmptyTrash purges everything in the user's trash
+func (s *Handler) EmptyTrash(ctx context.Context, userID int) (models.TrashPurgeResult, error) {
+	return s.purgeTrash(ctx, `
+	SELECT item_type, id, user_id FROM (`+trashItemsQuery+`) AS trash (item_type, id, user_id, deleted_at)
+	WHERE user_id = $1
+	`, userID)
+}
+
+// PurgeExpiredTrash purges items of every user that were deleted longer
+// than retention ago
+func (s *Handler) PurgeExpiredTrash(ctx context.Context, retention time.Duration) (models.TrashPurgeResult, error) {
+	return s.purgeTrash(ctx, `
+	SELECT item_type, id, user_id FROM (`+trashItemsQuery+`) AS trash (item_type, id, user_id, deleted_at)
+	WHERE deleted_at < $1
+	`, time.Now().Add(-retention))
+}
+
+func writeTrashError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "card not in trash", "task not in trash", "file not in trash":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "unknown trash type":
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case "card_id already in use":
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("trash error: %v", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+	}
+}
+
+func (s *Handler) GetTrashRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	itemType := r.URL.Query().Get("type")
+	switch itemType {
+	case "", models.TrashTypeCard, models.TrashTypeTask, models.TrashTypeFile:
+	default:
+		http.Error(w, "unknown trash type", http.StatusBadRequest)
+		return
+	}
+
+	items, err := s.QueryTrash(userID, itemType)
+	if err != nil {
+		writeTrashError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func (s *Handler) RestoreTrashItemRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var restored interface{}
+	switch mux.Vars(r)["type"] {
+	case models.TrashTypeCard:
+		restored, err = s.RestoreCard(userID, id)
+	case models.TrashTypeTask:
+		restored, err = s.RestoreTask(userID, id)
+	case models.TrashTypeFile:
+		restored, err = s.RestoreFile(userID, id)
+	default:
+		err = fmt.Errorf("unknown trash type")
+	}
+	if err != nil {
+		writeTrashError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restored)
+}
+
+func (s *Handler) PurgeTrashItemRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.purgeTrashItem(userID, mux.Vars(r)["type"], id); err != nil {
+		writeTrashError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Handler) EmptyTrashRoute(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("current_user").(int)
+
+	result, err := s.EmptyTrash(r.Context(), userID)
+	if err != nil {
+		writeTrashError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"go-backend/models"
+	"go-backend/tests"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func makeTrashRequest(s *Handler, t *testing.T, method, path string, route string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	token, _ := tests.GenerateTestJWT(1)
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc(route, s.JwtMiddleware(handler))
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestTrashRestoreAndPurgeCard(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	target, err := s.CreateCard(1, models.EditCardParams{CardID: "900", Title: "Trashed", Body: "Links to [1]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := s.CreateCard(1, models.EditCardParams{CardID: "901", Title: "Linking", Body: "See [900]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteCard(1, target.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The link is lost while the card is deleted
+	s.updateBacklinks(source.ID, extractBacklinks(source.Body))
+
+	rr := makeTrashRequest(s, t, "GET", "/api/trash?type=card", "/api/trash", s.GetTrashRoute)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+	var items []models.TrashItem
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &items)
+	if len(items) == 0 || items[0].ID != target.ID || items[0].CardID != "900" {
+		t.Fatalf("expected the deleted card in the trash, got %+v", items)
+	}
+
+	path := "/api/trash/card/" + strconv.Itoa(target.ID)
+	rr = makeTrashRequest(s, t, "POST", path+"/restore", "/api/trash/{type}/{id}/restore", s.RestoreTrashItemRoute)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+	if _, err := s.QueryFullCard(1, target.ID); err != nil {
+		t.Fatalf("expected the card to be restored, got %v", err)
+	}
+	backlinks, _ := s.getBacklinks(1, "900")
+	if len(backlinks) != 1 || backlinks[0].CardID != "901" {
+		t.Errorf("expected links to the restored card to be recorded, got %v", backlinks)
+	}
+
+	rr = makeTrashRequest(s, t, "DELETE", path, "/api/trash/{type}/{id}", s.PurgeTrashItemRoute)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("a card not in the trash should not be purged, got %v", status)
+	}
+
+	s.DeleteCard(1, target.ID)
+	// A card whose parent_id still points at the card, as one moved away
+	// from below it would
+	child, err := s.CreateCard(1, models.EditCardParams{CardID: "902", Title: "Child"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.DB.Exec("UPDATE cards SET parent_id = $1 WHERE id = $2", target.ID, child.ID)
+	rr = makeTrashRequest(s, t, "DELETE", path, "/api/trash/{type}/{id}", s.PurgeTrashItemRoute)
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusNoContent, rr.Body.String())
+	}
+	var count int
+	s.DB.QueryRow("SELECT count(*) FROM cards WHERE id = $1", target.ID).Scan(&count)
+	if count != 0 {
+		t.Errorf("expected the card to be purged")
+	}
+	s.DB.QueryRow("SELECT count(*) FROM backlinks WHERE target_id_int = $1", target.ID).Scan(&count)
+	if count != 0 {
+		t.Errorf("expected the links to the card to be purged")
+	}
+	var parentID int
+	s.DB.QueryRow("SELECT parent_id FROM cards WHERE id = $1", child.ID).Scan(&parentID)
+	if parentID != child.ID {
+		t.Errorf("expected the child to become its own parent, got %v", parentID)
+	}
+}
+
+func TestTrashFileAndRetention(t *testing.T) {
+	s := setup()
+	defer tests.Teardown()
+
+	rr := makeTrashRequest(s, t, "DELETE", "/api/files/1", "/api/files/{id}", s.DeleteFileRoute)
+	if status := rr.Code; status != http.StatusOK && status != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v, %v", status, rr.Body.String())
+	}
+
+	rr = makeTrashRequest(s, t, "POST", "/api/trash/file/1/restore", "/api/trash/{type}/{id}/restore", s.RestoreTrashItemRoute)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %v", status, http.StatusOK, rr.Body.String())
+	}
+	var file models.File
+	tests.ParseJsonResponse(t, rr.Body.Bytes(), &file)
+	if file.ID != 1 || file.IsDeleted {
+		t.Errorf("expected the file to be restored, got %+v", file)
+	}
+
+	makeTrashRequest(s, t, "DELETE", "/api/files/1", "/api/files/{id}", s.DeleteFileRoute)
+	result, err := s.PurgeExpiredTrash(context.Background(), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	s.DB.QueryRow("SELECT count(*) FROM files WHERE id = 1").Scan(&count)
+	if count != 1 {
+		t.Errorf("a file deleted today should be kept, got %+v", result)
+	}
+	s.DB.Exec("UPDATE files SET deleted_at = NOW() - INTERVAL '2 days' WHERE id = 1")
+	result, err = s.PurgeExpiredTrash(context.Background(), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.DB.QueryRow("SELECT count(*) FROM files WHERE id = 1").Scan(&count)
+	if count != 0 || result.Files == 0 {
+		t.Errorf("expected the expired file to be purged, got %+v", result)
+	}
+}
@@ -0,0 +1,268 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-backend/models"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 5
+	baseBackoff        = 30 * time.Second
+	maxBackoff         = time.Hour
+)
+
+const jobColumns = `
+	id, job_type, user_id, payload, status, attempts, max_attempts, run_at,
+	locked_by, locked_until, last_error, created_at, updated_at, completed_at
+`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (models.Job, error) {
+	var job models.Job
+	var payload []byte
+	err := row.Scan(
+		&job.ID,
+		&job.JobType,
+		&job.UserID,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LockedBy,
+		&job.LockedUntil,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.CompletedAt,
+	)
+	job.Payload = json.RawMessage(payload)
+	return job, err
+}
+
+// Backoff returns how long to wait before retrying a job that has failed
+// the given number of attempts
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := baseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
+
+// Enqueue stores a job to be picked up by the worker. A userID of 0 means the
+// job does not belong to a user.
+func Enqueue(db *sql.DB, jobType string, userID int, payload interface{}) (int, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+	var owner *int
+	if userID != 0 {
+		owner = &userID
+	}
+
+	var id int
+	err = db.QueryRow(`
+		INSERT INTO jobs (job_type, user_id, payload, max_attempts)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, jobType, owner, data, DefaultMaxAttempts).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue %s job: %w", jobType, err)
+	}
+	return id, nil
+}
+
+// reapExpired releases jobs still running when their lease ran out, most
+// likely because the worker was restarted mid-job. Leases are not renewed,
+// so the lease must be longer than the longest job; a job's context ends
+// with its lease for that reason.
+func reapExpired(db *sql.DB) error {
+	_, err := db.Exec(`
+		UPDATE jobs SET
+			status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+			last_error = 'lease expired',
+			locked_by = NULL,
+			locked_until = NULL,
+			updated_at = NOW()
+		WHERE status = 'running' AND locked_until < NOW()
+	`)
+	return err
+}
+
+// Lease claims the next runnable job for a worker, for the given duration.
+// It returns nil if there is nothing to do.
+func Lease(db *sql.DB, workerID string, lease time.Duration) (*models.Job, error) {
+	if err := reapExpired(db); err != nil {
+		return nil, fmt.Errorf("failed to release expired jobs: %w", err)
+	}
+
+	row := db.QueryRow(`
+		UPDATE jobs SET
+			status = 'running',
+			attempts = attempts + 1,
+			locked_by = $1,
+			locked_until = NOW() + $2 * INTERVAL '1 second',
+			updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = 'pending' AND run_at <= NOW()
+			ORDER BY run_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING `+jobColumns, workerID, int(lease.Seconds()))
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lease job: %w", err)
+	}
+	return &job, nil
+}
+
+// Complete marks a leased job as done
+func Complete(db *sql.DB, job models.Job) error {
+	_, err := db.Exec(`
+		UPDATE jobs SET
+			status = 'complete',
+			locked_by = NULL,
+			locked_until = NULL,
+			last_error = NULL,
+			completed_at = NOW(),
+			updated_at = NOW()
+		WHERE id = $1 AND status = 'running'
+	`, job.ID)
+	return err
+}
+
+// Deferrer is implemented by errors that know when the work can succeed,
+// such as a spent budget. Such jobs wait until then without using an attempt.
+type Deferrer interface {
+	RetryAt() time.Time
+}
+
+// Fail records a failed attempt. The job is retried after a backoff, or moved
+// to the dead state once it has used all of its attempts.
+func Fail(db *sql.DB, job models.Job, jobErr error) error {
+	var deferrer Deferrer
+	if errors.As(jobErr, &deferrer) {
+		_, err := db.Exec(`
+			UPDATE jobs SET
+				status = 'pending',
+				attempts = GREATEST(attempts - 1, 0),
+				last_error = $2,
+				run_at = $3,
+				locked_by = NULL,
+				locked_until = NULL,
+				updated_at = NOW()
+			WHERE id = $1 AND status = 'running'
+		`, job.ID, jobErr.Error(), deferrer.RetryAt())
+		return err
+	}
+
+	status := models.JobStatusPending
+	if job.Attempts >= job.MaxAttempts {
+		status = models.JobStatusDead
+	}
+	_, err := db.Exec(`
+		UPDATE jobs SET
+			status = $2,
+			last_error = $3,
+			run_at = NOW() + $4 * INTERVAL '1 second',
+			locked_by = NULL,
+			locked_until = NULL,
+			updated_at = NOW()
+		WHERE id = $1 AND status = 'running'
+	`, job.ID, status, jobErr.Error(), int(Backoff(job.Attempts).Seconds()))
+	return err
+}
+
+// Retry puts a dead job back in the queue with a fresh set of attempts
+func Retry(db *sql.DB, id int) error {
+	result, err := db.Exec(`
+		UPDATE jobs SET
+			status = 'pending',
+			attempts = 0,
+			run_at = NOW(),
+			updated_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+	`, id)
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return fmt.Errorf("job not found or not dead")
+	}
+	return nil
+}
+
+func Get(db *sql.DB, id int) (models.Job, error) {
+	job, err := scanJob(db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return models.Job{}, fmt.Errorf("job not found")
+	}
+	return job, err
+}
+
+// List returns the most recent jobs, optionally filtered by status and type
+func List(db *sql.DB, status, jobType string, limit int) ([]models.Job, error) {
+	rows, err := db.Query(`
+		SELECT `+jobColumns+` FROM jobs
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR job_type = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, status, jobType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, job)
+	}
+	return results, rows.Err()
+}
+
+func Stats(db *sql.DB) ([]models.JobStats, error) {
+	rows, err := db.Query(`
+		SELECT job_type, status, count(*)
+		FROM jobs
+		GROUP BY job_type, status
+		ORDER BY job_type, status
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.JobStats{}
+	for rows.Next() {
+		var stat models.JobStats
+		if err := rows.Scan(&stat.JobType, &stat.Status, &stat.Count); err != nil {
+			return nil, err
+		}
+		results = append(results, stat)
+	}
+	return results, rows.Err()
+}
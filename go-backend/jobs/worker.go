@@ -0,0 +1,84 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-backend/models"
+	"log"
+	"time"
+)
+
+// HandlerFunc runs a job. Its context ends when the job's lease does.
+type HandlerFunc func(ctx context.Context, job models.Job) error
+
+// Worker runs jobs from the queue. Lease is both how long a job is held and
+// how long it may run, so it must be longer than the slowest job; jobs still
+// running when it runs out are cancelled and may be retried elsewhere.
+type Worker struct {
+	DB           *sql.DB
+	ID           string
+	Handlers     map[string]HandlerFunc
+	Lease        time.Duration
+	PollInterval time.Duration
+}
+
+// RunOnce leases and executes a single job. It reports whether a job was
+// found, so callers can back off when the queue is empty.
+func (w *Worker) RunOnce() (bool, error) {
+	job, err := Lease(w.DB, w.ID, w.Lease)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	if err := w.execute(*job); err != nil {
+		log.Printf("job %d (%s) attempt %d failed: %v", job.ID, job.JobType, job.Attempts, err)
+		if err := Fail(w.DB, *job, err); err != nil {
+			return true, fmt.Errorf("failed to record failure of job %d: %w", job.ID, err)
+		}
+		return true, nil
+	}
+	if err := Complete(w.DB, *job); err != nil {
+		return true, fmt.Errorf("failed to complete job %d: %w", job.ID, err)
+	}
+	return true, nil
+}
+
+func (w *Worker) execute(job models.Job) (err error) {
+	handler, ok := w.Handlers[job.JobType]
+	if !ok {
+		return fmt.Errorf("no handler for job type %s", job.JobType)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	// Not derived from the worker's context, so that a job that is running
+	// at shutdown can finish
+	ctx, cancel := context.WithTimeout(context.Background(), w.Lease)
+	defer cancel()
+	return handler(ctx, job)
+}
+
+// Run processes jobs until the context is cancelled. A job that is running
+// when the context is cancelled is allowed to finish.
+func (w *Worker) Run(ctx context.Context) {
+	for {
+		found, err := w.RunOnce()
+		if err != nil {
+			log.Printf("worker %s: %v", w.ID, err)
+		}
+		if found && err == nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.PollInterval):
+		}
+	}
+}
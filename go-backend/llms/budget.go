@@ -0,0 +1,217 @@
+package llms
+
+import (
+	"database/sql"
+	"fmt"
+	"go-backend/models"
+	"time"
+)
+
+// BudgetExceededError is returned when a user has spent their monthly budget
+type BudgetExceededError struct {
+	UserID   int
+	LimitUSD float64
+	SpentUSD float64
+	ResetsAt time.Time
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("monthly llm budget exceeded: spent $%.4f of $%.4f", e.SpentUSD, e.LimitUSD)
+}
+
+// RetryAt lets the job queue hold work until the budget resets
+func (e *BudgetExceededError) RetryAt() time.Time {
+	return e.ResetsAt
+}
+
+// RateLimitError is returned when a user sends requests faster than their
+// budget allows
+type RateLimitError struct {
+	UserID            int
+	RequestsPerMinute int
+	RetryAfter        time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("llm rate limit exceeded: %d requests per minute", e.RequestsPerMinute)
+}
+
+func (e *RateLimitError) RetryAt() time.Time {
+	return time.Now().Add(e.RetryAfter)
+}
+
+// budgetPeriod returns the calendar month containing t, in UTC
+func budgetPeriod(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+const budgetColumns = `
+	b.id, b.plan, b.user_id, b.monthly_limit_usd, b.requests_per_minute, b.created_at, b.updated_at
+`
+
+func scanBudget(row interface{ Scan(...interface{}) error }) (models.LLMBudget, error) {
+	var budget models.LLMBudget
+	err := row.Scan(
+		&budget.ID,
+		&budget.Plan,
+		&budget.UserID,
+		&budget.MonthlyLimitUSD,
+		&budget.RequestsPerMinute,
+		&budget.CreatedAt,
+		&budget.UpdatedAt,
+	)
+	return budget, err
+}
+
+// GetUserBudget returns the budget that applies to a user: their own, then
+// their plan's, then the default plan's. It returns sql.ErrNoRows when the
+// user is not limited.
+func GetUserBudget(db *sql.DB, userID int) (models.LLMBudget, error) {
+	return scanBudget(db.QueryRow(`
+		SELECT `+budgetColumns+`
+		FROM llm_budgets b, users u
+		WHERE u.id = $1
+		AND (b.user_id = u.id OR b.plan = NULLIF(u.stripe_current_plan, '') OR b.plan = $2)
+		ORDER BY
+			b.user_id IS NOT NULL DESC,
+			b.plan = $2 ASC
+		LIMIT 1
+	`, userID, models.DefaultBudgetPlan))
+}
+
+func ListBudgets(db *sql.DB) ([]models.LLMBudget, error) {
+	rows, err := db.Query(`SELECT ` + budgetColumns + ` FROM llm_budgets b ORDER BY b.plan NULLS LAST, b.user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	budgets := []models.LLMBudget{}
+	for rows.Next() {
+		budget, err := scanBudget(rows)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, budget)
+	}
+	return budgets, rows.Err()
+}
+
+// SaveBudget creates or replaces the budget for a plan or a user
+func SaveBudget(db *sql.DB, budget models.LLMBudget) (models.LLMBudget, error) {
+	if (budget.Plan == nil) == (budget.UserID == nil) {
+		return models.LLMBudget{}, fmt.Errorf("budget needs either a plan or a user")
+	}
+	if budget.MonthlyLimitUSD < 0 {
+		return models.LLMBudget{}, fmt.Errorf("budget limit cannot be negative")
+	}
+
+	conflict := "(plan)"
+	if budget.UserID != nil {
+		conflict = "(user_id)"
+	}
+	return scanBudget(db.QueryRow(`
+		INSERT INTO llm_budgets AS b (plan, user_id, monthly_limit_usd, requests_per_minute)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT `+conflict+` DO UPDATE SET
+			monthly_limit_usd = EXCLUDED.monthly_limit_usd,
+			requests_per_minute = EXCLUDED.requests_per_minute,
+			updated_at = NOW()
+		RETURNING `+budgetColumns,
+		budget.Plan, budget.UserID, budget.MonthlyLimitUSD, budget.RequestsPerMinute,
+	))
+}
+
+func DeleteBudget(db *sql.DB, id int) error {
+	result, err := db.Exec(`DELETE FROM llm_budgets WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return fmt.Errorf("budget not found")
+	}
+	return nil
+}
+
+// GetSpend reports a user's spend for the current month against their budget
+func GetSpend(db *sql.DB, userID int) (models.LLMSpend, error) {
+	start, end := budgetPeriod(time.Now())
+	spend := models.LLMSpend{
+		UserID:      userID,
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(cost_usd), 0)
+		FROM llm_query_log
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+	`, userID, start, end).Scan(&spend.SpentUSD)
+	if err != nil {
+		return spend, err
+	}
+
+	budget, err := GetUserBudget(db, userID)
+	if err == sql.ErrNoRows {
+		return spend, nil
+	} else if err != nil {
+		return spend, err
+	}
+	remaining := budget.MonthlyLimitUSD - spend.SpentUSD
+	if remaining < 0 {
+		remaining = 0
+	}
+	spend.BudgetID = &budget.ID
+	spend.LimitUSD = &budget.MonthlyLimitUSD
+	spend.RemainingUSD = &remaining
+	spend.RequestsPerMinute = budget.RequestsPerMinute
+	return spend, nil
+}
+
+// CheckBudget returns a *BudgetExceededError or *RateLimitError if the user
+// may not make another request right now
+func CheckBudget(db *sql.DB, userID int) error {
+	spend, err := GetSpend(db, userID)
+	if err != nil {
+		return fmt.Errorf("failed to check llm budget: %w", err)
+	}
+	if spend.LimitUSD == nil {
+		return nil
+	}
+	if spend.SpentUSD >= *spend.LimitUSD {
+		return &BudgetExceededError{
+			UserID:   userID,
+			LimitUSD: *spend.LimitUSD,
+			SpentUSD: spend.SpentUSD,
+			ResetsAt: spend.PeriodEnd,
+		}
+	}
+
+	if spend.RequestsPerMinute != nil && *spend.RequestsPerMinute > 0 {
+		var count int
+		var oldest sql.NullTime
+		err := db.QueryRow(`
+			SELECT count(*), min(created_at)
+			FROM llm_query_log
+			WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 minute'
+		`, userID).Scan(&count, &oldest)
+		if err != nil {
+			return fmt.Errorf("failed to check llm rate limit: %w", err)
+		}
+		if count >= *spend.RequestsPerMinute {
+			retryAfter := time.Minute
+			if oldest.Valid {
+				retryAfter = time.Until(oldest.Time.Add(time.Minute))
+			}
+			retryAfter = min(max(retryAfter, time.Second), time.Minute)
+			return &RateLimitError{
+				UserID:            userID,
+				RequestsPerMinute: *spend.RequestsPerMinute,
+				RetryAfter:        retryAfter,
+			}
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package llms
 
 import (
+	"context"
 	"fmt"
 	"go-backend/models"
 	"log"
@@ -9,16 +10,7 @@ import (
 	openai "github.com/sashabaranov/go-openai"
 )
 
-func ChatCompletion(c *models.LLMClient, pastMessages []models.ChatCompletion) (models.ChatCompletion, error) {
-	if c.Testing {
-		// Return mock response
-		return models.ChatCompletion{
-			Role:    "assistant",
-			Content: "This is a mock response for testing",
-			Model:   c.Model.ModelIdentifier,
-			Tokens:  100,
-		}, nil
-	}
+func ChatCompletion(ctx context.Context, c *models.LLMClient, pastMessages []models.ChatCompletion) (models.ChatCompletion, error) {
 	var messages []openai.ChatCompletionMessage
 
 	for _, message := range pastMessages {
@@ -31,10 +23,10 @@ func ChatCompletion(c *models.LLMClient, pastMessages []models.ChatCompletion) (
 
 	// Create the OpenAI request
 
-	resp, err := ExecuteLLMRequest(c, messages)
+	resp, err := ExecuteLLMRequest(ctx, c, messages)
 	if err != nil {
 		log.Printf("error getting completion: %v", err)
-		return models.ChatCompletion{}, fmt.Errorf("failed to get AI response")
+		return models.ChatCompletion{}, fmt.Errorf("failed to get AI response: %w", err)
 	}
 
 	if len(resp.Choices) == 0 {
@@ -51,18 +43,7 @@ func ChatCompletion(c *models.LLMClient, pastMessages []models.ChatCompletion) (
 
 }
 
-func CreateConversationSummary(c *models.LLMClient, message models.ChatCompletion) (models.ConversationSummary, error) {
-	// Check if in testing mode
-	if c.Testing {
-		// Return mock summary
-		return models.ConversationSummary{
-			ID:        message.ConversationID,
-			Title:     "🤖 Mock Summary Title",
-			CreatedAt: message.CreatedAt,
-			Model:     models.MODEL,
-		}, nil
-	}
-
+func CreateConversationSummary(ctx context.Context, c *models.LLMClient, message models.ChatCompletion) (models.ConversationSummary, error) {
 	content := message.Role + ": " + message.Content + "\n"
 	id := message.ConversationID
 	created := message.CreatedAt
@@ -73,10 +54,13 @@ func CreateConversationSummary(c *models.LLMClient, message models.ChatCompletio
 			Content: fmt.Sprintf("Please generate a few words a title that summarizes the following quesiton and answer. Please start with an emoji that you think covers the topic as well. Respond only in the format: Emoji Title, nothing else. Please no quotation marks. Content: %v", content),
 		},
 	}
-	resp, err := ExecuteLLMRequest(c, new)
+	resp, err := ExecuteLLMRequest(ctx, c, new)
 	if err != nil {
 		log.Printf("error getting completion: %v", err)
-		return models.ConversationSummary{}, fmt.Errorf("failed to get AI response")
+		return models.ConversationSummary{}, fmt.Errorf("failed to get AI response: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return models.ConversationSummary{}, fmt.Errorf("no response from AI")
 	}
 	result := models.ConversationSummary{
 		ID:        id,
@@ -87,7 +71,7 @@ func CreateConversationSummary(c *models.LLMClient, message models.ChatCompletio
 	return result, nil
 }
 
-func AnswerUserInfoQuestion(c *models.LLMClient, userData models.User, lastMessage string) (models.ChatCompletion, error) {
+func AnswerUserInfoQuestion(ctx context.Context, c *models.LLMClient, userData models.User, lastMessage string) (models.ChatCompletion, error) {
 
 	prompt := `
 You are a helpful assistant. Your job is to take a go struct of user data and use it to answer the user's question. If you don't have the information you need, say you can't answer the question. Be brief. Never give out the user's password':
@@ -125,7 +109,7 @@ The user data is this: %v'
 		},
 	}
 
-	resp, err := ExecuteLLMRequest(c, messages)
+	resp, err := ExecuteLLMRequest(ctx, c, messages)
 	if err != nil {
 		log.Printf("error getting completion: %v", err)
 		return models.ChatCompletion{}, fmt.Errorf("failed to get AI response: %w", err)
@@ -144,7 +128,13 @@ The user data is this: %v'
 	return completion, err
 
 }
-func CardSearchChatCompletion(c *models.LLMClient, messages []models.ChatCompletion, relatedCards []models.CardChunk) (models.ChatCompletion, error) {
+func CardSearchChatCompletion(ctx context.Context, c *models.LLMClient, messages []models.ChatCompletion, relatedCards []models.CardChunk) (models.ChatCompletion, error) {
+	// Create a slice of card IDs that were used
+	cardIDs := make([]int, len(relatedCards))
+	for i, card := range relatedCards {
+		cardIDs[i] = card.ID
+	}
+
 	// Create a string representation of the cards for the context
 	var cardContext strings.Builder
 	cardContext.WriteString("Here are the relevant cards from the knowledge base:\n\n")
@@ -184,7 +174,7 @@ Context: %s`
 
 	// Get completion from OpenAI
 
-	resp, err := ExecuteLLMRequest(c, openAIMessages)
+	resp, err := ExecuteLLMRequest(ctx, c, openAIMessages)
 	if err != nil {
 		log.Printf("error getting completion: %v", err)
 		return models.ChatCompletion{}, fmt.Errorf("failed to get AI response: %w", err)
@@ -196,18 +186,12 @@ Context: %s`
 
 	// Create the completion response
 	completion := models.ChatCompletion{
-		Role:    resp.Choices[0].Message.Role,
-		Content: resp.Choices[0].Message.Content,
-		Model:   models.MODEL,
-		Tokens:  resp.Usage.TotalTokens,
-	}
-
-	// Create a slice of card IDs that were used
-	cardIDs := make([]int, len(relatedCards))
-	for i, card := range relatedCards {
-		cardIDs[i] = card.ID
+		Role:              resp.Choices[0].Message.Role,
+		Content:           resp.Choices[0].Message.Content,
+		Model:             c.Model.ModelIdentifier,
+		Tokens:            resp.Usage.TotalTokens,
+		ReferencedCardPKs: cardIDs,
 	}
-	completion.ReferencedCardPKs = cardIDs
 
 	return completion, nil
 }
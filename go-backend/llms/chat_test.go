@@ -1,5 +1,58 @@
 package llms
 
-type ChatCompletionMessage struct {
-	
+import (
+	"context"
+	"go-backend/models"
+	"strings"
+	"testing"
+)
+
+func TestChatCompletionUsesProvider(t *testing.T) {
+	fake := NewFakeProvider().OnPrompt("what is a zettel", "A note card")
+	defer SetProvider(fake)()
+
+	completion, err := ChatCompletion(context.Background(), fakeClient(), []models.ChatCompletion{
+		{Role: "user", Content: "what is a zettel"},
+	})
+	if err != nil {
+		t.Fatalf("failed to get completion: %v", err)
+	}
+	if completion.Content != "A note card" || completion.Tokens == 0 {
+		t.Errorf("wrong completion, got %+v", completion)
+	}
+}
+
+func TestCreateConversationSummaryUsesProvider(t *testing.T) {
+	fake := NewFakeProvider().OnPrompt("summarizes the following", "🗂️ Slip boxes")
+	defer SetProvider(fake)()
+
+	summary, err := CreateConversationSummary(context.Background(), fakeClient(), models.ChatCompletion{
+		ConversationID: "abc", Role: "assistant", Content: "A slip box is a set of note cards",
+	})
+	if err != nil {
+		t.Fatalf("failed to create summary: %v", err)
+	}
+	if summary.ID != "abc" || summary.Title != "🗂️ Slip boxes" || summary.Model != "fake-model" {
+		t.Errorf("wrong summary, got %+v", summary)
+	}
+}
+
+func TestCardSearchChatCompletionUsesProvider(t *testing.T) {
+	fake := NewFakeProvider()
+	defer SetProvider(fake)()
+
+	cards := []models.CardChunk{{ID: 7, Title: "Atomic notes", Chunk: "One idea per card"}}
+	completion, err := CardSearchChatCompletion(context.Background(), fakeClient(), []models.ChatCompletion{
+		{Role: "user", Content: "how big is a card?"},
+	}, cards)
+	if err != nil {
+		t.Fatalf("failed to get completion: %v", err)
+	}
+	if completion.Content != DefaultFakeReply || len(completion.ReferencedCardPKs) != 1 || completion.ReferencedCardPKs[0] != 7 {
+		t.Errorf("wrong completion, got %+v", completion)
+	}
+	requests := fake.Requests()
+	if len(requests) != 1 || !strings.Contains(requests[0].Messages[0].Content, "One idea per card") {
+		t.Errorf("expected the cards to be sent as context, got %+v", requests)
+	}
 }
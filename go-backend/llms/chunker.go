@@ -0,0 +1,330 @@
+package llms
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	DefaultChunkTokens  = 128
+	DefaultChunkOverlap = 16
+)
+
+// Chunk is a piece of a larger text. Start and End are byte offsets into the
+// original text and always fall on rune boundaries, so text[Start:End] == Text.
+type Chunk struct {
+	Text   string
+	Start  int
+	End    int
+	Tokens int
+}
+
+// ChunkOptions controls the size of chunks, in approximate tokens. Overlap is
+// the number of tokens repeated from the end of the previous chunk.
+type ChunkOptions struct {
+	MaxTokens int
+	Overlap   int
+}
+
+var DefaultChunkOptions = ChunkOptions{
+	MaxTokens: DefaultChunkTokens,
+	Overlap:   DefaultChunkOverlap,
+}
+
+var (
+	headingRegex  = regexp.MustCompile(`^#{1,6}(\s|$)`)
+	listItemRegex = regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s`)
+)
+
+type textSpan struct {
+	start   int
+	end     int
+	heading bool
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// EstimateTokens approximates the number of tokens in text. Latin words count
+// as one token per four runes, while CJK characters and punctuation count as
+// one token each.
+func EstimateTokens(text string) int {
+	tokens := 0
+	wordRunes := 0
+	flush := func() {
+		tokens += (wordRunes + 3) / 4
+		wordRunes = 0
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case isCJK(r), unicode.IsPunct(r), unicode.IsSymbol(r):
+			flush()
+			tokens++
+		default:
+			wordRunes++
+		}
+	}
+	flush()
+	return tokens
+}
+
+// ChunkText splits text into chunks of at most opts.MaxTokens tokens. Chunks
+// break between paragraphs, list items and code blocks where possible, and
+// every markdown heading starts a new chunk. Blocks that are too large on
+// their own are split on sentences, then on words.
+func ChunkText(text string, opts ChunkOptions) []Chunk {
+	if opts.MaxTokens <= 0 {
+		opts.MaxTokens = DefaultChunkTokens
+	}
+	if opts.Overlap < 0 {
+		opts.Overlap = 0
+	}
+
+	var units []textSpan
+	for _, block := range markdownBlocks(text) {
+		if EstimateTokens(text[block.start:block.end]) > opts.MaxTokens {
+			units = append(units, splitBlock(text, block, opts.MaxTokens)...)
+		} else {
+			units = append(units, block)
+		}
+	}
+
+	var chunks []Chunk
+	start, end, tokens := -1, 0, 0
+	for _, unit := range units {
+		unitTokens := EstimateTokens(text[unit.start:unit.end])
+		if start >= 0 && (unit.heading || tokens+unitTokens > opts.MaxTokens) {
+			chunks = append(chunks, newChunk(text, start, end))
+			start = -1
+		}
+		if start < 0 {
+			start = unit.start
+			tokens = 0
+			budget := min(opts.Overlap, opts.MaxTokens-unitTokens)
+			if len(chunks) > 0 && !unit.heading && budget > 0 {
+				prev := chunks[len(chunks)-1]
+				if overlap := overlapStart(text, prev.Start, prev.End, budget); overlap < prev.End {
+					start = overlap
+					tokens = EstimateTokens(text[overlap:prev.End])
+				}
+			}
+		}
+		end = unit.end
+		tokens += unitTokens
+	}
+	if start >= 0 {
+		chunks = append(chunks, newChunk(text, start, end))
+	}
+	return chunks
+}
+
+func newChunk(text string, start, end int) Chunk {
+	return Chunk{
+		Text:   text[start:end],
+		Start:  start,
+		End:    end,
+		Tokens: EstimateTokens(text[start:end]),
+	}
+}
+
+// markdownBlocks splits text into paragraphs, headings, list items and fenced
+// code blocks. Blank lines are not part of any block.
+func markdownBlocks(text string) []textSpan {
+	var blocks []textSpan
+	current := textSpan{start: -1}
+	fence := ""
+	closeBlock := func() {
+		if current.start >= 0 {
+			blocks = append(blocks, current)
+		}
+		current = textSpan{start: -1}
+	}
+
+	for lineStart := 0; lineStart < len(text); {
+		lineEnd := strings.IndexByte(text[lineStart:], '\n')
+		next := 0
+		if lineEnd < 0 {
+			lineEnd = len(text)
+			next = len(text)
+		} else {
+			lineEnd += lineStart
+			next = lineEnd + 1
+		}
+		line := strings.TrimRight(text[lineStart:lineEnd], "\r")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case fence != "":
+			current.end = lineStart + len(line)
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+				closeBlock()
+			}
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			closeBlock()
+			fence = trimmed[:3]
+			current = textSpan{start: lineStart, end: lineStart + len(line)}
+		case trimmed == "":
+			closeBlock()
+		case headingRegex.MatchString(trimmed):
+			closeBlock()
+			blocks = append(blocks, textSpan{start: lineStart, end: lineStart + len(line), heading: true})
+		default:
+			if listItemRegex.MatchString(line) {
+				closeBlock()
+			}
+			if current.start < 0 {
+				current.start = lineStart
+			}
+			current.end = lineStart + len(line)
+		}
+		lineStart = next
+	}
+	closeBlock()
+	return blocks
+}
+
+// splitBlock breaks an oversized block into sentences, falling back to words
+// for sentences that are still too large.
+func splitBlock(text string, block textSpan, maxTokens int) []textSpan {
+	var spans []textSpan
+	for _, sentence := range sentenceSpans(text, block) {
+		if EstimateTokens(text[sentence.start:sentence.end]) > maxTokens {
+			spans = append(spans, packWords(text, sentence, maxTokens)...)
+		} else {
+			spans = append(spans, sentence)
+		}
+	}
+	if len(spans) > 0 {
+		spans[0].heading = block.heading
+	}
+	return spans
+}
+
+func isSentenceEnd(r rune) bool {
+	switch r {
+	case '.', '!', '?', '。', '！', '？':
+		return true
+	}
+	return false
+}
+
+func sentenceSpans(text string, block textSpan) []textSpan {
+	var spans []textSpan
+	start := -1
+	for i := block.start; i < block.end; {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if start < 0 {
+			if !unicode.IsSpace(r) {
+				start = i
+			}
+			i += size
+			continue
+		}
+		i += size
+		if r == '\n' {
+			spans = append(spans, textSpan{start: start, end: i - size})
+			start = -1
+			continue
+		}
+		if isSentenceEnd(r) {
+			following, _ := utf8.DecodeRuneInString(text[i:])
+			if i >= block.end || unicode.IsSpace(following) || r > unicode.MaxASCII {
+				spans = append(spans, textSpan{start: start, end: i})
+				start = -1
+			}
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, textSpan{start: start, end: block.end})
+	}
+	return spans
+}
+
+// packWords groups the words of a span into pieces of at most maxTokens.
+// Each CJK character counts as a word, and a single word that is still too
+// large is cut on rune boundaries.
+func packWords(text string, span textSpan, maxTokens int) []textSpan {
+	var spans []textSpan
+	start, end, tokens := -1, 0, 0
+	add := func(wordStart, wordEnd int) {
+		wordTokens := EstimateTokens(text[wordStart:wordEnd])
+		if start >= 0 && tokens+wordTokens > maxTokens {
+			spans = append(spans, textSpan{start: start, end: end})
+			start = -1
+		}
+		if start < 0 {
+			start = wordStart
+			tokens = 0
+		}
+		end = wordEnd
+		tokens += wordTokens
+	}
+
+	for i := span.start; i < span.end; {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+		if isCJK(r) {
+			add(i, i+size)
+			i += size
+			continue
+		}
+		wordStart := i
+		for i < span.end {
+			r, size = utf8.DecodeRuneInString(text[i:])
+			if unicode.IsSpace(r) || isCJK(r) || EstimateTokens(text[wordStart:i+size]) > maxTokens {
+				break
+			}
+			i += size
+		}
+		if i == wordStart {
+			i += size
+		}
+		add(wordStart, i)
+	}
+	if start >= 0 {
+		spans = append(spans, textSpan{start: start, end: end})
+	}
+	return spans
+}
+
+// overlapStart walks back from end over whole words (or single CJK
+// characters) and returns the earliest offset after start whose text still
+// fits in the token budget.
+func overlapStart(text string, start, end, budget int) int {
+	pos := end
+	for pos > start {
+		i := pos
+		for i > start {
+			r, size := utf8.DecodeLastRuneInString(text[start:i])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			i -= size
+		}
+		if r, size := utf8.DecodeLastRuneInString(text[start:i]); isCJK(r) {
+			i -= size
+		} else {
+			for i > start {
+				r, size := utf8.DecodeLastRuneInString(text[start:i])
+				if unicode.IsSpace(r) || isCJK(r) {
+					break
+				}
+				i -= size
+			}
+		}
+		if i == pos || EstimateTokens(text[i:end]) > budget {
+			break
+		}
+		pos = i
+	}
+	return pos
+}
@@ -0,0 +1,114 @@
+package llms
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func checkChunks(t *testing.T, text string, chunks []Chunk, maxTokens int) {
+	t.Helper()
+	for i, chunk := range chunks {
+		if !utf8.ValidString(chunk.Text) {
+			t.Errorf("chunk %d is not valid utf-8: %q", i, chunk.Text)
+		}
+		if text[chunk.Start:chunk.End] != chunk.Text {
+			t.Errorf("chunk %d offsets do not match its text", i)
+		}
+		if chunk.Tokens > maxTokens {
+			t.Errorf("chunk %d has %d tokens, want at most %d", i, chunk.Tokens, maxTokens)
+		}
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	cases := map[string]int{
+		"":                0,
+		"hello world":     4,
+		"a, b":            3,
+		"日本語のテキスト":        8,
+		"  spaced   out ": 3,
+	}
+	for input, want := range cases {
+		if got := EstimateTokens(input); got != want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", input, got, want)
+		}
+	}
+}
+
+func TestChunkTextLongText(t *testing.T) {
+	inputBytes, err := os.ReadFile("../tests/long_text.txt")
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	input := string(inputBytes)
+	opts := ChunkOptions{MaxTokens: 64, Overlap: 8}
+	chunks := ChunkText(input, opts)
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+	checkChunks(t, input, chunks, opts.MaxTokens)
+	overlapping := 0
+	for i := 1; i < len(chunks); i++ {
+		if chunks[i].Start < chunks[i-1].End {
+			overlapping++
+		}
+		if strings.TrimSpace(input[chunks[i-1].End:max(chunks[i].Start, chunks[i-1].End)]) != "" {
+			t.Errorf("text between chunk %d and %d was skipped", i-1, i)
+		}
+	}
+	if overlapping == 0 {
+		t.Errorf("expected consecutive chunks to overlap")
+	}
+}
+
+func TestChunkTextHeadingsAndLists(t *testing.T) {
+	input := "# First\nSome intro text.\n\n- item one\n- item two\n\n## Second\nMore text here."
+	chunks := ChunkText(input, ChunkOptions{MaxTokens: 100, Overlap: 5})
+	if len(chunks) != 2 {
+		t.Fatalf("wrong number of chunks, got %d want %d: %v", len(chunks), 2, chunks)
+	}
+	if !strings.HasPrefix(chunks[1].Text, "## Second") {
+		t.Errorf("heading should start a new chunk without overlap, got %q", chunks[1].Text)
+	}
+	if !strings.Contains(chunks[0].Text, "- item two") {
+		t.Errorf("list items should stay in the first section, got %q", chunks[0].Text)
+	}
+}
+
+func TestChunkTextCodeBlock(t *testing.T) {
+	input := "Intro.\n\n```go\nfunc main() {\n\n\tprintln(\"hi\")\n}\n```\n\nOutro."
+	blocks := markdownBlocks(input)
+	if len(blocks) != 3 {
+		t.Fatalf("wrong number of blocks, got %d want %d", len(blocks), 3)
+	}
+	code := input[blocks[1].start:blocks[1].end]
+	if !strings.HasPrefix(code, "```go") || !strings.HasSuffix(code, "```") {
+		t.Errorf("code block should be kept whole, got %q", code)
+	}
+}
+
+func TestChunkTextRuneSafe(t *testing.T) {
+	input := strings.Repeat("Ça été très agréable. ", 40) + "\n\n" + strings.Repeat("これは日本語の文章です。", 40)
+	opts := ChunkOptions{MaxTokens: 20, Overlap: 4}
+	chunks := ChunkText(input, opts)
+	if len(chunks) < 10 {
+		t.Fatalf("expected many chunks, got %d", len(chunks))
+	}
+	checkChunks(t, input, chunks, opts.MaxTokens)
+}
+
+func TestChunkTextOversizedWord(t *testing.T) {
+	input := strings.Repeat("x", 1000)
+	opts := ChunkOptions{MaxTokens: 10}
+	chunks := ChunkText(input, opts)
+	checkChunks(t, input, chunks, opts.MaxTokens)
+	total := 0
+	for _, chunk := range chunks {
+		total += len(chunk.Text)
+	}
+	if total != len(input) {
+		t.Errorf("chunks should cover the whole word, got %d of %d bytes", total, len(input))
+	}
+}
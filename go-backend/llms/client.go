@@ -3,6 +3,7 @@ package llms
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"go-backend/models"
 	"log"
 	"net/http"
@@ -20,6 +21,87 @@ func NewClientFromModel(db *sql.DB, model models.LLMModel, userID int) *models.L
 	return client
 }
 
+// NewClientForTask builds a client with the model the user has chosen for a
+// task. It falls back to the user's default configuration, then to the
+// default model, then to the environment.
+func NewClientForTask(db *sql.DB, userID int, task string) *models.LLMClient {
+	model, err := ResolveModel(db, userID, task)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("failed to resolve %s model for user %d: %v", task, userID, err)
+		}
+		return NewDefaultClient(db, userID)
+	}
+	return NewClientFromModel(db, model, userID)
+}
+
+// NewClientFromConfiguration builds a client from one of the user's
+// configurations
+func NewClientFromConfiguration(db *sql.DB, userID, configurationID int) (*models.LLMClient, error) {
+	var apiKey string
+	model, err := models.ScanLLMModel(db.QueryRow(`
+		SELECT `+models.LLMModelColumns+`, COALESCE(c.api_key, '')
+		FROM user_llm_configurations c
+		JOIN llm_models m ON m.id = c.model_id
+		JOIN llm_providers p ON p.id = m.provider_id
+		WHERE c.id = $1 AND c.user_id = $2 AND m.is_active
+	`, configurationID, userID), &apiKey)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("configuration not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return NewClientFromModel(db, withAPIKey(model, apiKey), userID), nil
+}
+
+// ResolveModel finds the model to use for a user and task, with the user's
+// own API key applied. It returns sql.ErrNoRows when nothing is configured.
+func ResolveModel(db *sql.DB, userID int, task string) (models.LLMModel, error) {
+	var apiKey string
+	model, err := models.ScanLLMModel(db.QueryRow(`
+		SELECT `+models.LLMModelColumns+`, COALESCE(c.api_key, '')
+		FROM user_llm_configurations c
+		JOIN llm_models m ON m.id = c.model_id
+		JOIN llm_providers p ON p.id = m.provider_id
+		LEFT JOIN user_llm_task_configurations t
+			ON t.configuration_id = c.id AND t.user_id = c.user_id AND t.task = $2
+		WHERE c.user_id = $1 AND m.is_active AND (t.task IS NOT NULL OR c.is_default)
+		ORDER BY (t.task IS NOT NULL) DESC
+		LIMIT 1
+	`, userID, task), &apiKey)
+	if err == nil {
+		return withAPIKey(model, apiKey), nil
+	} else if err != sql.ErrNoRows {
+		return models.LLMModel{}, err
+	}
+
+	model, err = models.ScanLLMModel(db.QueryRow(`
+		SELECT ` + models.LLMModelColumns + `
+		FROM llm_models m
+		JOIN llm_providers p ON p.id = m.provider_id
+		WHERE m.is_active AND m.is_default
+		ORDER BY m.id
+		LIMIT 1
+	`))
+	if err != nil {
+		return models.LLMModel{}, err
+	}
+	return withAPIKey(model, ""), nil
+}
+
+// withAPIKey picks the key for a model: the user's own key, then the
+// provider's, then the server key if the provider is the default endpoint
+func withAPIKey(model models.LLMModel, userKey string) models.LLMModel {
+	provider := *model.Provider
+	if userKey != "" {
+		provider.APIKey = userKey
+	} else if provider.APIKey == "" && provider.BaseURL == os.Getenv("ZETTEL_LLM_ENDPOINT") {
+		provider.APIKey = os.Getenv("ZETTEL_LLM_KEY")
+	}
+	model.Provider = &provider
+	return model
+}
+
 func NewDefaultClient(db *sql.DB, userID int) *models.LLMClient {
 	config := openai.DefaultConfig(os.Getenv("ZETTEL_LLM_KEY"))
 	config.BaseURL = os.Getenv("ZETTEL_LLM_ENDPOINT")
@@ -41,10 +123,9 @@ func NewClient(db *sql.DB, config openai.ClientConfig, userID int) *models.LLMCl
 	}
 
 	return &models.LLMClient{
-		Client:  openai.NewClientWithConfig(config),
-		Testing: false,
-		UserID:  userID,
-		DB:      db,
+		Client: openai.NewClientWithConfig(config),
+		UserID: userID,
+		DB:     db,
 	}
 }
 
@@ -59,48 +140,63 @@ func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return t.RoundTripper.RoundTrip(req)
 }
 
-func ExecuteLLMRequest(c *models.LLMClient, messages []openai.ChatCompletionMessage) (openai.ChatCompletionResponse, error) {
-	resp, err := c.Client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:    c.Model.ModelIdentifier,
-			Messages: messages,
-		},
-	)
+// providerName identifies the provider behind a client, for circuit breaking
+// and errors
+func providerName(c *models.LLMClient) string {
+	if c.Model != nil && c.Model.Provider != nil {
+		if c.Model.Provider.Name != "" {
+			return c.Model.Provider.Name
+		}
+		if c.Model.Provider.BaseURL != "" {
+			return c.Model.Provider.BaseURL
+		}
+	}
+	return "default"
+}
+
+// ExecuteLLMRequest sends a chat completion, retrying transient failures.
+// Failures are returned as *ProviderError, or as a budget error if the user
+// may not make the request.
+func ExecuteLLMRequest(ctx context.Context, c *models.LLMClient, messages []openai.ChatCompletionMessage) (openai.ChatCompletionResponse, error) {
+	if c.DB != nil && c.UserID != 0 {
+		if err := CheckBudget(c.DB, c.UserID); err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+	}
 
-	if err == nil {
-		logLLMRequest(c, resp)
+	provider := providerName(c)
+	request := openai.ChatCompletionRequest{
+		Model:    c.Model.ModelIdentifier,
+		Messages: messages,
+	}
+	var resp openai.ChatCompletionResponse
+	err := withRetry(ctx, provider, completionRetryPolicy, func(ctx context.Context) error {
+		var err error
+		resp, err = completionProvider(c.Client).CreateChatCompletion(ctx, request)
+		return err
+	})
+	if err != nil {
+		return resp, err
 	}
 
-	return resp, err
+	if c.DB != nil {
+		logLLMRequest(c, resp)
+	}
+	return resp, refusalError(provider, resp)
 }
 
 func logLLMRequest(c *models.LLMClient, resp openai.ChatCompletionResponse) {
-	// fire and forget
-	go func() {
-		// simple model pricing table (per 1k tokens in USD)
-		var modelPricing = map[string]struct {
-			PromptPer1K     float64
-			CompletionPer1K float64
-		}{
-			"google/gemini-2.5-flash": {PromptPer1K: 0.0003, CompletionPer1K: 0.0025},
-			"google/gemini-2.5-pro":   {PromptPer1K: 0.00125, CompletionPer1K: 0.010},
-			"openai/gpt-5-chat":       {PromptPer1K: 0.00125, CompletionPer1K: 0.010},
-		}
-
-		var cost *float64
-		if pricing, ok := modelPricing[c.Model.ModelIdentifier]; ok {
-			est := float64(resp.Usage.PromptTokens)/1000.0*pricing.PromptPer1K +
-				float64(resp.Usage.CompletionTokens)/1000.0*pricing.CompletionPer1K
-			cost = &est
-		}
+	var cost *float64
+	if promptCost, completionCost, ok := estimateCost(c.DB, c.Model.ModelIdentifier, resp.Usage.PromptTokens, resp.Usage.CompletionTokens); ok {
+		est := promptCost + completionCost
+		cost = &est
+	}
 
-		_, err := c.DB.Exec(`
-			INSERT INTO llm_query_log (user_id, model, prompt_tokens, completion_tokens, cost_usd)
-			VALUES ($1, $2, $3, $4, $5)
-		`, c.UserID, c.Model.ModelIdentifier, resp.Usage.PromptTokens, resp.Usage.CompletionTokens, cost)
-		if err != nil {
-			log.Printf("Error logging llm request: %v", err)
-		}
-	}()
+	_, err := c.DB.Exec(`
+		INSERT INTO llm_query_log (user_id, model, prompt_tokens, completion_tokens, cost_usd)
+		VALUES ($1, $2, $3, $4, $5)
+	`, c.UserID, c.Model.ModelIdentifier, resp.Usage.PromptTokens, resp.Usage.CompletionTokens, cost)
+	if err != nil {
+		log.Printf("Error logging llm request: %v", err)
+	}
 }
@@ -1,51 +1,72 @@
 package llms
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
 	"database/sql"
-	"encoding/json"
-	"errors"
+	"encoding/hex"
 	"fmt"
 	"go-backend/models"
 	"log"
-	"net/http"
-	"os"
 
 	"github.com/pgvector/pgvector-go"
 	openai "github.com/sashabaranov/go-openai"
 )
 
-const CHUNK_SIZE = 500
+// embeddingVersion is mixed into the content hash so that changes to the
+// chunker invalidate existing embeddings
+const embeddingVersion = "2"
 
-func chunkInput(input string) []string {
-	var chunks []string
-	for i := 0; i < len(input); i += CHUNK_SIZE {
-		end := i + CHUNK_SIZE
-
-		// Ensure the end index does not exceed the string length
-		if end > len(input) {
-			end = len(input)
-		}
+// CardContentHash identifies the embedded content of a card, so unchanged
+// cards can skip re-embedding
+func CardContentHash(card models.Card) string {
+	sum := sha256.Sum256([]byte(embeddingVersion + "\x00" + card.Title + "\x00" + card.Body))
+	return hex.EncodeToString(sum[:])
+}
 
-		chunks = append(chunks, input[i:end])
+// ChunkCard splits a card's body into the chunks that get embedded. A card
+// without a body still gets a single empty chunk so its title is embedded.
+func ChunkCard(card models.Card) []models.CardChunk {
+	var chunks []models.CardChunk
+	for _, text := range ChunkText(card.Body, DefaultChunkOptions) {
+		chunk := models.ConvertCardToChunk(card)
+		chunk.Chunk = text.Text
+		chunk.ChunkStart = text.Start
+		chunk.ChunkEnd = text.End
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) == 0 {
+		chunk := models.ConvertCardToChunk(card)
+		chunk.Chunk = ""
+		chunks = append(chunks, chunk)
 	}
 	return chunks
 }
 
-func ProcessEmbeddings(db *sql.DB, userID, cardPK int, chunks []models.CardChunk) {
+// chunkEmbeddingInput prefixes a chunk with its card's title, so that every
+// chunk carries the topic of the card
+func chunkEmbeddingInput(chunk models.CardChunk) models.CardChunk {
+	if chunk.Title != "" {
+		chunk.Chunk = chunk.Title + "\n" + chunk.Chunk
+	}
+	return chunk
+}
+
+func ProcessEmbeddings(ctx context.Context, db *sql.DB, userID, cardPK int, chunks []models.CardChunk) error {
 	var allEmbeddings [][]pgvector.Vector
 	var allEmbeddings1024 [][]pgvector.Vector
 
-	for _, chunk := range chunks {
-		embeddings, err := GenerateChunkEmbeddings(chunk, false)
+	for i, chunk := range chunks {
+		chunk = chunkEmbeddingInput(chunk)
+		embeddings, err := GenerateChunkEmbeddings(ctx, chunk, false)
 		if err != nil {
-			log.Printf("failed to generate embeddings for card %v chunk %v: %v", cardPK, chunk.ID, err)
-			return
+			log.Printf("failed to generate embeddings for card %v chunk %v: %v", cardPK, i, err)
+			return err
 		}
-		embeddings1024, err := GenerateChunkEmbeddings1024(chunk, false)
+		embeddings1024, err := GenerateChunkEmbeddings1024(ctx, chunk, false)
 		if err != nil {
-			log.Printf("failed to generate embeddings1024 for card %v chunk %v: %v", cardPK, chunk.ID, err)
-			return
+			log.Printf("failed to generate embeddings1024 for card %v chunk %v: %v", cardPK, i, err)
+			return err
 		}
 		allEmbeddings = append(allEmbeddings, embeddings)
 		allEmbeddings1024 = append(allEmbeddings1024, embeddings1024)
@@ -55,133 +76,65 @@ func ProcessEmbeddings(db *sql.DB, userID, cardPK int, chunks []models.CardChunk
 		db,
 		userID,
 		cardPK,
+		chunks,
 		allEmbeddings,
 		allEmbeddings1024,
 	); err != nil {
 		log.Printf("failed to store embeddings for %v: %v", cardPK, err)
-		return
+		return err
 	}
+	return nil
 }
 
-// GetEmbedding generates an embedding vector for a given text string
-func GetEmbedding1024(text string, useForQuery bool) (pgvector.Vector, error) {
-	url := os.Getenv("ZETTEL_EMBEDDING_1024_API")
-	if url == "" {
-		return pgvector.Vector{}, errors.New("no embedding url given - set ZETTEL_EMBEDDING_1024_API")
-	}
-
-	prompt := text
-	if useForQuery {
-		prompt = "Represent this sentence for searching relevant passages:" + prompt
-	}
-
-	payload := map[string]string{
-		"inputs": prompt,
-	}
-
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return pgvector.Vector{}, fmt.Errorf("error creating JSON payload: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return pgvector.Vector{}, fmt.Errorf("error creating request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return pgvector.Vector{}, fmt.Errorf("error communicating with the embedding API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return pgvector.Vector{}, fmt.Errorf("error generating embeddings: %d - %s", resp.StatusCode, resp.Status)
-	}
-
-	var embeddings [][]float32
-	if err := json.NewDecoder(resp.Body).Decode(&embeddings); err != nil {
-		return pgvector.Vector{}, fmt.Errorf("error decoding embedding API response: %w", err)
-	}
-
-	if len(embeddings) == 0 {
-		return pgvector.Vector{}, errors.New("no embeddings returned")
-	}
-
-	// use the first embedding
-	return pgvector.NewVector(embeddings[0]), nil
+// createEmbedding asks the embedding provider for a vector, retrying
+// transient failures
+func createEmbedding(ctx context.Context, request EmbeddingRequest) (pgvector.Vector, error) {
+	var vector pgvector.Vector
+	err := withRetry(ctx, "embedding:"+request.Model, embeddingRetryPolicy, func(ctx context.Context) error {
+		var err error
+		vector, err = embeddingProvider().CreateEmbedding(ctx, request)
+		return err
+	})
+	return vector, err
 }
 
-// GetEmbedding generates an embedding vector for a given text string
-func GetEmbedding(text string, useForQuery bool) (pgvector.Vector, error) {
-	url := os.Getenv("ZETTEL_EMBEDDING_API")
-	if url == "" {
-		return pgvector.Vector{}, errors.New("no embedding url given - set ZETTEL_EMBEDDING_API")
-	}
-
-	prompt := text
-	if useForQuery {
-		prompt = "Represent this sentence for searching relevant passages:" + prompt
-	}
-
-	payload := map[string]string{
-		"model":  "nomic-embed-text",
-		"prompt": prompt,
-	}
-
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return pgvector.Vector{}, fmt.Errorf("error creating JSON payload: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return pgvector.Vector{}, fmt.Errorf("error creating request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return pgvector.Vector{}, fmt.Errorf("error communicating with the embedding API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return pgvector.Vector{}, fmt.Errorf("error generating embeddings: %d - %s", resp.StatusCode, resp.Status)
-	}
-
-	var response struct {
-		Embedding []float32 `json:"embedding"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return pgvector.Vector{}, errors.New("error decoding the embedding API response")
-	}
+// GetEmbedding1024 generates a 1024 dimension embedding for a text
+func GetEmbedding1024(ctx context.Context, text string, useForQuery bool) (pgvector.Vector, error) {
+	return createEmbedding(ctx, EmbeddingRequest{
+		Model:    EmbeddingModel1024,
+		Input:    text,
+		ForQuery: useForQuery,
+	})
+}
 
-	return pgvector.NewVector(response.Embedding), nil
+// GetEmbedding generates a nomic embedding for a text
+func GetEmbedding(ctx context.Context, text string, useForQuery bool) (pgvector.Vector, error) {
+	return createEmbedding(ctx, EmbeddingRequest{
+		Model:    EmbeddingModelNomic,
+		Input:    text,
+		ForQuery: useForQuery,
+	})
 }
 
-func GenerateChunkEmbeddings1024(chunk models.CardChunk, useForQuery bool) ([]pgvector.Vector, error) {
-	embedding, err := GetEmbedding1024(chunk.Chunk, useForQuery)
+func GenerateChunkEmbeddings1024(ctx context.Context, chunk models.CardChunk, useForQuery bool) ([]pgvector.Vector, error) {
+	embedding, err := GetEmbedding1024(ctx, chunk.Chunk, useForQuery)
 	if err != nil {
 		return nil, err
 	}
 	return []pgvector.Vector{embedding}, nil
 }
-func GenerateChunkEmbeddings(chunk models.CardChunk, useForQuery bool) ([]pgvector.Vector, error) {
-	embedding, err := GetEmbedding(chunk.Chunk, useForQuery)
+func GenerateChunkEmbeddings(ctx context.Context, chunk models.CardChunk, useForQuery bool) ([]pgvector.Vector, error) {
+	embedding, err := GetEmbedding(ctx, chunk.Chunk, useForQuery)
 	if err != nil {
 		return nil, err
 	}
 	return []pgvector.Vector{embedding}, nil
 }
 
-func GenerateEmbeddingsFromCard(db *sql.DB, chunks []models.CardChunk) ([][]pgvector.Vector, error) {
+func GenerateEmbeddingsFromCard(ctx context.Context, db *sql.DB, chunks []models.CardChunk) ([][]pgvector.Vector, error) {
 	results := [][]pgvector.Vector{}
 	for _, chunk := range chunks {
-		vec, err := GenerateChunkEmbeddings(chunk, false)
+		vec, err := GenerateChunkEmbeddings(ctx, chunk, false)
 		if err != nil {
 			log.Printf("error generating embeddings %v", err)
 			return [][]pgvector.Vector{}, err
@@ -191,10 +144,13 @@ func GenerateEmbeddingsFromCard(db *sql.DB, chunks []models.CardChunk) ([][]pgve
 	return results, nil
 }
 
-func StoreBothEmbeddings(db *sql.DB, userID, cardPK int, embeddings [][]pgvector.Vector, embeddings1024 [][]pgvector.Vector) error {
+func StoreBothEmbeddings(db *sql.DB, userID, cardPK int, chunks []models.CardChunk, embeddings [][]pgvector.Vector, embeddings1024 [][]pgvector.Vector) error {
 	if len(embeddings) != len(embeddings1024) {
 		return fmt.Errorf("embedding lengths do not match: %d vs %d", len(embeddings), len(embeddings1024))
 	}
+	if len(chunks) != len(embeddings) {
+		return fmt.Errorf("chunk and embedding lengths do not match: %d vs %d", len(chunks), len(embeddings))
+	}
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -213,8 +169,10 @@ func StoreBothEmbeddings(db *sql.DB, userID, cardPK int, embeddings [][]pgvector
 			return fmt.Errorf("embedding chunk %d length mismatch: %d vs %d", i, len(embeddings[i]), len(embeddings1024[i]))
 		}
 		for j := range embeddings[i] {
-			query = `INSERT INTO card_embeddings (card_pk, user_id, chunk, embedding_nomic, embedding_1024) VALUES ($1, $2, $3, $4, $5)`
-			if _, err := tx.Exec(query, cardPK, userID, i, embeddings[i][j], embeddings1024[i][j]); err != nil {
+			query = `
+			INSERT INTO card_embeddings (card_pk, user_id, chunk, chunk_start, chunk_end, embedding_nomic, embedding_1024)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`
+			if _, err := tx.Exec(query, cardPK, userID, i, chunks[i].ChunkStart, chunks[i].ChunkEnd, embeddings[i][j], embeddings1024[i][j]); err != nil {
 				tx.Rollback()
 				return fmt.Errorf("error inserting embeddings for card %d: %w", cardPK, err)
 			}
@@ -226,7 +184,7 @@ func StoreBothEmbeddings(db *sql.DB, userID, cardPK int, embeddings [][]pgvector
 	}
 	return nil
 }
-func GenerateSemanticSearchQuery(c *models.LLMClient, userQuery string) ([]pgvector.Vector, error) {
+func GenerateSemanticSearchQuery(ctx context.Context, c *models.LLMClient, userQuery string) ([]pgvector.Vector, error) {
 	// First, let's create a system prompt to help generate a better search query
 	messages := []openai.ChatCompletionMessage{
 		{
@@ -241,7 +199,7 @@ func GenerateSemanticSearchQuery(c *models.LLMClient, userQuery string) ([]pgvec
 
 	// Generate the optimized search query
 
-	resp, err := ExecuteLLMRequest(c, messages)
+	resp, err := ExecuteLLMRequest(ctx, c, messages)
 	if err != nil {
 		return []pgvector.Vector{}, fmt.Errorf("failed to generate optimized search query: %w", err)
 	}
@@ -258,7 +216,7 @@ func GenerateSemanticSearchQuery(c *models.LLMClient, userQuery string) ([]pgvec
 		Chunk: optimizedQuery,
 	}
 
-	embeddings, err := GenerateChunkEmbeddings(chunk, true)
+	embeddings, err := GenerateChunkEmbeddings(ctx, chunk, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate embeddings: %w", err)
 	}
@@ -1,24 +1,42 @@
 package llms
 
 import (
-	"os"
+	"go-backend/models"
 	"testing"
 )
 
-func TestChunkInput(t *testing.T) {
-	input := "hello world"
-	results := chunkInput(input)
-	if len(results) != 1 {
-		t.Errorf("wrong number of chunks returned, got %v want %v", len(results), 1)
+func TestCardContentHash(t *testing.T) {
+	card := models.Card{Title: "Title", Body: "Body"}
+	hash := CardContentHash(card)
+	if hash != CardContentHash(models.Card{ID: 2, Title: "Title", Body: "Body"}) {
+		t.Errorf("hash should only depend on title and body")
 	}
-	inputBytes, err := os.ReadFile("../tests/long_text.txt")
-	if err != nil {
-		t.Errorf("error reading file: %v", err)
+	if hash == CardContentHash(models.Card{Title: "Title", Body: "Body changed"}) {
+		t.Errorf("hash should change when the body changes")
 	}
-	input = string(inputBytes)
-	results = chunkInput(input)
-	if len(results) != 10 {
-		t.Errorf("wrong number of chunks returned, got %v want %v", len(results), 10)
+	if hash == CardContentHash(models.Card{Title: "TitleB", Body: "ody"}) {
+		t.Errorf("hash should separate title and body")
 	}
+}
 
+func TestChunkCard(t *testing.T) {
+	card := models.Card{ID: 1, CardID: "1", UserID: 1, Title: "Title", Body: "hello world"}
+	chunks := ChunkCard(card)
+	if len(chunks) != 1 {
+		t.Fatalf("wrong number of chunks returned, got %v want %v", len(chunks), 1)
+	}
+	if chunks[0].Chunk != "hello world" || chunks[0].ChunkStart != 0 || chunks[0].ChunkEnd != len(card.Body) {
+		t.Errorf("wrong chunk, got %q at %d-%d", chunks[0].Chunk, chunks[0].ChunkStart, chunks[0].ChunkEnd)
+	}
+	if input := chunkEmbeddingInput(chunks[0]); input.Chunk != "Title\nhello world" {
+		t.Errorf("embedding input should include the title, got %q", input.Chunk)
+	}
+
+	empty := ChunkCard(models.Card{Title: "Only a title"})
+	if len(empty) != 1 || empty[0].Chunk != "" {
+		t.Errorf("card without a body should have a single empty chunk, got %v", empty)
+	}
+	if chunks[0].ID != card.ID || chunks[0].UserID != card.UserID {
+		t.Errorf("chunk should carry the card identifiers, got %+v", chunks[0])
+	}
 }
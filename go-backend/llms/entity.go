@@ -1,6 +1,7 @@
 package llms
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"go-backend/models"
@@ -12,7 +13,7 @@ import (
 	openai "github.com/sashabaranov/go-openai"
 )
 
-func FindEntities(c *models.LLMClient, title, body string) ([]models.Entity, error) {
+func FindEntities(ctx context.Context, c *models.LLMClient, title, body string) ([]models.Entity, error) {
 	systemPrompt := `You are an AI specialized in analyzing zettelkasten cards and extracting entities.
 Follow these rules strictly:
 
@@ -65,7 +66,7 @@ Return only valid JSON matching the specified structure.`
 	var jsonErr error
 	for range 3 {
 
-		resp, err := ExecuteLLMRequest(c, messages)
+		resp, err := ExecuteLLMRequest(ctx, c, messages)
 		if err != nil {
 			log.Printf("error getting completion: %v", err)
 			return []models.Entity{}, err
@@ -89,7 +90,7 @@ Return only valid JSON matching the specified structure.`
 	var results []models.Entity
 	for _, entity := range entities {
 		text := fmt.Sprintf("%v - %v - %v", entity.Name, entity.Type, entity.Description)
-		embedding, err := GetEmbedding1024(text, false)
+		embedding, err := GetEmbedding1024(ctx, text, false)
 		if err != nil {
 			continue
 		}
@@ -101,7 +102,7 @@ Return only valid JSON matching the specified structure.`
 
 }
 
-func CheckExistingEntities(c *models.LLMClient, similar []models.Entity, entity models.Entity) (models.Entity, error) {
+func CheckExistingEntities(ctx context.Context, c *models.LLMClient, similar []models.Entity, entity models.Entity) (models.Entity, error) {
 	if len(similar) == 0 {
 		return entity, nil
 	}
@@ -154,7 +155,7 @@ Return JSON in this format:
 
 		// Make the API call
 
-		resp, err := ExecuteLLMRequest(c, messages)
+		resp, err := ExecuteLLMRequest(ctx, c, messages)
 		if err != nil {
 			log.Printf("error getting completion: %v", err)
 			continue
@@ -184,12 +185,12 @@ Return JSON in this format:
 	return entity, nil
 }
 
-func GenerateEntityEmbedding(c *models.LLMClient, entity models.Entity) (pgvector.Vector, error) {
+func GenerateEntityEmbedding(ctx context.Context, c *models.LLMClient, entity models.Entity) (pgvector.Vector, error) {
 	// Combine entity fields into a single text for embedding
 	text := fmt.Sprintf("%s - %s - %s", entity.Name, entity.Type, entity.Description)
 
 	// Generate embedding using existing function
-	embedding, err := GetEmbedding1024(text, false)
+	embedding, err := GetEmbedding1024(ctx, text, false)
 	if err != nil {
 		return pgvector.Vector{}, fmt.Errorf("failed to generate embedding: %w", err)
 	}
@@ -198,7 +199,7 @@ func GenerateEntityEmbedding(c *models.LLMClient, entity models.Entity) (pgvecto
 }
 
 // FindEntitiesBatch processes multiple cards (title, body) together and returns entities per card.
-func FindEntitiesBatch(c *models.LLMClient, facts []models.Fact) ([][]models.Entity, error) {
+func FindEntitiesBatch(ctx context.Context, c *models.LLMClient, facts []models.Fact) ([][]models.Entity, error) {
 	systemPrompt := `You are an AI specialized in analyzing zettelkasten cards and extracting entities.
 Follow these rules strictly:
 
@@ -262,7 +263,7 @@ Return only valid JSON matching the specified structure.`
 	var parsed []batchEntityResponse
 	var jsonErr error
 	for range 3 {
-		resp, err := ExecuteLLMRequest(c, messages)
+		resp, err := ExecuteLLMRequest(ctx, c, messages)
 		if err != nil {
 			log.Printf("error getting completion: %v", err)
 			return nil, err
@@ -293,7 +294,7 @@ Return only valid JSON matching the specified structure.`
 		var entitiesWithEmb []models.Entity
 		for _, entity := range resp.Entities {
 			text := fmt.Sprintf("%v - %v - %v", entity.Name, entity.Type, entity.Description)
-			embedding, err := GetEmbedding1024(text, false)
+			embedding, err := GetEmbedding1024(ctx, text, false)
 			if err != nil {
 				continue
 			}
@@ -308,7 +309,7 @@ Return only valid JSON matching the specified structure.`
 	return results, nil
 }
 
-func GenerateNewEntityDescription(c *models.LLMClient, e1, e2 models.Entity, newName string) (string, error) {
+func GenerateNewEntityDescription(ctx context.Context, c *models.LLMClient, e1, e2 models.Entity, newName string) (string, error) {
 	systemPrompt := `You are an AI assistant tasked with synthesizing new entities.
 Given two existing entities, propose a new entity with the provided name.
 Rules:
@@ -339,7 +340,7 @@ New Entity Name: %s`, e1.Name, e1.Type, e1.Description, e2.Name, e2.Type, e2.Des
 		},
 	}
 
-	resp, err := ExecuteLLMRequest(c, messages)
+	resp, err := ExecuteLLMRequest(ctx, c, messages)
 	if err != nil {
 		return "", fmt.Errorf("error generating new entity: %w", err)
 	}
@@ -0,0 +1,200 @@
+package llms
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pgvector/pgvector-go"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// DefaultFakeReply is what a FakeProvider answers when no rule matches
+const DefaultFakeReply = "This is a mock response for testing"
+
+type fakeRule struct {
+	match   func(openai.ChatCompletionRequest) bool
+	respond func(openai.ChatCompletionRequest) (string, error)
+}
+
+// FakeProvider is an offline, deterministic Provider for tests. Completions
+// are answered by the first rule that matches the request, embeddings are
+// hashed bags of words so that texts sharing words are similar, and reranking
+// scores documents by how many of the query's words they contain.
+type FakeProvider struct {
+	// Reply answers completions that match no rule
+	Reply string
+
+	mu                sync.Mutex
+	rules             []fakeRule
+	requests          []openai.ChatCompletionRequest
+	embeddingRequests []EmbeddingRequest
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{Reply: DefaultFakeReply}
+}
+
+// On answers requests accepted by match with the result of respond
+func (f *FakeProvider) On(
+	match func(openai.ChatCompletionRequest) bool,
+	respond func(openai.ChatCompletionRequest) (string, error),
+) *FakeProvider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, fakeRule{match: match, respond: respond})
+	return f
+}
+
+// OnPrompt answers with reply when any message of a request contains text
+func (f *FakeProvider) OnPrompt(text, reply string) *FakeProvider {
+	return f.On(promptContains(text), func(openai.ChatCompletionRequest) (string, error) {
+		return reply, nil
+	})
+}
+
+// FailOnPrompt returns err when any message of a request contains text
+func (f *FakeProvider) FailOnPrompt(text string, err error) *FakeProvider {
+	return f.On(promptContains(text), func(openai.ChatCompletionRequest) (string, error) {
+		return "", err
+	})
+}
+
+func promptContains(text string) func(openai.ChatCompletionRequest) bool {
+	return func(request openai.ChatCompletionRequest) bool {
+		for _, message := range request.Messages {
+			if strings.Contains(message.Content, text) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Requests returns the completion requests the provider has received
+func (f *FakeProvider) Requests() []openai.ChatCompletionRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]openai.ChatCompletionRequest(nil), f.requests...)
+}
+
+// EmbeddingRequests returns the embedding requests the provider has received
+func (f *FakeProvider) EmbeddingRequests() []EmbeddingRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]EmbeddingRequest(nil), f.embeddingRequests...)
+}
+
+func (f *FakeProvider) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, request)
+	rules := f.rules
+	reply := f.Reply
+	f.mu.Unlock()
+
+	for _, rule := range rules {
+		if rule.match(request) {
+			var err error
+			reply, err = rule.respond(request)
+			if err != nil {
+				return openai.ChatCompletionResponse{}, err
+			}
+			break
+		}
+	}
+
+	promptTokens := 0
+	for _, message := range request.Messages {
+		promptTokens += EstimateTokens(message.Content)
+	}
+	completionTokens := EstimateTokens(reply)
+	return openai.ChatCompletionResponse{
+		Model: request.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: reply,
+			},
+			FinishReason: openai.FinishReasonStop,
+		}},
+		Usage: openai.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	}, nil
+}
+
+func fakeWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (f *FakeProvider) CreateEmbedding(ctx context.Context, request EmbeddingRequest) (pgvector.Vector, error) {
+	f.mu.Lock()
+	f.embeddingRequests = append(f.embeddingRequests, request)
+	f.mu.Unlock()
+
+	dimensions := 1024
+	if request.Model == EmbeddingModelNomic {
+		dimensions = 768
+	}
+	vector := make([]float32, dimensions)
+	for _, word := range fakeWords(request.Input) {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		sign := float32(1)
+		if sum&1 == 1 {
+			sign = -1
+		}
+		vector[(sum>>1)%uint64(dimensions)] += sign
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		vector[0] = 1
+		return pgvector.NewVector(vector), nil
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vector {
+		vector[i] *= scale
+	}
+	return pgvector.NewVector(vector), nil
+}
+
+func (f *FakeProvider) Rerank(ctx context.Context, request RerankRequest) ([]RerankResult, error) {
+	queryWords := fakeWords(request.Query)
+	results := make([]RerankResult, len(request.Documents))
+	for i, document := range request.Documents {
+		words := map[string]bool{}
+		for _, word := range fakeWords(document) {
+			words[word] = true
+		}
+		matched := 0
+		for _, word := range queryWords {
+			if words[word] {
+				matched++
+			}
+		}
+		results[i] = RerankResult{Index: i}
+		if len(queryWords) > 0 {
+			results[i].Score = float64(matched) / float64(len(queryWords))
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if request.TopN > 0 && request.TopN < len(results) {
+		results = results[:request.TopN]
+	}
+	return results, nil
+}
@@ -0,0 +1,130 @@
+package llms
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-backend/models"
+	"os"
+	"path/filepath"
+
+	"github.com/pgvector/pgvector-go"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+type FixtureMode string
+
+const (
+	// FixtureReplay answers from fixture files and fails on unknown requests
+	FixtureReplay FixtureMode = "replay"
+	// FixtureRecord forwards requests and saves each response as a fixture
+	FixtureRecord FixtureMode = "record"
+)
+
+// ErrFixtureNotFound is returned when replaying a request with no fixture
+var ErrFixtureNotFound = errors.New("no fixture for request")
+
+// FixtureProvider records requests and their responses to files in Dir, or
+// replays them from there. A fixture is keyed by a hash of its request, so
+// the same prompt to the same model always finds the same file. In record
+// mode the request goes to Completions, Embeddings or Reranker.
+type FixtureProvider struct {
+	Dir         string
+	Mode        FixtureMode
+	Completions models.CompletionProvider
+	Embeddings  EmbeddingProvider
+	Reranker    Reranker
+}
+
+type fixture struct {
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response"`
+}
+
+func (f *FixtureProvider) path(kind string, request []byte) string {
+	sum := sha256.Sum256(append([]byte(kind+"\x00"), request...))
+	return filepath.Join(f.Dir, kind+"-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// do replays the response to request, or records the result of call
+func (f *FixtureProvider) do(kind string, request interface{}, response interface{}, call func() (interface{}, error)) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	path := f.path(kind, data)
+
+	if f.Mode != FixtureRecord {
+		raw, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrFixtureNotFound, path)
+		} else if err != nil {
+			return err
+		}
+		var saved fixture
+		if err := json.Unmarshal(raw, &saved); err != nil {
+			return fmt.Errorf("invalid fixture %s: %w", path, err)
+		}
+		return json.Unmarshal(saved.Response, response)
+	}
+
+	result, err := call()
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(fixture{Request: data, Response: encoded}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, out, 0o644); err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, response)
+}
+
+func (f *FixtureProvider) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	var response openai.ChatCompletionResponse
+	err := f.do("completion", request, &response, func() (interface{}, error) {
+		if f.Completions == nil {
+			return nil, errors.New("no completion provider to record from")
+		}
+		return f.Completions.CreateChatCompletion(ctx, request)
+	})
+	return response, err
+}
+
+func (f *FixtureProvider) CreateEmbedding(ctx context.Context, request EmbeddingRequest) (pgvector.Vector, error) {
+	var response []float32
+	err := f.do("embedding", request, &response, func() (interface{}, error) {
+		if f.Embeddings == nil {
+			return nil, errors.New("no embedding provider to record from")
+		}
+		vector, err := f.Embeddings.CreateEmbedding(ctx, request)
+		return vector.Slice(), err
+	})
+	if err != nil {
+		return pgvector.Vector{}, err
+	}
+	return pgvector.NewVector(response), nil
+}
+
+func (f *FixtureProvider) Rerank(ctx context.Context, request RerankRequest) ([]RerankResult, error) {
+	var response []RerankResult
+	err := f.do("rerank", request, &response, func() (interface{}, error) {
+		if f.Reranker == nil {
+			return nil, errors.New("no reranker to record from")
+		}
+		return f.Reranker.Rerank(ctx, request)
+	})
+	return response, err
+}
@@ -1,6 +1,7 @@
 package llms
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"go-backend/models"
@@ -31,7 +32,7 @@ func UpdateUserMemory(db *sql.DB, userID uint, memory string) error {
 	return err
 }
 
-func GenerateUserMemory(db *sql.DB, client *models.LLMClient, userID uint, cardContent string) (string, error) {
+func GenerateUserMemory(ctx context.Context, db *sql.DB, client *models.LLMClient, userID uint, cardContent string) (string, error) {
 	userMemory, err := GetUserMemory(db, userID)
 	if err != nil {
 		return "", err
@@ -75,7 +76,7 @@ You must follow this process precisely:
 		},
 	}
 
-	response, err := ExecuteLLMRequest(client, messages)
+	response, err := ExecuteLLMRequest(ctx, client, messages)
 	if err != nil {
 		return "", err
 	}
@@ -96,7 +97,7 @@ You must follow this process precisely:
 	return response.Choices[0].Message.Content, nil
 }
 
-func CompressUserMemory(db *sql.DB, client *models.LLMClient, userID uint) (string, error) {
+func CompressUserMemory(ctx context.Context, db *sql.DB, client *models.LLMClient, userID uint) (string, error) {
 	userMemory, err := GetUserMemory(db, userID)
 	if err != nil {
 		log.Printf("error getting memory: %v", err)
@@ -144,7 +145,7 @@ Your task is to produce a new, superior, and more compact version of the entire
 		},
 	}
 
-	response, err := ExecuteLLMRequest(client, messages)
+	response, err := ExecuteLLMRequest(ctx, client, messages)
 	if err != nil {
 		log.Printf("error getting LLM response: %v", err)
 		return "", err
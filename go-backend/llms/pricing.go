@@ -0,0 +1,262 @@
+package llms
+
+import (
+	"database/sql"
+	"fmt"
+	"go-backend/models"
+	"log"
+	"time"
+)
+
+// pricingEpoch is the start of a price with no effective_from, so that it
+// covers every historical request
+var pricingEpoch = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const pricingColumns = `
+	id, model_identifier, prompt_per_1k, completion_per_1k, effective_from, effective_to, created_at, updated_at
+`
+
+func scanPricing(row interface{ Scan(...interface{}) error }) (models.LLMModelPricing, error) {
+	var pricing models.LLMModelPricing
+	err := row.Scan(
+		&pricing.ID,
+		&pricing.ModelIdentifier,
+		&pricing.PromptPer1K,
+		&pricing.CompletionPer1K,
+		&pricing.EffectiveFrom,
+		&pricing.EffectiveTo,
+		&pricing.CreatedAt,
+		&pricing.UpdatedAt,
+	)
+	return pricing, err
+}
+
+// GetModelPricing returns the price of a model at the given time. It returns
+// sql.ErrNoRows when the model has no price for that time.
+func GetModelPricing(db *sql.DB, model string, at time.Time) (models.LLMModelPricing, error) {
+	return scanPricing(db.QueryRow(`
+		SELECT `+pricingColumns+`
+		FROM llm_model_pricing
+		WHERE model_identifier = $1
+		AND effective_from <= $2
+		AND (effective_to IS NULL OR effective_to > $2)
+		ORDER BY effective_from DESC
+		LIMIT 1
+	`, model, at.UTC()))
+}
+
+// costOf returns the prompt and completion cost of a request in USD
+func costOf(pricing models.LLMModelPricing, promptTokens, completionTokens int) (float64, float64) {
+	return float64(promptTokens) / 1000.0 * pricing.PromptPer1K,
+		float64(completionTokens) / 1000.0 * pricing.CompletionPer1K
+}
+
+// estimateCost returns the prompt and completion cost of a request made now,
+// and false if the model has no price
+func estimateCost(db *sql.DB, model string, promptTokens, completionTokens int) (float64, float64, bool) {
+	if db == nil {
+		return 0, 0, false
+	}
+	pricing, err := GetModelPricing(db, model, time.Now())
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("failed to look up pricing for %s: %v", model, err)
+		}
+		return 0, 0, false
+	}
+	promptCost, completionCost := costOf(pricing, promptTokens, completionTokens)
+	return promptCost, completionCost, true
+}
+
+// ListModelPricing returns every price, or only those of one model
+func ListModelPricing(db *sql.DB, model string) ([]models.LLMModelPricing, error) {
+	rows, err := db.Query(`
+		SELECT `+pricingColumns+`
+		FROM llm_model_pricing
+		WHERE $1 = '' OR model_identifier = $1
+		ORDER BY model_identifier, effective_from
+	`, model)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.LLMModelPricing{}
+	for rows.Next() {
+		pricing, err := scanPricing(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, pricing)
+	}
+	return results, rows.Err()
+}
+
+func validatePricing(pricing *models.LLMModelPricing) error {
+	if pricing.ModelIdentifier == "" {
+		return fmt.Errorf("model identifier is required")
+	}
+	if pricing.PromptPer1K < 0 || pricing.CompletionPer1K < 0 {
+		return fmt.Errorf("prices cannot be negative")
+	}
+	if pricing.EffectiveFrom.IsZero() {
+		pricing.EffectiveFrom = pricingEpoch
+	}
+	pricing.EffectiveFrom = pricing.EffectiveFrom.UTC()
+	if pricing.EffectiveTo != nil {
+		to := pricing.EffectiveTo.UTC()
+		if !to.After(pricing.EffectiveFrom) {
+			return fmt.Errorf("effective_to must be after effective_from")
+		}
+		pricing.EffectiveTo = &to
+	}
+	return nil
+}
+
+// checkPricingOverlap fails if the price's range overlaps another price of
+// the same model
+func checkPricingOverlap(tx *sql.Tx, pricing models.LLMModelPricing) error {
+	var exists bool
+	err := tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM llm_model_pricing
+			WHERE model_identifier = $1 AND id <> $2
+			AND effective_from < COALESCE($4, 'infinity'::timestamp)
+			AND COALESCE(effective_to, 'infinity'::timestamp) > $3
+		)
+	`, pricing.ModelIdentifier, pricing.ID, pricing.EffectiveFrom, pricing.EffectiveTo).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("pricing overlaps an existing range")
+	}
+	return nil
+}
+
+// CreateModelPricing adds a price for a model. A new open-ended price ends
+// the model's current open-ended price, so a price change is a single call.
+func CreateModelPricing(db *sql.DB, pricing models.LLMModelPricing) (models.LLMModelPricing, error) {
+	if err := validatePricing(&pricing); err != nil {
+		return models.LLMModelPricing{}, err
+	}
+	pricing.ID = 0
+
+	tx, err := db.Begin()
+	if err != nil {
+		return models.LLMModelPricing{}, err
+	}
+	defer tx.Rollback()
+
+	if pricing.EffectiveTo == nil {
+		_, err = tx.Exec(`
+			UPDATE llm_model_pricing SET effective_to = $2, updated_at = NOW()
+			WHERE model_identifier = $1 AND effective_to IS NULL AND effective_from < $2
+		`, pricing.ModelIdentifier, pricing.EffectiveFrom)
+		if err != nil {
+			return models.LLMModelPricing{}, err
+		}
+	}
+	if err := checkPricingOverlap(tx, pricing); err != nil {
+		return models.LLMModelPricing{}, err
+	}
+
+	created, err := scanPricing(tx.QueryRow(`
+		INSERT INTO llm_model_pricing (model_identifier, prompt_per_1k, completion_per_1k, effective_from, effective_to)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+pricingColumns,
+		pricing.ModelIdentifier, pricing.PromptPer1K, pricing.CompletionPer1K, pricing.EffectiveFrom, pricing.EffectiveTo,
+	))
+	if err != nil {
+		return models.LLMModelPricing{}, err
+	}
+	return created, tx.Commit()
+}
+
+func UpdateModelPricing(db *sql.DB, id int, pricing models.LLMModelPricing) (models.LLMModelPricing, error) {
+	if err := validatePricing(&pricing); err != nil {
+		return models.LLMModelPricing{}, err
+	}
+	pricing.ID = id
+
+	tx, err := db.Begin()
+	if err != nil {
+		return models.LLMModelPricing{}, err
+	}
+	defer tx.Rollback()
+
+	if err := checkPricingOverlap(tx, pricing); err != nil {
+		return models.LLMModelPricing{}, err
+	}
+	updated, err := scanPricing(tx.QueryRow(`
+		UPDATE llm_model_pricing SET
+			model_identifier = $2,
+			prompt_per_1k = $3,
+			completion_per_1k = $4,
+			effective_from = $5,
+			effective_to = $6,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+pricingColumns,
+		id, pricing.ModelIdentifier, pricing.PromptPer1K, pricing.CompletionPer1K, pricing.EffectiveFrom, pricing.EffectiveTo,
+	))
+	if err == sql.ErrNoRows {
+		return models.LLMModelPricing{}, fmt.Errorf("pricing not found")
+	} else if err != nil {
+		return models.LLMModelPricing{}, err
+	}
+	return updated, tx.Commit()
+}
+
+func DeleteModelPricing(db *sql.DB, id int) error {
+	result, err := db.Exec(`DELETE FROM llm_model_pricing WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return fmt.Errorf("pricing not found")
+	}
+	return nil
+}
+
+// RecomputeCosts prices logged requests and completed summarizations made
+// since the given time with the price that applied when they were made. Unless
+// all is set, only rows without a cost are touched. Rows whose model has no
+// price for their time are left alone.
+func RecomputeCosts(db *sql.DB, since time.Time, all bool) (models.CostRecomputeResult, error) {
+	var result models.CostRecomputeResult
+
+	res, err := db.Exec(`
+		UPDATE llm_query_log l SET cost_usd =
+			COALESCE(l.prompt_tokens, 0) / 1000.0 * p.prompt_per_1k +
+			COALESCE(l.completion_tokens, 0) / 1000.0 * p.completion_per_1k
+		FROM llm_model_pricing p
+		WHERE p.model_identifier = l.model
+		AND l.created_at >= p.effective_from
+		AND (p.effective_to IS NULL OR l.created_at < p.effective_to)
+		AND l.created_at >= $1
+		AND ($2 OR l.cost_usd IS NULL)
+	`, since.UTC(), all)
+	if err != nil {
+		return result, fmt.Errorf("failed to recompute llm query costs: %w", err)
+	}
+	result.QueryLogRows, _ = res.RowsAffected()
+
+	res, err = db.Exec(`
+		UPDATE summarizations s SET cost =
+			COALESCE(s.prompt_tokens, 0) / 1000.0 * p.prompt_per_1k +
+			COALESCE(s.completion_tokens, 0) / 1000.0 * p.completion_per_1k
+		FROM llm_model_pricing p
+		WHERE p.model_identifier = s.model
+		AND s.created_at >= p.effective_from
+		AND (p.effective_to IS NULL OR s.created_at < p.effective_to)
+		AND s.status = 'complete'
+		AND s.created_at >= $1
+		AND ($2 OR COALESCE(s.cost, 0) = 0)
+	`, since.UTC(), all)
+	if err != nil {
+		return result, fmt.Errorf("failed to recompute summarization costs: %w", err)
+	}
+	result.SummarizationRows, _ = res.RowsAffected()
+	return result, nil
+}
@@ -0,0 +1,262 @@
+package llms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-backend/models"
+	"net/http"
+	"os"
+	"sync"
+
+	cohere "github.com/cohere-ai/cohere-go/v2"
+	cohereclient "github.com/cohere-ai/cohere-go/v2/client"
+	"github.com/pgvector/pgvector-go"
+)
+
+// Embedding models served by the embedding provider
+const (
+	EmbeddingModelNomic = "nomic-embed-text"
+	EmbeddingModel1024  = "embedding-1024"
+)
+
+// queryEmbeddingPrefix is prepended to search queries, as the embedding
+// models expect
+const queryEmbeddingPrefix = "Represent this sentence for searching relevant passages:"
+
+type EmbeddingRequest struct {
+	Model    string `json:"model"`
+	Input    string `json:"input"`
+	ForQuery bool   `json:"for_query"`
+}
+
+// EmbeddingProvider turns text into vectors
+type EmbeddingProvider interface {
+	CreateEmbedding(ctx context.Context, request EmbeddingRequest) (pgvector.Vector, error)
+}
+
+type RerankRequest struct {
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopN      int      `json:"top_n"`
+}
+
+// RerankResult scores the document at Index of a RerankRequest
+type RerankResult struct {
+	Index int     `json:"index"`
+	Score float64 `json:"score"`
+}
+
+// Reranker orders documents by their relevance to a query, most relevant
+// first
+type Reranker interface {
+	Rerank(ctx context.Context, request RerankRequest) ([]RerankResult, error)
+}
+
+// Provider is a backend that serves everything the llms package needs
+type Provider interface {
+	models.CompletionProvider
+	EmbeddingProvider
+	Reranker
+}
+
+// providers holds the backends currently in use. Completions are built per
+// client, since they depend on the user's model and key, so they are
+// configured as a wrapper around the client's own provider.
+var providers = struct {
+	sync.RWMutex
+	completions func(models.CompletionProvider) models.CompletionProvider
+	embeddings  EmbeddingProvider
+	reranker    Reranker
+}{
+	completions: func(p models.CompletionProvider) models.CompletionProvider { return p },
+	embeddings:  HTTPEmbeddingProvider{},
+	reranker:    CohereReranker{},
+}
+
+func completionProvider(client models.CompletionProvider) models.CompletionProvider {
+	providers.RLock()
+	defer providers.RUnlock()
+	return providers.completions(client)
+}
+
+func embeddingProvider() EmbeddingProvider {
+	providers.RLock()
+	defer providers.RUnlock()
+	return providers.embeddings
+}
+
+func rerankProvider() Reranker {
+	providers.RLock()
+	defer providers.RUnlock()
+	return providers.reranker
+}
+
+// swapProviders replaces the current backends and returns a function that
+// puts the previous ones back
+func swapProviders(
+	completions func(models.CompletionProvider) models.CompletionProvider,
+	embeddings EmbeddingProvider,
+	reranker Reranker,
+) func() {
+	providers.Lock()
+	defer providers.Unlock()
+	prevCompletions, prevEmbeddings, prevReranker := providers.completions, providers.embeddings, providers.reranker
+	providers.completions, providers.embeddings, providers.reranker = completions, embeddings, reranker
+	return func() {
+		providers.Lock()
+		defer providers.Unlock()
+		providers.completions, providers.embeddings, providers.reranker = prevCompletions, prevEmbeddings, prevReranker
+	}
+}
+
+// SetProvider sends every completion, embedding and rerank request to p,
+// whatever model a client was built with. It returns a function that restores
+// the previous providers.
+func SetProvider(p Provider) func() {
+	return swapProviders(
+		func(models.CompletionProvider) models.CompletionProvider { return p },
+		p,
+		p,
+	)
+}
+
+// UseFixtures records requests to, or replays them from, fixture files in
+// dir. It returns a function that restores the previous providers.
+func UseFixtures(dir string, mode FixtureMode) func() {
+	providers.RLock()
+	wrap, embeddings, reranker := providers.completions, providers.embeddings, providers.reranker
+	providers.RUnlock()
+
+	return swapProviders(
+		func(client models.CompletionProvider) models.CompletionProvider {
+			return &FixtureProvider{Dir: dir, Mode: mode, Completions: wrap(client)}
+		},
+		&FixtureProvider{Dir: dir, Mode: mode, Embeddings: embeddings},
+		&FixtureProvider{Dir: dir, Mode: mode, Reranker: reranker},
+	)
+}
+
+// ConfigureProvidersFromEnv switches to offline providers when asked to.
+// ZETTEL_LLM_PROVIDER=fake answers everything with a FakeProvider, and
+// ZETTEL_LLM_FIXTURES names a directory of fixtures to replay, or to record
+// into when ZETTEL_LLM_FIXTURE_MODE=record.
+func ConfigureProvidersFromEnv() {
+	if os.Getenv("ZETTEL_LLM_PROVIDER") == "fake" {
+		SetProvider(NewFakeProvider())
+	}
+	if dir := os.Getenv("ZETTEL_LLM_FIXTURES"); dir != "" {
+		mode := FixtureReplay
+		if os.Getenv("ZETTEL_LLM_FIXTURE_MODE") == string(FixtureRecord) {
+			mode = FixtureRecord
+		}
+		UseFixtures(dir, mode)
+	}
+}
+
+// HTTPEmbeddingProvider calls the embedding services configured by
+// ZETTEL_EMBEDDING_API and ZETTEL_EMBEDDING_1024_API
+type HTTPEmbeddingProvider struct{}
+
+func (HTTPEmbeddingProvider) CreateEmbedding(ctx context.Context, request EmbeddingRequest) (pgvector.Vector, error) {
+	prompt := request.Input
+	if request.ForQuery {
+		prompt = queryEmbeddingPrefix + prompt
+	}
+
+	switch request.Model {
+	case EmbeddingModelNomic:
+		url := os.Getenv("ZETTEL_EMBEDDING_API")
+		if url == "" {
+			return pgvector.Vector{}, errors.New("no embedding url given - set ZETTEL_EMBEDDING_API")
+		}
+		var response struct {
+			Embedding []float32 `json:"embedding"`
+		}
+		payload := map[string]string{
+			"model":  "nomic-embed-text",
+			"prompt": prompt,
+		}
+		if err := postEmbedding(ctx, url, payload, &response); err != nil {
+			return pgvector.Vector{}, err
+		}
+		return pgvector.NewVector(response.Embedding), nil
+
+	case EmbeddingModel1024:
+		url := os.Getenv("ZETTEL_EMBEDDING_1024_API")
+		if url == "" {
+			return pgvector.Vector{}, errors.New("no embedding url given - set ZETTEL_EMBEDDING_1024_API")
+		}
+		var embeddings [][]float32
+		payload := map[string]string{
+			"inputs": prompt,
+		}
+		if err := postEmbedding(ctx, url, payload, &embeddings); err != nil {
+			return pgvector.Vector{}, err
+		}
+		if len(embeddings) == 0 {
+			return pgvector.Vector{}, errors.New("no embeddings returned")
+		}
+		// use the first embedding
+		return pgvector.NewVector(embeddings[0]), nil
+	}
+	return pgvector.Vector{}, fmt.Errorf("unknown embedding model %q", request.Model)
+}
+
+func postEmbedding(ctx context.Context, url string, payload interface{}, result interface{}) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error creating JSON payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error communicating with the embedding API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error generating embeddings: %w", &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("error decoding embedding API response: %w", err)
+	}
+	return nil
+}
+
+// CohereReranker reranks with Cohere, using ZETTEL_COHERE_API_KEY
+type CohereReranker struct{}
+
+func (CohereReranker) Rerank(ctx context.Context, request RerankRequest) ([]RerankResult, error) {
+	documents := make([]*cohere.RerankRequestDocumentsItem, len(request.Documents))
+	for i, document := range request.Documents {
+		documents[i] = &cohere.RerankRequestDocumentsItem{String: document}
+	}
+	model := "rerank-english-v3.0"
+	topn := request.TopN
+
+	client := cohereclient.NewClient(cohereclient.WithToken(os.Getenv("ZETTEL_COHERE_API_KEY")))
+	response, err := client.Rerank(ctx, &cohere.RerankRequest{
+		Model:     &model,
+		Query:     request.Query,
+		Documents: documents,
+		TopN:      &topn,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]RerankResult, len(response.Results))
+	for i, result := range response.Results {
+		results[i] = RerankResult{Index: result.Index, Score: result.RelevanceScore}
+	}
+	return results, nil
+}
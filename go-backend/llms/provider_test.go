@@ -0,0 +1,129 @@
+package llms
+
+import (
+	"context"
+	"errors"
+	"go-backend/models"
+	"path/filepath"
+	"testing"
+)
+
+func fakeClient() *models.LLMClient {
+	return &models.LLMClient{Model: &models.LLMModel{ModelIdentifier: "fake-model"}}
+}
+
+func TestFakeProviderExtractsTheses(t *testing.T) {
+	fake := NewFakeProvider().OnPrompt("extracts theses", `{
+		"section": "1",
+		"theses": [{"thesis": "Cards should be atomic", "facts": ["Luhmann wrote 90,000 cards"], "arguments": []}]
+	}`)
+	defer SetProvider(fake)()
+
+	analyses, usage, err := ExtractThesesAndArguments(context.Background(), fakeClient(), "Notes about the slip box.")
+	if err != nil {
+		t.Fatalf("failed to extract theses: %v", err)
+	}
+	if len(analyses) != 1 || len(analyses[0].Theses) != 1 {
+		t.Fatalf("wrong analyses, got %+v", analyses)
+	}
+	if facts := analyses[0].Theses[0].Facts; len(facts) != 1 || facts[0] != "Luhmann wrote 90,000 cards" {
+		t.Errorf("wrong facts, got %v", facts)
+	}
+	if usage.PromptTokens == 0 || usage.CompletionTokens == 0 {
+		t.Errorf("expected token usage to be reported, got %+v", usage)
+	}
+	if requests := fake.Requests(); len(requests) != 1 || requests[0].Model != "fake-model" {
+		t.Errorf("expected one request to the client's model, got %+v", requests)
+	}
+}
+
+func TestFakeProviderFindsEntities(t *testing.T) {
+	fake := NewFakeProvider().OnPrompt("extracting entities", `[
+		{"name": "Niklas Luhmann", "description": "Sociologist", "type": "person"}
+	]`)
+	defer SetProvider(fake)()
+
+	entities, err := FindEntities(context.Background(), fakeClient(), "Luhmann", "Niklas Luhmann kept a slip box.")
+	if err != nil {
+		t.Fatalf("failed to find entities: %v", err)
+	}
+	if len(entities) != 1 || entities[0].Name != "Niklas Luhmann" {
+		t.Fatalf("wrong entities, got %+v", entities)
+	}
+	if len(entities[0].Embedding.Slice()) != 1024 {
+		t.Errorf("expected a 1024 dimension embedding, got %v", len(entities[0].Embedding.Slice()))
+	}
+
+	boom := errors.New("boom")
+	defer SetProvider(NewFakeProvider().FailOnPrompt("extracting entities", boom))()
+	if _, err := FindEntities(context.Background(), fakeClient(), "Luhmann", "body"); !errors.Is(err, boom) {
+		t.Errorf("expected the scripted error, got %v", err)
+	}
+}
+
+func TestFakeProviderReranks(t *testing.T) {
+	defer SetProvider(NewFakeProvider())()
+
+	results, err := RerankSearchResults(context.Background(), fakeClient(), "slip box", []models.SearchResult{
+		{ID: "1", Title: "Gardening", Preview: "tomatoes"},
+		{ID: "2", Title: "The slip box", Preview: "a box of slips"},
+	})
+	if err != nil {
+		t.Fatalf("failed to rerank: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != "2" {
+		t.Errorf("expected the matching result first, got %+v", results)
+	}
+}
+
+func TestFakeProviderEmbeddingsAreDeterministic(t *testing.T) {
+	defer SetProvider(NewFakeProvider())()
+
+	a, _ := GetEmbedding(context.Background(), "the slip box", false)
+	b, _ := GetEmbedding(context.Background(), "the slip box", false)
+	if len(a.Slice()) != 768 {
+		t.Fatalf("expected a 768 dimension embedding, got %v", len(a.Slice()))
+	}
+	for i := range a.Slice() {
+		if a.Slice()[i] != b.Slice()[i] {
+			t.Fatalf("embeddings of the same text should match")
+		}
+	}
+}
+
+func TestFixturesRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	restoreFake := SetProvider(NewFakeProvider().OnPrompt("hello", "recorded reply"))
+	restoreRecord := UseFixtures(dir, FixtureRecord)
+
+	messages := []models.ChatCompletion{{Role: "user", Content: "hello"}}
+	recorded, err := ChatCompletion(context.Background(), fakeClient(), messages)
+	if err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	if _, err := GetEmbedding1024(context.Background(), "hello", true); err != nil {
+		t.Fatalf("failed to record embedding: %v", err)
+	}
+	restoreRecord()
+	restoreFake()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("expected two fixture files, got %v", files)
+	}
+
+	// Replay with nothing behind the fixtures, so only recorded requests work
+	defer UseFixtures(dir, FixtureReplay)()
+	replayed, err := ChatCompletion(context.Background(), fakeClient(), messages)
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	if replayed.Content != recorded.Content || replayed.Content != "recorded reply" {
+		t.Errorf("wrong replayed content, got %q want %q", replayed.Content, recorded.Content)
+	}
+
+	_, err = (&FixtureProvider{Dir: dir}).CreateEmbedding(context.Background(), EmbeddingRequest{Model: EmbeddingModel1024, Input: "unknown"})
+	if !errors.Is(err, ErrFixtureNotFound) {
+		t.Errorf("expected a missing fixture error, got %v", err)
+	}
+}
@@ -4,54 +4,52 @@ import (
 	"context"
 	"fmt"
 	"go-backend/models"
-	"os"
 	"strconv"
 	"strings"
 
-	cohere "github.com/cohere-ai/cohere-go/v2"
-	cohereclient "github.com/cohere-ai/cohere-go/v2/client"
 	openai "github.com/sashabaranov/go-openai"
 )
 
-func RerankSearchResults(c *models.LLMClient, query string, input []models.SearchResult) ([]models.SearchResult, error) {
+func RerankSearchResults(ctx context.Context, c *models.LLMClient, query string, input []models.SearchResult) ([]models.SearchResult, error) {
 	if query == "" {
 		return input, nil
 	}
-	// Convert SearchResults to strings for reranking
-	documents := make([]*cohere.RerankRequestDocumentsItem, len(input))
-	for i, result := range input {
-		documents[i] = &cohere.RerankRequestDocumentsItem{
-			String: fmt.Sprintf("%s\n%s", result.Title, result.Preview),
+	if c.DB != nil && c.UserID != 0 {
+		if err := CheckBudget(c.DB, c.UserID); err != nil {
+			return nil, err
 		}
 	}
-	model := "rerank-english-v3.0"
-	topn := len(input)
+	documents := make([]string, len(input))
+	for i, result := range input {
+		documents[i] = fmt.Sprintf("%s\n%s", result.Title, result.Preview)
+	}
 
-	request := cohere.RerankRequest{
-		Model:     &model,
+	request := RerankRequest{
 		Query:     query,
 		Documents: documents,
-		TopN:      &topn, // or specify a smaller number if you want fewer results
+		TopN:      len(input),
 	}
-
-	client := cohereclient.NewClient(cohereclient.WithToken(os.Getenv("ZETTEL_COHERE_API_KEY")))
-
-	response, err := client.Rerank(context.TODO(), &request)
+	var results []RerankResult
+	err := withRetry(ctx, "rerank", rerankRetryPolicy, func(ctx context.Context) error {
+		var err error
+		results, err = rerankProvider().Rerank(ctx, request)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	// Create a new slice to store reranked results
-	reranked := make([]models.SearchResult, len(response.Results))
-	for i, result := range response.Results {
+	reranked := make([]models.SearchResult, len(results))
+	for i, result := range results {
 		reranked[i] = input[result.Index]
-		reranked[i].Score = result.RelevanceScore
+		reranked[i].Score = result.Score
 	}
 
 	return reranked, nil
 }
 
-func RerankResults(c *models.LLMClient, query string, input []models.CardChunk) ([]float64, error) {
+func RerankResults(ctx context.Context, c *models.LLMClient, query string, input []models.CardChunk) ([]float64, error) {
 	summaries := make([]string, len(input))
 	for i, result := range input {
 		// Create a brief summary of each result
@@ -70,7 +68,7 @@ Only respond with numbers separated by commas, like: 8.5,7.2,6.8
 
 Documents to rate:
 %s`, query, strings.Join(summaries, "\n"))
-	resp, err := ExecuteLLMRequest(c, []openai.ChatCompletionMessage{
+	resp, err := ExecuteLLMRequest(ctx, c, []openai.ChatCompletionMessage{
 		{
 			Role:    "system",
 			Content: "You are a search result scoring assistant. Only respond with comma-separated numbers.",
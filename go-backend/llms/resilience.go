@@ -0,0 +1,300 @@
+package llms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	cohereCore "github.com/cohere-ai/cohere-go/v2/core"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ErrorKind says why a provider request failed
+type ErrorKind string
+
+const (
+	ErrorKindRateLimit   ErrorKind = "rate_limit"
+	ErrorKindTimeout     ErrorKind = "timeout"
+	ErrorKindRefusal     ErrorKind = "refusal"
+	ErrorKindUnavailable ErrorKind = "unavailable"
+	ErrorKindInvalid     ErrorKind = "invalid"
+	// ErrorKindCanceled is a request the caller gave up on
+	ErrorKindCanceled ErrorKind = "canceled"
+)
+
+// ProviderError is returned when a request to a completion, embedding or
+// rerank provider fails after any retries
+type ProviderError struct {
+	Kind       ErrorKind
+	Provider   string
+	StatusCode int
+	Attempts   int
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	if e.Attempts > 1 {
+		return fmt.Sprintf("%s: %s after %d attempts: %v", e.Provider, e.Kind, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Provider, e.Kind, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether trying the request again may succeed
+func (e *ProviderError) Retryable() bool {
+	switch e.Kind {
+	case ErrorKindRateLimit, ErrorKindTimeout, ErrorKindUnavailable:
+		return true
+	}
+	return false
+}
+
+// ErrCircuitOpen is returned without calling a provider that has been
+// failing, until its cooldown has passed
+var ErrCircuitOpen = errors.New("circuit open")
+
+// HTTPStatusError is returned by providers that speak plain HTTP when the
+// response is not a success
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%d - %s", e.StatusCode, e.Status)
+}
+
+func kindForStatus(status int) ErrorKind {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrorKindRateLimit
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return ErrorKindTimeout
+	case status >= 500:
+		return ErrorKindUnavailable
+	}
+	return ErrorKindInvalid
+}
+
+// classifyError wraps err in a ProviderError that says what kind of failure
+// it was
+func classifyError(provider string, err error) *ProviderError {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr
+	}
+	result := &ProviderError{Kind: ErrorKindInvalid, Provider: provider, Err: err}
+
+	var apiErr *openai.APIError
+	var requestErr *openai.RequestError
+	var statusErr *HTTPStatusError
+	var cohereErr *cohereCore.APIError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		result.Kind = ErrorKindTimeout
+	case errors.Is(err, context.Canceled):
+		result.Kind = ErrorKindCanceled
+	case errors.Is(err, ErrCircuitOpen):
+		result.Kind = ErrorKindUnavailable
+	case errors.As(err, &apiErr):
+		result.StatusCode = apiErr.HTTPStatusCode
+		result.Kind = kindForStatus(apiErr.HTTPStatusCode)
+		if code, ok := apiErr.Code.(string); ok && code == "content_filter" {
+			result.Kind = ErrorKindRefusal
+		}
+		if apiErr.InnerError != nil && apiErr.InnerError.Code == "ResponsibleAIPolicyViolation" {
+			result.Kind = ErrorKindRefusal
+		}
+	case errors.As(err, &requestErr):
+		result.StatusCode = requestErr.HTTPStatusCode
+		result.Kind = kindForStatus(requestErr.HTTPStatusCode)
+	case errors.As(err, &statusErr):
+		result.StatusCode = statusErr.StatusCode
+		result.Kind = kindForStatus(statusErr.StatusCode)
+	case errors.As(err, &cohereErr):
+		result.StatusCode = cohereErr.StatusCode
+		result.Kind = kindForStatus(cohereErr.StatusCode)
+	case errors.As(err, &netErr):
+		result.Kind = ErrorKindUnavailable
+		if netErr.Timeout() {
+			result.Kind = ErrorKindTimeout
+		}
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		result.Kind = ErrorKindUnavailable
+	}
+	return result
+}
+
+// refusalError returns a refusal error if the model declined to answer
+func refusalError(provider string, resp openai.ChatCompletionResponse) error {
+	for _, choice := range resp.Choices {
+		if choice.Message.Refusal != "" {
+			return &ProviderError{Kind: ErrorKindRefusal, Provider: provider, Err: errors.New(choice.Message.Refusal)}
+		}
+		if choice.FinishReason == openai.FinishReasonContentFilter {
+			return &ProviderError{Kind: ErrorKindRefusal, Provider: provider, Err: errors.New("response was filtered")}
+		}
+	}
+	return nil
+}
+
+// RetryPolicy controls how a request is retried
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt. It doubles for
+	// each later attempt, up to MaxDelay, and a random part of it is used.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Timeout bounds each attempt
+	Timeout time.Duration
+}
+
+var (
+	completionRetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: time.Second, MaxDelay: 20 * time.Second, Timeout: 2 * time.Minute}
+	embeddingRetryPolicy  = RetryPolicy{MaxAttempts: 5, BaseDelay: 500 * time.Millisecond, MaxDelay: 10 * time.Second, Timeout: 30 * time.Second}
+	rerankRetryPolicy     = RetryPolicy{MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second, Timeout: 30 * time.Second}
+)
+
+// backoff returns a random delay of up to BaseDelay·2^(attempt-1), capped at
+// MaxDelay, so that clients retrying together spread out
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+const (
+	circuitFailureThreshold = 5
+	circuitCooldown         = 30 * time.Second
+)
+
+// circuitBreaker stops calls to a provider after repeated failures. Once
+// the cooldown passes it lets one call through, and closes again if that
+// call succeeds.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < circuitFailureThreshold {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// release ends a trial call without a verdict on the provider, as when the
+// caller gave up on it, so that the next call may try again
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) record(success bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= circuitFailureThreshold {
+		b.openUntil = now.Add(circuitCooldown)
+	}
+}
+
+var breakers = struct {
+	sync.Mutex
+	byProvider map[string]*circuitBreaker
+}{byProvider: map[string]*circuitBreaker{}}
+
+func breakerFor(provider string) *circuitBreaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+	breaker, ok := breakers.byProvider[provider]
+	if !ok {
+		breaker = &circuitBreaker{}
+		breakers.byProvider[provider] = breaker
+	}
+	return breaker
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not
+// worth retrying, or runs out of attempts. Failures count against the
+// provider's circuit breaker. Errors are returned as *ProviderError.
+func withRetry(ctx context.Context, provider string, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	breaker := breakerFor(provider)
+	attempts := max(policy.MaxAttempts, 1)
+
+	var last *ProviderError
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				last.Attempts = attempt - 1
+				return last
+			case <-time.After(policy.backoff(attempt - 1)):
+			}
+		}
+
+		if !breaker.allow(time.Now()) {
+			return &ProviderError{Kind: ErrorKindUnavailable, Provider: provider, Attempts: attempt - 1, Err: ErrCircuitOpen}
+		}
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, policy.Timeout)
+		}
+		err := fn(attemptCtx)
+		cancel()
+		if err == nil {
+			breaker.record(true, time.Now())
+			return nil
+		}
+
+		last = classifyError(provider, err)
+		if ctx.Err() != nil || last.Kind == ErrorKindCanceled {
+			// The caller gave up, which says nothing about the provider
+			breaker.release()
+			last.Attempts = attempt
+			return last
+		}
+		// Only failures of the provider itself count against it
+		breaker.record(!last.Retryable(), time.Now())
+		if !last.Retryable() {
+			last.Attempts = attempt
+			return last
+		}
+	}
+	last.Attempts = attempts
+	return last
+}
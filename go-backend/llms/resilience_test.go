@@ -0,0 +1,178 @@
+package llms
+
+import (
+	"context"
+	"errors"
+	"go-backend/models"
+	"net/http"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind ErrorKind
+	}{
+		{&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, ErrorKindRateLimit},
+		{&openai.APIError{HTTPStatusCode: http.StatusBadRequest, Code: "content_filter"}, ErrorKindRefusal},
+		{&openai.RequestError{HTTPStatusCode: http.StatusBadGateway}, ErrorKindUnavailable},
+		{&HTTPStatusError{StatusCode: http.StatusGatewayTimeout}, ErrorKindTimeout},
+		{&HTTPStatusError{StatusCode: http.StatusUnauthorized}, ErrorKindInvalid},
+		{context.DeadlineExceeded, ErrorKindTimeout},
+		{context.Canceled, ErrorKindCanceled},
+		{errors.New("bad input"), ErrorKindInvalid},
+	}
+	for _, tt := range tests {
+		if got := classifyError("test", tt.err).Kind; got != tt.kind {
+			t.Errorf("classifyError(%v) = %v, want %v", tt.err, got, tt.kind)
+		}
+	}
+}
+
+func TestBackoffIsBounded(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 4 * time.Second}
+	for attempt := 1; attempt <= 10; attempt++ {
+		if delay := policy.backoff(attempt); delay <= 0 || delay > 4*time.Second {
+			t.Errorf("backoff(%d) = %v, want a delay up to %v", attempt, delay, 4*time.Second)
+		}
+	}
+}
+
+func TestWithRetryRecoversFromTransientErrors(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), "transient", testRetryPolicy, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("wrong number of attempts, got %v want %v", calls, 3)
+	}
+}
+
+func TestWithRetryStopsOnPermanentErrors(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), "permanent", testRetryPolicy, func(ctx context.Context) error {
+		calls++
+		return &HTTPStatusError{StatusCode: http.StatusBadRequest}
+	})
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Kind != ErrorKindInvalid {
+		t.Fatalf("expected an invalid request error, got %v", err)
+	}
+	if calls != 1 || providerErr.Attempts != 1 {
+		t.Errorf("permanent errors should not be retried, got %v calls", calls)
+	}
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	failing := func(ctx context.Context) error {
+		return &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+	}
+	policy := RetryPolicy{MaxAttempts: circuitFailureThreshold}
+	withRetry(context.Background(), "outage", policy, failing)
+
+	calls := 0
+	err := withRetry(context.Background(), "outage", policy, func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	if !errors.Is(err, ErrCircuitOpen) || calls != 0 {
+		t.Fatalf("expected the circuit to be open, got %v after %v calls", err, calls)
+	}
+
+	breaker := breakerFor("outage")
+	if !breaker.allow(time.Now().Add(circuitCooldown)) {
+		t.Fatalf("expected a trial request after the cooldown")
+	}
+	if breaker.allow(time.Now().Add(circuitCooldown)) {
+		t.Errorf("only one trial request should be let through")
+	}
+	breaker.record(true, time.Now())
+	if !breaker.allow(time.Now()) {
+		t.Errorf("a successful trial should close the circuit")
+	}
+}
+
+func TestCancelledRequestsLeaveTheCircuitClosed(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: circuitFailureThreshold}
+	for i := 0; i < circuitFailureThreshold; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := withRetry(ctx, "cancelled", policy, func(ctx context.Context) error {
+			calls++
+			cancel()
+			return ctx.Err()
+		})
+		var providerErr *ProviderError
+		if !errors.As(err, &providerErr) || providerErr.Kind != ErrorKindCanceled {
+			t.Fatalf("expected a canceled error, got %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("cancelled requests should not be retried, got %v calls", calls)
+		}
+	}
+	if !breakerFor("cancelled").allow(time.Now()) {
+		t.Errorf("cancelled requests should not open the circuit")
+	}
+}
+
+func TestCancelledTrialLeavesTheCircuitHalfOpen(t *testing.T) {
+	failing := func(ctx context.Context) error {
+		return &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+	}
+	withRetry(context.Background(), "cancelled trial", RetryPolicy{MaxAttempts: circuitFailureThreshold}, failing)
+
+	// Wait out the cooldown
+	breaker := breakerFor("cancelled trial")
+	breaker.mu.Lock()
+	breaker.openUntil = time.Now().Add(-time.Second)
+	breaker.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := withRetry(ctx, "cancelled trial", RetryPolicy{MaxAttempts: 1}, func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+	if errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the trial request to be let through, got %v", err)
+	}
+	if !breaker.allow(time.Now()) {
+		t.Errorf("a cancelled trial should let the next request try again")
+	}
+}
+
+type stubCompletions struct {
+	resp openai.ChatCompletionResponse
+}
+
+func (s stubCompletions) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return s.resp, nil
+}
+
+func TestExecuteLLMRequestReportsRefusals(t *testing.T) {
+	client := &models.LLMClient{
+		Client: stubCompletions{resp: openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: "assistant", Refusal: "I can't help with that"},
+				FinishReason: openai.FinishReasonStop,
+			}},
+		}},
+		Model: &models.LLMModel{ModelIdentifier: "stub"},
+	}
+	_, err := ExecuteLLMRequest(context.Background(), client, []openai.ChatCompletionMessage{{Role: "user", Content: "hi"}})
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Kind != ErrorKindRefusal {
+		t.Errorf("expected a refusal error, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package llms
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -37,8 +38,8 @@ type Usage struct {
 // ExtractThesesAndArguments processes input text into SectionAnalysis entries,
 // aggregating theses, facts, and arguments from each chunk.
 // Returns all analyses and usage statistics.
-func ExtractThesesAndArguments(c *models.LLMClient, input string) ([]SectionAnalysis, Usage, error) {
-	chunks := chunkText(input, 25000)
+func ExtractThesesAndArguments(ctx context.Context, c *models.LLMClient, input string) ([]SectionAnalysis, Usage, error) {
+	chunks := ChunkText(input, ChunkOptions{MaxTokens: 6000})
 
 	totalPromptTokens := 0
 	totalCompletionTokens := 0
@@ -76,7 +77,7 @@ func ExtractThesesAndArguments(c *models.LLMClient, input string) ([]SectionAnal
 			"Now analyze the following text. " +
 			"If you believe the author has started a new section, record that section number or title. " +
 			"Otherwise, continue assigning output under the previous section. " +
-			"Always include \"section\" explicitly in your JSON output.\n" + chunk
+			"Always include \"section\" explicitly in your JSON output.\n" + chunk.Text
 
 		messages := []openai.ChatCompletionMessage{
 			{
@@ -117,7 +118,7 @@ Format Example:
 			},
 		}
 
-		resp, err := ExecuteLLMRequest(c, messages)
+		resp, err := ExecuteLLMRequest(ctx, c, messages)
 		if err != nil {
 			return nil, Usage{}, err
 		}
@@ -162,9 +163,8 @@ func cleanContent(content string) string {
 }
 
 // AnalyzeAndSummarizeText: the advanced pipeline
-func AnalyzeAndSummarizeText(c *models.LLMClient, allAnalyses []SectionAnalysis, usage Usage) (string, []SectionAnalysis, Usage, error) {
+func AnalyzeAndSummarizeText(ctx context.Context, c *models.LLMClient, allAnalyses []SectionAnalysis, usage Usage) (string, []SectionAnalysis, Usage, error) {
 	start := time.Now()
-	c.Model.ModelIdentifier = "openai/gpt-5-chat"
 
 	totalPromptTokens := usage.PromptTokens
 	totalCompletionTokens := usage.CompletionTokens
@@ -220,7 +220,7 @@ Respond ONLY in JSON with the following format:
 			Content: "Please consider the full set of arguments (with importance values) above when performing deduplication and ranking.",
 		},
 	}
-	dedupResp, err := ExecuteLLMRequest(c, dedupMessages)
+	dedupResp, err := ExecuteLLMRequest(ctx, c, dedupMessages)
 	if err != nil {
 		return "", nil, Usage{}, err
 	}
@@ -285,7 +285,7 @@ Input (including deduplicated theses, facts, and arguments with importance/rank)
 		},
 	}
 
-	finalResp, err := ExecuteLLMRequest(c, finalMessages)
+	finalResp, err := ExecuteLLMRequest(ctx, c, finalMessages)
 	if err != nil {
 		return "", nil, Usage{}, err
 	}
@@ -301,10 +301,7 @@ Input (including deduplicated theses, facts, and arguments with importance/rank)
 			totalPromptTokens+totalCompletionTokens,
 			totalPromptTokens, totalCompletionTokens)
 
-	const promptCostPerMillion = 1.25
-	const completionCostPerMillion = 10.0
-	promptCost := float64(totalPromptTokens) / 1_000_000 * promptCostPerMillion
-	completionCost := float64(totalCompletionTokens) / 1_000_000 * completionCostPerMillion
+	promptCost, completionCost, _ := estimateCost(c.DB, c.Model.ModelIdentifier, totalPromptTokens, totalCompletionTokens)
 	totalCost := promptCost + completionCost
 
 	summary += "\n\nEstimated Cost: " +
@@ -333,30 +330,3 @@ func flattenArguments(analyses []SectionAnalysis) []Argument {
 	}
 	return args
 }
-
-// chunkText splits input into segments of maxLength, breaking at sentence boundaries.
-func chunkText(input string, maxLength int) []string {
-	sentences := strings.Split(input, ".")
-	var chunks []string
-	var current string
-	for _, sentence := range sentences {
-		s := strings.TrimSpace(sentence)
-		if s == "" {
-			continue
-		}
-		if len(current)+len(s)+1 > maxLength {
-			chunks = append(chunks, strings.TrimSpace(current))
-			current = s + "."
-		} else {
-			if current == "" {
-				current = s + "."
-			} else {
-				current += " " + s + "."
-			}
-		}
-	}
-	if strings.TrimSpace(current) != "" {
-		chunks = append(chunks, strings.TrimSpace(current))
-	}
-	return chunks
-}
@@ -165,14 +165,25 @@ func main() {
 	addProtectedRoute(r, "/api/files/{id}", h.DeleteFileRoute, "DELETE")
 	addProtectedRoute(r, "/api/files/download/{id}", h.DownloadFileRoute, "GET")
 
+	addProtectedRoute(r, "/api/export/markdown", h.ExportMarkdownRoute, "GET")
+	addProtectedRoute(r, "/api/import/markdown", h.ImportMarkdownRoute, "POST")
+
 	addProtectedRoute(r, "/api/cards", h.GetCardsRoute, "GET")
 	addProtectedRoute(r, "/api/cards", h.CreateCardRoute, "POST")
 	addProtectedRoute(r, "/api/cards/next-root-id", h.GetNextRootCardIDRoute, "GET")
+	addProtectedRoute(r, "/api/cards/next-id", h.GetNextCardIDRoute, "GET")
+	addProtectedRoute(r, "/api/cards/outline", h.GetOutlineRoute, "GET")
 	addProtectedRoute(r, "/api/cards/pinned", h.GetPinnedCardsRoute, "GET")
 	addProtectedRoute(r, "/api/cards/{id}", h.GetCardRoute, "GET")
 	addProtectedRoute(r, "/api/cards/{id}", h.UpdateCardRoute, "PUT")
 	addProtectedRoute(r, "/api/cards/{id}", h.DeleteCardRoute, "DELETE")
 	addProtectedRoute(r, "/api/cards/{id}/audit", h.GetCardAuditEventsRoute, "GET")
+	addProtectedRoute(r, "/api/cards/{id}/revisions", h.GetCardRevisionsRoute, "GET")
+	addProtectedRoute(r, "/api/cards/{id}/revisions/diff", h.GetCardRevisionDiffRoute, "GET")
+	addProtectedRoute(r, "/api/cards/{id}/revisions/{revision}/restore", h.RestoreCardRevisionRoute, "POST")
+	addProtectedRoute(r, "/api/cards/{id}/move", h.MoveCardRoute, "POST")
+	addProtectedRoute(r, "/api/cards/{id}/outline", h.GetCardOutlineRoute, "GET")
+	addProtectedRoute(r, "/api/cards/{id}/render", h.RenderCardRoute, "GET")
 	addProtectedRoute(r, "/api/cards/{id}/pin", h.PinCardRoute, "POST")
 	addProtectedRoute(r, "/api/cards/{id}/pin", h.UnpinCardRoute, "DELETE")
 	addProtectedRoute(r, "/api/cards/{id}/facts", h.GetCardFacts, "GET")
@@ -183,6 +194,20 @@ func main() {
 	addProtectedRoute(r, "/api/cards/{id}/tasks", h.GetCardTasksRoute, "GET")
 	addProtectedRoute(r, "/api/cards/{id}/entities", h.GetCardEntitiesRoute, "GET")
 	addProtectedRoute(r, "/api/cards/{card_pk:[0-9]+}/linked-entities", h.GetEntityByLinkedCardPKRoute, "GET")
+	addProtectedRoute(r, "/api/cards/{id}/flashcard", h.MarkFlashcardRoute, "POST")
+	addProtectedRoute(r, "/api/cards/{id}/flashcard", h.UnmarkFlashcardRoute, "DELETE")
+
+	addProtectedRoute(r, "/api/flashcards/due", h.GetDueFlashcardsRoute, "GET")
+	addProtectedRoute(r, "/api/flashcards/{id}/review", h.ReviewFlashcardRoute, "POST")
+
+	addProtectedRoute(r, "/api/trash", h.GetTrashRoute, "GET")
+	addProtectedRoute(r, "/api/trash", h.EmptyTrashRoute, "DELETE")
+	addProtectedRoute(r, "/api/trash/{type}/{id}/restore", h.RestoreTrashItemRoute, "POST")
+	addProtectedRoute(r, "/api/trash/{type}/{id}", h.PurgeTrashItemRoute, "DELETE")
+
+	addProtectedRoute(r, "/api/link-health", h.GetLinkHealthRoute, "GET")
+	addProtectedRoute(r, "/api/graph", h.GetGraphRoute, "GET")
+	addProtectedRoute(r, "/api/graph/{type}/{id}", h.GetGraphNeighbourhoodRoute, "GET")
 
 	addProtectedRoute(r, "/api/templates", h.GetTemplatesRoute, "GET")
 	addProtectedRoute(r, "/api/templates", h.CreateTemplateRoute, "POST")
@@ -191,6 +216,29 @@ func main() {
 	addProtectedRoute(r, "/api/templates/{id}", h.DeleteTemplateRoute, "DELETE")
 
 	addProtectedRoute(r, "/api/search", h.SearchRoute, "POST")
+	addProtectedRoute(r, "/api/search/language", h.GetSearchLanguageRoute, "GET")
+	addProtectedRoute(r, "/api/search/language", h.UpdateSearchLanguageRoute, "PUT")
+
+	addProtectedRoute(r, "/api/chat", h.GetUserConversationsRoute, "GET")
+	addProtectedRoute(r, "/api/chat", h.PostChatMessageRoute, "POST")
+	addProtectedRoute(r, "/api/chat/conversations", h.CreateConversationRoute, "POST")
+	addProtectedRoute(r, "/api/chat/{id}", h.GetChatConversationRoute, "GET")
+
+	addProtectedRoute(r, "/api/llms/providers", h.GetLLMProvidersRoute, "GET")
+	addProtectedRoute(r, "/api/llms/providers", admin(h.CreateLLMProviderRoute), "POST")
+	addProtectedRoute(r, "/api/llms/providers/{id}", admin(h.UpdateLLMProviderRoute), "PUT")
+	addProtectedRoute(r, "/api/llms/providers/{id}", admin(h.DeleteLLMProviderRoute), "DELETE")
+	addProtectedRoute(r, "/api/llms/models", h.GetLLMModelsRoute, "GET")
+	addProtectedRoute(r, "/api/llms/models", admin(h.CreateLLMModelRoute), "POST")
+	addProtectedRoute(r, "/api/llms/models/{id}", admin(h.UpdateLLMModelRoute), "PUT")
+	addProtectedRoute(r, "/api/llms/models/{id}", admin(h.DeleteLLMModelRoute), "DELETE")
+	addProtectedRoute(r, "/api/llms/configurations", h.GetUserLLMConfigurationsRoute, "GET")
+	addProtectedRoute(r, "/api/llms/configurations", h.CreateUserLLMConfigurationRoute, "POST")
+	addProtectedRoute(r, "/api/llms/configurations/{id}", h.UpdateUserLLMConfigurationRoute, "PUT")
+	addProtectedRoute(r, "/api/llms/configurations/{id}", h.DeleteUserLLMConfigurationRoute, "DELETE")
+	addProtectedRoute(r, "/api/llms/tasks", h.GetUserLLMTasksRoute, "GET")
+	addProtectedRoute(r, "/api/llms/tasks/{task}", h.UpdateUserLLMTaskRoute, "PUT")
+	addProtectedRoute(r, "/api/llms/spend", h.GetLLMSpendRoute, "GET")
 
 	addProtectedRoute(r, "/api/users/{id}", h.GetUserRoute, "GET")
 	addProtectedRoute(r, "/api/users/{id}", h.UpdateUserRoute, "PUT")
@@ -206,6 +254,17 @@ func main() {
 	addProtectedRoute(r, "/api/user/memory", h.UpdateUserMemoryRoute, "PUT")
 	addProtectedRoute(r, "/api/current", h.GetCurrentUserRoute, "GET")
 	addProtectedRoute(r, "/api/admin", h.GetUserAdminRoute, "GET")
+	addProtectedRoute(r, "/api/admin/jobs", admin(h.GetJobsRoute), "GET")
+	addProtectedRoute(r, "/api/admin/jobs/stats", admin(h.GetJobStatsRoute), "GET")
+	addProtectedRoute(r, "/api/admin/jobs/{id}", admin(h.GetJobRoute), "GET")
+	addProtectedRoute(r, "/api/admin/jobs/{id}/retry", admin(h.RetryJobRoute), "POST")
+	addProtectedRoute(r, "/api/admin/llm-budgets", admin(h.GetLLMBudgetsRoute), "GET")
+	addProtectedRoute(r, "/api/admin/llm-budgets", admin(h.SaveLLMBudgetRoute), "PUT")
+	addProtectedRoute(r, "/api/admin/llm-budgets/{id}", admin(h.DeleteLLMBudgetRoute), "DELETE")
+	addProtectedRoute(r, "/api/admin/llm-pricing", admin(h.GetLLMPricingRoute), "GET")
+	addProtectedRoute(r, "/api/admin/llm-pricing", admin(h.CreateLLMPricingRoute), "POST")
+	addProtectedRoute(r, "/api/admin/llm-pricing/{id}", admin(h.UpdateLLMPricingRoute), "PUT")
+	addProtectedRoute(r, "/api/admin/llm-pricing/{id}", admin(h.DeleteLLMPricingRoute), "DELETE")
 
 	addProtectedRoute(r, "/api/tasks/{id}", h.GetTaskRoute, "GET")
 	addProtectedRoute(r, "/api/tasks", h.GetTasksRoute, "GET")
@@ -258,7 +317,8 @@ func main() {
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{os.Getenv("ZETTEL_URL")},
 		AllowCredentials: true,
-		AllowedHeaders:   []string{"authorization", "content-type"},
+		AllowedHeaders:   []string{"authorization", "content-type", "if-match"},
+		ExposedHeaders:   []string{"etag"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
 		// Enable Debugging for testing, consider disabling in production
 		//Debug: true,
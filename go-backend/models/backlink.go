@@ -2,9 +2,33 @@ package models
 
 import "time"
 
+// Link types recorded in backlinks
+const (
+	LinkTypeReference    = "reference"    // [card_id]
+	LinkTypeTransclusion = "transclusion" // ![card_id] or ![card_id#heading]
+)
+
 type Backlink struct {
 	SourceIDInt int
 	TargetIDInt int
+	LinkType    string
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
+
+// CardLink is a link to another card found in a card's body
+type CardLink struct {
+	CardID   string `json:"card_id"`
+	Heading  string `json:"heading,omitempty"`
+	LinkType string `json:"link_type"`
+}
+
+// RenderedCard is a card with the cards it transcludes expanded in place
+type RenderedCard struct {
+	ID       int      `json:"id"`
+	CardID   string   `json:"card_id"`
+	Title    string   `json:"title"`
+	Markdown string   `json:"markdown"`
+	HTML     string   `json:"html"`
+	Embedded []string `json:"embedded"`
+}
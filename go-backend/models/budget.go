@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// DefaultBudgetPlan is the plan budget used for users whose plan has none
+const DefaultBudgetPlan = "default"
+
+// LLMBudget limits the monthly LLM spend of a plan or a single user
+type LLMBudget struct {
+	ID                int       `json:"id"`
+	Plan              *string   `json:"plan"`
+	UserID            *int      `json:"user_id"`
+	MonthlyLimitUSD   float64   `json:"monthly_limit_usd"`
+	RequestsPerMinute *int      `json:"requests_per_minute"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// LLMSpend reports a user's spend for the current month against their
+// budget. Limit and Remaining are nil when the user has no budget.
+type LLMSpend struct {
+	UserID            int       `json:"user_id"`
+	PeriodStart       time.Time `json:"period_start"`
+	PeriodEnd         time.Time `json:"period_end"`
+	SpentUSD          float64   `json:"spent_usd"`
+	LimitUSD          *float64  `json:"limit_usd"`
+	RemainingUSD      *float64  `json:"remaining_usd"`
+	RequestsPerMinute *int      `json:"requests_per_minute"`
+	BudgetID          *int      `json:"budget_id"`
+}
@@ -112,6 +112,8 @@ type EditCardParams struct {
 	Body                    string `json:"body"`
 	Link                    string `json:"link"`
 	ProcessEntitiesAndFacts *bool  `json:"process_entities_and_facts,omitempty"`
+	// Allocate gives the card a free card_id instead of CardID
+	Allocate *CardIDAllocation `json:"allocate,omitempty"`
 }
 
 type NextIDParams struct {
@@ -130,6 +132,8 @@ type CardChunk struct {
 	UserID           int       `json:"user_id"`
 	Title            string    `json:"title"`
 	Chunk            string    `json:"body"`
+	ChunkStart       int       `json:"chunk_start"`
+	ChunkEnd         int       `json:"chunk_end"`
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
 	ParentID         int       `json:"parent_id"`
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -30,6 +31,43 @@ type LLMModel struct {
 	Provider        *LLMProvider `json:"provider,omitempty"`
 }
 
+// LLMModelColumns selects a model joined with its provider, aliased as m and
+// p, in the order read by ScanLLMModel
+const LLMModelColumns = `
+	m.id, m.provider_id, m.name, m.model_identifier, COALESCE(m.description, ''),
+	COALESCE(m.is_active, false), COALESCE(m.is_default, false), m.created_at, m.updated_at,
+	p.id, p.name, COALESCE(p.base_url, ''), COALESCE(p.api_key_required, true),
+	COALESCE(p.api_key, ''), p.created_at, p.updated_at
+`
+
+// ScanLLMModel reads the columns of LLMModelColumns, followed by any extra
+// destinations
+func ScanLLMModel(row interface{ Scan(...interface{}) error }, extra ...interface{}) (LLMModel, error) {
+	var model LLMModel
+	var provider LLMProvider
+	dest := []interface{}{
+		&model.ID,
+		&model.ProviderID,
+		&model.Name,
+		&model.ModelIdentifier,
+		&model.Description,
+		&model.IsActive,
+		&model.IsDefault,
+		&model.CreatedAt,
+		&model.UpdatedAt,
+		&provider.ID,
+		&provider.Name,
+		&provider.BaseURL,
+		&provider.APIKeyRequired,
+		&provider.APIKey,
+		&provider.CreatedAt,
+		&provider.UpdatedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	model.Provider = &provider
+	return model, err
+}
+
 type UserLLMConfiguration struct {
 	ID             int                    `json:"id"`
 	UserID         int                    `json:"user_id"`
@@ -50,12 +88,17 @@ type LLMRequest struct {
 	Retries int
 }
 
+// CompletionProvider serves chat completions. *openai.Client implements it,
+// as do the fake and fixture providers in the llms package.
+type CompletionProvider interface {
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+}
+
 type LLMClient struct {
-	Client  *openai.Client
-	Testing bool
-	Model   *LLMModel
-	UserID  int
-	DB      *sql.DB
+	Client CompletionProvider
+	Model  *LLMModel
+	UserID int
+	DB     *sql.DB
 }
 
 type ChatCompletion struct {
@@ -103,4 +146,54 @@ type CreateLLMModelRequest struct {
 	ProviderID      int    `json:"provider_id"`
 	Name            string `json:"name"`
 	ModelIdentifier string `json:"model_identifier"`
+	Description     string `json:"description"`
+	IsDefault       bool   `json:"is_default"`
+}
+
+type EditLLMModelParams struct {
+	Name            string `json:"name"`
+	ModelIdentifier string `json:"model_identifier"`
+	Description     string `json:"description"`
+	IsActive        bool   `json:"is_active"`
+	IsDefault       bool   `json:"is_default"`
+}
+
+// EditLLMProviderParams updates a provider. A nil APIKey keeps the stored key
+// and an empty one clears it.
+type EditLLMProviderParams struct {
+	Name           string  `json:"name"`
+	BaseURL        string  `json:"base_url"`
+	APIKeyRequired bool    `json:"api_key_required"`
+	APIKey         *string `json:"api_key"`
+}
+
+// EditLLMConfigurationParams creates or updates a user's configuration. A nil
+// APIKey keeps the stored key and an empty one clears it.
+type EditLLMConfigurationParams struct {
+	ModelID        int                    `json:"model_id"`
+	APIKey         *string                `json:"api_key"`
+	CustomSettings map[string]interface{} `json:"custom_settings"`
+	IsDefault      bool                   `json:"is_default"`
+}
+
+// Tasks that a user can assign their own model configuration to
+const (
+	LLMTaskChat           = "chat"
+	LLMTaskFactExtraction = "fact_extraction"
+	LLMTaskSummarization  = "summarization"
+	LLMTaskMemory         = "memory"
+	LLMTaskSearch         = "search"
+)
+
+var LLMTasks = []string{
+	LLMTaskChat,
+	LLMTaskFactExtraction,
+	LLMTaskSummarization,
+	LLMTaskMemory,
+	LLMTaskSearch,
+}
+
+type LLMTaskConfiguration struct {
+	Task            string `json:"task"`
+	ConfigurationID *int   `json:"configuration_id"`
 }
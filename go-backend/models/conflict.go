@@ -0,0 +1,23 @@
+package models
+
+// VersionConflict is the body of a 409 response to an update made against
+// an out of date version: the version the server has now and a suggested
+// merge of the two
+type VersionConflict struct {
+	Error   string          `json:"error"`
+	ETag    string          `json:"etag"`
+	Current interface{}     `json:"current"`
+	Merge   MergeSuggestion `json:"merge"`
+}
+
+// MergeSuggestion is a three-way merge of an update with the changes made
+// since the version it was based on. Fields are keyed by their JSON names.
+// Fields changed differently on both sides are listed in Conflicts and keep
+// the update's value, apart from text fields, which are merged line by line
+// with conflict markers around the lines that clash. Without the base
+// version every difference is a conflict.
+type MergeSuggestion struct {
+	BaseFound bool                   `json:"base_found"`
+	Fields    map[string]interface{} `json:"fields"`
+	Conflicts []string               `json:"conflicts"`
+}
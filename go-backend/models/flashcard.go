@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// Flashcard ratings, following the FSRS convention
+const (
+	FlashcardRatingAgain = 1
+	FlashcardRatingHard  = 2
+	FlashcardRatingGood  = 3
+	FlashcardRatingEasy  = 4
+)
+
+// Flashcard scheduling states
+const (
+	FlashcardStateNew        = "new"
+	FlashcardStateLearning   = "learning"
+	FlashcardStateReview     = "review"
+	FlashcardStateRelearning = "relearning"
+)
+
+// Flashcard is a card that is part of the user's review deck, along with its
+// spaced-repetition scheduling fields
+type Flashcard struct {
+	Card       PartialCard `json:"card"`
+	Body       string      `json:"body"`
+	State      string      `json:"state"`
+	Reps       int         `json:"reps"`
+	Lapses     int         `json:"lapses"`
+	Stability  float64     `json:"stability"`
+	Difficulty float64     `json:"difficulty"`
+	LastReview *time.Time  `json:"last_review"`
+	Due        *time.Time  `json:"due"`
+}
+
+// FlashcardReview is a single review of a flashcard
+type FlashcardReview struct {
+	ID          int       `json:"id"`
+	CardPK      int       `json:"card_pk"`
+	UserID      int       `json:"user_id"`
+	Rating      int       `json:"rating"`
+	State       string    `json:"state"`
+	Stability   float64   `json:"stability"`
+	Difficulty  float64   `json:"difficulty"`
+	ElapsedDays float64   `json:"elapsed_days"`
+	Due         time.Time `json:"due"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ReviewFlashcardParams struct {
+	Rating int `json:"rating"`
+}
@@ -0,0 +1,51 @@
+package models
+
+// Ways of allocating a card_id
+const (
+	AllocateRoot    = "root"
+	AllocateChild   = "child"
+	AllocateSibling = "sibling"
+	AllocateBetween = "between"
+)
+
+// CardIDAllocation asks for a free card_id relative to an existing one.
+// Between takes the card_ids of two siblings, in CardID and Before. Children
+// follow a separator, so the first child of 12/3 is 12/3.A, not 12/3a.
+type CardIDAllocation struct {
+	Mode   string `json:"mode"`
+	CardID string `json:"card_id"`
+	Before string `json:"before,omitempty"`
+}
+
+type MoveCardParams struct {
+	CardID string `json:"card_id"`
+}
+
+// MovedCard is a card that was given a new card_id by a move
+type MovedCard struct {
+	ID   int    `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MoveCardResult lists the cards of a moved subtree and the cards whose
+// references to them were rewritten
+type MoveCardResult struct {
+	Moved     []MovedCard `json:"moved"`
+	Rewritten []int       `json:"rewritten"`
+}
+
+// OutlineNode is a card in the outline of the card tree. ChildCount counts
+// all of its children, including those cut off by a depth limit.
+type OutlineNode struct {
+	ID            int           `json:"id"`
+	CardID        string        `json:"card_id"`
+	Title         string        `json:"title"`
+	ParentID      int           `json:"parent_id"`
+	Depth         int           `json:"depth"`
+	ChildCount    int           `json:"child_count"`
+	TagCount      int           `json:"tag_count"`
+	TaskCount     int           `json:"task_count"`
+	OpenTaskCount int           `json:"open_task_count"`
+	Children      []OutlineNode `json:"children"`
+}
@@ -0,0 +1,40 @@
+package models
+
+// Kinds of nodes in the knowledge graph
+const (
+	GraphNodeCard   = "card"
+	GraphNodeEntity = "entity"
+	GraphNodeFact   = "fact"
+)
+
+// Kinds of edges in the knowledge graph
+const (
+	GraphEdgeLink         = "link"         // a card references another card
+	GraphEdgeTransclusion = "transclusion" // a card embeds another card
+	GraphEdgeParent       = "parent"       // a card sits below another card
+	GraphEdgeEntityCard   = "entity_card"  // an entity is mentioned on a card
+	GraphEdgeEntityFact   = "entity_fact"  // a fact is about an entity
+	GraphEdgeFactCard     = "fact_card"    // a fact was found on a card
+)
+
+// GraphNode is a card, entity or fact. ID is unique across kinds, as in
+// card:12.
+type GraphNode struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	PK         int    `json:"pk"`
+	Label      string `json:"label"`
+	CardID     string `json:"card_id,omitempty"`
+	EntityType string `json:"entity_type,omitempty"`
+}
+
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Type   string `json:"type"`
+}
+
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
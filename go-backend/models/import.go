@@ -0,0 +1,34 @@
+package models
+
+// ImportedCard is a card created, or to be created in a dry run, from a
+// note of an imported vault
+type ImportedCard struct {
+	ID          int      `json:"id,omitempty"`
+	CardID      string   `json:"card_id"`
+	Title       string   `json:"title"`
+	Source      string   `json:"source"`
+	Attachments []string `json:"attachments"`
+}
+
+// ImportConflict is a note that was not imported
+type ImportConflict struct {
+	Source string `json:"source"`
+	CardID string `json:"card_id"`
+	Reason string `json:"reason"`
+}
+
+// ImportBrokenLink is a link in a note that does not match any note in the
+// vault or card of the user
+type ImportBrokenLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+type ImportResult struct {
+	DryRun      bool               `json:"dry_run"`
+	Cards       []ImportedCard     `json:"cards"`
+	Conflicts   []ImportConflict   `json:"conflicts"`
+	BrokenLinks []ImportBrokenLink `json:"broken_links"`
+	// Failures are notes that were to be imported but could not be
+	Failures []ImportConflict `json:"failures"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job states. Pending jobs are waiting for a worker, running jobs hold a
+// lease, and dead jobs have used up their attempts.
+const (
+	JobStatusPending  = "pending"
+	JobStatusRunning  = "running"
+	JobStatusComplete = "complete"
+	JobStatusDead     = "dead"
+)
+
+// Job types run by the worker
+const (
+	JobTypeProcessEntitiesAndFacts = "process_entities_and_facts"
+	JobTypeSummarize               = "summarize"
+	JobTypeGenerateMemory          = "generate_memory"
+	JobTypeUpsertCardTypesense     = "upsert_card_typesense"
+	JobTypeEmbedCard               = "embed_card"
+	JobTypeLinkHealthReport        = "link_health_report"
+)
+
+type Job struct {
+	ID          int             `json:"id"`
+	JobType     string          `json:"job_type"`
+	UserID      *int            `json:"user_id"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedBy    *string         `json:"locked_by"`
+	LockedUntil *time.Time      `json:"locked_until"`
+	LastError   *string         `json:"last_error"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	CompletedAt *time.Time      `json:"completed_at"`
+}
+
+// JobStats counts jobs per type and status
+type JobStats struct {
+	JobType string `json:"job_type"`
+	Status  string `json:"status"`
+	Count   int    `json:"count"`
+}
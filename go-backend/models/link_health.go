@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// BrokenLink is a [card_id] reference to a card that does not exist or is
+// in the trash
+type BrokenLink struct {
+	SourceID     int    `json:"source_id"`
+	SourceCardID string `json:"source_card_id"`
+	SourceTitle  string `json:"source_title"`
+	Target       string `json:"target"`
+	Reason       string `json:"reason"` // 'missing' or 'deleted'
+}
+
+// CardIDCollision is a card whose card_id is also held by cards in the
+// trash, which cannot be restored while it is in use
+type CardIDCollision struct {
+	ID         int    `json:"id"`
+	CardID     string `json:"card_id"`
+	Title      string `json:"title"`
+	DeletedIDs []int  `json:"deleted_ids"`
+}
+
+// LinkHealthReport lists the problems with the links between a user's cards.
+// Orphans are cards with no parent, children, backlinks or tags.
+type LinkHealthReport struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	BrokenLinks []BrokenLink      `json:"broken_links"`
+	Orphans     []PartialCard     `json:"orphans"`
+	Collisions  []CardIDCollision `json:"collisions"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// LLMModelPricing is the price of a model's tokens, in USD per 1000 tokens,
+// for requests made from EffectiveFrom until EffectiveTo. A nil EffectiveTo
+// means the price is current.
+type LLMModelPricing struct {
+	ID              int        `json:"id"`
+	ModelIdentifier string     `json:"model_identifier"`
+	PromptPer1K     float64    `json:"prompt_per_1k"`
+	CompletionPer1K float64    `json:"completion_per_1k"`
+	EffectiveFrom   time.Time  `json:"effective_from"`
+	EffectiveTo     *time.Time `json:"effective_to"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// CostRecomputeResult reports how many historical rows a pricing backfill
+// updated
+type CostRecomputeResult struct {
+	QueryLogRows      int64 `json:"query_log_rows"`
+	SummarizationRows int64 `json:"summarization_rows"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// CardRevision is the state of a card after one of its audited changes.
+// Revisions are numbered from 1, oldest first.
+type CardRevision struct {
+	Number        int       `json:"number"`
+	EventID       int       `json:"event_id,omitempty"`
+	Action        string    `json:"action"`
+	UserID        int       `json:"user_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	CardID        string    `json:"card_id"`
+	Title         string    `json:"title"`
+	Body          string    `json:"body"`
+	Link          string    `json:"link"`
+	ChangedFields []string  `json:"changed_fields"`
+	RestoredFrom  int       `json:"restored_from,omitempty"`
+}
+
+type DiffLine struct {
+	Op      string `json:"op"` // 'equal', 'insert' or 'delete'
+	Text    string `json:"text"`
+	OldLine int    `json:"old_line,omitempty"`
+	NewLine int    `json:"new_line,omitempty"`
+}
+
+type RevisionDiff struct {
+	From      int        `json:"from"`
+	To        int        `json:"to"`
+	TitleFrom string     `json:"title_from"`
+	TitleTo   string     `json:"title_to"`
+	Lines     []DiffLine `json:"lines"`
+}
@@ -15,6 +15,35 @@ type SearchResult struct {
 	Metadata  interface{} `json:"metadata"`
 	Tags      []Tag       `json:"tags,omitempty"`
 	CardID    string      `json:"card_id"`
+	// Highlights show where the search matched, with the matching text
+	// between <mark> tags
+	Highlights []SearchHighlight `json:"highlights,omitempty"`
+}
+
+type SearchHighlight struct {
+	Field   string `json:"field"`
+	Snippet string `json:"snippet"`
+}
+
+// SearchResponse is one page of search results. NextCursor fetches the page
+// after it, and is empty on the last page. Total and Facets count every
+// result, not just those on the page.
+type SearchResponse struct {
+	Results    []SearchResult `json:"results"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"next_cursor"`
+	Facets     SearchFacets   `json:"facets"`
+}
+
+type SearchFacets struct {
+	Tags        []FacetCount `json:"tags"`
+	EntityTypes []FacetCount `json:"entity_types"`
+	Types       []FacetCount `json:"types"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
 }
 
 func CardChunkToSearchResult(chunk CardChunk) SearchResult {
@@ -35,3 +64,22 @@ func CardChunkToSearchResult(chunk CardChunk) SearchResult {
 		},
 	}
 }
+
+// ScoreBreakdown explains where a hybrid search result came from: its rank
+// and score in keyword and semantic retrieval, 1-based with 0 meaning it was
+// not retrieved that way, and the fused score it is ordered by
+type ScoreBreakdown struct {
+	Fusion        string  `json:"fusion"`
+	KeywordRank   int     `json:"keyword_rank"`
+	KeywordScore  float64 `json:"keyword_score"`
+	SemanticRank  int     `json:"semantic_rank"`
+	SemanticScore float64 `json:"semantic_score"`
+	FusedScore    float64 `json:"fused_score"`
+}
+
+// SearchLanguage is the text search configuration a user's cards are
+// stemmed with for Postgres full text search, and the ones to choose from
+type SearchLanguage struct {
+	Language  string   `json:"language"`
+	Available []string `json:"available,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Kinds of items kept in the trash
+const (
+	TrashTypeCard = "card"
+	TrashTypeTask = "task"
+	TrashTypeFile = "file"
+)
+
+// TrashItem is a deleted card, task or file that can still be restored
+type TrashItem struct {
+	Type      string    `json:"type"`
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	CardID    string    `json:"card_id,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// TrashPurgeResult counts the items purged from the trash
+type TrashPurgeResult struct {
+	Cards int `json:"cards"`
+	Tasks int `json:"tasks"`
+	Files int `json:"files"`
+}
@@ -0,0 +1,16 @@
+-- Give flashcard_reviews a real primary key and record the scheduling
+-- state each review produced
+CREATE SEQUENCE IF NOT EXISTS flashcard_reviews_id_seq OWNED BY flashcard_reviews.id;
+ALTER TABLE flashcard_reviews ALTER COLUMN id SET DEFAULT nextval('flashcard_reviews_id_seq');
+-- Nothing refers to a review by id, so every row is numbered afresh in case
+-- older rows share one
+UPDATE flashcard_reviews SET id = nextval('flashcard_reviews_id_seq');
+ALTER TABLE flashcard_reviews ADD PRIMARY KEY (id);
+
+ALTER TABLE flashcard_reviews ADD COLUMN state TEXT;
+ALTER TABLE flashcard_reviews ADD COLUMN stability REAL;
+ALTER TABLE flashcard_reviews ADD COLUMN difficulty REAL;
+ALTER TABLE flashcard_reviews ADD COLUMN elapsed_days REAL;
+ALTER TABLE flashcard_reviews ADD COLUMN due TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS cards_flashcard_due_idx ON cards(user_id, flashcard_due) WHERE is_flashcard = TRUE;
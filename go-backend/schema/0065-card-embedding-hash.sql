@@ -0,0 +1,2 @@
+-- Hash of the title and body that the card's current embeddings were built from
+ALTER TABLE cards ADD COLUMN embedding_hash TEXT;
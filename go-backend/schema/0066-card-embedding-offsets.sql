@@ -0,0 +1,3 @@
+-- Byte offsets of each embedded chunk within the card body
+ALTER TABLE card_embeddings ADD COLUMN chunk_start INT;
+ALTER TABLE card_embeddings ADD COLUMN chunk_end INT;
@@ -0,0 +1,12 @@
+-- The model used when a user has not configured one
+ALTER TABLE llm_models ADD COLUMN is_default BOOLEAN DEFAULT false;
+
+-- Lets a user pick a different configuration per task, e.g. a cheaper model
+-- for fact extraction and a stronger one for chat
+CREATE TABLE IF NOT EXISTS user_llm_task_configurations (
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    task TEXT NOT NULL,
+    configuration_id INTEGER NOT NULL REFERENCES user_llm_configurations(id) ON DELETE CASCADE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, task)
+);
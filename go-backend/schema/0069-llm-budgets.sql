@@ -0,0 +1,15 @@
+-- Monthly LLM spend limits. A budget applies either to every user on a plan
+-- (matching users.stripe_current_plan, or 'default' for everyone else) or to
+-- a single user, which takes precedence.
+CREATE TABLE IF NOT EXISTS llm_budgets (
+    id SERIAL PRIMARY KEY,
+    plan TEXT UNIQUE,
+    user_id INTEGER UNIQUE REFERENCES users(id),
+    monthly_limit_usd NUMERIC(10,4) NOT NULL,
+    requests_per_minute INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((plan IS NULL) <> (user_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS llm_query_log_user_created_idx ON llm_query_log (user_id, created_at);
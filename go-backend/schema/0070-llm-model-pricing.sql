@@ -0,0 +1,24 @@
+-- Per-model token prices. A model may have several rows as its price
+-- changes; each applies to requests made in [effective_from, effective_to).
+CREATE TABLE IF NOT EXISTS llm_model_pricing (
+    id SERIAL PRIMARY KEY,
+    model_identifier TEXT NOT NULL,
+    prompt_per_1k NUMERIC(12,6) NOT NULL,
+    completion_per_1k NUMERIC(12,6) NOT NULL,
+    effective_from TIMESTAMP NOT NULL DEFAULT '1970-01-01',
+    effective_to TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (prompt_per_1k >= 0 AND completion_per_1k >= 0),
+    CHECK (effective_to IS NULL OR effective_to > effective_from)
+);
+
+CREATE INDEX IF NOT EXISTS llm_model_pricing_model_idx ON llm_model_pricing (model_identifier, effective_from);
+
+INSERT INTO llm_model_pricing (model_identifier, prompt_per_1k, completion_per_1k) VALUES
+    ('google/gemini-2.5-flash', 0.0003, 0.0025),
+    ('google/gemini-2.5-pro', 0.00125, 0.010),
+    ('openai/gpt-5-chat', 0.00125, 0.010);
+
+-- Four decimal places rounded most single requests to zero
+ALTER TABLE llm_query_log ALTER COLUMN cost_usd TYPE NUMERIC(12,6);
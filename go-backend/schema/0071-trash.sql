@@ -0,0 +1,15 @@
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+-- Deleting a card set its updated_at, deleting a task left it alone
+UPDATE cards SET deleted_at = updated_at WHERE is_deleted = TRUE AND deleted_at IS NULL;
+UPDATE tasks SET deleted_at = NOW() WHERE is_deleted = TRUE AND deleted_at IS NULL;
+
+-- Files deleted so far already lost their S3 object, so they are dated to be
+-- purged on the first retention run
+UPDATE files SET deleted_at = '1970-01-01' WHERE is_deleted = TRUE AND deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS cards_deleted_at_idx ON cards (deleted_at) WHERE is_deleted = TRUE;
+CREATE INDEX IF NOT EXISTS tasks_deleted_at_idx ON tasks (deleted_at) WHERE is_deleted = TRUE;
+CREATE INDEX IF NOT EXISTS files_deleted_at_idx ON files (deleted_at) WHERE is_deleted = TRUE;
@@ -0,0 +1,3 @@
+ALTER TABLE backlinks ADD COLUMN IF NOT EXISTS link_type TEXT NOT NULL DEFAULT 'reference';
+
+CREATE INDEX IF NOT EXISTS backlinks_target_link_type_idx ON backlinks (target_id_int, link_type);
@@ -0,0 +1,28 @@
+-- The text search configuration cards are stemmed with. Each card keeps a
+-- copy of its owner's so the vector can be generated from the row alone.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search_language REGCONFIG NOT NULL DEFAULT 'english';
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS search_language REGCONFIG NOT NULL DEFAULT 'english';
+
+-- card_ids and titles outrank bodies
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector(search_language, coalesce(card_id, '')), 'A') ||
+    setweight(to_tsvector(search_language, coalesce(title, '')), 'A') ||
+    setweight(to_tsvector(search_language, coalesce(body, '')), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS cards_search_vector_idx ON cards USING GIN (search_vector);
+
+CREATE OR REPLACE FUNCTION set_card_search_language() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_language := COALESCE(
+        (SELECT search_language FROM users WHERE id = NEW.user_id),
+        'english'
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS cards_search_language ON cards;
+CREATE TRIGGER cards_search_language
+    BEFORE INSERT ON cards
+    FOR EACH ROW EXECUTE FUNCTION set_card_search_language();
@@ -62,6 +62,11 @@ func ResetDatabase(S *Server) error {
 			DROP TABLE IF EXISTS fact_card_junction CASCADE;
 			DROP TABLE IF EXISTS llm_query_log CASCADE;
 			DROP TABLE IF EXISTS revenue CASCADE;
+			DROP TABLE IF EXISTS flashcard_reviews CASCADE;
+			DROP TABLE IF EXISTS jobs CASCADE;
+			DROP TABLE IF EXISTS user_llm_task_configurations CASCADE;
+			DROP TABLE IF EXISTS llm_budgets CASCADE;
+			DROP TABLE IF EXISTS llm_model_pricing CASCADE;
 
 			CREATE TABLE IF NOT EXISTS migrations (
 				id SERIAL PRIMARY KEY,
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"go-backend/llms"
 	"go-backend/mail"
 	"go-backend/models"
 	"go-backend/server"
@@ -54,7 +55,8 @@ func Setup() *server.Server {
 		DB:                db,
 	}
 	S.TestInspector = &server.TestInspector{}
-	S.LLMClient = &models.LLMClient{Testing: true}
+	S.LLMClient = &models.LLMClient{}
+	llms.SetProvider(llms.NewFakeProvider())
 
 	server.RunMigrations(S)
 	err = importTestData(S)
@@ -7,20 +7,23 @@ import {
   getRatingValue,
   Entity,
   SearchResult,
+  SearchResponse,
   defaultPartialCard,
 } from "../models/Card";
 import { checkStatus } from "./common";
 
 const base_url = import.meta.env.VITE_URL;
 
-interface SearchRequestParams {
+export interface SearchRequestParams {
   search_term: string;
   sort?: string;
   full_text?: boolean;
   show_entities?: boolean;
   show_facts?: boolean;
-  search_type?: string; // classic or typesense
+  search_type?: string; // classic, typesense, postgres or hybrid
   rerank?: boolean;
+  cursor?: string;
+  page_size?: number;
 }
 
 export function semanticSearchCards(
@@ -32,10 +35,7 @@ export function semanticSearchCards(
   searchType = "classic",
   rerank = true,
 ): Promise<SearchResult[]> {
-  let token = localStorage.getItem("token");
-  let url = base_url + "/search";
-
-  const params: SearchRequestParams = {
+  return searchCardsPage({
     search_term: searchTerm,
     search_type: searchType,
     full_text: fullText,
@@ -43,7 +43,16 @@ export function semanticSearchCards(
     show_facts: showFacts,
     sort: sortBy,
     rerank: rerank,
-  };
+  }).then((response) => response.results);
+}
+
+// searchCardsPage fetches one page of search results, with the total count
+// and facets. Pass next_cursor back as cursor for the following page.
+export function searchCardsPage(
+  params: SearchRequestParams,
+): Promise<SearchResponse> {
+  let token = localStorage.getItem("token");
+  let url = base_url + "/search";
 
   return fetch(url, {
     method: "POST",
@@ -56,16 +65,14 @@ export function semanticSearchCards(
     .then(checkStatus)
     .then((response) => {
       if (response) {
-        return response.json().then((results: SearchResult[]) => {
-          if (results === null) {
-            return [];
-          }
-          return results.map((result) => ({
+        return response.json().then((page: SearchResponse) => ({
+          ...page,
+          results: (page.results ?? []).map((result) => ({
             ...result,
             created_at: new Date(result.created_at),
             updated_at: new Date(result.updated_at),
-          }));
-        });
+          })),
+        }));
       } else {
         return Promise.reject(new Error("Response is undefined"));
       }
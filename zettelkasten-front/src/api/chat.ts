@@ -264,7 +264,7 @@ export function updateLLMConfiguration(
   }
 ): Promise<UserLLMConfiguration> {
   const token = localStorage.getItem("token");
-  const url = `${base_url}/llms/models/${configId}`;
+  const url = `${base_url}/llms/configurations/${configId}`;
 
   console.log("updates", updates)
 
@@ -80,6 +80,28 @@ export interface SearchResult {
     semantic_ranking?: number;
     [key: string]: any;
   };
+  highlights?: SearchHighlight[];
+}
+
+export interface SearchHighlight {
+  field: string;
+  snippet: string;
+}
+
+export interface FacetCount {
+  value: string;
+  count: number;
+}
+
+export interface SearchResponse {
+  results: SearchResult[];
+  total: number;
+  next_cursor: string;
+  facets: {
+    tags: FacetCount[];
+    entity_types: FacetCount[];
+    types: FacetCount[];
+  };
 }
 
 export const defaultPartialCard: PartialCard = {
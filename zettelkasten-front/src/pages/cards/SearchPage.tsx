@@ -1,6 +1,6 @@
 import React, { useState, useEffect, ChangeEvent, KeyboardEvent } from "react";
 import { Menu } from '@headlessui/react';
-import { semanticSearchCards } from "../../api/cards";
+import { searchCardsPage, SearchRequestParams } from "../../api/cards";
 import { fetchUserTags } from "../../api/tags";
 import { SearchResult } from "../../models/Card";
 import { Tag } from "../../models/Tags";
@@ -40,6 +40,10 @@ export function SearchPage({
   const { tags } = useTagContext();
   const [showPinSearchDialog, setShowPinSearchDialog] = useState<boolean>(false);
   const [message, setMessage] = useState<string>("");
+  const [nextCursor, setNextCursor] = useState<string>("");
+  const [totalResults, setTotalResults] = useState<number>(0);
+  const [isLoadingMore, setIsLoadingMore] = useState<boolean>(false);
+  const lastRequest = React.useRef<SearchRequestParams | null>(null);
   const latestRequestId = React.useRef(0);
   const {
     showEntityDialog,
@@ -64,18 +68,23 @@ export function SearchPage({
     const term = searchTerm || "";
     console.log("searching for term:", term);
 
+    const request: SearchRequestParams = {
+      search_term: term,
+      search_type: config.searchType,
+      full_text: config.useFullText,
+      show_entities: config.showEntities,
+      show_facts: config.showFacts,
+      sort: config.sortBy,
+      rerank: config.rerank,
+    };
+
     try {
-      const results = await semanticSearchCards(
-        term,
-        config.useFullText,
-        config.showEntities,
-        config.showFacts,
-        config.sortBy,
-        config.searchType,
-        config.rerank,
-      );
+      const page = await searchCardsPage(request);
       if (requestId === latestRequestId.current) {
-        setSearchResults(results || []);
+        lastRequest.current = request;
+        setSearchResults(page.results);
+        setTotalResults(page.total);
+        setNextCursor(page.next_cursor ?? "");
       }
     } catch (error) {
       console.error("Search error:", error);
@@ -89,6 +98,32 @@ export function SearchPage({
     }
   }
 
+  // Backends that page their results return the first page with a cursor
+  // for the rest, which are fetched on demand
+  async function handleLoadMore() {
+    if (!nextCursor || lastRequest.current === null) return;
+    const requestId = latestRequestId.current;
+
+    setIsLoadingMore(true);
+    try {
+      const page = await searchCardsPage({
+        ...lastRequest.current,
+        cursor: nextCursor,
+      });
+      if (requestId === latestRequestId.current) {
+        setSearchResults([...searchResults, ...page.results]);
+        setNextCursor(page.next_cursor ?? "");
+      }
+    } catch (error) {
+      console.error("Search error:", error);
+      if (requestId === latestRequestId.current) {
+        setError(error);
+      }
+    } finally {
+      setIsLoadingMore(false);
+    }
+  }
+
   useEffect(() => {
     const initializeSearch = async () => {
       setDocumentTitle("Search")
@@ -394,6 +429,18 @@ export function SearchPage({
                     children={"Next"}
                   />
                 </div>
+                {nextCursor && (
+                  <div className="flex justify-center gap-4 mt-4">
+                    <span className="flex items-center">
+                      Showing {searchResults.length} of {totalResults} results
+                    </span>
+                    <Button
+                      onClick={handleLoadMore}
+                      disabled={isLoadingMore}
+                      children={isLoadingMore ? "Loading" : "Load More"}
+                    />
+                  </div>
+                )}
               </div>
             ) : (
               <div>